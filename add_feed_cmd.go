@@ -0,0 +1,113 @@
+//
+// Add a single feed, with its options, to the configuration file.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// optionFlags collects the options given by repeated '-option' flags.
+type optionFlags []configfile.Option
+
+// String is part of the flag.Value interface.
+func (o *optionFlags) String() string {
+	var out []string
+	for _, opt := range *o {
+		out = append(out, opt.Name+"="+opt.Value)
+	}
+	return strings.Join(out, ",")
+}
+
+// Set is part of the flag.Value interface, and records an option given
+// as "name=value".
+func (o *optionFlags) Set(value string) error {
+	name, val, found := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !found || name == "" {
+		return fmt.Errorf("options must be given as 'name=value', not '%s'", value)
+	}
+	*o = append(*o, configfile.Option{Name: name, Value: strings.TrimSpace(val)})
+	return nil
+}
+
+// Structure for our options and state.
+type addFeedCmd struct {
+
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+
+	// The options to give the feed
+	options optionFlags
+}
+
+// Arguments handles argument-flags we might have.
+//
+// In our case we use this as a hook to setup our configuration-file,
+// which allows testing.
+func (a *addFeedCmd) Arguments(flags *flag.FlagSet) {
+	a.config = configfile.New()
+
+	flags.Var(&a.options, "option", "An option for the feed, as 'name=value', which may be repeated")
+}
+
+// Info is part of the subcommand-API
+func (a *addFeedCmd) Info() (string, string) {
+	return "add-feed", `Add a feed, with its options, to our feed-list.
+
+Append the given URL to the configuration file, along with any options
+given by '-option name=value', which may be repeated.
+
+Unlike 'add' the rest of the file, including any comments, is left
+exactly as it is, and the file is replaced atomically.  It is an error
+if the feed is already present.
+
+To see details of the configuration file, including the location and
+the supported options, please run:
+
+   $ rss2email help config
+
+Example:
+
+    $ rss2email add-feed https://blog.steve.fi/index.rss
+    $ rss2email add-feed -option retry=3 -option exclude=(?i)sponsored \
+        https://blog.steve.fi/index.rss
+`
+}
+
+// Execute is invoked if the user specifies `add-feed` as the subcommand.
+func (a *addFeedCmd) Execute(args []string) int {
+
+	if len(args) != 1 {
+		fmt.Fprintf(out, "Usage: rss2email add-feed [-option name=value ..] URL\n")
+		return 1
+	}
+	url := args[0]
+
+	// Unknown options are allowed, as for the file itself, but
+	// they're probably a typo.
+	for _, opt := range a.options {
+		if _, ok := configfile.KnownOptions[opt.Name]; !ok {
+			logger.Warn("unknown option, it will be ignored",
+				slog.String("option", opt.Name),
+				slog.String("value", opt.Value))
+		}
+	}
+
+	err := a.config.AddFeed(url, a.options...)
+	if err != nil {
+		logger.Error("failed to add feed",
+			slog.String("configfile", a.config.Path()),
+			slog.String("feed", url),
+			slog.String("error", err.Error()))
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// TestAddFeedRemoveFeed adds a feed, with options, to a configuration
+// file and then removes it again, ensuring the other feeds are untouched.
+func TestAddFeedRemoveFeed(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+	out = &bytes.Buffer{}
+
+	content := `# Comment here
+https://example.org/
+https://example.net/
+ - foo: bar
+`
+	path := filepath.Join(t.TempDir(), "feeds.txt")
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	add := addFeedCmd{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	add.Arguments(flags)
+	add.config = configfile.NewWithPath(path)
+
+	err = flags.Parse([]string{"-option", "retry=3", "-option", "exclude = (?i)spam", "https://example.com/"})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %s", err)
+	}
+	if res := add.Execute(flags.Args()); res != 0 {
+		t.Fatalf("failed to add feed")
+	}
+
+	entries, err := configfile.NewWithPath(path).Parse()
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if len(entries) != 3 || entries[2].URL != "https://example.com/" ||
+		entries[2].GetOption("retry", "") != "3" || entries[2].GetOption("exclude", "") != "(?i)spam" {
+		t.Fatalf("added feed not found %v", entries)
+	}
+
+	// Adding it twice fails.
+	if res := add.Execute([]string{"https://example.com/"}); res != 1 {
+		t.Fatalf("expected failure adding the feed twice")
+	}
+
+	// Now remove it.
+	rem := removeFeedCmd{config: configfile.NewWithPath(path)}
+	if res := rem.Execute([]string{"https://example.com/"}); res != 0 {
+		t.Fatalf("failed to remove feed")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err)
+	}
+	if string(data) != content {
+		t.Fatalf("other feeds were modified:\n%s", data)
+	}
+
+	// Removing it twice fails.
+	if res := rem.Execute([]string{"https://example.com/"}); res != 1 {
+		t.Fatalf("expected failure removing a missing feed")
+	}
+}
+
+// TestAddFeedUsage ensures we require a single URL, and options of the
+// right form.
+func TestAddFeedUsage(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+	out = &bytes.Buffer{}
+
+	add := addFeedCmd{}
+	if res := add.Execute([]string{}); res != 1 {
+		t.Fatalf("expected failure without a URL")
+	}
+
+	var opts optionFlags
+	if err := opts.Set("retry"); err == nil {
+		t.Fatalf("expected an error for an option without a value")
+	}
+	if err := opts.Set("=3"); err == nil {
+		t.Fatalf("expected an error for an option without a name")
+	}
+	if err := opts.Set("retry=3"); err != nil || opts.String() != "retry=3" {
+		t.Fatalf("unexpected options %v: %v", opts, err)
+	}
+}
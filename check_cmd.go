@@ -7,16 +7,37 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/mmcdole/gofeed"
 	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/httpfetch"
+	"github.com/skx/rss2email/processor"
 )
 
+// namespaces maps the prefixes of feed extensions to friendly names.
+var namespaces = map[string]string{
+	"content":    "Content",
+	"dc":         "Dublin Core",
+	"georss":     "GeoRSS",
+	"googleplay": "Google Play",
+	"itunes":     "iTunes",
+	"media":      "Media RSS",
+	"podcast":    "Podcasting 2.0",
+	"slash":      "Slash",
+	"sy":         "Syndication",
+	"wfw":        "Well-Formed Web",
+}
+
 // Structure for our options and state.
 type checkCmd struct {
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+
 	// Check all feeds from config
 	all bool
 
@@ -31,7 +52,16 @@ func (c *checkCmd) Info() (string, string) {
 This sub-command tests one or more feed URLs to verify they are
 reachable and contain valid RSS/Atom content.
 
-With --all it checks every feed in your configuration file.
+For each URL given we show details of the feed, such as the number
+of items it contains, the age of those items, and the extensions it
+uses, along with a sample of the item titles.  If the feed is present
+in your configuration file we also show how many of the items would
+pass the filters configured for it.
+
+No state is updated, and no emails are sent.
+
+With --all it checks every feed in your configuration file, showing
+a summary of each.
 
 Examples:
 
@@ -42,6 +72,8 @@ Examples:
 
 // Arguments handles our flag-setup.
 func (c *checkCmd) Arguments(f *flag.FlagSet) {
+	c.config = configfile.New()
+
 	f.BoolVar(&c.all, "all", false, "Check all feeds from the configuration file")
 	f.IntVar(&c.timeout, "timeout", 15, "HTTP timeout in seconds")
 }
@@ -52,20 +84,19 @@ func (c *checkCmd) Execute(args []string) int {
 	var urls []string
 
 	if c.all {
-		conf := configfile.New()
-		entries, err := conf.Parse()
+		entries, err := c.config.Parse()
 		if err != nil {
-			fmt.Printf("Error parsing config: %s\n", err.Error())
+			fmt.Fprintf(out, "Error parsing config: %s\n", err.Error())
 			return 1
 		}
 		for _, entry := range entries {
 			urls = append(urls, entry.URL)
 		}
-		fmt.Printf("Checking %d feeds from %s\n\n", len(urls), conf.Path())
+		fmt.Fprintf(out, "Checking %d feeds from %s\n\n", len(urls), c.config.Path())
 	} else {
 		if len(args) < 1 {
-			fmt.Printf("Usage: rss2email check <url> [url...]\n")
-			fmt.Printf("       rss2email check --all\n")
+			fmt.Fprintf(out, "Usage: rss2email check <url> [url...]\n")
+			fmt.Fprintf(out, "       rss2email check --all\n")
 			return 1
 		}
 		urls = args
@@ -75,48 +106,185 @@ func (c *checkCmd) Execute(args []string) int {
 		Timeout: time.Duration(c.timeout) * time.Second,
 	}
 
-	parser := gofeed.NewParser()
+	parser := httpfetch.NewParser()
 	parser.Client = httpClient
 
 	errors := 0
 	for _, feedURL := range urls {
-		result := c.checkFeed(parser, feedURL)
-		if !result {
+		feed, ok := c.checkFeed(parser, feedURL)
+		if !ok {
 			errors++
+			continue
+		}
+
+		// Show the details of feeds the user asked about.
+		if !c.all {
+			c.showDetails(feedURL, feed)
 		}
 	}
 
 	if errors > 0 {
-		fmt.Printf("\n%d/%d feeds had errors\n", errors, len(urls))
+		fmt.Fprintf(out, "\n%d/%d feeds had errors\n", errors, len(urls))
 		return 1
 	}
 
 	if len(urls) > 1 {
-		fmt.Printf("\nAll %d feeds OK\n", len(urls))
+		fmt.Fprintf(out, "\nAll %d feeds OK\n", len(urls))
 	}
 	return 0
 }
 
-func (c *checkCmd) checkFeed(parser *gofeed.Parser, feedURL string) bool {
+func (c *checkCmd) checkFeed(parser *gofeed.Parser, feedURL string) (*gofeed.Feed, bool) {
 	feed, err := parser.ParseURL(feedURL)
 	if err != nil {
 		errStr := err.Error()
 
 		// Provide more human-friendly error messages
 		if strings.Contains(errStr, "no such host") {
-			fmt.Printf("FAIL %s\n     DNS resolution failed\n", feedURL)
+			fmt.Fprintf(out, "FAIL %s\n     DNS resolution failed\n", feedURL)
 		} else if strings.Contains(errStr, "connection refused") {
-			fmt.Printf("FAIL %s\n     Connection refused\n", feedURL)
+			fmt.Fprintf(out, "FAIL %s\n     Connection refused\n", feedURL)
 		} else if strings.Contains(errStr, "timeout") || strings.Contains(errStr, "deadline exceeded") {
-			fmt.Printf("FAIL %s\n     Timed out after %ds\n", feedURL, c.timeout)
+			fmt.Fprintf(out, "FAIL %s\n     Timed out after %ds\n", feedURL, c.timeout)
 		} else if strings.Contains(errStr, "404") {
-			fmt.Printf("FAIL %s\n     Not found (404)\n", feedURL)
+			fmt.Fprintf(out, "FAIL %s\n     Not found (404)\n", feedURL)
 		} else {
-			fmt.Printf("FAIL %s\n     %s\n", feedURL, errStr)
+			fmt.Fprintf(out, "FAIL %s\n     %s\n", feedURL, errStr)
+		}
+		return nil, false
+	}
+
+	fmt.Fprintf(out, "OK   %s\n     %s — %d items\n", feedURL, feed.Title, len(feed.Items))
+	return feed, true
+}
+
+// showDetails shows the metadata of the given feed, and the result of
+// applying any filters configured for it.
+func (c *checkCmd) showDetails(feedURL string, feed *gofeed.Feed) {
+
+	show := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(out, "     %-12s %s\n", name+":", value)
+		}
+	}
+
+	show("Description", strings.TrimSpace(feed.Description))
+	show("Language", feed.Language)
+	if feed.Image != nil {
+		show("Image", feed.Image.URL)
+	}
+
+	// Find the age-range of the items, and count enclosures.
+	var oldest, newest time.Time
+	enclosures := 0
+	for _, item := range feed.Items {
+		if len(item.Enclosures) > 0 {
+			enclosures++
+		}
+
+		date := item.PublishedParsed
+		if date == nil {
+			date = item.UpdatedParsed
+		}
+		if date == nil {
+			continue
+		}
+		if oldest.IsZero() || date.Before(oldest) {
+			oldest = *date
+		}
+		if newest.IsZero() || date.After(newest) {
+			newest = *date
+		}
+	}
+
+	if !oldest.IsZero() {
+		show("Oldest", oldest.Format(time.RFC1123))
+		show("Newest", newest.Format(time.RFC1123))
+	}
+
+	if enclosures > 0 {
+		show("Enclosures", fmt.Sprintf("yes, %d/%d items", enclosures, len(feed.Items)))
+	} else {
+		show("Enclosures", "no")
+	}
+
+	show("Namespaces", strings.Join(feedNamespaces(feed), ", "))
+
+	// Show a sample of the items.
+	for i, item := range feed.Items {
+		if i >= 3 {
+			break
+		}
+		if i == 0 {
+			fmt.Fprintf(out, "     Items:\n")
+		}
+		fmt.Fprintf(out, "       - %s\n", item.Title)
+	}
+
+	// Now apply the filters, if the feed is one we're watching.
+	entries, err := c.config.Parse()
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.URL != feedURL {
+			continue
+		}
+
+		p, err := processor.New(processor.ProcessorConfig{})
+		if err != nil {
+			logger.Error("failed to create feed processor",
+				slog.String("error", err.Error()))
+			return
+		}
+		p.SetLogger(logger)
+		pass := p.FilterItems(entry, feed.Items)
+		p.Close()
+
+		show("Filters", fmt.Sprintf("%d/%d items would be sent", len(pass), len(feed.Items)))
+		return
+	}
+
+	show("Filters", "feed is not in the configuration file")
+}
+
+// feedNamespaces returns the names of the extensions used by the feed,
+// or its items.
+func feedNamespaces(feed *gofeed.Feed) []string {
+
+	found := make(map[string]bool)
+
+	for prefix := range feed.Extensions {
+		found[prefix] = true
+	}
+	if feed.ITunesExt != nil {
+		found["itunes"] = true
+	}
+	if feed.DublinCoreExt != nil {
+		found["dc"] = true
+	}
+
+	for _, item := range feed.Items {
+		for prefix := range item.Extensions {
+			found[prefix] = true
+		}
+		if item.ITunesExt != nil {
+			found["itunes"] = true
+		}
+		if item.DublinCoreExt != nil {
+			found["dc"] = true
+		}
+	}
+
+	var names []string
+	for prefix := range found {
+		name, ok := namespaces[prefix]
+		if !ok {
+			name = prefix
 		}
-		return false
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	fmt.Printf("OK   %s\n     %s — %d items\n", feedURL, feed.Title, len(feed.Items))
-	return true
+	return names
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+func TestCheckDetails(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	out = &bytes.Buffer{}
+	defer func() { out = bak }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+<title>Test Podcast</title>
+<description>All about testing</description>
+<language>en-gb</language>
+<itunes:author>Steve</itunes:author>
+<item><title>Episode One</title><link>https://example.com/1</link>
+  <pubDate>Mon, 01 Jan 2024 10:00:00 GMT</pubDate>
+  <enclosure url="https://example.com/1.mp3" length="1" type="audio/mpeg"/></item>
+<item><title>Episode Two</title><link>https://example.com/2</link>
+  <pubDate>Tue, 02 Jan 2024 10:00:00 GMT</pubDate></item>
+<item><title>Sponsored Episode</title><link>https://example.com/3</link>
+  <pubDate>Wed, 03 Jan 2024 10:00:00 GMT</pubDate></item>
+<item><title>Episode Four</title><link>https://example.com/4</link></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	tmpfile, err := os.CreateTemp("", "example")
+	if err != nil {
+		t.Fatalf("Error creating temporary file")
+	}
+	defer os.Remove(tmpfile.Name())
+
+	err = os.WriteFile(tmpfile.Name(), []byte(ts.URL+"\n - exclude-title: Sponsored\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	c := checkCmd{timeout: 5, config: configfile.NewWithPath(tmpfile.Name())}
+	res := c.Execute([]string{ts.URL})
+	if res != 0 {
+		t.Fatalf("unexpected failure")
+	}
+
+	output := out.(*bytes.Buffer).String()
+
+	expected := []string{
+		"Test Podcast — 4 items",
+		"All about testing",
+		"en-gb",
+		"Mon, 01 Jan 2024 10:00:00 UTC",
+		"Wed, 03 Jan 2024 10:00:00 UTC",
+		"yes, 1/4 items",
+		"iTunes",
+		"- Episode One",
+		"- Sponsored Episode",
+		"3/4 items would be sent",
+	}
+	for _, txt := range expected {
+		if !strings.Contains(output, txt) {
+			t.Fatalf("Failed to find expected output '%s' in %s", txt, output)
+		}
+	}
+
+	// Only three items are shown.
+	if strings.Contains(output, "Episode Four") {
+		t.Fatalf("too many items shown")
+	}
+}
+
+func TestCheckFailure(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	out = &bytes.Buffer{}
+	defer func() { out = bak }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Not a feed")
+	}))
+	defer ts.Close()
+
+	c := checkCmd{timeout: 5}
+	res := c.Execute([]string{ts.URL})
+	if res != 1 {
+		t.Fatalf("expected failure")
+	}
+	if !strings.Contains(out.(*bytes.Buffer).String(), "FAIL") {
+		t.Fatalf("expected failure message")
+	}
+}
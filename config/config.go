@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/skx/rss2email/state"
 	"gopkg.in/yaml.v3"
@@ -25,6 +26,37 @@ type SMTPConfig struct {
 	Port     int    `yaml:"port"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+
+	// SMTPS causes the connection to use TLS from the start, as is
+	// usual on port 465, rather than being upgraded by STARTTLS.
+	SMTPS bool `yaml:"smtps"`
+
+	// Auth is the authentication mechanism, either "plain", the
+	// default, which uses the password, or "oauth2" which uses an
+	// access token obtained with the OAuth2 settings below.
+	Auth string `yaml:"smtp-auth"`
+
+	// OAuth2TokenURL is the token endpoint of the provider, such as
+	// "https://oauth2.googleapis.com/token".
+	OAuth2TokenURL string `yaml:"oauth2-token-url"`
+
+	// OAuth2ClientID and OAuth2ClientSecret identify our application
+	// to the provider.
+	OAuth2ClientID     string `yaml:"oauth2-client-id"`
+	OAuth2ClientSecret string `yaml:"oauth2-client-secret"`
+
+	// OAuth2RefreshToken is exchanged for an access token each time we
+	// connect to the server.
+	OAuth2RefreshToken string `yaml:"oauth2-refresh-token"`
+}
+
+// AuthOAuth2 is the value of SMTPConfig.Auth which selects XOAUTH2.
+const AuthOAuth2 = "oauth2"
+
+// UsesOAuth2 returns true if we authenticate with an OAuth2 access token,
+// rather than a password.
+func (s SMTPConfig) UsesOAuth2() bool {
+	return s.Auth == AuthOAuth2
 }
 
 // Config holds the top-level application configuration.
@@ -34,6 +66,58 @@ type Config struct {
 
 	// From is the default sender address.
 	From string `yaml:"from"`
+
+	// SMTPRateLimit is the maximum number of items delivered per
+	// second, across all feeds.  Zero means there is no limit.
+	SMTPRateLimit float64 `yaml:"smtp-rate-limit"`
+
+	// SMTPPoolSize is the number of SMTP connections which are kept
+	// open, and reused, between emails.  Zero means a single connection,
+	// which is reused for every email of a run.
+	SMTPPoolSize int `yaml:"smtp-connection-pool-size"`
+
+	// SMTPIdleTimeout is the time after which unused pooled
+	// connections are closed, such as "30s".
+	SMTPIdleTimeout time.Duration `yaml:"smtp-idle-timeout"`
+
+	// MaxEmailsPerRun is the number of items which are delivered by a
+	// single run, across all feeds.  Zero means there is no limit.
+	MaxEmailsPerRun int `yaml:"max-emails-per-run"`
+
+	// Proxy is the URL of the proxy feeds are fetched through, unless
+	// they have their own "proxy" option, such as
+	// "http://proxy.example.com:8080".
+	Proxy string `yaml:"proxy"`
+
+	// TemplateVariableFile is the path to a YAML file of values which
+	// are made available to email templates, as ".Vars".  Relative
+	// paths are beneath the state directory.
+	TemplateVariableFile string `yaml:"template-variable-file"`
+
+	// WebhookURL is the URL new items are POSTed to, as JSON, for
+	// feeds which don't have their own "webhook-url" option.
+	WebhookURL string `yaml:"webhook-url"`
+
+	// WebhookSecret is the key the payloads POSTed to WebhookURL are
+	// signed with, for feeds without their own "webhook-secret".
+	WebhookSecret string `yaml:"webhook-secret"`
+
+	// ListUnsubscribe causes a List-Unsubscribe header to be added to
+	// every email, so that mail clients offer to unsubscribe from it.
+	ListUnsubscribe bool `yaml:"list-unsubscribe"`
+
+	// AutoReplySuppress controls whether headers asking mail servers
+	// not to send auto-replies, such as vacation messages, are added to
+	// every email.  If it isn't set they are added.
+	AutoReplySuppress *bool `yaml:"auto-reply-suppress"`
+
+	// GDriveCredentialsFile is the path to the Google service account
+	// key used by the gdrive output.
+	GDriveCredentialsFile string `yaml:"gdrive-credentials-file"`
+
+	// GDriveDelay is the minimum time between uploads to Google Drive,
+	// such as "1s".
+	GDriveDelay time.Duration `yaml:"gdrive-delay"`
 }
 
 // path is the resolved config file path, stored after Load.
@@ -87,20 +171,60 @@ func LoadFrom(filePath string) (*Config, error) {
 	return cfg, nil
 }
 
+// TemplateVariables reads the file named by TemplateVariableFile, and
+// returns the values it contains.
+//
+// If no file is configured the result is empty, rather than nil.
+func (c *Config) TemplateVariables() (map[string]any, error) {
+	vars := make(map[string]any)
+
+	if c.TemplateVariableFile == "" {
+		return vars, nil
+	}
+
+	file := c.TemplateVariableFile
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(state.Directory(), file)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return vars, err
+	}
+
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return make(map[string]any), fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	// An empty file leaves us with a nil map.
+	if vars == nil {
+		vars = make(map[string]any)
+	}
+
+	return vars, nil
+}
+
 // applyEnvDefaults fills any unset fields from environment variables.
 func (c *Config) applyEnvDefaults() {
 	if c.SMTP.Host == "" {
 		c.SMTP.Host = os.Getenv("SMTP_HOST")
 	}
+	if !c.SMTP.SMTPS {
+		c.SMTP.SMTPS, _ = strconv.ParseBool(os.Getenv("SMTP_SMTPS"))
+	}
 	if c.SMTP.Port == 0 {
 		if p := os.Getenv("SMTP_PORT"); p != "" {
 			if n, err := strconv.Atoi(p); err == nil {
 				c.SMTP.Port = n
 			}
 		}
-		// Default port
+		// Default port, which depends upon whether we're using
+		// STARTTLS or SMTPS.
 		if c.SMTP.Port == 0 {
 			c.SMTP.Port = 587
+			if c.SMTP.SMTPS {
+				c.SMTP.Port = 465
+			}
 		}
 	}
 	if c.SMTP.Username == "" {
@@ -114,10 +238,16 @@ func (c *Config) applyEnvDefaults() {
 	}
 }
 
+// SuppressAutoReplies returns true if the headers which suppress
+// auto-replies should be added to our emails, which is the default.
+func (c *Config) SuppressAutoReplies() bool {
+	return c.AutoReplySuppress == nil || *c.AutoReplySuppress
+}
+
 // HasSMTP returns true if enough SMTP configuration is present to
 // attempt direct SMTP delivery.
 func (c *Config) HasSMTP() bool {
-	return c.SMTP.Host != "" && c.SMTP.Username != "" && c.SMTP.Password != ""
+	return c.SMTP.Host != "" && c.SMTP.Username != "" && (c.SMTP.Password != "" || c.SMTP.UsesOAuth2())
 }
 
 // Validate checks the configuration for obvious problems.
@@ -130,12 +260,45 @@ func (c *Config) Validate() []string {
 	if c.SMTP.Username == "" {
 		issues = append(issues, "smtp.username is not configured (set in config.yaml or SMTP_USERNAME env)")
 	}
-	if c.SMTP.Password == "" {
-		issues = append(issues, "smtp.password is not configured (set in config.yaml or SMTP_PASSWORD env)")
+	switch c.SMTP.Auth {
+	case "", "plain":
+		if c.SMTP.Password == "" {
+			issues = append(issues, "smtp.password is not configured (set in config.yaml or SMTP_PASSWORD env)")
+		}
+	case AuthOAuth2:
+		// Everything is required to obtain an access token.
+		for _, setting := range []struct{ name, value string }{
+			{"oauth2-token-url", c.SMTP.OAuth2TokenURL},
+			{"oauth2-client-id", c.SMTP.OAuth2ClientID},
+			{"oauth2-client-secret", c.SMTP.OAuth2ClientSecret},
+			{"oauth2-refresh-token", c.SMTP.OAuth2RefreshToken},
+		} {
+			if setting.value == "" {
+				issues = append(issues, fmt.Sprintf("smtp.%s is not configured (required by smtp-auth: oauth2)", setting.name))
+			}
+		}
+	default:
+		issues = append(issues, fmt.Sprintf("smtp.smtp-auth %q is invalid (must be plain or oauth2)", c.SMTP.Auth))
 	}
 	if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
 		issues = append(issues, fmt.Sprintf("smtp.port %d is invalid (must be 1-65535)", c.SMTP.Port))
 	}
+	if c.SMTPRateLimit < 0 {
+		issues = append(issues, fmt.Sprintf("smtp-rate-limit %g is invalid (must not be negative)", c.SMTPRateLimit))
+	}
+	if c.SMTPPoolSize < 0 {
+		issues = append(issues, fmt.Sprintf("smtp-connection-pool-size %d is invalid (must not be negative)", c.SMTPPoolSize))
+	}
+	if c.MaxEmailsPerRun < 0 {
+		issues = append(issues, fmt.Sprintf("max-emails-per-run %d is invalid (must not be negative)", c.MaxEmailsPerRun))
+	}
+
+	if c.GDriveDelay < 0 {
+		issues = append(issues, fmt.Sprintf("gdrive-delay %s is invalid (must not be negative)", c.GDriveDelay))
+	}
+	if c.SMTPIdleTimeout < 0 {
+		issues = append(issues, fmt.Sprintf("smtp-idle-timeout %s is invalid (must not be negative)", c.SMTPIdleTimeout))
+	}
 
 	return issues
 }
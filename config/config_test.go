@@ -3,7 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/skx/rss2email/state"
 )
 
 func TestLoadFromFile(t *testing.T) {
@@ -143,6 +147,7 @@ func TestDefaultPort(t *testing.T) {
 
 	// No file, no env — should default to 587
 	t.Setenv("SMTP_PORT", "")
+	t.Setenv("SMTP_SMTPS", "")
 
 	cfg, err := LoadFrom(cfgPath)
 	if err != nil {
@@ -153,6 +158,48 @@ func TestDefaultPort(t *testing.T) {
 	}
 }
 
+func TestSMTPS(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+
+	t.Setenv("SMTP_PORT", "")
+	t.Setenv("SMTP_SMTPS", "")
+
+	// SMTPS defaults to port 465.
+	if err := os.WriteFile(cfgPath, []byte("smtp:\n  host: smtp.example.com\n  smtps: true\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	cfg, err := LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if !cfg.SMTP.SMTPS || cfg.SMTP.Port != 465 {
+		t.Errorf("expected SMTPS on port 465, got %v on %d", cfg.SMTP.SMTPS, cfg.SMTP.Port)
+	}
+
+	// An explicit port is kept.
+	if err := os.WriteFile(cfgPath, []byte("smtp:\n  host: smtp.example.com\n  port: 2465\n  smtps: true\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	cfg, err = LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if cfg.SMTP.Port != 2465 {
+		t.Errorf("expected port 2465, got %d", cfg.SMTP.Port)
+	}
+
+	// The environment may enable it too.
+	t.Setenv("SMTP_SMTPS", "true")
+	cfg, err = LoadFrom(filepath.Join(dir, "missing.yaml"))
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if !cfg.SMTP.SMTPS || cfg.SMTP.Port != 465 {
+		t.Errorf("expected SMTPS on port 465, got %v on %d", cfg.SMTP.SMTPS, cfg.SMTP.Port)
+	}
+}
+
 func TestInvalidYAML(t *testing.T) {
 	dir := t.TempDir()
 	cfgPath := filepath.Join(dir, "config.yaml")
@@ -166,3 +213,220 @@ func TestInvalidYAML(t *testing.T) {
 		t.Error("expected error for invalid YAML")
 	}
 }
+
+func TestRateLimit(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+
+	if err := os.WriteFile(cfgPath, []byte("smtp-rate-limit: 0.5\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if cfg.SMTPRateLimit != 0.5 {
+		t.Errorf("expected rate limit 0.5, got %g", cfg.SMTPRateLimit)
+	}
+
+	cfg.SMTPRateLimit = -1
+	found := false
+	for _, issue := range cfg.Validate() {
+		if strings.Contains(issue, "smtp-rate-limit") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected negative rate limit to be reported")
+	}
+}
+
+func TestMaxEmailsPerRun(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+
+	if err := os.WriteFile(cfgPath, []byte("max-emails-per-run: 50\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if cfg.MaxEmailsPerRun != 50 {
+		t.Errorf("expected a limit of 50, got %d", cfg.MaxEmailsPerRun)
+	}
+
+	cfg.MaxEmailsPerRun = -1
+	found := false
+	for _, issue := range cfg.Validate() {
+		if strings.Contains(issue, "max-emails-per-run") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a negative limit to be reported")
+	}
+}
+
+func TestListUnsubscribe(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+
+	if err := os.WriteFile(cfgPath, []byte("list-unsubscribe: true\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if !cfg.ListUnsubscribe {
+		t.Errorf("expected list-unsubscribe to be enabled")
+	}
+}
+
+func TestAutoReplySuppress(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+
+	// Auto-replies are suppressed unless we're told otherwise.
+	cfg, err := LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if !cfg.SuppressAutoReplies() {
+		t.Errorf("expected auto-replies to be suppressed by default")
+	}
+
+	if err := os.WriteFile(cfgPath, []byte("auto-reply-suppress: false\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err = LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if cfg.SuppressAutoReplies() {
+		t.Errorf("expected auto-reply-suppress: false to be respected")
+	}
+}
+
+func TestConnectionPool(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+
+	if err := os.WriteFile(cfgPath, []byte("smtp-connection-pool-size: 3\nsmtp-idle-timeout: 45s\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if cfg.SMTPPoolSize != 3 {
+		t.Errorf("expected pool size 3, got %d", cfg.SMTPPoolSize)
+	}
+	if cfg.SMTPIdleTimeout != 45*time.Second {
+		t.Errorf("expected idle timeout 45s, got %s", cfg.SMTPIdleTimeout)
+	}
+
+	cfg.SMTPPoolSize = -1
+	cfg.SMTPIdleTimeout = -time.Second
+	found := 0
+	for _, issue := range cfg.Validate() {
+		if strings.Contains(issue, "smtp-connection-pool-size") || strings.Contains(issue, "smtp-idle-timeout") {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("expected negative pool settings to be reported")
+	}
+}
+
+func TestTemplateVariables(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+
+	// No file configured means no variables.
+	cfg := &Config{}
+	vars, err := cfg.TemplateVariables()
+	if err != nil || vars == nil || len(vars) != 0 {
+		t.Fatalf("expected empty variables, got %v %v", vars, err)
+	}
+
+	// Relative paths are beneath the state directory.
+	if err := os.MkdirAll(state.Directory(), 0755); err != nil {
+		t.Fatalf("failed to create state directory: %v", err)
+	}
+	content := "signature: Steve\ncompany: Example Ltd\n"
+	if err := os.WriteFile(filepath.Join(state.Directory(), "vars.yaml"), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write variables: %v", err)
+	}
+
+	cfg.TemplateVariableFile = "vars.yaml"
+	vars, err = cfg.TemplateVariables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vars["signature"] != "Steve" || vars["company"] != "Example Ltd" || len(vars) != 2 {
+		t.Fatalf("unexpected variables %v", vars)
+	}
+
+	// A missing file is an error, but still gives us an empty set.
+	cfg.TemplateVariableFile = filepath.Join(dir, "missing.yaml")
+	vars, err = cfg.TemplateVariables()
+	if err == nil || vars == nil || len(vars) != 0 {
+		t.Fatalf("expected error for missing file, got %v %v", vars, err)
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.yaml")
+
+	t.Setenv("SMTP_PASSWORD", "")
+
+	content := `smtp:
+  host: smtp.gmail.com
+  username: user@gmail.com
+  smtp-auth: oauth2
+  oauth2-token-url: https://oauth2.googleapis.com/token
+  oauth2-client-id: id
+  oauth2-client-secret: secret
+  oauth2-refresh-token: refresh
+`
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	cfg, err := LoadFrom(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+	if !cfg.SMTP.UsesOAuth2() || cfg.SMTP.OAuth2TokenURL != "https://oauth2.googleapis.com/token" || cfg.SMTP.OAuth2RefreshToken != "refresh" {
+		t.Errorf("unexpected SMTP settings %+v", cfg.SMTP)
+	}
+
+	// No password is needed.
+	if !cfg.HasSMTP() {
+		t.Errorf("expected SMTP to be configured without a password")
+	}
+	if issues := cfg.Validate(); len(issues) != 0 {
+		t.Errorf("unexpected issues %v", issues)
+	}
+
+	// But the OAuth2 settings are.
+	cfg.SMTP.OAuth2RefreshToken = ""
+	issues := cfg.Validate()
+	if len(issues) != 1 || !strings.Contains(issues[0], "oauth2-refresh-token") {
+		t.Errorf("expected the missing refresh token to be reported, got %v", issues)
+	}
+
+	// Unknown mechanisms are reported.
+	cfg.SMTP.Auth = "cram-md5"
+	issues = cfg.Validate()
+	if len(issues) != 1 || !strings.Contains(issues[0], "cram-md5") {
+		t.Errorf("expected the unknown mechanism to be reported, got %v", issues)
+	}
+}
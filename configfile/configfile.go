@@ -12,14 +12,26 @@
 //
 // It is assumed lines contain URLs, but anything prefixed with a "-"
 // is taken to be a parameter using a colon-deliminator.
+//
+// Options given beneath the special URL "default:" apply to every feed,
+// unless a feed sets the same option itself:
+//
+//	default:
+//	 - exclude-older:30
+//	 - from:rss2email@example.com
 package configfile
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/skx/rss2email/state"
@@ -32,36 +44,138 @@ import (
 // for example.
 type Option struct {
 	// Name holds the name of the configuration option.
-	Name string
+	Name string `json:"name" yaml:"name"`
 
 	// Value contains the specified value of the configuration option.
-	Value string
+	Value string `json:"value" yaml:"value"`
 }
 
 // Feed is an entry which is read from our configuration-file.
 //
 // A feed consists of an URL pointing to an Atom/RSS feed, as well as
 // an optional set of parameters which are specific to that feed.
+//
+// The struct tags allow a Feed to be serialized, or embedded within
+// other types which are.
 type Feed struct {
 	// URL is the URL of an Atom/RSS feed.
-	URL string
+	URL string `json:"url" yaml:"url"`
 
 	// Options contains a collection of any optional parameters
 	// which have been read after an URL
-	Options []Option
+	Options []Option `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// String returns a summary of the feed, for debugging.
+func (f Feed) String() string {
+	return fmt.Sprintf("Feed{URL: %s, Options: %d}", f.URL, len(f.Options))
 }
 
+// HasOption returns true if the feed has at least one option with the
+// given name.
+func (f Feed) HasOption(name string) bool {
+	for _, opt := range f.Options {
+		if opt.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOption returns the value of the option with the given name, or the
+// given default if the feed doesn't have one.
+//
+// If the option is given more than once the last value is returned, as
+// that is the one which takes effect for options with a single value.
+func (f Feed) GetOption(name string, defaultValue string) string {
+	value := defaultValue
+	for _, opt := range f.Options {
+		if opt.Name == name {
+			value = opt.Value
+		}
+	}
+	return value
+}
+
+// GetAllOptions returns the values of every option with the given name,
+// in the order they were given, for options which may be given more than
+// once such as "exclude".  The result is nil if there are none.
+func (f Feed) GetAllOptions(name string) []string {
+	var values []string
+	for _, opt := range f.Options {
+		if opt.Name == name {
+			values = append(values, opt.Value)
+		}
+	}
+	return values
+}
+
+// Fingerprint returns a hash of the feed's URL and options, which changes
+// whenever any of them do, but not if the options are merely reordered.
+func (f Feed) Fingerprint() string {
+
+	opts := make([]Option, len(f.Options))
+	copy(opts, f.Options)
+	sort.Slice(opts, func(i, j int) bool {
+		if opts[i].Name != opts[j].Name {
+			return opts[i].Name < opts[j].Name
+		}
+		return opts[i].Value < opts[j].Value
+	})
+
+	// Each value is length-prefixed, so that they can't run into
+	// each other.
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s", len(f.URL), f.URL)
+	for _, opt := range opts {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(opt.Name), opt.Name, len(opt.Value), opt.Value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DefaultsURL is the special URL beneath which options that apply to
+// every feed are given.
+const DefaultsURL = "default:"
+
 // ConfigFile contains our state.
 type ConfigFile struct {
 
 	// Path contains the path to our config file
 	path string
 
-	// The entries we found.
+	// The entries we found, with only the options given beneath
+	// each of them.
 	entries []Feed
 
+	// The options given beneath DefaultsURL, which apply to every
+	// feed.
+	defaults []Option
+
+	// The lines of the file upon which each feed, including any
+	// defaults, was found.
+	spans []lineSpan
+
 	// Key:value regular expression
 	re *regexp.Regexp
+
+	// logger is used to report problems which can't be returned
+	// to the caller, such as failures when reloading via Watch.
+	logger *slog.Logger
+}
+
+// lineSpan records the lines of the file holding a feed, from its URL up
+// to, and including, the last line of its options.
+type lineSpan struct {
+
+	// url is the URL of the feed.
+	url string
+
+	// start is the index of the line containing the URL.
+	start int
+
+	// end is the index of the line following the last option.
+	end int
 }
 
 // New creates a new configuration-file reader.
@@ -95,11 +209,150 @@ func (c *ConfigFile) Path() string {
 	return c.path
 }
 
+// SetLogger sets the logging handle used to report problems which
+// cannot be returned directly, such as errors encountered by Watch.
+func (c *ConfigFile) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Entries returns a copy of the feeds found by the most recent call
+// to Parse, with the default options applied to them.
+func (c *ConfigFile) Entries() []Feed {
+	out := make([]Feed, len(c.entries))
+	for i, entry := range c.entries {
+		out[i] = c.withDefaults(entry)
+	}
+	return out
+}
+
+// Defaults returns a copy of the options, found by the most recent call
+// to Parse, which were given beneath DefaultsURL.
+func (c *ConfigFile) Defaults() []Option {
+	return append([]Option(nil), c.defaults...)
+}
+
+// withDefaults returns a copy of the feed, with the default options
+// applied to it.
+//
+// Options which may be given more than once, such as "exclude", are added
+// to those of the feed.  Any other default is used only if the feed
+// doesn't set that option itself.
+func (c *ConfigFile) withDefaults(feed Feed) Feed {
+
+	set := make(map[string]bool)
+	for _, opt := range feed.Options {
+		set[opt.Name] = true
+	}
+
+	options := append([]Option(nil), feed.Options...)
+	for _, opt := range c.defaults {
+		if opt.IsRepeatable() || !set[opt.Name] {
+			options = append(options, opt)
+		}
+	}
+
+	return Feed{URL: feed.URL, Options: options}
+}
+
+// FindFeedByOption returns a copy of each feed, found by the most recent
+// call to Parse, which has an option with exactly the given name and value.
+func (c *ConfigFile) FindFeedByOption(name, value string) []Feed {
+	return c.findFeeds(func(opt Option) bool {
+		return opt.Name == name && opt.Value == value
+	})
+}
+
+// FindFeedsByOptionName returns a copy of each feed, found by the most
+// recent call to Parse, which has an option with the given name, whatever
+// its value.
+func (c *ConfigFile) FindFeedsByOptionName(name string) []Feed {
+	return c.findFeeds(func(opt Option) bool {
+		return opt.Name == name
+	})
+}
+
+// findFeeds returns a copy of each feed which has an option matching the
+// given function.
+func (c *ConfigFile) findFeeds(match func(Option) bool) []Feed {
+
+	var found []Feed
+
+	for _, entry := range c.Entries() {
+		for _, opt := range entry.Options {
+			if match(opt) {
+				found = append(found, entry)
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+// blockDelimiter surrounds option values which span multiple lines.
+const blockDelimiter = "```"
+
+// continues returns true if the given line ends with a backslash, meaning
+// that the value continues on the next line.
+//
+// A backslash which is itself escaped, as in a regular expression ending
+// with "\\", doesn't count.
+func continues(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// dedent joins the lines of a block, after removing the indentation they
+// have in common, along with any blank lines at the start and end.
+func dedent(lines []string) string {
+
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	var out []string
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if len(line) >= indent {
+			line = line[indent:]
+		} else {
+			line = ""
+		}
+		out = append(out, line)
+	}
+
+	return strings.Trim(strings.Join(out, "\n"), "\n")
+}
+
 // Parse returns the entries from the config-file
+//
+// Options are usually written on a single line, but a long value may be
+// continued onto the following lines by ending each line but the last
+// with a backslash, the lines being joined without any whitespace:
+//
+//	https://example.com/feed.xml
+//	 - exclude: (?i)(sponsored|\
+//	            advert)
+//
+// A value may also be written as a block, beginning and ending with a line
+// of three backticks, in which case the lines are joined with newlines
+// after their common indentation is removed.
+//
+// The options given beneath DefaultsURL are applied to each of the feeds
+// which are returned, as described by Entries.
 func (c *ConfigFile) Parse() ([]Feed, error) {
 
 	// Remove all existing entries
 	c.entries = []Feed{}
+	c.defaults = nil
+	c.spans = nil
 
 	// Open the file
 	file, err := os.Open(c.Path())
@@ -115,12 +368,52 @@ func (c *ConfigFile) Parse() ([]Feed, error) {
 	// Create a scanner to process the file.
 	scanner := bufio.NewScanner(file)
 
+	// An option whose value continues onto the following lines, either
+	// via a trailing backslash or a ``` block, is held here until it
+	// is complete.
+	var pending *Option
+	block := false
+	var blockLines []string
+
+	// The lines of the feed we're reading.
+	var span lineSpan
+
 	// Scan line by line
-	for scanner.Scan() {
+	for n := 0; scanner.Scan(); n++ {
 
 		// Get the line, and strip leading/trailing space
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		// Within a block every line is part of the value, until
+		// we find the closing delimiter.
+		if block {
+			if line == blockDelimiter {
+				pending.Value = dedent(blockLines)
+				tmp.Options = append(tmp.Options, *pending)
+				pending = nil
+				block = false
+				blockLines = nil
+			} else {
+				blockLines = append(blockLines, raw)
+			}
+			span.end = n + 1
+			continue
+		}
+
+		// A line following a backslash is appended to the value,
+		// and might itself be continued.
+		if pending != nil {
+			if continues(line) {
+				pending.Value += strings.TrimSpace(strings.TrimSuffix(line, `\`))
+			} else {
+				pending.Value += line
+				tmp.Options = append(tmp.Options, *pending)
+				pending = nil
+			}
+			span.end = n + 1
+			continue
+		}
 
 		// skip comments
 		if strings.HasPrefix(line, "#") {
@@ -135,6 +428,8 @@ func (c *ConfigFile) Parse() ([]Feed, error) {
 				return c.entries, fmt.Errorf("error: option outside a URL: %s", scanner.Text())
 			}
 
+			span.end = n + 1
+
 			// Remove the prefix and split by ":"
 			line = strings.TrimPrefix(line, "-")
 
@@ -145,7 +440,18 @@ func (c *ConfigFile) Parse() ([]Feed, error) {
 			if len(fields) == 3 {
 				key := strings.TrimSpace(fields[1])
 				val := strings.TrimSpace(fields[2])
-				tmp.Options = append(tmp.Options, Option{Name: key, Value: val})
+				opt := Option{Name: key, Value: val}
+
+				switch {
+				case val == blockDelimiter:
+					pending = &opt
+					block = true
+				case continues(val):
+					opt.Value = strings.TrimSpace(strings.TrimSuffix(val, `\`))
+					pending = &opt
+				default:
+					tmp.Options = append(tmp.Options, opt)
+				}
 			} else {
 				// If we have an URL show it, to help identify the section which is broken
 				if tmp.URL != "" {
@@ -160,18 +466,30 @@ func (c *ConfigFile) Parse() ([]Feed, error) {
 			// it and reset our temporary structure
 			if tmp.URL != "" {
 				// store it, and reset our map
-				c.entries = append(c.entries, tmp)
+				c.store(tmp)
+				c.spans = append(c.spans, span)
 				tmp.Options = []Option{}
 			}
 
 			// set the url
 			tmp.URL = line
+			span = lineSpan{url: line, start: n, end: n + 1}
 		}
 	}
 
+	// A block must be closed, but a backslash on the last line is
+	// harmless.
+	if block {
+		return c.entries, fmt.Errorf("unterminated %s block for option '%s', beneath feed %s", blockDelimiter, pending.Name, tmp.URL)
+	}
+	if pending != nil {
+		tmp.Options = append(tmp.Options, *pending)
+	}
+
 	// Ensure we don't forget about the last item in the file.
 	if tmp.URL != "" {
-		c.entries = append(c.entries, tmp)
+		c.store(tmp)
+		c.spans = append(c.spans, span)
 	}
 
 	// Look for scanner-errors
@@ -179,7 +497,17 @@ func (c *ConfigFile) Parse() ([]Feed, error) {
 		return c.entries, err
 	}
 
-	return c.entries, nil
+	return c.Entries(), nil
+}
+
+// store records a feed which has been parsed, or its options as our
+// defaults if it is DefaultsURL.
+func (c *ConfigFile) store(feed Feed) {
+	if feed.URL == DefaultsURL {
+		c.defaults = append(c.defaults, feed.Options...)
+		return
+	}
+	c.entries = append(c.entries, feed)
 }
 
 // Add appends the given URIs to the config-file
@@ -230,17 +558,41 @@ func (c *ConfigFile) Save() error {
 		return err
 	}
 
+	// The defaults come first, so that they're not mistaken for the
+	// options of a feed.
+	entries := c.entries
+	if len(c.defaults) > 0 {
+		entries = append([]Feed{{URL: DefaultsURL, Options: c.defaults}}, entries...)
+	}
+
 	// For each entry do the necessary
-	for _, entry := range c.entries {
+	for _, entry := range entries {
+		writeFeed(file, entry)
+	}
 
-		fmt.Fprintf(file, "%s\n", entry.URL)
+	err = file.Close()
+	return err
+}
 
-		for _, opt := range entry.Options {
-			fmt.Fprintf(file, " - %s:%s\n", opt.Name, opt.Value)
+// writeFeed writes the URL of the given feed, followed by its options, in
+// the form which Parse reads.
+func writeFeed(w io.Writer, entry Feed) {
+
+	fmt.Fprintf(w, "%s\n", entry.URL)
+
+	for _, opt := range entry.Options {
+
+		// Values which can't be written on a single line
+		// are written as a block.
+		if strings.Contains(opt.Value, "\n") || continues(opt.Value) {
+			fmt.Fprintf(w, " - %s:%s\n", opt.Name, blockDelimiter)
+			for _, line := range strings.Split(opt.Value, "\n") {
+				fmt.Fprintf(w, "   %s\n", line)
+			}
+			fmt.Fprintf(w, "   %s\n", blockDelimiter)
+			continue
 		}
 
+		fmt.Fprintf(w, " - %s:%s\n", opt.Name, opt.Value)
 	}
-
-	err = file.Close()
-	return err
 }
@@ -1,9 +1,12 @@
 package configfile
 
 import (
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 // Test the default path works
@@ -194,6 +197,110 @@ http://example.com/
 	os.Remove(c.path)
 }
 
+// TestContinuation tests options continued with a trailing backslash.
+func TestContinuation(t *testing.T) {
+
+	c := ParserHelper(t, `
+http://example.com/
+ - exclude: foo \
+bar
+ - include-title: (?i)(one|\
+     two|\
+     three)
+ - exclude-title: literal\\
+ - tag: after
+http://example.net/
+ - exclude: end \
+   of \`)
+
+	out, err := c.Parse()
+	if err != nil {
+		t.Fatalf("Error parsing file: %v", err)
+	}
+	defer os.Remove(c.path)
+
+	if len(out) != 2 {
+		t.Fatalf("parsed wrong number of entries, got %d\n%v", len(out), out)
+	}
+
+	expected := []Option{
+		{Name: "exclude", Value: "foobar"},
+		{Name: "include-title", Value: "(?i)(one|two|three)"},
+		{Name: "exclude-title", Value: `literal\\`},
+		{Name: "tag", Value: "after"},
+	}
+	if len(out[0].Options) != len(expected) {
+		t.Fatalf("unexpected options %v", out[0].Options)
+	}
+	for i, opt := range expected {
+		if out[0].Options[i] != opt {
+			t.Fatalf("unexpected option %v, expected %v", out[0].Options[i], opt)
+		}
+	}
+
+	// A continuation at the end of the file is harmless.
+	if len(out[1].Options) != 1 || out[1].Options[0].Value != "endof" {
+		t.Fatalf("unexpected options %v", out[1].Options)
+	}
+}
+
+// TestBlockOption tests options with values spanning multiple lines.
+func TestBlockOption(t *testing.T) {
+
+	c := ParserHelper(t, "http://example.com/\n"+
+		" - exclude:```\n"+
+		"\n"+
+		"            (?i)sponsored\n"+
+		"                # not a comment\n"+
+		"\n"+
+		"            - not an option\n"+
+		"       ```\n"+
+		" - tag: after\n")
+
+	out, err := c.Parse()
+	if err != nil {
+		t.Fatalf("Error parsing file: %v", err)
+	}
+	defer os.Remove(c.path)
+
+	if len(out) != 1 || len(out[0].Options) != 2 {
+		t.Fatalf("unexpected entries %v", out)
+	}
+
+	value := "(?i)sponsored\n    # not a comment\n\n- not an option"
+	if out[0].Options[0].Value != value {
+		t.Fatalf("unexpected value %q", out[0].Options[0].Value)
+	}
+	if out[0].Options[1].Value != "after" {
+		t.Fatalf("unexpected option %v", out[0].Options[1])
+	}
+
+	// Saving and reloading gives the same values.
+	out[0].Options = append(out[0].Options, Option{Name: "exclude-title", Value: `ends\`})
+	c.entries = out
+	err = c.Save()
+	if err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+	again, err := c.Parse()
+	if err != nil {
+		t.Fatalf("Error parsing file: %v", err)
+	}
+	for i, opt := range out[0].Options {
+		if again[0].Options[i] != opt {
+			t.Fatalf("unexpected option %v, expected %v", again[0].Options[i], opt)
+		}
+	}
+
+	// A block must be closed.
+	broken := ParserHelper(t, "http://example.com/\n - exclude: ```\n   foo\n")
+	defer os.Remove(broken.path)
+	_, err = broken.Parse()
+	if err == nil || !strings.Contains(err.Error(), "unterminated") {
+		t.Fatalf("expected error with unterminated block, got %v", err)
+	}
+}
+
 // TestBrokenOptions looks for options outside an URL
 func TestBrokenOptions(t *testing.T) {
 
@@ -392,3 +499,262 @@ func ParserHelper(t *testing.T, content string) *ConfigFile {
 
 	return c
 }
+
+// TestFeedSerialization ensures feeds can be embedded in other types, and
+// round-tripped via JSON and YAML.
+func TestFeedSerialization(t *testing.T) {
+
+	type Custom struct {
+		Feed  `yaml:",inline"`
+		Owner string `json:"owner" yaml:"owner"`
+	}
+
+	in := Custom{
+		Feed: Feed{
+			URL:     "https://example.com/",
+			Options: []Option{{Name: "tag", Value: "foo"}},
+		},
+		Owner: "steve",
+	}
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("failed to marshal JSON: %s", err)
+	}
+	if string(data) != `{"url":"https://example.com/","options":[{"name":"tag","value":"foo"}],"owner":"steve"}` {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+
+	var out Custom
+	err = json.Unmarshal(data, &out)
+	if err != nil {
+		t.Fatalf("failed to unmarshal JSON: %s", err)
+	}
+	if out.URL != in.URL || len(out.Options) != 1 || out.Options[0] != in.Options[0] || out.Owner != in.Owner {
+		t.Fatalf("JSON round-trip failed: %v", out)
+	}
+
+	data, err = yaml.Marshal(in)
+	if err != nil {
+		t.Fatalf("failed to marshal YAML: %s", err)
+	}
+
+	out = Custom{}
+	err = yaml.Unmarshal(data, &out)
+	if err != nil {
+		t.Fatalf("failed to unmarshal YAML: %s", err)
+	}
+	if out.URL != in.URL || len(out.Options) != 1 || out.Options[0] != in.Options[0] || out.Owner != in.Owner {
+		t.Fatalf("YAML round-trip failed: %s", data)
+	}
+}
+
+// TestFeedString tests our debugging output.
+func TestFeedString(t *testing.T) {
+
+	f := Feed{URL: "https://example.com/", Options: []Option{{Name: "a"}, {Name: "b"}}}
+	if f.String() != "Feed{URL: https://example.com/, Options: 2}" {
+		t.Fatalf("unexpected output: %s", f.String())
+	}
+}
+
+// TestFeedOptions tests looking up the options of a feed.
+func TestFeedOptions(t *testing.T) {
+
+	f := Feed{URL: "https://example.com/", Options: []Option{
+		{Name: "exclude", Value: "one"},
+		{Name: "from", Value: "first@example.com"},
+		{Name: "exclude", Value: "two"},
+		{Name: "from", Value: "feed@example.com"},
+		{Name: "tag", Value: ""},
+	}}
+
+	// Present options, including those with empty values.
+	if !f.HasOption("from") || !f.HasOption("tag") || f.HasOption("notify") {
+		t.Fatalf("unexpected HasOption results")
+	}
+	if f.GetOption("from", "global@example.com") != "feed@example.com" {
+		t.Fatalf("unexpected from %s", f.GetOption("from", "global@example.com"))
+	}
+	if f.GetOption("tag", "default") != "" {
+		t.Fatalf("an empty value should replace the default")
+	}
+
+	// Absent options give the default.
+	if f.GetOption("notify", "user@example.com") != "user@example.com" {
+		t.Fatalf("expected the default for a missing option")
+	}
+
+	// Multiple values are returned in order.
+	if strings.Join(f.GetAllOptions("exclude"), ",") != "one,two" {
+		t.Fatalf("unexpected values %v", f.GetAllOptions("exclude"))
+	}
+	if f.GetAllOptions("notify") != nil {
+		t.Fatalf("expected no values for a missing option")
+	}
+
+	// A feed without options has none of them.
+	empty := Feed{URL: "https://example.com/"}
+	if empty.HasOption("exclude") || empty.GetOption("from", "x") != "x" || len(empty.GetAllOptions("exclude")) != 0 {
+		t.Fatalf("unexpected options for a feed without any")
+	}
+}
+
+// TestFeedFingerprint tests that fingerprints change with the options.
+func TestFeedFingerprint(t *testing.T) {
+
+	f := Feed{URL: "https://example.com/", Options: []Option{{Name: "tag", Value: "news"}, {Name: "exclude", Value: "x"}}}
+
+	// Reordering the options makes no difference.
+	reordered := Feed{URL: f.URL, Options: []Option{f.Options[1], f.Options[0]}}
+	if f.Fingerprint() != reordered.Fingerprint() || len(f.Fingerprint()) != 64 {
+		t.Fatalf("unexpected fingerprints %s %s", f.Fingerprint(), reordered.Fingerprint())
+	}
+
+	// Anything else does.
+	others := []Feed{
+		{URL: "https://example.com/other", Options: f.Options},
+		{URL: f.URL, Options: f.Options[:1]},
+		{URL: f.URL, Options: []Option{{Name: "tag", Value: "news"}, {Name: "exclude", Value: "y"}}},
+		{URL: f.URL, Options: []Option{{Name: "tag", Value: "newsexclude"}, {Name: "", Value: "x"}}},
+	}
+	for _, o := range others {
+		if o.Fingerprint() == f.Fingerprint() {
+			t.Fatalf("%v has the same fingerprint as %v", o, f)
+		}
+	}
+}
+
+// TestFindFeeds tests finding feeds by their options.
+func TestFindFeeds(t *testing.T) {
+
+	c := ParserHelper(t, `https://example.com/
+ - include-category: tech
+ - exclude-older: 7
+https://example.net/
+ - include-category: food
+https://example.org/
+ - exclude-older: 30
+`)
+	defer os.Remove(c.path)
+
+	_, err := c.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %s", err)
+	}
+
+	found := c.FindFeedByOption("include-category", "tech")
+	if len(found) != 1 || found[0].URL != "https://example.com/" {
+		t.Fatalf("unexpected result %v", found)
+	}
+
+	found = c.FindFeedsByOptionName("exclude-older")
+	if len(found) != 2 || found[0].URL != "https://example.com/" || found[1].URL != "https://example.org/" {
+		t.Fatalf("unexpected result %v", found)
+	}
+
+	if len(c.FindFeedByOption("include-category", "sport")) != 0 {
+		t.Fatalf("unexpected match")
+	}
+	if len(c.FindFeedsByOptionName("tag")) != 0 {
+		t.Fatalf("unexpected match")
+	}
+
+	// The results are copies.
+	found[0].Options[0].Value = "changed"
+	if c.Entries()[0].Options[0].Value != "tech" {
+		t.Fatalf("modifying the result changed the configuration")
+	}
+}
+
+// TestDefaults tests that the options given beneath DefaultsURL are
+// applied to every feed, unless the feed overrides them.
+func TestDefaults(t *testing.T) {
+
+	c := ParserHelper(t, `default:
+ - exclude-older: 30
+ - from: rss@example.com
+ - exclude: (?i)sponsored
+ - colour: blue
+
+https://example.com/
+ - exclude: (?i)advert
+https://example.net/
+ - exclude-older: 7
+`)
+	defer os.Remove(c.path)
+
+	out, err := c.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %s", err)
+	}
+
+	// The defaults aren't a feed.
+	if len(out) != 2 {
+		t.Fatalf("parsed wrong number of entries, got %d\n%v", len(out), out)
+	}
+
+	values := func(feed Feed, name string) string {
+		var found []string
+		for _, opt := range feed.Options {
+			if opt.Name == name {
+				found = append(found, opt.Value)
+			}
+		}
+		return strings.Join(found, ",")
+	}
+
+	tests := []struct {
+		feed   int
+		name   string
+		values string
+	}{
+		// Inherited.
+		{0, "exclude-older", "30"},
+		{0, "from", "rss@example.com"},
+
+		// Multiple values are added together.
+		{0, "exclude", "(?i)advert,(?i)sponsored"},
+		{1, "exclude", "(?i)sponsored"},
+
+		// Overridden.
+		{1, "exclude-older", "7"},
+		{1, "from", "rss@example.com"},
+	}
+
+	for _, tst := range tests {
+		got := values(out[tst.feed], tst.name)
+		if got != tst.values {
+			t.Fatalf("%s: expected %s to be '%s', got '%s'", out[tst.feed].URL, tst.name, tst.values, got)
+		}
+	}
+
+	// Unknown defaults are reported once.
+	unknown := c.ListUnknownOptions()
+	if len(unknown) != 1 || unknown[0].FeedURL != DefaultsURL || unknown[0].OptionName != "colour" {
+		t.Fatalf("unexpected unknown options %v", unknown)
+	}
+
+	// Saving keeps the defaults separate from the feeds.
+	c.Add("https://example.org/")
+	err = c.Save()
+	if err != nil {
+		t.Fatalf("unexpected error saving: %s", err)
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		t.Fatalf("failed to read saved file: %s", err)
+	}
+	if strings.Count(string(data), "exclude-older:30") != 1 {
+		t.Fatalf("defaults were not saved once:\n%s", data)
+	}
+
+	out, err = c.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %s", err)
+	}
+	if len(out) != 3 || values(out[2], "exclude-older") != "30" {
+		t.Fatalf("unexpected entries after saving %v", out)
+	}
+}
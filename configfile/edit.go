@@ -0,0 +1,141 @@
+package configfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrFeedExists is returned by AddFeed if the feed is already present.
+var ErrFeedExists = errors.New("feed already present")
+
+// ErrFeedNotFound is returned by RemoveFeed if the feed isn't present.
+var ErrFeedNotFound = errors.New("feed not found")
+
+// AddFeed appends the given feed, with any options, to the configuration
+// file, creating the file if it doesn't exist.
+//
+// Unlike Add and Save, which rewrite the whole file, the rest of the file
+// is left exactly as it is, including any comments.  The file is replaced
+// atomically, so a failure leaves it unchanged.
+//
+// An error wrapping ErrFeedExists is returned if the feed is already
+// present.
+func (c *ConfigFile) AddFeed(url string, options ...Option) error {
+
+	data, err := os.ReadFile(c.Path())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err == nil {
+		_, err = c.Parse()
+		if err != nil {
+			return err
+		}
+		for _, span := range c.spans {
+			if span.url == url {
+				return fmt.Errorf("%w: %s", ErrFeedExists, url)
+			}
+		}
+	}
+
+	buf := bytes.NewBuffer(data)
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	writeFeed(buf, Feed{URL: url, Options: options})
+
+	err = writeAtomic(c.Path(), buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Parse()
+	return err
+}
+
+// RemoveFeed removes the given feed, and its options, from the
+// configuration file.
+//
+// As with AddFeed the rest of the file is left exactly as it is, and the
+// file is replaced atomically.  An error wrapping ErrFeedNotFound is
+// returned if the feed isn't present.
+func (c *ConfigFile) RemoveFeed(url string) error {
+
+	data, err := os.ReadFile(c.Path())
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Parse()
+	if err != nil {
+		return err
+	}
+
+	// The lines of the file, as Parse numbered them.
+	lines := strings.SplitAfter(string(data), "\n")
+
+	remove := make(map[int]bool)
+	for _, span := range c.spans {
+		if span.url == url {
+			for i := span.start; i < span.end; i++ {
+				remove[i] = true
+			}
+		}
+	}
+	if len(remove) == 0 {
+		return fmt.Errorf("%w: %s", ErrFeedNotFound, url)
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if !remove[i] {
+			buf.WriteString(line)
+		}
+	}
+
+	err = writeAtomic(c.Path(), buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Parse()
+	return err
+}
+
+// writeAtomic replaces the given file with the given data, by writing a
+// temporary file alongside it and renaming that into place.
+//
+// The permissions of the existing file, if any, are kept.
+func writeAtomic(path string, data []byte) error {
+
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
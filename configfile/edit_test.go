@@ -0,0 +1,118 @@
+package configfile
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// original is the configuration file our edits start from, which has
+// comments, a block, and a continued value, all of which must be kept.
+const original = `# My feeds
+default:
+ - retry: 3
+
+https://example.org/
+ - exclude: (?i)(sponsored|\
+            advert)
+# The next feed is noisy
+https://example.net/
+ - notes: ` + "```" + `
+   https://example.com/
+   ` + "```" + `
+`
+
+// TestAddRemoveFeed ensures that feeds may be added and removed without
+// changing anything else in the file.
+func TestAddRemoveFeed(t *testing.T) {
+
+	c := ParserHelper(t, original)
+	defer os.Remove(c.path)
+
+	err := c.AddFeed("https://example.com/", Option{Name: "retry", Value: "5"}, Option{Name: "exclude", Value: "spam"})
+	if err != nil {
+		t.Fatalf("failed to add feed: %s", err)
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err)
+	}
+	expected := original + "https://example.com/\n - retry:5\n - exclude:spam\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected content after adding:\n%s", data)
+	}
+
+	entries := c.Entries()
+	if len(entries) != 3 || entries[2].URL != "https://example.com/" || entries[2].GetOption("retry", "") != "5" {
+		t.Fatalf("added feed not found %v", entries)
+	}
+
+	// Adding it again is an error, which leaves the file alone.
+	err = c.AddFeed("https://example.com/")
+	if !errors.Is(err, ErrFeedExists) {
+		t.Fatalf("expected ErrFeedExists, got %v", err)
+	}
+
+	// The block value of the last feed contains the URL, but isn't
+	// mistaken for it on removal.
+	err = c.RemoveFeed("https://example.com/")
+	if err != nil {
+		t.Fatalf("failed to remove feed: %s", err)
+	}
+
+	data, err = os.ReadFile(c.path)
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err)
+	}
+	if string(data) != original {
+		t.Fatalf("unexpected content after removing:\n%s", data)
+	}
+	if len(c.Entries()) != 2 {
+		t.Fatalf("expected two feeds, got %v", c.Entries())
+	}
+
+	// Removing a feed which has continued options removes all of
+	// its lines, but not the comment following them.
+	err = c.RemoveFeed("https://example.org/")
+	if err != nil {
+		t.Fatalf("failed to remove feed: %s", err)
+	}
+	data, err = os.ReadFile(c.path)
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err)
+	}
+	expected = "# My feeds\ndefault:\n - retry: 3\n\n# The next feed is noisy\nhttps://example.net/\n - notes: ```\n   https://example.com/\n   ```\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected content after removing:\n%s", data)
+	}
+
+	// Removing a missing feed is an error.
+	err = c.RemoveFeed("https://example.org/")
+	if !errors.Is(err, ErrFeedNotFound) {
+		t.Fatalf("expected ErrFeedNotFound, got %v", err)
+	}
+}
+
+// TestAddFeedMissingFile ensures the configuration file is created if
+// necessary.
+func TestAddFeedMissingFile(t *testing.T) {
+
+	c := NewWithPath(t.TempDir() + "/feeds.txt")
+
+	err := c.AddFeed("https://example.com/")
+	if err != nil {
+		t.Fatalf("failed to add feed: %s", err)
+	}
+
+	data, err := os.ReadFile(c.Path())
+	if err != nil || string(data) != "https://example.com/\n" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+
+	// But a missing file has no feeds to remove.
+	c = NewWithPath(t.TempDir() + "/feeds.txt")
+	if err = c.RemoveFeed("https://example.com/"); err == nil {
+		t.Fatalf("expected an error removing from a missing file")
+	}
+}
@@ -0,0 +1,76 @@
+package configfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseLegacy reads a list of feeds in the formats understood by the
+// original, Python, rss2email, so that users of it can migrate.
+//
+// That is either a list of bare feed URLs, one per line, or its
+// configuration file, in which each feed is a "[feed.NAME]" section with
+// a "url = ..." setting.  Blank lines, and comments beginning with "#" or
+// ";", are ignored.  Other settings aren't imported, so each feed has our
+// default options.
+//
+// The result has the default path, so calling Save will replace the
+// user's feed list.
+func ParseLegacy(r io.Reader) (*ConfigFile, error) {
+
+	c := New()
+
+	// The section we're in, if the input is an INI-style file.
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		// A new section.
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		// A setting, within a section.
+		if section != "" {
+			key, value, found := strings.Cut(line, "=")
+			if !found {
+				key, value, found = strings.Cut(line, ":")
+			}
+			if !found {
+				return nil, fmt.Errorf("line %d: expected 'key = value' in section [%s]: %s", lineNum, section, line)
+			}
+
+			if strings.HasPrefix(section, "feed.") && strings.TrimSpace(key) == "url" {
+				url := strings.TrimSpace(value)
+				if url == "" {
+					return nil, fmt.Errorf("line %d: empty url in section [%s]", lineNum, section)
+				}
+				c.Add(url)
+			}
+			continue
+		}
+
+		// Otherwise this must be a bare URL.
+		if strings.ContainsAny(line, " \t") || !strings.Contains(line, "://") {
+			return nil, fmt.Errorf("line %d: expected a feed URL: %s", lineNum, line)
+		}
+		c.Add(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
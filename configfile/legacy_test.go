@@ -0,0 +1,69 @@
+package configfile
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseLegacy tests reading the feed lists of the Python rss2email.
+func TestParseLegacy(t *testing.T) {
+
+	tests := map[string][]string{
+		// A list of URLs.
+		`
+# my feeds
+https://blog.steve.fi/index.rss
+http://floooh.github.io/feed.xml
+
+https://blog.steve.fi/index.rss
+`: {"https://blog.steve.fi/index.rss", "http://floooh.github.io/feed.xml"},
+
+		// A configuration file.
+		`[DEFAULT]
+from = user@rss2email.invalid
+to = me@example.com
+
+[feed.steve]
+url = https://blog.steve.fi/index.rss
+; a comment
+active = True
+
+[feed.floooh]
+url = http://floooh.github.io/feed.xml
+to = other@example.com
+`: {"https://blog.steve.fi/index.rss", "http://floooh.github.io/feed.xml"},
+
+		"": nil,
+	}
+
+	for input, expected := range tests {
+		c, err := ParseLegacy(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %s", input, err)
+		}
+
+		var urls []string
+		for _, entry := range c.Entries() {
+			if len(entry.Options) != 0 {
+				t.Fatalf("expected no options, got %v", entry.Options)
+			}
+			urls = append(urls, entry.URL)
+		}
+		if strings.Join(urls, ",") != strings.Join(expected, ",") {
+			t.Fatalf("expected %v, got %v", expected, urls)
+		}
+	}
+
+	// Things which aren't URLs are errors.
+	for _, input := range []string{
+		"not a url",
+		"https://example.com/ https://example.com/other",
+		"[feed.steve]\nurl\n",
+		"[feed.steve]\nurl =\n",
+	} {
+		_, err := ParseLegacy(strings.NewReader(input))
+		if err == nil {
+			t.Fatalf("expected error parsing %q", input)
+		}
+	}
+}
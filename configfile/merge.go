@@ -0,0 +1,68 @@
+package configfile
+
+// Merge returns a new configuration which combines the feeds of the base
+// configuration with those of the overlay, allowing shared feeds to be
+// kept in one file and personal overrides in another.
+//
+// Feeds which appear only in the overlay are added after those of the
+// base.  Feeds which appear in both have their options merged by
+// mergeOptions, as are the default options of the two configurations.
+//
+// Both configurations must already have been parsed.  The result has the
+// path of the base, but is intended to be read rather than saved, since
+// saving it would write the options of the overlay into the base file.
+func Merge(base, overlay *ConfigFile) *ConfigFile {
+
+	merged := New()
+	merged.path = base.path
+	merged.logger = base.logger
+	merged.defaults = mergeOptions(base.defaults, overlay.defaults)
+
+	// Find the feeds of the overlay, by URL, so that we can spot
+	// those which the base also contains.
+	overrides := make(map[string][]Option)
+	for _, entry := range overlay.entries {
+		overrides[entry.URL] = append(overrides[entry.URL], entry.Options...)
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range base.entries {
+		options := mergeOptions(entry.Options, overrides[entry.URL])
+		merged.entries = append(merged.entries, Feed{URL: entry.URL, Options: options})
+		seen[entry.URL] = true
+	}
+
+	for _, entry := range overlay.entries {
+		if !seen[entry.URL] {
+			merged.entries = append(merged.entries, Feed{URL: entry.URL, Options: mergeOptions(nil, entry.Options)})
+			seen[entry.URL] = true
+		}
+	}
+
+	return merged
+}
+
+// mergeOptions returns the options of the base followed by those of the
+// overlay.
+//
+// The result is always a new slice, even if the overlay is empty.
+//
+// Options which may be given more than once, such as "exclude", are added
+// to those of the base.  Any other option given by the overlay replaces
+// that of the base.
+func mergeOptions(base, overlay []Option) []Option {
+
+	set := make(map[string]bool)
+	for _, opt := range overlay {
+		set[opt.Name] = true
+	}
+
+	var options []Option
+	for _, opt := range base {
+		if opt.IsRepeatable() || !set[opt.Name] {
+			options = append(options, opt)
+		}
+	}
+
+	return append(options, overlay...)
+}
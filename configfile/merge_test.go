@@ -0,0 +1,95 @@
+package configfile
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestMerge ensures that an overlay configuration is combined with the
+// base one.
+func TestMerge(t *testing.T) {
+
+	base := ParserHelper(t, `default:
+ - frequency: 60
+ - exclude: spam
+https://example.com/
+ - retry: 3
+ - exclude: adverts
+ - notes: base
+https://example.org/
+ - retry: 1
+`)
+	defer os.Remove(base.path)
+
+	overlay := ParserHelper(t, `default:
+ - frequency: 30
+https://example.com/
+ - retry: 5
+ - exclude: sponsored
+https://example.net/
+ - tag: new
+`)
+	defer os.Remove(overlay.path)
+
+	if _, err := base.Parse(); err != nil {
+		t.Fatalf("failed to parse base: %s", err)
+	}
+	if _, err := overlay.Parse(); err != nil {
+		t.Fatalf("failed to parse overlay: %s", err)
+	}
+
+	merged := Merge(base, overlay)
+	if merged.Path() != base.Path() {
+		t.Fatalf("unexpected path %s", merged.Path())
+	}
+
+	entries := merged.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected three feeds, got %d: %v", len(entries), entries)
+	}
+
+	// Feeds keep the order of the base, with the overlay-only
+	// feeds added afterwards.
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+	}
+	if strings.Join(urls, " ") != "https://example.com/ https://example.org/ https://example.net/" {
+		t.Fatalf("unexpected feeds %v", urls)
+	}
+
+	// The overlay wins for single-value options, and adds to those
+	// which may be repeated.
+	shared := entries[0]
+	if shared.GetOption("retry", "") != "5" || len(shared.GetAllOptions("retry")) != 1 {
+		t.Fatalf("expected the overlay retry, got %v", shared.Options)
+	}
+	if shared.GetOption("notes", "") != "base" {
+		t.Fatalf("expected the base notes to be kept, got %v", shared.Options)
+	}
+	if strings.Join(shared.GetAllOptions("exclude"), ",") != "adverts,sponsored,spam" {
+		t.Fatalf("unexpected exclude options %v", shared.GetAllOptions("exclude"))
+	}
+
+	// The defaults are merged in the same way.
+	if shared.GetOption("frequency", "") != "30" || len(shared.GetAllOptions("frequency")) != 1 {
+		t.Fatalf("expected the overlay default frequency, got %v", shared.Options)
+	}
+
+	// Feeds only found in the base are unchanged, other than by the
+	// merged defaults.
+	if entries[1].GetOption("retry", "") != "1" || entries[1].GetOption("frequency", "") != "30" {
+		t.Fatalf("unexpected base-only feed %v", entries[1])
+	}
+
+	// Feeds only found in the overlay are added.
+	if entries[2].GetOption("tag", "") != "new" || strings.Join(entries[2].GetAllOptions("exclude"), ",") != "spam" {
+		t.Fatalf("unexpected overlay-only feed %v", entries[2])
+	}
+
+	// The inputs are not modified.
+	if len(base.Entries()) != 2 || base.Entries()[0].GetOption("retry", "") != "3" {
+		t.Fatalf("the base configuration was modified %v", base.Entries())
+	}
+}
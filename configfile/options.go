@@ -0,0 +1,490 @@
+package configfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The types of values which options accept.
+const (
+	// ValueString is used for options which accept any value.
+	ValueString = "string"
+
+	// ValueNumber is used for options which accept a number.
+	ValueNumber = "number"
+
+	// ValueBoolean is used for options which accept "true" or "yes".
+	ValueBoolean = "boolean"
+
+	// ValueRegex is used for options which accept a regular expression.
+	ValueRegex = "regex"
+)
+
+// OptionSpec describes a per-feed option which we understand.
+type OptionSpec struct {
+
+	// Description is a short summary of what the option does.
+	Description string
+
+	// ValueType is the type of value the option accepts, one of the
+	// Value-constants.
+	ValueType string
+}
+
+// KnownOptions contains all the per-feed options which are recognized,
+// keyed by name.
+//
+// Any option which is not listed here will be silently ignored when
+// feeds are processed, so new options must be added here too.
+var KnownOptions = map[string]OptionSpec{
+	"bearer-token": {
+		Description: "A token sent in an \"Authorization: Bearer\" header when fetching this feed, or \"$NAME\" to read it from the environment variable NAME.",
+		ValueType:   ValueString,
+	},
+	"body-template": {
+		Description: "A template for the body of emails from this feed, or the path to a file containing one if it begins with / or ./, using {{.FeedTitle}}, {{.FeedURL}}, {{.ItemTitle}}, {{.ItemLink}}, {{.ItemAuthor}}, {{.ItemDate}}, {{.ItemBody}}, {{.ItemCategories}} and {{.ItemGUID}}.",
+		ValueType:   ValueString,
+	},
+	"bulk-send-mode": {
+		Description: "Send all the emails for this feed over a single SMTP connection, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"content-transform": {
+		Description: "How to treat images in the content: \"keep-images\", \"strip-images\", or \"images-to-text\".",
+		ValueType:   ValueString,
+	},
+	"deduplicate-by-link": {
+		Description: "Decide whether items are new by their normalized link, rather than their link as given, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"deduplicate-by-link-or-guid": {
+		Description: "Treat items as seen if either their normalized link or their GUID has been seen, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"delay": {
+		Description: "The number of seconds to sleep before retrying a failed HTTP-fetch, the older name of retry-delay.",
+		ValueType:   ValueNumber,
+	},
+	"digest": {
+		Description: "Send all the new items of this feed in a single email, on each run, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"email-body-template-file": {
+		Description: "The path to an email template to use for this feed, in place of the global one.",
+		ValueType:   ValueString,
+	},
+	"email-priority": {
+		Description: "The priority of emails from this feed: \"high\", \"normal\", or \"low\".",
+		ValueType:   ValueString,
+	},
+	"exclude": {
+		Description: "Exclude any item which matches the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"exclude-author": {
+		Description: "Exclude any item with an author matching the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"exclude-author-list-file": {
+		Description: "A file of regular-expressions, one per line, excluding any item with an author matching any of them.",
+		ValueType:   ValueString,
+	},
+	"exclude-category": {
+		Description: "Exclude any item with a category matching the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"exclude-has-enclosure": {
+		Description: "Exclude any item with an enclosure, such as the audio of a podcast episode, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"exclude-if-no-content": {
+		Description: "Exclude any item whose content is empty, or only whitespace, once the HTML is removed, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"exclude-link": {
+		Description: "Exclude any item whose link matches the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"exclude-newer": {
+		Description: "Exclude any item whose publication date is more recent than this many days, until it is older.",
+		ValueType:   ValueNumber,
+	},
+	"exclude-older": {
+		Description: "Exclude any item whose publication date is older than this many days.",
+		ValueType:   ValueNumber,
+	},
+	"exclude-publisher": {
+		Description: "Exclude any item whose original publisher, from its <source> element, matches the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"exclude-title": {
+		Description: "Exclude any item with a title matching the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"exclude-title-list-file": {
+		Description: "A file of regular-expressions, one per line, excluding any item with a title matching any of them.",
+		ValueType:   ValueString,
+	},
+	"feed-name": {
+		Description: "A name for this feed, which replaces the title given by the feed in its emails, and is its title when the feed list is exported as OPML.",
+		ValueType:   ValueString,
+	},
+	"frequency": {
+		Description: "How frequently to poll this feed, in minutes.",
+		ValueType:   ValueNumber,
+	},
+	"from": {
+		Description: "The sender address to use for emails from this feed.",
+		ValueType:   ValueString,
+	},
+	"imap-seen-check": {
+		Description: "An IMAP mailbox URL, items which have been read there are not resent.",
+		ValueType:   ValueString,
+	},
+	"include": {
+		Description: "Include only items which match the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"include-author": {
+		Description: "Include only items with an author matching the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"include-author-list-file": {
+		Description: "A file of regular-expressions, one per line, including only items with an author matching any of them.",
+		ValueType:   ValueString,
+	},
+	"include-bottom-items": {
+		Description: "Consider only the last N items of the feed, by the order the feed lists them.",
+		ValueType:   ValueNumber,
+	},
+	"include-category": {
+		Description: "Include only items with a category matching the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"include-sentences-min": {
+		Description: "Exclude any item whose content has fewer sentences than this.",
+		ValueType:   ValueNumber,
+	},
+	"include-has-enclosure": {
+		Description: "Include only items with an enclosure, such as the audio of a podcast episode, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"include-link": {
+		Description: "Include only items whose link matches the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"include-publisher": {
+		Description: "Include only items whose original publisher, from its <source> element, matches the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"include-top-items": {
+		Description: "Consider only the first N items of the feed, by the order the feed lists them.",
+		ValueType:   ValueNumber,
+	},
+	"include-title": {
+		Description: "Include only items with a title matching the given regular-expression.",
+		ValueType:   ValueRegex,
+	},
+	"include-words-min": {
+		Description: "Exclude any item whose content has fewer words than this.",
+		ValueType:   ValueNumber,
+	},
+	"inline-images": {
+		Description: "Embed the images of each item in its email as data: URIs, if set to \"true\" or \"yes\", for mail clients which block remote images.",
+		ValueType:   ValueBoolean,
+	},
+	"insecure": {
+		Description: "Ignore TLS failures when fetching this feed, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"max-content-length": {
+		Description: "Truncate the content of emails whose text is longer than this many characters, at a word boundary, with a \"Read more\" link to the item.",
+		ValueType:   ValueNumber,
+	},
+	"max-image-size": {
+		Description: "The largest image, in bytes, which inline-images embeds, larger images stay remote.  The default is 1048576.",
+		ValueType:   ValueNumber,
+	},
+	"max-inline-images": {
+		Description: "The most images of each item which inline-images embeds, the rest stay remote.  The default is 10.",
+		ValueType:   ValueNumber,
+	},
+	"max-items": {
+		Description: "The most new items of this feed processed by a single run, the oldest first, the rest wait for a later run.",
+		ValueType:   ValueNumber,
+	},
+	"max-words": {
+		Description: "Exclude any item whose content has more words than this.",
+		ValueType:   ValueNumber,
+	},
+	"min-words": {
+		Description: "Exclude any item whose content has fewer words than this, the same as include-words-min.",
+		ValueType:   ValueNumber,
+	},
+	"multipart": {
+		Description: "Send emails as a simple multipart/alternative message, with a plain-text and a HTML part, instead of using the email template.",
+		ValueType:   ValueBoolean,
+	},
+	"notify": {
+		Description: "A comma-separated list of recipients, replacing those given on the command-line.",
+		ValueType:   ValueString,
+	},
+	"password": {
+		Description: "The password to fetch this feed with, using HTTP Basic Authentication, along with username.",
+		ValueType:   ValueString,
+	},
+	"proxy": {
+		Description: "The http://, https://, or socks5:// URL of a proxy to fetch this feed through, overriding the global proxy setting.",
+		ValueType:   ValueString,
+	},
+	"resend-updated": {
+		Description: "Send items again, with \"[UPDATED]\" before their title, when their updated timestamp changes, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"retry": {
+		Description: "The number of times to retry a failing HTTP-fetch, after the first attempt.",
+		ValueType:   ValueNumber,
+	},
+	"retry-delay": {
+		Description: "The number of seconds to sleep before retrying a failed HTTP-fetch, or 0 to back off exponentially.",
+		ValueType:   ValueNumber,
+	},
+	"sleep": {
+		Description: "The number of seconds to sleep before fetching this feed.",
+		ValueType:   ValueNumber,
+	},
+	"strip-utm": {
+		Description: "Remove the utm_*, fbclid, and gclid tracking parameters from the links of items, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"subject-template": {
+		Description: "A template for the subject of emails from this feed, using {{.FeedTitle}}, {{.ItemTitle}}, {{.ItemAuthor}}, {{.ItemDate}} and {{.ItemLink}}.",
+		ValueType:   ValueString,
+	},
+	"tag": {
+		Description: "A tag for this feed, which is available to the email template.",
+		ValueType:   ValueString,
+	},
+	"template": {
+		Description: "The path to a feed-specific email template.",
+		ValueType:   ValueString,
+	},
+	"timeout": {
+		Description: "The number of seconds to wait for this feed to be fetched, before giving up.",
+		ValueType:   ValueNumber,
+	},
+	"to": {
+		Description: "A comma-separated list of recipients, replacing those given on the command-line, the same as notify.",
+		ValueType:   ValueString,
+	},
+	"user-agent": {
+		Description: "The User-Agent to send when fetching this feed.",
+		ValueType:   ValueString,
+	},
+	"username": {
+		Description: "The username to fetch this feed with, using HTTP Basic Authentication, along with password.",
+		ValueType:   ValueString,
+	},
+	"verify-item-link": {
+		Description: "Skip new items whose link doesn't respond successfully to a HEAD request, if set to \"true\" or \"yes\".",
+		ValueType:   ValueBoolean,
+	},
+	"webhook-secret": {
+		Description: "The key the JSON POSTed to the webhook-url is signed with, in the X-Webhook-Signature header, overriding the global webhook-secret setting.",
+		ValueType:   ValueString,
+	},
+	"webhook-url": {
+		Description: "A http:// or https:// URL which each new item is POSTed to as JSON, as well as being delivered, overriding the global webhook-url setting.",
+		ValueType:   ValueString,
+	},
+}
+
+// UnknownOption describes a per-feed option which isn't recognized.
+type UnknownOption struct {
+
+	// FeedURL is the URL of the feed the option was set for.
+	FeedURL string `json:"feed_url"`
+
+	// OptionName is the name of the unknown option.
+	OptionName string `json:"option_name"`
+
+	// OptionValue is the value it was given.
+	OptionValue string `json:"option_value"`
+}
+
+// Error is part of the error-interface, so that unknown options may be
+// returned by Validate.
+func (u *UnknownOption) Error() string {
+	return fmt.Sprintf("%s: unknown option %s '%s'", u.FeedURL, u.OptionName, u.OptionValue)
+}
+
+// ListUnknownOptions returns every option, for every feed, which does not
+// appear in KnownOptions.
+//
+// This operates upon the entries which have already been read, so Parse
+// must have been called first.  Unknown default options are reported once,
+// with DefaultsURL as their feed.
+func (c *ConfigFile) ListUnknownOptions() []UnknownOption {
+
+	var unknown []UnknownOption
+
+	entries := c.entries
+	if len(c.defaults) > 0 {
+		entries = append([]Feed{{URL: DefaultsURL, Options: c.defaults}}, entries...)
+	}
+
+	for _, entry := range entries {
+		for _, opt := range entry.Options {
+			if _, ok := KnownOptions[opt.Name]; !ok {
+				unknown = append(unknown, UnknownOption{
+					FeedURL:     entry.URL,
+					OptionName:  opt.Name,
+					OptionValue: opt.Value,
+				})
+			}
+		}
+	}
+
+	return unknown
+}
+
+// IsRegex returns true if the value of this option is treated as a
+// regular expression.
+func (o Option) IsRegex() bool {
+	spec, ok := KnownOptions[o.Name]
+	return ok && spec.ValueType == ValueRegex
+}
+
+// IsRepeatable returns true if the option may be given more than once for
+// a feed, with each value taking effect, as is the case for the regular
+// expressions and files of them which include or exclude items.
+func (o Option) IsRepeatable() bool {
+	return o.IsRegex() || strings.HasSuffix(o.Name, "-list-file")
+}
+
+// RegexError describes an option whose value is not a valid regular
+// expression.
+type RegexError struct {
+
+	// FeedURL is the URL of the feed the option was set for.
+	FeedURL string
+
+	// Option is the invalid option.
+	Option Option
+
+	// Err is the error from compiling the expression.
+	Err error
+}
+
+// Error is part of the error-interface.
+func (e *RegexError) Error() string {
+	return fmt.Sprintf("%s: invalid regular expression for %s '%s': %s", e.FeedURL, e.Option.Name, e.Option.Value, e.Err)
+}
+
+// Unwrap returns the error from compiling the expression.
+func (e *RegexError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON converts the error to JSON, in the same form as an
+// UnknownOption along with the error message.
+func (e *RegexError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FeedURL     string `json:"feed_url"`
+		OptionName  string `json:"option_name"`
+		OptionValue string `json:"option_value"`
+		Error       string `json:"error"`
+	}{
+		FeedURL:     e.FeedURL,
+		OptionName:  e.Option.Name,
+		OptionValue: e.Option.Value,
+		Error:       e.Err.Error(),
+	})
+}
+
+// ValidateAllRegexOptions compiles the value of every option of the given
+// feed which is a regular expression, and returns a *RegexError for each
+// which fails.
+func ValidateAllRegexOptions(feed Feed) []error {
+
+	var errs []error
+
+	for _, opt := range feed.Options {
+		if !opt.IsRegex() {
+			continue
+		}
+
+		_, err := regexp.Compile(opt.Value)
+		if err != nil {
+			errs = append(errs, &RegexError{FeedURL: feed.URL, Option: opt, Err: err})
+		}
+	}
+
+	return errs
+}
+
+// URLError describes a feed whose URL cannot be parsed.
+type URLError struct {
+
+	// FeedURL is the URL of the feed.
+	FeedURL string
+
+	// Err is the error from parsing the URL.
+	Err error
+}
+
+// Error is part of the error-interface.
+func (e *URLError) Error() string {
+	return fmt.Sprintf("%s: invalid URL: %s", e.FeedURL, e.Err)
+}
+
+// Unwrap returns the error from parsing the URL.
+func (e *URLError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON converts the error to JSON, with the URL of the feed and the
+// error message.
+func (e *URLError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FeedURL string `json:"feed_url"`
+		Error   string `json:"error"`
+	}{
+		FeedURL: e.FeedURL,
+		Error:   e.Err.Error(),
+	})
+}
+
+// Validate checks the feed for problems which would otherwise only be
+// discovered, or silently ignored, when it is processed, and returns an
+// error for each of them:
+//
+//   - A *URLError if the URL of the feed cannot be parsed.
+//   - An *UnknownOption for each option not in KnownOptions.
+//   - A *RegexError for each regular expression which doesn't compile.
+//
+// The result is empty if the feed is valid.
+func (f Feed) Validate() []error {
+
+	var errs []error
+
+	_, err := url.Parse(f.URL)
+	if err != nil {
+		errs = append(errs, &URLError{FeedURL: f.URL, Err: err})
+	}
+
+	for _, opt := range f.Options {
+		if _, ok := KnownOptions[opt.Name]; !ok {
+			errs = append(errs, &UnknownOption{
+				FeedURL:     f.URL,
+				OptionName:  opt.Name,
+				OptionValue: opt.Value,
+			})
+		}
+	}
+
+	return append(errs, ValidateAllRegexOptions(f)...)
+}
@@ -0,0 +1,187 @@
+package configfile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp/syntax"
+	"strings"
+	"testing"
+)
+
+// TestListUnknownOptions tests that we find options we don't recognize.
+func TestListUnknownOptions(t *testing.T) {
+
+	c := ParserHelper(t, `https://example.com/
+ - tag: foo
+ - colour: blue
+https://example.net/
+ - exclude-title: cake
+ - nofity: user@example.com
+`)
+	defer os.Remove(c.path)
+
+	_, err := c.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %s", err)
+	}
+
+	unknown := c.ListUnknownOptions()
+	if len(unknown) != 2 {
+		t.Fatalf("expected two unknown options, got %d", len(unknown))
+	}
+
+	if unknown[0] != (UnknownOption{FeedURL: "https://example.com/", OptionName: "colour", OptionValue: "blue"}) {
+		t.Fatalf("unexpected result %v", unknown[0])
+	}
+	if unknown[1] != (UnknownOption{FeedURL: "https://example.net/", OptionName: "nofity", OptionValue: "user@example.com"}) {
+		t.Fatalf("unexpected result %v", unknown[1])
+	}
+}
+
+// TestKnownOptions ensures that all our options are documented.
+func TestKnownOptions(t *testing.T) {
+
+	for name, spec := range KnownOptions {
+		if spec.Description == "" {
+			t.Fatalf("option %s has no description", name)
+		}
+		switch spec.ValueType {
+		case ValueString, ValueNumber, ValueBoolean, ValueRegex:
+		default:
+			t.Fatalf("option %s has unknown value type '%s'", name, spec.ValueType)
+		}
+	}
+}
+
+// TestIsRegex tests we know which options are regular expressions.
+func TestIsRegex(t *testing.T) {
+
+	tests := map[string]bool{
+		"exclude":          true,
+		"include-category": true,
+		"exclude-title":    true,
+		"exclude-older":    false,
+		"tag":              false,
+		"colour":           false,
+	}
+
+	for name, expected := range tests {
+		if (Option{Name: name}).IsRegex() != expected {
+			t.Fatalf("unexpected result for %s", name)
+		}
+	}
+}
+
+// TestValidateAllRegexOptions tests that invalid regular expressions are
+// reported.
+func TestValidateAllRegexOptions(t *testing.T) {
+
+	feed := Feed{
+		URL: "https://example.com/",
+		Options: []Option{
+			{Name: "exclude", Value: "[a-z"},
+			{Name: "include-title", Value: "(?i)cake"},
+			{Name: "tag", Value: "(not a regexp"},
+			{Name: "exclude-category", Value: "(news"},
+		},
+	}
+
+	errs := ValidateAllRegexOptions(feed)
+	if len(errs) != 2 {
+		t.Fatalf("expected two errors, got %d: %v", len(errs), errs)
+	}
+
+	var re *RegexError
+	if !errors.As(errs[0], &re) {
+		t.Fatalf("expected a RegexError, got %T", errs[0])
+	}
+	if re.FeedURL != feed.URL || re.Option.Name != "exclude" {
+		t.Fatalf("unexpected error %v", re)
+	}
+
+	var se *syntax.Error
+	if !errors.As(errs[1], &se) {
+		t.Fatalf("expected to unwrap a syntax error, got %v", errs[1])
+	}
+
+	if !strings.Contains(errs[1].Error(), "exclude-category '(news'") {
+		t.Fatalf("unexpected error message %s", errs[1])
+	}
+
+	data, err := json.Marshal(errs[0])
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+	if !strings.Contains(string(data), `"option_name":"exclude"`) ||
+		!strings.Contains(string(data), `"error":`) {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+
+	// A feed with valid options has no errors.
+	if len(ValidateAllRegexOptions(Feed{Options: feed.Options[1:2]})) != 0 {
+		t.Fatalf("unexpected errors for valid feed")
+	}
+}
+
+func TestFeedValidate(t *testing.T) {
+
+	// A valid feed has no problems.
+	feed := Feed{
+		URL: "https://example.com/",
+		Options: []Option{
+			{Name: "exclude", Value: "[a-z]+"},
+			{Name: "frequency", Value: "30"},
+		},
+	}
+	if errs := feed.Validate(); len(errs) != 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+
+	// Every problem is reported, not just the first.
+	feed = Feed{
+		URL: "http://[::1",
+		Options: []Option{
+			{Name: "exclude", Value: "[a-z"},
+			{Name: "colour", Value: "blue"},
+			{Name: "include-title", Value: "(cake"},
+		},
+	}
+
+	errs := feed.Validate()
+	if len(errs) != 4 {
+		t.Fatalf("expected four errors, got %d: %v", len(errs), errs)
+	}
+
+	var urlErr *URLError
+	if !errors.As(errs[0], &urlErr) || urlErr.FeedURL != feed.URL {
+		t.Fatalf("expected a URLError, got %v", errs[0])
+	}
+	if !strings.Contains(errs[0].Error(), "invalid URL") {
+		t.Fatalf("unexpected error message %s", errs[0])
+	}
+
+	var unknown *UnknownOption
+	if !errors.As(errs[1], &unknown) || unknown.OptionName != "colour" || unknown.OptionValue != "blue" {
+		t.Fatalf("expected an UnknownOption, got %v", errs[1])
+	}
+	if !strings.Contains(errs[1].Error(), "unknown option colour 'blue'") {
+		t.Fatalf("unexpected error message %s", errs[1])
+	}
+
+	for _, err := range errs[2:] {
+		var re *RegexError
+		if !errors.As(err, &re) {
+			t.Fatalf("expected a RegexError, got %v", err)
+		}
+	}
+
+	data, err := json.Marshal(errs[0])
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+	if !strings.Contains(string(data), `"feed_url":"http://[::1"`) ||
+		!strings.Contains(string(data), `"error":`) {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+}
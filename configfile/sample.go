@@ -0,0 +1,103 @@
+package configfile
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sampleValues are the example values given to options of each type, in
+// the sample configuration file.
+var sampleValues = map[string]string{
+	ValueString:  "value",
+	ValueNumber:  "1",
+	ValueBoolean: "true",
+	ValueRegex:   "(?i)example",
+}
+
+// sampleTypes describes the values options of each type accept, in the
+// sample configuration file, where their descriptions don't already.
+var sampleTypes = map[string]string{
+	ValueNumber:  "a number",
+	ValueBoolean: `"true" or "yes"`,
+	ValueRegex:   "a regular expression",
+}
+
+// sampleChoice finds the first quoted value in a description, which is
+// used as the example for options taking one of a fixed set of values.
+var sampleChoice = regexp.MustCompile(`"([^"]+)"`)
+
+// Sample returns a sample configuration file, containing one feed which
+// has every option in KnownOptions set, but commented out, along with its
+// description.
+//
+// Removing the "#" from the start of an option enables it, and the result
+// can be read by Parse.
+func Sample() string {
+
+	var names []string
+	for name := range KnownOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(`# This is a sample rss2email configuration file.
+#
+# Each feed is given by its URL, on a line of its own, followed by any
+# options for that feed, one per line, in the form " - name:value".
+#
+# Every option is listed beneath the sample feed below, but commented
+# out.  Remove the "#" from the start of an option to enable it.
+
+https://example.com/feed.xml
+`)
+
+	for _, name := range names {
+		spec := KnownOptions[name]
+
+		// A blank line would end the feed, so the options are
+		// separated by empty comments instead.
+		sb.WriteString("#\n")
+		description := spec.Description
+		if kind := sampleTypes[spec.ValueType]; kind != "" && !strings.Contains(description, kind) {
+			description = fmt.Sprintf("%s (%s)", description, kind)
+		}
+		for _, line := range wrap(description, 70) {
+			sb.WriteString("# " + line + "\n")
+		}
+
+		example := sampleValues[spec.ValueType]
+		if m := sampleChoice.FindStringSubmatch(spec.Description); spec.ValueType == ValueString && m != nil {
+			example = m[1]
+		}
+		sb.WriteString("# - " + name + ":" + example + "\n")
+	}
+
+	return sb.String()
+}
+
+// wrap splits the text into lines of no more than the given width, where
+// possible, breaking between words.
+func wrap(text string, width int) []string {
+
+	var lines []string
+	line := ""
+
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
@@ -0,0 +1,66 @@
+package configfile
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestSample tests that the sample configuration file documents every
+// option, and can be parsed once they're enabled.
+func TestSample(t *testing.T) {
+
+	sample := Sample()
+
+	for _, enable := range []bool{false, true} {
+		content := sample
+		if enable {
+			content = regexp.MustCompile(`(?m)^# - `).ReplaceAllString(content, " - ")
+		}
+
+		path := filepath.Join(t.TempDir(), "feeds.txt")
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("failed to write sample: %s", err)
+		}
+
+		entries, err := NewWithPath(path).Parse()
+		if err != nil {
+			t.Fatalf("failed to parse sample: %s", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected one feed, got %v", entries)
+		}
+
+		expected := 0
+		if enable {
+			expected = len(KnownOptions)
+		}
+		if len(entries[0].Options) != expected {
+			t.Fatalf("expected %d options, got %d", expected, len(entries[0].Options))
+		}
+		for _, opt := range entries[0].Options {
+			if _, ok := KnownOptions[opt.Name]; !ok {
+				t.Fatalf("unknown option %s in sample", opt.Name)
+			}
+		}
+	}
+
+	// Each description is present.
+	for name, spec := range KnownOptions {
+		words := strings.Fields(spec.Description)
+		if !strings.Contains(sample, words[0]) || !strings.Contains(sample, "# - "+name+":") {
+			t.Fatalf("option %s is not documented", name)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+
+	lines := wrap("one two three four five", 9)
+	if strings.Join(lines, "|") != "one two|three|four five" {
+		t.Fatalf("unexpected wrapping %q", lines)
+	}
+}
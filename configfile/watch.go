@@ -0,0 +1,98 @@
+package configfile
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// watchInterval controls how often Watch checks the configuration file
+// for modifications.  It is a variable so that the test-cases can
+// reduce it.
+var watchInterval = 5 * time.Second
+
+// Watch monitors the configuration file for changes, returning a channel
+// upon which an updated (and already parsed) configuration is sent each
+// time the file is modified.
+//
+// We poll the modification-time and size of the file rather than using
+// inotify, because the latter doesn't work reliably on the bind-mounts
+// and network filesystems our docker users tend to have.
+//
+// If the modified file cannot be parsed then we log the error and send
+// a nil value on the channel, so the caller may continue to use the
+// configuration it already has.
+//
+// The channel is closed once the supplied context is cancelled.
+func (c *ConfigFile) Watch(ctx context.Context) (<-chan *ConfigFile, error) {
+
+	// Get the current state of the file, so that we can spot changes.
+	info, err := os.Stat(c.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure we have a logger
+	logger := c.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With(slog.String("configfile", c.Path()))
+
+	ch := make(chan *ConfigFile)
+
+	go func() {
+		defer close(ch)
+
+		modified := info.ModTime()
+		size := info.Size()
+
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// The file might be missing, briefly, if an editor
+			// is replacing it.  We'll see it next time around.
+			cur, err := os.Stat(c.Path())
+			if err != nil {
+				continue
+			}
+
+			if cur.ModTime().Equal(modified) && cur.Size() == size {
+				continue
+			}
+			modified = cur.ModTime()
+			size = cur.Size()
+
+			logger.Debug("configuration file changed, reloading")
+
+			// Parse the updated contents into a new object.
+			updated := NewWithPath(c.Path())
+			updated.logger = c.logger
+
+			var result *ConfigFile
+			_, err = updated.Parse()
+			if err != nil {
+				logger.Error("failed to reload configuration file",
+					slog.String("error", err.Error()))
+			} else {
+				result = updated
+			}
+
+			select {
+			case ch <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,98 @@
+package configfile
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// rewrite replaces the content of the given file, and bumps the
+// modification time so that the change is always visible.
+func rewrite(t *testing.T, path string, content string, when time.Time) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	err = os.Chtimes(path, when, when)
+	if err != nil {
+		t.Fatalf("failed to update file times: %s", err)
+	}
+}
+
+// TestWatch ensures we see updates being made to a configuration file.
+func TestWatch(t *testing.T) {
+
+	bak := watchInterval
+	watchInterval = 10 * time.Millisecond
+	defer func() { watchInterval = bak }()
+
+	c := ParserHelper(t, `https://example.com/`)
+	defer os.Remove(c.path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error watching file: %s", err)
+	}
+
+	// Update the file, and we should get the new entries.
+	rewrite(t, c.path, `https://example.com/
+https://example.net/
+ - tag:foo
+`, time.Now().Add(time.Minute))
+
+	select {
+	case updated := <-ch:
+		if updated == nil {
+			t.Fatalf("expected a configuration, got nil")
+		}
+		entries := updated.Entries()
+		if len(entries) != 2 {
+			t.Fatalf("expected two entries, got %d", len(entries))
+		}
+		if entries[1].URL != "https://example.net/" {
+			t.Fatalf("unexpected entry: %v", entries[1])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	}
+
+	// Break the file, which should give us a nil.
+	rewrite(t, c.path, ` - foo:bar`, time.Now().Add(2*time.Minute))
+
+	select {
+	case updated := <-ch:
+		if updated != nil {
+			t.Fatalf("expected nil for a broken configuration")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	}
+
+	// Cancelling the context should close the channel.
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("expected the channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for channel to close")
+	}
+}
+
+// TestWatchMissing ensures that watching a missing file fails.
+func TestWatchMissing(t *testing.T) {
+
+	c := NewWithPath("/this/does/not/exist/feeds.txt")
+
+	_, err := c.Watch(context.Background())
+	if err == nil {
+		t.Fatalf("expected an error watching a missing file")
+	}
+}
@@ -0,0 +1,172 @@
+//
+// Count the new items of each feed, without sending them.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/skx/rss2email/config"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/processor"
+)
+
+// countRecipient is the address our emails are rendered for, if none are
+// given, since they're discarded anyway.
+const countRecipient = "rss2email@localhost"
+
+// Structure for our options and state.
+type countCmd struct {
+
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+
+	// stateDB is the SQLite database holding our state, if any.
+	stateDB string
+}
+
+// Info is part of the subcommand-API.
+func (c *countCmd) Info() (string, string) {
+	return "count", `Count the new items of each feed, without sending them.
+
+This sub-command fetches every feed, applies its filters, and compares
+its items with those we've seen, exactly as 'cron' would.  Rather than
+sending the new items it shows how many there are, along with how many
+were skipped by the filters, and the totals of each.
+
+Nothing is sent, and nothing is recorded, as for 'cron -dry-run', so this
+is a safe way for monitoring scripts to see what the next run would send.
+
+The addresses to send to may be given, as for 'cron', which affects the
+count only if the feeds have "notify" options.
+
+The state is the BoltDB database in ~/.rss2email/state.db, unless
+'-state-db' names the SQLite database given to 'cron' or 'daemon'.
+
+Example:
+
+    $ rss2email count
+    FEED                          NEW  SKIPPED
+    https://example.com/feed.xml  2    1
+    https://example.org/rss       0    0
+    TOTAL                         2    1
+`
+}
+
+// Arguments handles our flag-setup.
+//
+// In our case we use this as a hook to setup our configuration-file,
+// which allows testing.
+func (c *countCmd) Arguments(f *flag.FlagSet) {
+	c.config = configfile.New()
+
+	f.StringVar(&c.stateDB, "state-db", "", "Read the state from the given SQLite database, rather than the default BoltDB one")
+}
+
+// Entry-point.
+func (c *countCmd) Execute(args []string) int {
+
+	recipients := []string{}
+	for _, email := range args {
+		if !strings.Contains(email, "@") {
+			fmt.Fprintf(out, "Usage: rss2email count [flags] [email1 .. emailN]\n")
+			return 1
+		}
+		recipients = append(recipients, email)
+	}
+	if len(recipients) == 0 {
+		recipients = append(recipients, countRecipient)
+	}
+
+	entries, err := c.config.Parse()
+	if err != nil {
+		logger.Error("failed to parse configuration file",
+			slog.String("configfile", c.config.Path()),
+			slog.String("error", err.Error()))
+		return 1
+	}
+
+	// Load the application configuration, which affects how many
+	// items are sent.
+	appConfig, cErr := config.Load()
+	if cErr != nil {
+		appConfig = &config.Config{}
+	}
+
+	fromAddr := appConfig.From
+	if fromAddr == "" {
+		fromAddr = os.Getenv("FROM")
+	}
+
+	p, err := processor.New(processor.ProcessorConfig{
+		Send:              true,
+		StatePath:         processor.DefaultStatePath(),
+		StateDB:           c.stateDB,
+		DefaultFrom:       fromAddr,
+		MaxEmailsPerRun:   appConfig.MaxEmailsPerRun,
+		Version:           version,
+		TemplateVariables: templateVariables(appConfig),
+	})
+	if err != nil {
+		logger.Error("failed to create feed processor",
+			slog.String("error", err.Error()))
+		return 1
+	}
+	defer p.Close()
+
+	// The emails of a dry-run are discarded, and nothing is recorded.
+	p.SetLogger(logger)
+	p.SetFeeds(entries)
+	p.SetDryRun(io.Discard)
+
+	errors := p.ProcessFeeds(recipients)
+
+	stats := p.Statistics()
+
+	var feeds []string
+	for url := range stats {
+		feeds = append(feeds, url)
+	}
+	sort.Strings(feeds)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "FEED\tNEW\tSKIPPED\n")
+
+	newItems, skippedItems := 0, 0
+	for _, url := range feeds {
+		s := stats[url]
+		if s.FetchError != nil {
+			fmt.Fprintf(w, "%s\t-\t-\n", url)
+			continue
+		}
+
+		skipped := 0
+		for _, count := range s.ItemsSkippedByFilter {
+			skipped += count
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\n", url, s.ItemsSent, skipped)
+		newItems += s.ItemsSent
+		skippedItems += skipped
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\n", newItems, skippedItems)
+	w.Flush()
+
+	// If we found errors then show them.
+	if len(errors) != 0 {
+		for _, err := range errors {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+func TestCount(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	os.MkdirAll(filepath.Join(dir, ".rss2email"), 0755)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0">
+<channel>
+<title>Test Feed</title>
+<item><title>Cake recipe</title><link>https://example.com/1</link><guid>guid-1</guid></item>
+<item><title>Bread recipe</title><link>https://example.com/2</link><guid>guid-2</guid></item>
+<item><title>Soup recipe</title><link>https://example.com/3</link><guid>guid-3</guid></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(dir, "feeds.txt")
+	err := os.WriteFile(path, []byte(ts.URL+"\n - exclude-title: (?i)cake\n - retry: 0\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	// One item has been seen already.
+	store, _, err := openStateStore("", true)
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	err = store.Record(ts.URL, "https://example.com/2")
+	store.Close()
+	if err != nil {
+		t.Fatalf("failed to record item: %s", err)
+	}
+
+	// Counting twice gives the same result, as nothing is recorded.
+	for i := 0; i < 2; i++ {
+		out = &bytes.Buffer{}
+		c := countCmd{config: configfile.NewWithPath(path)}
+		if c.Execute([]string{}) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+
+		output := out.(*bytes.Buffer).String()
+		for _, expected := range []string{
+			`FEED\s+NEW\s+SKIPPED`,
+			regexp.QuoteMeta(ts.URL) + `\s+1\s+1\n`,
+			`TOTAL\s+1\s+1\n`,
+		} {
+			if !regexp.MustCompile(expected).MatchString(output) {
+				t.Fatalf("expected %q in the output, got %s", expected, output)
+			}
+		}
+	}
+
+	store, _, err = openStateStore("", false)
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	items, err := store.Items(ts.URL)
+	store.Close()
+	if err != nil || len(items) != 1 {
+		t.Fatalf("expected the state to be unchanged, got %v: %v", items, err)
+	}
+
+	// The arguments must be addresses.
+	out = &bytes.Buffer{}
+	c := countCmd{config: configfile.NewWithPath(path)}
+	if c.Execute([]string{"bogus"}) != 1 {
+		t.Fatalf("expected failure with a bogus address")
+	}
+}
@@ -7,12 +7,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/skx/rss2email/config"
+	"github.com/skx/rss2email/configfile"
 	"github.com/skx/rss2email/processor"
+	"github.com/skx/rss2email/processor/output"
+	"github.com/skx/rss2email/state"
 )
 
 // Structure for our options and state.
@@ -25,6 +31,45 @@ type cronCmd struct {
 
 	// Default from address for emails
 	from string
+
+	// How many items to send from brand new feeds
+	backfill int
+
+	// Where to write new items, instead of emailing them
+	output string
+
+	// Should the SQLite output maintain full-text search tables?
+	sqliteFTS bool
+
+	// The Maildir to deliver emails into, instead of sending them
+	maildir string
+
+	// The mbox file to append emails to, instead of sending them
+	mbox string
+
+	// Should we forget the items of feeds whose options changed?
+	resetOnConfigChange bool
+
+	// How many feeds should we process at once?
+	concurrency int
+
+	// The SQLite database to record state in, if any
+	stateDB string
+
+	// How many times in a row may a feed fail before we stop trying?
+	circuitThreshold int
+
+	// How many days do we wait before trying such a feed again?
+	circuitReset int
+
+	// Should we write the emails we'd send, rather than sending them?
+	dryRun bool
+
+	// Where to write the emails of a dry-run, instead of STDOUT
+	dryRunOutput string
+
+	// The path to a configuration file to merge over our own, if any
+	mergeConfig string
 }
 
 // Info is part of the subcommand-API.
@@ -45,6 +90,121 @@ Example:
     $ rss2email cron user1@example.com user2@example.com
 
 
+Backfill:
+
+The first time a feed is processed every item it contains is new, which
+can mean a lot of email.  Adding '-backfill=N' will send only the oldest
+N items from such feeds, marking the rest as seen.  Feeds which have been
+processed previously are unaffected.
+
+
+Changed Options:
+
+The options of each feed are recorded, and if they change between runs a
+warning is logged, as the items seen previously were filtered by other
+settings.  Adding '-reset-on-config-change' also forgets the items seen
+in such feeds, so they are treated as new - combine it with '-backfill'
+to avoid receiving every item again.
+
+
+Merging Configurations:
+
+Feeds may be split between our configuration file and another, such as a
+shared file of feeds along with a file of personal overrides, by passing
+the path of the latter with '-merge-config'.  Feeds only found in the
+given file are added, and those found in both have their options merged,
+with those of the given file replacing ours - other than the options
+which may be repeated, such as "exclude", which are added to ours.
+
+
+State:
+
+The items we've seen are recorded in a BoltDB database by default.  To
+use a SQLite database instead, which will be created if necessary, run:
+
+    $ rss2email cron -state-db=/path/to/state.sqlite.db
+
+The existing state can be copied into it first with 'migrate-state':
+
+    $ rss2email migrate-state -to sqlite:/path/to/state.sqlite.db
+
+
+Concurrency:
+
+Feeds are processed one after another by default.  If you have a lot of
+them '-concurrency=N' processes N feeds at once, each fetching its feed
+and sending its emails independently.  An error with one feed doesn't
+stop the others.
+
+
+Failing Feeds:
+
+A feed which fails to be fetched, or parsed, five times in a row is not
+fetched again until a day has passed since its last failure, so that a
+feed which has gone away doesn't cause an error every run.  A single
+warning is logged when this happens, and one successful fetch resets the
+count.  Use '-circuit-breaker-threshold=N' to change the number of
+failures, or zero to always fetch feeds, and '-circuit-breaker-reset=N' to
+wait N days.  To clear the count of a feed, so that it is fetched again
+immediately, run:
+
+    $ rss2email reset-circuit-breaker https://example.com/feed.xml
+
+
+Output:
+
+Rather than sending emails new items may be written elsewhere, in which
+case no email addresses need to be given.  To store items in a SQLite
+database, which will be created if necessary, run:
+
+    $ rss2email cron -output=sqlite:/path/to/feeds.db
+
+Add '-sqlite-fts' to also maintain an 'items_fts' table, which allows
+full-text searching of item titles and content.
+
+To write each item as a Markdown file, with YAML front matter, for use
+with a static site generator such as Hugo or Jekyll, run:
+
+    $ rss2email cron -output=markdown:/path/to/content/
+
+Files are named after the date and title of each item, and existing
+files are never overwritten.
+
+To write the emails themselves, one after another, to STDOUT or a file,
+for use by a script, run:
+
+    $ rss2email cron -output=- user@example.com
+    $ rss2email cron -output=/path/to/emails.txt user@example.com
+
+Each email is preceded by an 'X-rss2email-feed' header naming its feed,
+and followed by a blank line.  The file is replaced by each run, and no
+SMTP settings are needed.  Adding '-dry-run' writes the same emails
+without recording anything.
+
+To create a Google Doc for each item, in a Google Drive folder, run:
+
+    $ rss2email cron -output=gdrive:FOLDER_ID
+
+This authenticates as a service account, which must be able to write to
+the folder, whose key file is named by 'gdrive-credentials-file' in the
+config.yaml file.  Set 'gdrive-delay', such as "2s", to wait between
+uploads if you exceed the Drive API quotas.  Items which fail to upload
+are not marked as seen, so they are tried again on the next run.
+
+
+Dry Run:
+
+To see the emails which would be sent, without sending them, run:
+
+    $ rss2email cron -dry-run user@example.com
+
+Each email is written to STDOUT, or to the file given by '-dry-run-output',
+with its headers, after an 'X-rss2email-feed' header naming its feed, and
+separated from the next by a blank line.  Nothing is
+recorded, so the next run will find the same new items.  This differs
+from '-send=false', which records new items as seen without sending them.
+
+
 Email Sending:
 
 By default we pipe outgoing messages through '/usr/sbin/sendmail' for delivery,
@@ -57,6 +217,26 @@ wish to use SMTP you need to configure the following environmental variables:
     SMTP_PASSWORD   (e.g. "secret!word#here")
 
 
+Maildir and Mbox:
+
+Instead of sending emails they may be delivered into a local Maildir, for
+a mail client such as mutt to read, by running:
+
+    $ rss2email cron -maildir=$HOME/Maildir/feeds user@example.com
+
+The Maildir, and its 'new', 'cur', and 'tmp' directories, are created if
+necessary.  Each email is written to a new file in the 'new' directory,
+once for each recipient, and no SMTP or sendmail settings are needed.
+
+Similarly, to append every email to a single file in the mbox format,
+which is handy for archiving, run:
+
+    $ rss2email cron -mbox=$HOME/feeds.mbox user@example.com
+
+Lines of the emails which begin with "From " are quoted as ">From ", and
+the file is created if necessary.
+
+
 Email Template:
 
 An embedded template is used to generate the emails which are sent, you
@@ -71,6 +251,19 @@ func (c *cronCmd) Arguments(f *flag.FlagSet) {
 	f.BoolVar(&c.verbose, "verbose", false, "Should we be extra verbose?")
 	f.BoolVar(&c.send, "send", true, "Should we send emails, or just pretend to?")
 	f.StringVar(&c.from, "from", "", "Default from address for emails")
+	f.IntVar(&c.backfill, "backfill", 0, "Only send the oldest N items from feeds which have never been processed")
+	f.StringVar(&c.output, "output", "", "Write new items to the given output, such as 'sqlite:/path/to/feeds.db', or the emails to a file, or '-' for STDOUT, instead of sending them")
+	f.StringVar(&c.maildir, "maildir", "", "Deliver emails into the Maildir at the given path, creating it if necessary, rather than sending them")
+	f.StringVar(&c.mbox, "mbox", "", "Append emails to the mbox file at the given path, creating it if necessary, rather than sending them")
+	f.BoolVar(&c.sqliteFTS, "sqlite-fts", false, "Create full-text search tables when using a SQLite output")
+	f.BoolVar(&c.resetOnConfigChange, "reset-on-config-change", false, "Forget the items seen in feeds whose options have changed, treating them as new")
+	f.IntVar(&c.concurrency, "concurrency", 1, "The number of feeds to process at once")
+	f.StringVar(&c.stateDB, "state-db", "", "Record the items we've seen in the given SQLite database, rather than the default BoltDB one")
+	f.IntVar(&c.circuitThreshold, "circuit-breaker-threshold", 5, "Stop fetching feeds which fail this many times in a row, zero to always fetch them")
+	f.IntVar(&c.circuitReset, "circuit-breaker-reset", 1, "The number of days to wait before fetching such feeds again")
+	f.BoolVar(&c.dryRun, "dry-run", false, "Write the emails we'd send to STDOUT, without sending them or recording anything")
+	f.StringVar(&c.dryRunOutput, "dry-run-output", "", "Write the emails of a dry-run to the given file, rather than STDOUT")
+	f.StringVar(&c.mergeConfig, "merge-config", "", "Merge the feeds and options of the given configuration file over those of our own")
 }
 
 // Entry-point
@@ -81,8 +274,35 @@ func (c *cronCmd) Execute(args []string) int {
 		loggerLevel.Set(slog.LevelDebug)
 	}
 
-	// No argument?  That's a bug
-	if len(args) == 0 {
+	// Is our output where emails are written, or is it an output,
+	// such as SQLite, which receives items instead of emails?
+	emails := c.output != "" && emailOutput(c.output)
+	items := c.output != "" && !emails
+
+	// A dry-run shows the emails we'd send, which makes no sense
+	// if we're not sending emails.
+	if c.dryRun && items {
+		fmt.Printf("The -dry-run flag can only be combined with an -output of emails\n")
+		return 1
+	}
+	if emails && c.dryRunOutput != "" {
+		fmt.Printf("The -dry-run-output and -output flags can't be combined\n")
+		return 1
+	}
+
+	// Items written to an output aren't emailed, so there's nothing to
+	// deliver to a Maildir, or an mbox file.
+	if (c.maildir != "" || c.mbox != "") && c.output != "" {
+		fmt.Printf("The -maildir and -mbox flags can't be combined with -output\n")
+		return 1
+	}
+	if c.maildir != "" && c.mbox != "" {
+		fmt.Printf("The -maildir and -mbox flags can't be combined\n")
+		return 1
+	}
+
+	// No argument?  That's a bug, unless we're not sending email.
+	if len(args) == 0 && !items {
 		fmt.Printf("Usage: rss2email cron email1@example.com .. emailN@example.com\n")
 		return 1
 	}
@@ -101,8 +321,48 @@ func (c *cronCmd) Execute(args []string) int {
 		}
 	}
 
+	// Load the application configuration, for the defaults it
+	// contains.  Errors are reported when sending email.
+	appConfig, cErr := config.Load()
+	if cErr != nil {
+		appConfig = &config.Config{}
+	}
+
+	// Set the default from address if provided
+	// Priority: --from flag, then config file, then FROM env var
+	fromAddr := c.from
+	if fromAddr == "" {
+		fromAddr = appConfig.From
+	}
+	if fromAddr == "" {
+		fromAddr = os.Getenv("FROM")
+	}
+
 	// Create the helper
-	p, err := processor.New()
+	p, err := processor.New(processor.ProcessorConfig{
+		Send:            c.send,
+		StatePath:       processor.DefaultStatePath(),
+		StatusPath:      processor.DefaultStatusPath(),
+		StateDB:         c.stateDB,
+		DefaultFrom:     fromAddr,
+		Backfill:        c.backfill,
+		RateLimit:       appConfig.SMTPRateLimit,
+		Maildir:         c.maildir,
+		Mbox:            c.mbox,
+		SMTPPoolSize:    appConfig.SMTPPoolSize,
+		SMTPIdleTimeout: appConfig.SMTPIdleTimeout,
+		MaxEmailsPerRun: appConfig.MaxEmailsPerRun,
+		Proxy:           appConfig.Proxy,
+		WebhookURL:      appConfig.WebhookURL,
+		WebhookSecret:   appConfig.WebhookSecret,
+		Concurrency:     c.concurrency,
+		Version:         version,
+
+		ResetOnConfigChange:     c.resetOnConfigChange,
+		CircuitBreakerThreshold: c.circuitThreshold,
+		CircuitBreakerReset:     time.Duration(c.circuitReset) * 24 * time.Hour,
+		TemplateVariables:       templateVariables(appConfig),
+	})
 	if err != nil {
 		logger.Error("failed to create feed processor",
 			slog.String("error", err.Error()))
@@ -113,22 +373,67 @@ func (c *cronCmd) Execute(args []string) int {
 	defer p.Close()
 
 	// Setup the state
-	p.SetSendEmail(c.send)
 	p.SetLogger(logger)
 
-	// Set the default from address if provided
-	// Priority: --from flag, then config file, then FROM env var
-	fromAddr := c.from
-	if fromAddr == "" {
-		if cfg, err := config.Load(); err == nil && cfg.From != "" {
-			fromAddr = cfg.From
+	// Are we writing our emails, rather than sending them, or only
+	// showing the emails we'd send?
+	if emails || c.dryRun {
+		path := c.output
+		if !emails {
+			path = c.dryRunOutput
+		}
+
+		var w io.Writer = os.Stdout
+		if path != "" && path != "-" {
+			file, err := os.Create(path)
+			if err != nil {
+				logger.Error("failed to create email output",
+					slog.String("path", path),
+					slog.String("error", err.Error()))
+				return 1
+			}
+			defer file.Close()
+
+			w = file
+		}
+
+		if c.dryRun {
+			p.SetDryRun(w)
+		} else {
+			p.SetEmailOutput(w)
 		}
 	}
-	if fromAddr == "" {
-		fromAddr = os.Getenv("FROM")
+
+	// Are we writing items somewhere other than email?
+	if items {
+		out, err := output.New(c.output, outputOptions(appConfig, c.sqliteFTS))
+		if err != nil {
+			logger.Error("failed to create output",
+				slog.String("output", c.output),
+				slog.String("error", err.Error()))
+			return 1
+		}
+		defer out.Close()
+
+		p.SetOutput(out)
 	}
-	if fromAddr != "" {
-		p.SetDefaultFrom(fromAddr)
+
+	// Are we combining our feeds with those of another file?
+	if c.mergeConfig != "" {
+		conf := configfile.New()
+
+		var feeds []configfile.Feed
+		_, err = conf.Parse()
+		if err == nil {
+			feeds, err = mergedFeeds(conf, c.mergeConfig)
+		}
+		if err != nil {
+			logger.Error("failed to merge configuration files",
+				slog.String("merge-config", c.mergeConfig),
+				slog.String("error", err.Error()))
+			return 1
+		}
+		p.SetFeeds(feeds)
 	}
 
 	errors := p.ProcessFeeds(recipients)
@@ -145,3 +450,64 @@ func (c *cronCmd) Execute(args []string) int {
 	// All good.
 	return 0
 }
+
+// mergedFeeds returns the feeds of the given configuration, with those of
+// the configuration file at the given path merged over them.
+//
+// The given configuration must already have been parsed, as is the case
+// for those received from Watch.
+func mergedFeeds(base *configfile.ConfigFile, path string) ([]configfile.Feed, error) {
+
+	overlay := configfile.NewWithPath(path)
+	_, err := overlay.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return configfile.Merge(base, overlay).Entries(), nil
+}
+
+// templateVariables returns the values from the template-variable-file
+// of the application configuration, if any.
+//
+// A file which can't be read is not fatal, we log a warning and the
+// templates see no values instead.
+func templateVariables(appConfig *config.Config) map[string]any {
+
+	vars, err := appConfig.TemplateVariables()
+	if err != nil {
+		logger.Warn("failed to load template-variable-file, using no variables",
+			slog.String("template-variable-file", appConfig.TemplateVariableFile),
+			slog.String("error", err.Error()))
+	}
+
+	return vars
+}
+
+// outputOptions returns the settings for our outputs, from the application
+// configuration and our flags.
+func outputOptions(appConfig *config.Config, sqliteFTS bool) output.Options {
+
+	// Relative paths are beneath our state directory.
+	credentials := appConfig.GDriveCredentialsFile
+	if credentials != "" && !filepath.IsAbs(credentials) {
+		credentials = filepath.Join(state.Directory(), credentials)
+	}
+
+	return output.Options{
+		SQLiteFTS:             sqliteFTS,
+		GDriveCredentialsFile: credentials,
+		GDriveDelay:           appConfig.GDriveDelay,
+		Logger:                logger,
+	}
+}
+
+// emailOutput returns true if the given -output is where our emails are
+// written, either "-" for STDOUT or the path to a file, rather than an
+// output such as "sqlite:/path/to/feeds.db" which receives the items.
+func emailOutput(spec string) bool {
+	return spec == "-" ||
+		!strings.Contains(spec, ":") ||
+		strings.HasPrefix(spec, "/") ||
+		strings.HasPrefix(spec, ".")
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/skx/rss2email/configfile"
 )
 
 func TestCronNoArguments(t *testing.T) {
@@ -23,3 +27,70 @@ func TestCronNotEmails(t *testing.T) {
 		t.Fatalf("Expected error when called with non-email addresses")
 	}
 }
+
+func TestCronEmailOutput(t *testing.T) {
+
+	for spec, expected := range map[string]bool{
+		"-":                        true,
+		"emails.txt":               true,
+		"/tmp/emails:today.txt":    true,
+		"./emails:today.txt":       true,
+		"sqlite:/path/to/feeds.db": false,
+		"markdown:/path/to/dir":    false,
+		"gdrive:FOLDER_ID":         false,
+	} {
+		if emailOutput(spec) != expected {
+			t.Fatalf("expected emailOutput(%q) to be %v", spec, expected)
+		}
+	}
+
+	// Emails need a recipient, and a dry-run can't be combined with
+	// an output of items.
+	c := cronCmd{output: "-"}
+	if c.Execute([]string{}) != 1 {
+		t.Fatalf("expected an error without recipients")
+	}
+	c = cronCmd{output: "sqlite:/path/to/feeds.db", dryRun: true}
+	if c.Execute([]string{}) != 1 {
+		t.Fatalf("expected an error with a dry-run and an output of items")
+	}
+	c = cronCmd{output: "-", dryRun: true, dryRunOutput: "emails.txt"}
+	if c.Execute([]string{"user@example.com"}) != 1 {
+		t.Fatalf("expected an error with a dry-run output and an output of emails")
+	}
+}
+
+func TestMergedFeeds(t *testing.T) {
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, "feeds.txt")
+	overlay := filepath.Join(dir, "overlay.txt")
+
+	err := os.WriteFile(base, []byte("https://example.com/\n - retry: 3\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	err = os.WriteFile(overlay, []byte("https://example.com/\n - retry: 5\nhttps://example.net/\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	conf := configfile.NewWithPath(base)
+	if _, err = conf.Parse(); err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+
+	feeds, err := mergedFeeds(conf, overlay)
+	if err != nil {
+		t.Fatalf("failed to merge: %s", err)
+	}
+	if len(feeds) != 2 || feeds[0].GetOption("retry", "") != "5" || feeds[1].URL != "https://example.net/" {
+		t.Fatalf("unexpected feeds %v", feeds)
+	}
+
+	// A missing overlay is an error.
+	_, err = mergedFeeds(conf, filepath.Join(dir, "missing.txt"))
+	if err == nil {
+		t.Fatalf("expected an error merging a missing file")
+	}
+}
@@ -5,15 +5,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/skx/rss2email/config"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/metrics"
 	"github.com/skx/rss2email/processor"
+	"github.com/skx/rss2email/processor/output"
 )
 
 // Structure for our options and state.
@@ -24,6 +31,42 @@ type daemonCmd struct {
 
 	// Default from address for emails
 	from string
+
+	// How many items to send from brand new feeds
+	backfill int
+
+	// Where to write new items, instead of emailing them
+	output string
+
+	// Should the SQLite output maintain full-text search tables?
+	sqliteFTS bool
+
+	// The Maildir to deliver emails into, instead of sending them
+	maildir string
+
+	// The mbox file to append emails to, instead of sending them
+	mbox string
+
+	// Should we forget the items of feeds whose options changed?
+	resetOnConfigChange bool
+
+	// How many feeds should we process at once?
+	concurrency int
+
+	// The SQLite database to record state in, if any
+	stateDB string
+
+	// How many times in a row may a feed fail before we stop trying?
+	circuitThreshold int
+
+	// How many days do we wait before trying such a feed again?
+	circuitReset int
+
+	// The address to export Prometheus metrics on, if any
+	metricsAddr string
+
+	// The path to a configuration file to merge over our own, if any
+	mergeConfig string
 }
 
 // Info is part of the subcommand-API.
@@ -43,6 +86,32 @@ In terms of implementation this command follows everything documented
 in the 'cron' sub-command.  The only difference is this one never
 terminates - even if email-generation fails.
 
+The configuration file is watched for changes, and when it is updated
+the feeds are processed again immediately, without waiting for the
+next poll.  Sending the process a SIGHUP has the same effect, and also
+reloads any files referenced by the configuration, such as the lists
+of patterns used by 'exclude-title-list-file', the author lists, and the
+'template-variable-file'.
+
+Several feeds may be processed at once by using '-concurrency', state
+may be recorded in a SQLite database with '-state-db', and feeds which keep
+failing are skipped for a while, as controlled by '-circuit-breaker-threshold'
+and '-circuit-breaker-reset', as described in the 'cron' sub-command.
+
+Items may be written to an output, such as a SQLite database, rather than
+emailed by using '-output', and emails may be delivered into a Maildir, or
+an mbox file, by using '-maildir' or '-mbox', as described in the 'cron'
+sub-command.
+
+To monitor the daemon with Prometheus use '-metrics-addr', such as ":9090",
+and the counts of the feeds processed, the items seen, skipped, and sent,
+and the fetch errors of each feed, will be available from the '/metrics'
+endpoint on that address, along with the duration of the last run.
+
+Another configuration file may be merged over our own with '-merge-config',
+as described in the 'cron' sub-command.  Only our own file is watched for
+changes, send a SIGHUP to reload the other.
+
 
 Example:
 
@@ -54,6 +123,18 @@ Example:
 func (d *daemonCmd) Arguments(f *flag.FlagSet) {
 	f.BoolVar(&d.verbose, "verbose", false, "Should we be extra verbose?")
 	f.StringVar(&d.from, "from", "", "Default from address for emails")
+	f.IntVar(&d.backfill, "backfill", 0, "Only send the oldest N items from feeds which have never been processed")
+	f.StringVar(&d.output, "output", "", "Write new items to the given output, such as 'sqlite:/path/to/feeds.db', instead of emailing them")
+	f.StringVar(&d.maildir, "maildir", "", "Deliver emails into the Maildir at the given path, creating it if necessary, rather than sending them")
+	f.StringVar(&d.mbox, "mbox", "", "Append emails to the mbox file at the given path, creating it if necessary, rather than sending them")
+	f.BoolVar(&d.sqliteFTS, "sqlite-fts", false, "Create full-text search tables when using a SQLite output")
+	f.BoolVar(&d.resetOnConfigChange, "reset-on-config-change", false, "Forget the items seen in feeds whose options have changed, treating them as new")
+	f.IntVar(&d.concurrency, "concurrency", 1, "The number of feeds to process at once")
+	f.StringVar(&d.stateDB, "state-db", "", "Record the items we've seen in the given SQLite database, rather than the default BoltDB one")
+	f.IntVar(&d.circuitThreshold, "circuit-breaker-threshold", 5, "Stop fetching feeds which fail this many times in a row, zero to always fetch them")
+	f.IntVar(&d.circuitReset, "circuit-breaker-reset", 1, "The number of days to wait before fetching such feeds again")
+	f.StringVar(&d.metricsAddr, "metrics-addr", "", "Export Prometheus metrics from '/metrics' on the given address, such as ':9090'")
+	f.StringVar(&d.mergeConfig, "merge-config", "", "Merge the feeds and options of the given configuration file over those of our own")
 }
 
 // Entry-point
@@ -64,8 +145,19 @@ func (d *daemonCmd) Execute(args []string) int {
 		loggerLevel.Set(slog.LevelDebug)
 	}
 
-	// No argument?  That's a bug
-	if len(args) == 0 {
+	// Items written to an output aren't emailed, so there's nothing to
+	// deliver to a Maildir, or an mbox file.
+	if (d.maildir != "" || d.mbox != "") && d.output != "" {
+		fmt.Printf("The -maildir and -mbox flags can't be combined with -output\n")
+		return 1
+	}
+	if d.maildir != "" && d.mbox != "" {
+		fmt.Printf("The -maildir and -mbox flags can't be combined\n")
+		return 1
+	}
+
+	// No argument?  That's a bug, unless we're not sending email.
+	if len(args) == 0 && d.output == "" {
 		fmt.Printf("Usage: rss2email daemon email1@example.com .. emailN@example.com\n")
 		return 1
 	}
@@ -84,51 +176,166 @@ func (d *daemonCmd) Execute(args []string) int {
 		}
 	}
 
-	for {
+	// Watch the configuration file for changes.
+	//
+	// If this fails we just keep going, the configuration file will
+	// be re-read at the start of each run anyway.
+	conf := configfile.New()
+	conf.SetLogger(logger)
+
+	changes, err := conf.Watch(context.Background())
+	if err != nil {
+		logger.Warn("failed to watch configuration file for changes",
+			slog.String("configfile", conf.Path()),
+			slog.String("error", err.Error()))
+	}
 
-		// Create the helper
-		p, err := processor.New()
+	// Are we writing items somewhere other than email?
+	var out output.Output
+	if d.output != "" {
+		appConfig, cErr := config.Load()
+		if cErr != nil {
+			appConfig = &config.Config{}
+		}
 
+		out, err = output.New(d.output, outputOptions(appConfig, d.sqliteFTS))
 		if err != nil {
-			logger.Error("failed to create feed processor",
+			logger.Error("failed to create output",
+				slog.String("output", d.output),
 				slog.String("error", err.Error()))
 			return 1
 		}
+		defer out.Close()
+	}
 
-		// Ensure we send our version
-		p.SetVersion(version)
+	// Start exporting our metrics, if we're asked to, before we
+	// process any feeds.
+	var metricsServer *metrics.Server
+	if d.metricsAddr != "" {
+		metricsServer, err = metrics.New(d.metricsAddr, logger)
+		if err != nil {
+			logger.Error("failed to start metrics server",
+				slog.String("addr", d.metricsAddr),
+				slog.String("error", err.Error()))
+			return 1
+		}
+		defer metricsServer.Close()
+
+		logger.Info("exporting metrics",
+			slog.String("addr", metricsServer.Addr()))
+	}
+
+	// SIGHUP causes the configuration file, and any files it references,
+	// to be reloaded and the feeds processed immediately.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// The feeds we process, which are replaced when the configuration
+	// file changes.  While this is nil the processor will parse the
+	// configuration file itself.
+	var feeds []configfile.Feed
+
+	// The values available to our email templates, which are only
+	// re-read when we receive a SIGHUP.  While this is nil they will
+	// be loaded from the template-variable-file.
+	var vars map[string]any
+
+	for {
 
-		// Setup the state - note we ALWAYS send emails in this mode.
-		p.SetSendEmail(true)
-		p.SetLogger(logger)
+		// Load the application configuration, for the defaults it
+		// contains.  Errors are reported when sending email.
+		appConfig, cErr := config.Load()
+		if cErr != nil {
+			appConfig = &config.Config{}
+		}
+
+		if vars == nil {
+			vars = templateVariables(appConfig)
+		}
+
+		// Merge the other configuration file over ours, if we've not
+		// already done so.
+		if feeds == nil && d.mergeConfig != "" {
+			_, err := conf.Parse()
+			if err == nil {
+				feeds, err = mergedFeeds(conf, d.mergeConfig)
+			}
+			if err != nil {
+				logger.Error("failed to merge configuration files",
+					slog.String("merge-config", d.mergeConfig),
+					slog.String("error", err.Error()))
+				return 1
+			}
+		}
 
 		// Set the default from address if provided
 		// Priority: --from flag, then config file, then FROM env var
 		fromAddr := d.from
 		if fromAddr == "" {
-			if cfg, err := config.Load(); err == nil && cfg.From != "" {
-				fromAddr = cfg.From
-			}
+			fromAddr = appConfig.From
 		}
 		if fromAddr == "" {
 			fromAddr = os.Getenv("FROM")
 		}
-		if fromAddr != "" {
-			p.SetDefaultFrom(fromAddr)
-		}
 
-		// Process all the feeds
-		errors := p.ProcessFeeds(recipients)
+		// Create the helper - note we ALWAYS send emails in this mode.
+		p, err := processor.New(processor.ProcessorConfig{
+			Send:            true,
+			StatePath:       processor.DefaultStatePath(),
+			StatusPath:      processor.DefaultStatusPath(),
+			StateDB:         d.stateDB,
+			DefaultFrom:     fromAddr,
+			Backfill:        d.backfill,
+			RateLimit:       appConfig.SMTPRateLimit,
+			Maildir:         d.maildir,
+			Mbox:            d.mbox,
+			SMTPPoolSize:    appConfig.SMTPPoolSize,
+			SMTPIdleTimeout: appConfig.SMTPIdleTimeout,
+			MaxEmailsPerRun: appConfig.MaxEmailsPerRun,
+			Proxy:           appConfig.Proxy,
+			WebhookURL:      appConfig.WebhookURL,
+			WebhookSecret:   appConfig.WebhookSecret,
+			Concurrency:     d.concurrency,
+			Version:         version,
+
+			ResetOnConfigChange:     d.resetOnConfigChange,
+			CircuitBreakerThreshold: d.circuitThreshold,
+			CircuitBreakerReset:     time.Duration(d.circuitReset) * 24 * time.Hour,
+			TemplateVariables:       vars,
+		})
+
+		// Another process, such as a cron-job, might be using our
+		// state, in which case we'll try again next time.
+		if errors.Is(err, processor.ErrLocked) {
+			logger.Warn("state is in use by another process, skipping this poll",
+				slog.String("error", err.Error()))
+		} else if err != nil {
+			logger.Error("failed to create feed processor",
+				slog.String("error", err.Error()))
+			return 1
+		} else {
+
+			// Setup the state
+			p.SetLogger(logger)
+			p.SetFeeds(feeds)
+			p.SetOutput(out)
+			if metricsServer != nil {
+				p.SetMetrics(metricsServer)
+			}
+
+			// Process all the feeds
+			errs := p.ProcessFeeds(recipients)
 
-		// If we found errors then show them.
-		if len(errors) != 0 {
-			for _, err := range errors {
-				fmt.Fprintln(os.Stderr, err.Error())
+			// If we found errors then show them.
+			if len(errs) != 0 {
+				for _, err := range errs {
+					fmt.Fprintln(os.Stderr, err.Error())
+				}
 			}
-		}
 
-		// Close the database handle, once processed.
-		p.Close()
+			// Close the database handle, once processed.
+			p.Close()
+		}
 
 		// Default time to sleep - in minutes
 		n := 5
@@ -136,6 +343,45 @@ func (d *daemonCmd) Execute(args []string) int {
 		logger.Debug("sleeping before polling feeds again",
 			slog.Int("delay.minutes", n))
 
-		time.Sleep(time.Duration(n) * time.Minute)
+		// Wait for the delay to pass, or the configuration
+		// file to change - whichever comes first.
+		//
+		// A nil configuration means the updated file could not
+		// be parsed, in which case we keep using our existing
+		// feeds until the normal delay has passed.
+		timer := time.NewTimer(time.Duration(n) * time.Minute)
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case <-hup:
+				logger.Info("received SIGHUP, reloading configuration")
+				feeds = nil
+				vars = nil
+				timer.Stop()
+				break wait
+			case updated := <-changes:
+				if updated == nil {
+					continue
+				}
+				logger.Debug("configuration file changed, processing feeds")
+				// As for a file which can't be parsed, we keep
+				// our feeds if the merge fails.
+				merged := updated.Entries()
+				if d.mergeConfig != "" {
+					merged, err = mergedFeeds(updated, d.mergeConfig)
+					if err != nil {
+						logger.Error("failed to merge configuration files",
+							slog.String("merge-config", d.mergeConfig),
+							slog.String("error", err.Error()))
+						continue
+					}
+				}
+				feeds = merged
+				timer.Stop()
+				break wait
+			}
+		}
 	}
 }
@@ -5,19 +5,20 @@
 package main
 
 import (
+	"encoding/xml"
 	"flag"
+	"fmt"
 	"log/slog"
-	"text/template"
+	"os"
 
 	"github.com/skx/rss2email/configfile"
-	"github.com/skx/subcommands"
 )
 
 // Structure for our options and state.
 type exportCmd struct {
 
-	// We embed the NoFlags option, because we accept no command-line flags.
-	subcommands.NoFlags
+	// output is the file to write to, rather than STDOUT.
+	output string
 
 	// Configuration file, used for testing
 	config *configfile.ConfigFile
@@ -27,40 +28,73 @@ type exportCmd struct {
 func (e *exportCmd) Info() (string, string) {
 	return "export", `Export the feed list as an OPML file.
 
-This command exports the list of configured feeds as an OPML file.
+This command exports the list of configured feeds as an OPML 2.0 file,
+which may be imported by other feed readers.
+
+Each feed is an outline within the body of the file, whose title is the
+"feed-name" option of the feed, if it has one.
 
 To see details of the configuration file, including the location,
 please run:
 
    $ rss2email help config
 
-Example:
+Examples:
 
-    $ rss2email export
+    $ rss2email export > feeds.opml
+    $ rss2email export -output feeds.opml
 `
 }
 
 // Arguments handles argument-flags we might have.
 //
-// In our case we use this as a hook to setup our configuration-file,
-// which allows testing.
+// We also use this as a hook to setup our configuration-file, which
+// allows testing.
 func (e *exportCmd) Arguments(flags *flag.FlagSet) {
 	e.config = configfile.New()
+
+	if flags != nil {
+		flags.StringVar(&e.output, "output", "", "Write the OPML to the given file, rather than STDOUT")
+	}
 }
 
-// Execute is invoked if the user specifies `add` as the subcommand.
-func (e *exportCmd) Execute(args []string) int {
+// exportOPML returns the OPML document describing the given feeds.
+func exportOPML(entries []configfile.Feed) ([]byte, error) {
+
+	doc := opml{Version: "2.0", OpmlTitle: "Feed Export"}
 
-	// Individual feed URL
-	type Feed struct {
-		URL string
+	for _, entry := range entries {
+
+		// The text is required, so we fall back to the URL.
+		name := ""
+		for _, opt := range entry.Options {
+			if opt.Name == "feed-name" {
+				name = opt.Value
+			}
+		}
+		text := name
+		if text == "" {
+			text = entry.URL
+		}
+
+		doc.Outlines = append(doc.Outlines, outline{
+			Text:   text,
+			Title:  name,
+			Type:   "rss",
+			XMLURL: entry.URL,
+		})
 	}
 
-	// Template Data
-	type TemplateData struct {
-		Entries []Feed
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
 	}
-	data := TemplateData{}
+
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// Execute is invoked if the user specifies `export` as the subcommand.
+func (e *exportCmd) Execute(args []string) int {
 
 	// Now do the parsing
 	entries, err := e.config.Parse()
@@ -71,29 +105,27 @@ func (e *exportCmd) Execute(args []string) int {
 		return 1
 	}
 
-	// Populate our template variables
-	for _, entry := range entries {
-		data.Entries = append(data.Entries, Feed{URL: entry.URL})
+	data, err := exportOPML(entries)
+	if err != nil {
+		logger.Error("failed to encode OPML", slog.String("error", err.Error()))
+		return 1
 	}
 
-	// Template
-	tmpl := `<?xml version="1.0" encoding="utf-8"?>
-<opml version="1.0">
-<head>
-<title>Feed Export</title>
-</head>
-<body>
-{{range .Entries}}<outline xmlUrl="{{.URL}}"/>
-{{end}}</body>
-</opml>
-`
-	// Compile the template and write to STDOUT
-	t := template.Must(template.New("tmpl").Parse(tmpl))
-	err = t.Execute(out, data)
+	if e.output == "" {
+		_, err = out.Write(data)
+		if err != nil {
+			logger.Error("failed to write OPML", slog.String("error", err.Error()))
+			return 1
+		}
+		return 0
+	}
+
+	err = os.WriteFile(e.output, data, 0644)
 	if err != nil {
-		logger.Error("error rendering template", slog.String("error", err.Error()))
+		logger.Error("failed to write OPML", slog.String("path", e.output), slog.String("error", err.Error()))
 		return 1
 	}
 
+	fmt.Fprintf(out, "Exported %d feeds to %s\n", len(entries), e.output)
 	return 0
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/xml"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -65,3 +67,64 @@ https://example.net/
 	// Cleanup
 	os.Remove(tmpfile.Name())
 }
+
+func TestExportOPML(t *testing.T) {
+
+	bak := out
+	buf := &bytes.Buffer{}
+	out = buf
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feeds.txt")
+	err := os.WriteFile(path, []byte(`https://example.org/
+ - feed-name: Example & Co
+https://example.net/feed?a=1&b=2
+ - tag: other
+https://example.com/atom.xml
+`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config file")
+	}
+
+	ex := exportCmd{}
+	ex.Arguments(nil)
+	ex.config = configfile.NewWithPath(path)
+	ex.output = filepath.Join(dir, "feeds.opml")
+
+	if ex.Execute([]string{}) != 0 {
+		t.Fatalf("export failed")
+	}
+	if !strings.Contains(buf.String(), "Exported 3 feeds") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	data, err := os.ReadFile(ex.output)
+	if err != nil {
+		t.Fatalf("failed to read OPML: %s", err)
+	}
+
+	// The output is valid XML, which we can import.
+	doc := opml{}
+	err = xml.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatalf("invalid OPML: %s\n%s", err, data)
+	}
+	if doc.Version != "2.0" || len(doc.Outlines) != 3 {
+		t.Fatalf("unexpected OPML %s", data)
+	}
+
+	expected := []outline{
+		{Text: "Example & Co", Title: "Example & Co", Type: "rss", XMLURL: "https://example.org/"},
+		{Text: "https://example.net/feed?a=1&b=2", Type: "rss", XMLURL: "https://example.net/feed?a=1&b=2"},
+		{Text: "https://example.com/atom.xml", Type: "rss", XMLURL: "https://example.com/atom.xml"},
+	}
+	for i, o := range doc.Outlines {
+		if o.Text != expected[i].Text || o.Title != expected[i].Title || o.Type != expected[i].Type || o.XMLURL != expected[i].XMLURL {
+			t.Fatalf("unexpected outline %d: %+v", i, o)
+		}
+	}
+	if strings.Contains(string(data), "title=\"\"") {
+		t.Fatalf("empty attributes were written %s", data)
+	}
+}
@@ -0,0 +1,179 @@
+//
+// Export our seen-state as JSON.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/skx/rss2email/state"
+)
+
+// stateExport is the JSON document written by export-state, and read by
+// import-state.
+type stateExport struct {
+	Feeds []stateExportFeed `json:"feeds"`
+}
+
+// stateExportFeed holds the seen items of a single feed.
+type stateExportFeed struct {
+	URL   string            `json:"url"`
+	Items []stateExportItem `json:"items"`
+}
+
+// stateExportItem is a single seen item, and the time it was seen in RFC
+// 3339 format, which is empty if the state doesn't record it.
+type stateExportItem struct {
+	GUID   string `json:"guid"`
+	SeenAt string `json:"seen_at,omitempty"`
+}
+
+// Structure for our options and state.
+type exportStateCmd struct {
+
+	// output is the file to write to, rather than STDOUT.
+	output string
+
+	// stateDB is the SQLite database holding our state, if any.
+	stateDB string
+}
+
+// Info is part of the subcommand-API.
+func (e *exportStateCmd) Info() (string, string) {
+	return "export-state", `Export the seen-state as JSON.
+
+This sub-command writes every item we've seen, in each feed, along with
+the time it was seen, as JSON.  This may be used to back up the state,
+or to move it to another machine with 'import-state'.
+
+The state is read from the BoltDB database in ~/.rss2email/state.db,
+unless '-state-db' names the SQLite database given to 'cron' or 'daemon'.
+
+The output looks like this, the 'seen_at' time is missing for items
+recorded by older releases:
+
+    {"feeds": [{"url": "https://example.com/feed.xml",
+                "items": [{"guid": "https://example.com/1",
+                           "seen_at": "2024-03-10T12:00:00Z"}]}]}
+
+Examples:
+
+    $ rss2email export-state > state.json
+    $ rss2email export-state -output state.json
+    $ rss2email export-state -state-db /path/to/state.sqlite.db
+`
+}
+
+// Arguments handles our flag-setup.
+func (e *exportStateCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&e.output, "output", "", "Write the JSON to the given file, rather than STDOUT")
+	f.StringVar(&e.stateDB, "state-db", "", "Read the state from the given SQLite database, rather than the default BoltDB one")
+}
+
+// openStateStore opens the state store our commands use, which is the
+// SQLite database given, or our BoltDB database if that is empty.
+//
+// Unless create is set the store must already exist.
+func openStateStore(stateDB string, create bool) (state.Store, string, error) {
+
+	path := state.DefaultPath()
+	if stateDB != "" {
+		path = stateDB
+	}
+
+	if !create {
+		if _, err := os.Stat(path); err != nil {
+			return nil, path, err
+		}
+	} else {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return nil, path, err
+		}
+	}
+
+	if stateDB != "" {
+		store, err := state.OpenSQLite(path)
+		return store, path, err
+	}
+	store, err := state.OpenBolt(path)
+	return store, path, err
+}
+
+// Entry-point.
+func (e *exportStateCmd) Execute(args []string) int {
+
+	store, path, err := openStateStore(e.stateDB, false)
+	if err != nil {
+		logger.Error("failed to open state", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	defer store.Close()
+
+	feeds, err := store.Feeds()
+	if err != nil {
+		logger.Error("failed to read feeds", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	sort.Strings(feeds)
+
+	export := stateExport{Feeds: []stateExportFeed{}}
+	total := 0
+
+	for _, feed := range feeds {
+
+		times, err := store.SeenTimes(feed)
+		if err != nil {
+			logger.Error("failed to read items", slog.String("state", path), slog.String("feed", feed), slog.String("error", err.Error()))
+			return 1
+		}
+
+		entry := stateExportFeed{URL: feed, Items: []stateExportItem{}}
+		for guid, seen := range times {
+			item := stateExportItem{GUID: guid}
+			if !seen.IsZero() {
+				item.SeenAt = seen.UTC().Format(time.RFC3339)
+			}
+			entry.Items = append(entry.Items, item)
+		}
+		sort.Slice(entry.Items, func(i, j int) bool {
+			return entry.Items[i].GUID < entry.Items[j].GUID
+		})
+
+		export.Feeds = append(export.Feeds, entry)
+		total += len(entry.Items)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		logger.Error("failed to encode state", slog.String("error", err.Error()))
+		return 1
+	}
+	data = append(data, '\n')
+
+	if e.output == "" {
+		_, err = out.Write(data)
+		if err != nil {
+			logger.Error("failed to write state", slog.String("error", err.Error()))
+			return 1
+		}
+		return 0
+	}
+
+	err = os.WriteFile(e.output, data, 0644)
+	if err != nil {
+		logger.Error("failed to write state", slog.String("path", e.output), slog.String("error", err.Error()))
+		return 1
+	}
+
+	fmt.Fprintf(out, "Exported %d entries from %d feeds to %s\n", total, len(export.Feeds), e.output)
+	return 0
+}
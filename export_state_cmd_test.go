@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skx/rss2email/state"
+)
+
+// TestExportState round-trips state through export-state and import-state,
+// with each backend.
+func TestExportState(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	os.MkdirAll(filepath.Join(dir, ".rss2email"), 0755)
+
+	// Populate the default BoltDB state.
+	src, err := state.OpenBolt(state.DefaultPath())
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	then := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	err = src.RecordTimes("https://example.com/feed", map[string]time.Time{
+		"https://example.com/1": then,
+		"https://example.com/2": then.Add(time.Hour),
+	})
+	if err == nil {
+		err = src.RecordTimes("https://example.net/feed", map[string]time.Time{"legacy": {}})
+	}
+	if err == nil {
+		err = src.SetFingerprint("https://example.com/feed", "abc")
+	}
+	if err != nil {
+		t.Fatalf("failed to record state: %s", err)
+	}
+	src.Close()
+
+	// Export to STDOUT.
+	out = &bytes.Buffer{}
+	e := exportStateCmd{}
+	if e.Execute(nil) != 0 {
+		t.Fatalf("unexpected failure: %s", out)
+	}
+	exported := out.(*bytes.Buffer).String()
+	for _, expected := range []string{
+		`"url": "https://example.com/feed"`,
+		`"guid": "https://example.com/1"`,
+		`"seen_at": "2024-03-10T12:00:00Z"`,
+		`"guid": "legacy"`,
+	} {
+		if !strings.Contains(exported, expected) {
+			t.Fatalf("expected %s in export: %s", expected, exported)
+		}
+	}
+
+	// The fingerprints aren't a feed.
+	if strings.Count(exported, `"url"`) != 2 {
+		t.Fatalf("unexpected feeds in export: %s", exported)
+	}
+
+	// Export to a file, and import it into a SQLite database, twice.
+	file := filepath.Join(dir, "state.json")
+	out = &bytes.Buffer{}
+	e = exportStateCmd{output: file}
+	if e.Execute(nil) != 0 || !strings.Contains(out.(*bytes.Buffer).String(), "Exported 3 entries from 2 feeds") {
+		t.Fatalf("unexpected export: %s", out)
+	}
+
+	sqlite := filepath.Join(dir, "state.sqlite.db")
+	for _, expected := range []string{
+		"Imported 3 entries from 2 feeds, 0 were already present",
+		"Imported 0 entries from 2 feeds, 3 were already present",
+	} {
+		out = &bytes.Buffer{}
+		i := importStateCmd{stateDB: sqlite}
+		if i.Execute([]string{file}) != 0 || !strings.Contains(out.(*bytes.Buffer).String(), expected) {
+			t.Fatalf("unexpected import: %s", out)
+		}
+	}
+
+	// Exporting the SQLite database gives the same result.
+	out = &bytes.Buffer{}
+	e = exportStateCmd{stateDB: sqlite}
+	if e.Execute(nil) != 0 {
+		t.Fatalf("unexpected failure: %s", out)
+	}
+	if out.(*bytes.Buffer).String() != exported {
+		t.Fatalf("state differs after round-trip:\n%s\n%s", exported, out)
+	}
+
+	dst, err := state.OpenSQLite(sqlite)
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	defer dst.Close()
+	items, err := dst.Items("https://example.com/feed")
+	sort.Strings(items)
+	if err != nil || strings.Join(items, ",") != "https://example.com/1,https://example.com/2" {
+		t.Fatalf("unexpected items %v %v", items, err)
+	}
+
+	// Missing state is reported.
+	out = &bytes.Buffer{}
+	e = exportStateCmd{stateDB: filepath.Join(dir, "missing.db")}
+	if e.Execute(nil) != 1 {
+		t.Fatalf("expected failure with missing state")
+	}
+}
@@ -0,0 +1,46 @@
+//
+// Generate a sample configuration file.
+//
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/subcommands"
+)
+
+// generateConfigCmd holds our state.
+type generateConfigCmd struct {
+
+	// We embed the NoFlags option, because we accept no command-line flags.
+	subcommands.NoFlags
+}
+
+// Info is part of the subcommand-API
+func (g *generateConfigCmd) Info() (string, string) {
+	return "generate-config", `Display a sample configuration file.
+
+This sub-command outputs a sample configuration file, containing a single
+feed with every per-feed option we support listed beneath it, commented
+out, along with a description of what it does and the values it accepts.
+
+Remove the '#' from the start of an option to enable it.
+
+The output can be used as a starting point for your own configuration:
+
+   $ rss2email generate-config > my.conf
+
+
+Example:
+
+    $ rss2email generate-config
+`
+}
+
+// Execute is invoked if the user specifies `generate-config` as the subcommand.
+func (g *generateConfigCmd) Execute(args []string) int {
+	fmt.Fprint(out, configfile.Sample())
+	return 0
+}
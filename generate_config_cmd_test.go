@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+func TestGenerateConfig(t *testing.T) {
+	bak := out
+	buf := &bytes.Buffer{}
+	out = buf
+	defer func() { out = bak }()
+
+	g := generateConfigCmd{}
+	if g.Execute([]string{}) != 0 {
+		t.Fatalf("unexpected failure")
+	}
+
+	if buf.String() != configfile.Sample() {
+		t.Fatalf("unexpected output %s", buf.String())
+	}
+}
@@ -0,0 +1,84 @@
+//
+// Report whether our feeds have been processed recently.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/skx/rss2email/processor"
+)
+
+// Structure for our options and state.
+type healthCheckCmd struct {
+
+	// The oldest the last run may be, for us to be healthy
+	maxAge time.Duration
+
+	// The status file to read, used for testing
+	path string
+
+	// Where problems are reported, used for testing
+	stderr io.Writer
+}
+
+// Info is part of the subcommand-API.
+func (h *healthCheckCmd) Info() (string, string) {
+	return "health-check", `Report whether our feeds have been processed recently.
+
+At the end of each run which reaches every feed, the 'cron' and 'daemon'
+sub-commands record the time, the number of feeds which succeeded and
+failed, and the number of emails sent, as JSON in the status file:
+
+    ~/.rss2email/status.json
+
+This sub-command reads that file and exits with a zero status if the last
+run finished within '-max-age', which defaults to two hours.  If the file
+is missing, or the last run is older than that, the problem is reported to
+STDERR and the exit code is non-zero.  This makes it suitable for use by
+monitoring systems, or as the health-check of a container.
+
+Example:
+
+    $ rss2email health-check
+    $ rss2email health-check -max-age 30m
+`
+}
+
+// Arguments handles our flag-setup.
+func (h *healthCheckCmd) Arguments(f *flag.FlagSet) {
+	h.path = processor.DefaultStatusPath()
+	h.stderr = os.Stderr
+
+	f.DurationVar(&h.maxAge, "max-age", 2*time.Hour, "The oldest the last run may be, such as '30m' or '2h'")
+}
+
+// Entry-point.
+func (h *healthCheckCmd) Execute(args []string) int {
+
+	status, err := processor.ReadStatus(h.path)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(h.stderr, "UNHEALTHY: no status file found at %s, have the feeds been processed?\n", h.path)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(h.stderr, "UNHEALTHY: failed to read status file %s: %s\n", h.path, err)
+		return 1
+	}
+
+	age := time.Since(status.LastRun).Round(time.Second)
+	if age > h.maxAge {
+		fmt.Fprintf(h.stderr, "UNHEALTHY: the last run was at %s, %s ago, which is older than %s\n",
+			status.LastRun.Format(time.RFC3339), age, h.maxAge)
+		return 1
+	}
+
+	fmt.Fprintf(out, "OK: the last run was at %s, %s ago - %d feeds OK, %d with errors, %d emails sent\n",
+		status.LastRun.Format(time.RFC3339), age, status.FeedsOK, status.FeedsError, status.EmailsSent)
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	defer func() { out = bak }()
+
+	path := filepath.Join(t.TempDir(), "status.json")
+
+	write := func(lastRun time.Time) {
+		err := os.WriteFile(path, []byte(fmt.Sprintf(`{"last_run": %q, "feeds_ok": 3, "feeds_error": 1, "emails_sent": 7}`, lastRun.Format(time.RFC3339))), 0644)
+		if err != nil {
+			t.Fatalf("failed to write status file: %s", err)
+		}
+	}
+
+	tests := []struct {
+		// lastRun is how long ago the last run was, or zero if
+		// there's no status file.
+		lastRun time.Duration
+		result  int
+		output  string
+	}{
+		{0, 1, "no status file found"},
+		{10 * time.Minute, 0, "3 feeds OK, 1 with errors, 7 emails sent"},
+		{3 * time.Hour, 1, "which is older than 2h0m0s"},
+	}
+
+	for _, tst := range tests {
+		if tst.lastRun != 0 {
+			write(time.Now().Add(-tst.lastRun))
+		}
+
+		out = &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		h := healthCheckCmd{path: path, stderr: stderr, maxAge: 2 * time.Hour}
+
+		res := h.Execute(nil)
+		if res != tst.result {
+			t.Fatalf("expected result %d, got %d", tst.result, res)
+		}
+
+		output := out.(*bytes.Buffer).String() + stderr.String()
+		if !strings.Contains(output, tst.output) {
+			t.Fatalf("failed to find expected output %q, got %s", tst.output, output)
+		}
+
+		// Problems are reported to STDERR.
+		if (stderr.Len() > 0) != (tst.result != 0) {
+			t.Fatalf("unexpected STDERR %q", stderr.String())
+		}
+	}
+
+	// A file we can't parse is unhealthy.
+	err := os.WriteFile(path, []byte("not json"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write status file: %s", err)
+	}
+	h := healthCheckCmd{path: path, stderr: &bytes.Buffer{}, maxAge: 2 * time.Hour}
+	if h.Execute(nil) != 1 {
+		t.Fatalf("expected failure with an invalid status file")
+	}
+}
@@ -5,17 +5,21 @@
 package httpfetch
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mmcdole/gofeed"
@@ -27,9 +31,17 @@ var (
 	// cache contains the values we can use to be cache-friendly.
 	cache map[string]CacheHelper
 
+	// cacheMutex guards cache, and the file it is saved to, as feeds
+	// may be fetched concurrently.
+	cacheMutex sync.Mutex
+
 	// ErrUnchanged is returned by our HTTP-fetcher if the content was previously
 	// fetched and has not changed since then.
 	ErrUnchanged = errors.New("UNCHANGED")
+
+	// ErrParse is wrapped by the error our HTTP-fetcher returns if the
+	// content was retrieved but could not be parsed as a feed.
+	ErrParse = errors.New("error parsing")
 )
 
 // CacheHelper is a struct used to store modification-data relating to the
@@ -64,26 +76,65 @@ type HTTPFetch struct {
 	// Contents of the remote URL, used for testing
 	content string
 
-	// How many times we should attempt to retry a failed
-	// fetch before giving up.
-	maxRetries int
+	// contentType is the Content-Type header the content was served
+	// with, which tells us if it is a JSON Feed.
+	contentType string
+
+	// How many more times we should attempt a failed fetch before
+	// giving up.
+	retries int
 
 	// insecure will cause invalid SSL certificate options to be ignored
 	insecure bool
 
 	// Between retries we should delay to avoid overwhelming
-	// the remote server.  This specifies how many times we should
-	// do that.
+	// the remote server.  This specifies how long we should wait,
+	// unless backoff is set, in which case the delay doubles after
+	// each attempt.
 	retryDelay time.Duration
+	backoff    bool
 
 	// frequency controls the poll frequency.  If this is set to 1hr then
 	// we don't fetch the feed until 1 hour after the last fetch, even if
 	// we were executed as a daemon with a SLEEP setting of 5 (minutes).
 	frequency time.Duration
 
+	// timeout is the deadline for each attempt to fetch the feed, if
+	// it is zero there is none.
+	timeout time.Duration
+
 	// The User-Agent header to send when making our HTTP fetch
 	userAgent string
 
+	// The credentials to send with HTTP Basic Authentication, which
+	// are only used if both are set.
+	username string
+	password string
+
+	// bearerToken is sent in an "Authorization: Bearer" header, in
+	// preference to any Basic Authentication.  If it begins with "$"
+	// it names the environment variable which holds the token.
+	bearerToken string
+
+	// proxy is the proxy our requests are made through, if it is nil
+	// the environment settings are used.  hasProxy records that the
+	// feed has its own proxy option, which overrides the global one.
+	proxy    *url.URL
+	hasProxy bool
+
+	// pending holds the cache-headers of a successful fetch, which are
+	// only saved once the content has been parsed.  Otherwise a feed
+	// we failed to process would be reported as unchanged next time.
+	pending *CacheHelper
+
+	// readOnly prevents the cache-headers from being saved, so that
+	// fetching the feed doesn't affect the next run.
+	readOnly bool
+
+	// bypassCache causes the feed to be fetched in full, ignoring the
+	// cache-headers and the frequency of previous fetches.
+	bypassCache bool
+
 	// logger contains the logging handle to use, if any
 	logger *slog.Logger
 }
@@ -93,8 +144,8 @@ func New(entry configfile.Feed, log *slog.Logger, version string) *HTTPFetch {
 
 	// Create object with defaults
 	state := &HTTPFetch{url: entry.URL,
-		maxRetries: 3,
-		retryDelay: 5 * time.Second,
+		retries:    0,
+		retryDelay: time.Second,
 		userAgent:  fmt.Sprintf("rss2email %s (https://github.com/skx/rss2email)", version),
 	}
 
@@ -109,7 +160,9 @@ func New(entry configfile.Feed, log *slog.Logger, version string) *HTTPFetch {
 			slog.String("error", err.Error()))
 	} else {
 		// We can't even log it usefully.
+		cacheMutex.Lock()
 		err = json.Unmarshal(data, &cache)
+		cacheMutex.Unlock()
 		if err != nil {
 			log.Debug("failed to unmarshall cache-values",
 				slog.String("path", fileName),
@@ -136,8 +189,8 @@ func New(entry configfile.Feed, log *slog.Logger, version string) *HTTPFetch {
 		if opt.Name == "retry" {
 
 			num, err := strconv.Atoi(opt.Value)
-			if err == nil {
-				state.maxRetries = num
+			if err == nil && num >= 0 {
+				state.retries = num
 			}
 		}
 
@@ -154,19 +207,58 @@ func New(entry configfile.Feed, log *slog.Logger, version string) *HTTPFetch {
 		}
 
 		// Sleep-delay between failed fetch-attempts.
-		if opt.Name == "delay" {
+		//
+		// "delay" is the older name, which doesn't support
+		// backoff, and is ignored if "retry-delay" is set.
+		if opt.Name == "delay" && !hasOption(entry, "retry-delay") {
 
 			num, err := strconv.Atoi(opt.Value)
-			if err == nil {
+			if err == nil && num >= 0 {
 				state.retryDelay = time.Duration(num) * time.Second
 			}
 		}
+		if opt.Name == "retry-delay" {
+
+			num, err := strconv.Atoi(opt.Value)
+			if err == nil && num >= 0 {
+				state.retryDelay = time.Duration(num) * time.Second
+				state.backoff = num == 0
+			}
+		}
 
 		// User-Agent
 		if opt.Name == "user-agent" {
 			state.userAgent = opt.Value
 		}
 
+		// Deadline for each fetch, ignored unless positive.
+		if opt.Name == "timeout" {
+			num, err := strconv.Atoi(opt.Value)
+			if err == nil && num > 0 {
+				state.timeout = time.Duration(num) * time.Second
+			}
+		}
+
+		// HTTP Basic Authentication
+		if opt.Name == "username" {
+			state.username = opt.Value
+		}
+		if opt.Name == "password" {
+			state.password = opt.Value
+		}
+
+		// Bearer token authentication
+		if opt.Name == "bearer-token" {
+			state.bearerToken = strings.TrimSpace(opt.Value)
+		}
+
+		// Proxy, which overrides any global setting even if it
+		// is invalid.
+		if opt.Name == "proxy" {
+			state.hasProxy = true
+			state.proxy = parseProxy(log, entry.URL, opt.Value)
+		}
+
 		// Polling frequency
 		if opt.Name == "frequency" {
 			num, err := strconv.Atoi(opt.Value)
@@ -182,9 +274,13 @@ func New(entry configfile.Feed, log *slog.Logger, version string) *HTTPFetch {
 			slog.String("link", entry.URL),
 			slog.String("user-agent", state.userAgent),
 			slog.Bool("insecure", state.insecure),
-			slog.Int("retry-max", state.maxRetries),
+			slog.Bool("basic-auth", state.basicAuth()),
+			slog.Bool("bearer-auth", state.bearerToken != ""),
+			slog.Int("retry-max", state.retries),
 			slog.Duration("retry-delay", state.retryDelay),
-			slog.Duration("frequency", state.frequency)))
+			slog.Bool("retry-backoff", state.backoff),
+			slog.Duration("frequency", state.frequency),
+			slog.Duration("timeout", state.timeout)))
 
 	return state
 }
@@ -199,7 +295,7 @@ func (h *HTTPFetch) Fetch() (*gofeed.Feed, error) {
 	var err error
 
 	// Download contents, if not already present.
-	for i := 0; h.content == "" && i < h.maxRetries; i++ {
+	for i := 0; h.content == "" && i <= h.retries; i++ {
 
 		// Log the fetch attempt
 		h.logger.Debug("fetching URL",
@@ -224,8 +320,9 @@ func (h *HTTPFetch) Fetch() (*gofeed.Feed, error) {
 		h.logger.Debug("fetching URL failed",
 			slog.String("error", err.Error()))
 
-		time.Sleep(h.retryDelay)
-
+		if i < h.retries {
+			time.Sleep(h.wait(i))
+		}
 	}
 
 	// Failed, after all the retries?
@@ -234,14 +331,20 @@ func (h *HTTPFetch) Fetch() (*gofeed.Feed, error) {
 	}
 
 	// Parse it
-	fp := gofeed.NewParser()
-	feed, err2 := fp.ParseString(h.content)
+	feed, err2 := parseFeed(h.content, h.contentType)
 	if err2 != nil {
 
 		h.logger.Warn("failed to parse content",
 			slog.String("error", err2.Error()))
 
-		return nil, fmt.Errorf("error parsing %s contents: %s", h.url, err2.Error())
+		return nil, fmt.Errorf("%w %s contents: %s", ErrParse, h.url, err2.Error())
+	}
+
+	// Now we know the content was good we can make conditional
+	// requests for it in the future.
+	if h.pending != nil {
+		h.saveCache(*h.pending)
+		h.pending = nil
 	}
 
 	return feed, nil
@@ -251,7 +354,12 @@ func (h *HTTPFetch) Fetch() (*gofeed.Feed, error) {
 func (h *HTTPFetch) fetch() error {
 
 	// Do we have a cache-entry?
+	cacheMutex.Lock()
 	prevCache, okCache := cache[h.url]
+	cacheMutex.Unlock()
+	if h.bypassCache {
+		okCache = false
+	}
 	if okCache {
 		h.logger.Debug("we have cached headers saved from a previous request",
 			slog.String("etag", prevCache.Etag),
@@ -259,20 +367,15 @@ func (h *HTTPFetch) fetch() error {
 	}
 
 	// Create a HTTP-client
-	client := &http.Client{}
+	client := h.client()
 
-	// Setup a transport which disables TLS-checks
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-
-	// If we're ignoring the TLS then use a non-validating transport.
-	if h.insecure {
-		client.Transport = tr
-	}
+	// Give up on servers which take too long to respond, including
+	// reading the body.
+	ctx, cancel := h.requestContext()
+	defer cancel()
 
 	// We only support making HTTP GET requests.
-	req, err := http.NewRequest("GET", h.url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", h.url, nil)
 	if err != nil {
 		return err
 	}
@@ -311,6 +414,13 @@ func (h *HTTPFetch) fetch() error {
 	// Populate the HTTP User-Agent header - some sites (e.g. reddit) fail without this.
 	req.Header.Set("User-Agent", h.userAgent)
 
+	// Private feeds might need credentials, which we never log.
+	if token := h.token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if h.basicAuth() {
+		req.SetBasicAuth(h.username, h.password)
+	}
+
 	// Make the actual HTTP request.
 	resp, err := client.Do(req)
 	if err != nil {
@@ -318,6 +428,12 @@ func (h *HTTPFetch) fetch() error {
 	}
 	defer resp.Body.Close()
 
+	// Errors from the server are failures, which might be retried,
+	// and aren't cached.
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
 	// Read the response headers and save any cache-like things
 	// we can use to avoid excessive load in the future.
 	x := CacheHelper{
@@ -325,19 +441,6 @@ func (h *HTTPFetch) fetch() error {
 		LastModified: resp.Header.Get("Last-Modified"),
 		Updated:      time.Now(),
 	}
-	cache[h.url] = x
-
-	// Save cache.
-	encoded, errEncoding := json.Marshal(cache)
-	if errEncoding == nil {
-		fileName := filepath.Join(statePath.Directory(), "httpcache.json")
-		errWrite := os.WriteFile(fileName, encoded, 0644)
-		if errWrite != nil {
-			h.logger.Debug("failed to write cache to json",
-				slog.String("path", fileName),
-				slog.String("error", errWrite.Error()))
-		}
-	}
 
 	//
 	// Did the remote page not change?
@@ -347,20 +450,341 @@ func (h *HTTPFetch) fetch() error {
 		h.logger.Debug("response from request was unchanged",
 			slog.String("status", resp.Status),
 			slog.Int("code", resp.StatusCode))
+
+		// A server needn't repeat the headers in a 304 response,
+		// in which case we keep using the ones we have.
+		if x.Etag == "" {
+			x.Etag = prevCache.Etag
+		}
+		if x.LastModified == "" {
+			x.LastModified = prevCache.LastModified
+		}
+		h.saveCache(x)
+
 		return ErrUnchanged
 	}
+	h.pending = &x
 
 	// Otherwise we save the result away and
 	// return any error/not as a result of reading
 	// the body.
 	data, err2 := io.ReadAll(resp.Body)
 	h.content = string(data)
+	h.contentType = resp.Header.Get("Content-Type")
 
 	h.logger.Debug("response from request",
 		slog.String("url", h.url),
 		slog.String("status", resp.Status),
 		slog.Int("code", resp.StatusCode),
-		slog.Int("size", len(h.content)))
+		slog.Int("size", len(h.content)),
+		slog.String("content-type", h.contentType))
 
 	return err2
 }
+
+// saveCache records the cache-headers of our URL, and writes them all
+// to disk alongside our state.
+//
+// The file is replaced atomically, so an interrupted write never loses
+// the headers of every other feed.
+func (h *HTTPFetch) saveCache(entry CacheHelper) {
+
+	if h.readOnly {
+		return
+	}
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	cache[h.url] = entry
+
+	encoded, err := json.Marshal(cache)
+	if err != nil {
+		h.logger.Debug("failed to encode cache to json",
+			slog.String("error", err.Error()))
+		return
+	}
+
+	fileName := filepath.Join(statePath.Directory(), "httpcache.json")
+
+	err = writeFileAtomic(fileName, encoded)
+	if err != nil {
+		h.logger.Debug("failed to write cache to json",
+			slog.String("path", fileName),
+			slog.String("error", err.Error()))
+	}
+}
+
+// writeFileAtomic writes the data to a temporary file, in the same
+// directory as the given path, and then renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// wait returns the time to wait after the given failed attempt, counting
+// from zero, before the next.
+//
+// With backoff we wait one second after the first attempt, and double
+// that after each subsequent one, up to a minute.
+func (h *HTTPFetch) wait(attempt int) time.Duration {
+
+	if !h.backoff {
+		return h.retryDelay
+	}
+
+	delay := time.Second
+	for i := 0; i < attempt && delay < time.Minute; i++ {
+		delay *= 2
+	}
+	if delay > time.Minute {
+		delay = time.Minute
+	}
+	return delay
+}
+
+// SetReadOnly prevents the cache-headers of the feed from being saved,
+// so that the next fetch is made as if this one never happened.
+func (h *HTTPFetch) SetReadOnly(readOnly bool) {
+	h.readOnly = readOnly
+}
+
+// SetBypassCache causes the feed to be fetched in full, as if we'd never
+// fetched it before, regardless of its frequency option.
+func (h *HTTPFetch) SetBypassCache(bypass bool) {
+	h.bypassCache = bypass
+}
+
+// SetDefaultProxy sets the proxy to fetch the feed through, from the
+// global configuration, unless the feed has its own proxy option.
+//
+// An empty value leaves the proxy unset, so the environment settings
+// are used.
+func (h *HTTPFetch) SetDefaultProxy(value string) {
+	if h.hasProxy || value == "" {
+		return
+	}
+	h.proxy = parseProxy(h.logger, h.url, value)
+}
+
+// parseProxy parses the URL of a proxy, which must be a http://,
+// https://, or socks5:// URL.
+//
+// If it is invalid we log a warning and return nil, so requests are made
+// as if there was no proxy setting.
+func parseProxy(logger *slog.Logger, feed string, value string) *url.URL {
+
+	parsed, err := url.Parse(value)
+	if err == nil {
+		switch {
+		case parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "socks5":
+			err = fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+		case parsed.Host == "":
+			err = errors.New("missing host")
+		}
+	}
+
+	if err != nil {
+		logger.Warn("ignoring invalid proxy",
+			slog.String("link", feed),
+			slog.String("error", err.Error()))
+		return nil
+	}
+
+	return parsed
+}
+
+// hasOption returns true if the feed has the given option set.
+func hasOption(entry configfile.Feed, name string) bool {
+	for _, opt := range entry.Options {
+		if opt.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// basicAuth returns true if we have credentials for HTTP Basic
+// Authentication, which needs both a username and a password.
+func (h *HTTPFetch) basicAuth() bool {
+	return h.username != "" && h.password != ""
+}
+
+// token returns the bearer token we should send, if any.
+//
+// A "bearer-token" of "$NAME" is read from the environment variable NAME
+// each time we fetch the feed, so the token can be changed without
+// reloading our configuration.
+func (h *HTTPFetch) token() string {
+
+	name, ok := strings.CutPrefix(h.bearerToken, "$")
+	if !ok {
+		return h.bearerToken
+	}
+
+	token := strings.TrimSpace(os.Getenv(name))
+	if token == "" {
+		h.logger.Warn("bearer-token environment variable is empty, or not set",
+			slog.String("variable", name))
+	}
+	return token
+}
+
+// requestContext returns the context for one of our requests, which
+// expires once our timeout has passed, if we have one.
+func (h *HTTPFetch) requestContext() (context.Context, context.CancelFunc) {
+	if h.timeout > 0 {
+		return context.WithTimeout(context.Background(), h.timeout)
+	}
+	return context.Background(), func() {}
+}
+
+// client returns the HTTP-client to use for our requests.
+func (h *HTTPFetch) client() *http.Client {
+
+	// Create a HTTP-client
+	client := &http.Client{}
+
+	// The default transport is fine, unless we need to change it.
+	if !h.insecure && h.proxy == nil {
+		return client
+	}
+
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+
+	// If we're ignoring the TLS then use a non-validating transport.
+	if h.insecure {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	// Route our requests through the proxy, if we have one.
+	if h.proxy != nil {
+		tr.Proxy = http.ProxyURL(h.proxy)
+	}
+
+	client.Transport = tr
+
+	return client
+}
+
+// CheckLink makes a HEAD request for the given link, with the same
+// client-settings and timeout as the feed fetch, and returns an error
+// unless the response, after following any redirects, was successful.
+//
+// Some servers don't implement HEAD, so if they tell us so we try again
+// with a GET request, without reading the body.
+func (h *HTTPFetch) CheckLink(link string) error {
+
+	client := h.client()
+
+	var status int
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+
+		ctx, cancel := h.requestContext()
+		req, err := http.NewRequestWithContext(ctx, method, link, nil)
+		if err != nil {
+			cancel()
+			return err
+		}
+		req.Header.Set("User-Agent", h.userAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			return err
+		}
+		resp.Body.Close()
+		cancel()
+
+		status = resp.StatusCode
+		h.logger.Debug("checked item link",
+			slog.String("link", link),
+			slog.String("method", method),
+			slog.Int("code", status))
+
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+
+	if status < 200 || status >= 300 {
+		return fmt.Errorf("%s returned status %d", link, status)
+	}
+	return nil
+}
+
+// FetchImage downloads the image at the given link, with the same
+// client-settings and timeout as the feed fetch, returning its content
+// and media type.
+//
+// An error is returned unless the response was successful, and was an
+// image of no more than maxSize bytes.
+func (h *HTTPFetch) FetchImage(link string, maxSize int64) ([]byte, string, error) {
+
+	// The deadline covers reading the body too.
+	ctx, cancel := h.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", h.userAgent)
+
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("%s returned status %d", link, resp.StatusCode)
+	}
+
+	// Read one byte more than we allow, so that we know when an image
+	// is too large, without reading all of it.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("%s is larger than %d bytes", link, maxSize)
+	}
+
+	// Servers aren't always right about the type of their content, so
+	// we'll look for ourselves if they don't say it's an image.
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, "", fmt.Errorf("%s is not an image, it is %s", link, mediaType)
+	}
+
+	h.logger.Debug("fetched image",
+		slog.String("link", link),
+		slog.String("type", mediaType),
+		slog.Int("size", len(data)))
+
+	return data, mediaType, nil
+}
@@ -1,11 +1,14 @@
 package httpfetch
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -103,6 +106,50 @@ func TestOneEntry(t *testing.T) {
 	}
 }
 
+// TestSource confirms we keep the <source> of items in aggregated feeds.
+func TestSource(t *testing.T) {
+
+	tests := map[string]string{
+		"rss": `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Planet</title>
+<item><title>One</title><source url="https://example.org/rss">Example Blog</source></item>
+<item><title>Two</title></item>
+</channel></rss>`,
+		"atom": `<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Planet</title>
+<entry><title>One</title><source><title>Example Blog</title>
+  <link rel="alternate" href="https://example.org/"/>
+  <link rel="self" href="https://example.org/rss"/></source></entry>
+<entry><title>Two</title></entry>
+</feed>`,
+	}
+
+	for kind, content := range tests {
+		x := New(configfile.Feed{URL: "https://planet.example.com/"}, logger, "unversioned")
+		x.content = content
+
+		out, err := x.Fetch()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", kind, err)
+		}
+		if len(out.Items) != 2 {
+			t.Fatalf("%s: unexpected items %d", kind, len(out.Items))
+		}
+
+		item := withstate.FeedItem{Item: out.Items[0]}
+		title, url := item.Source()
+		if title != "Example Blog" || url != "https://example.org/rss" {
+			t.Fatalf("%s: unexpected source '%s' '%s'", kind, title, url)
+		}
+
+		item = withstate.FeedItem{Item: out.Items[1]}
+		title, url = item.Source()
+		if title != "" || url != "" {
+			t.Fatalf("%s: unexpected source '%s' '%s'", kind, title, url)
+		}
+	}
+}
+
 // TestRewrite ensures that a broken file is rewriting
 func TestRewrite(t *testing.T) {
 
@@ -180,9 +227,31 @@ func TestDelay(t *testing.T) {
 			{Name: "delay", Value: "steve"},
 		}}, logger, "unversioned")
 
-	if i.retryDelay != 5*time.Second {
+	if i.retryDelay != time.Second {
 		t.Errorf("bogus value changed our delay-value")
 	}
+
+	// retry-delay replaces delay, and zero means backoff.
+	b := New(configfile.Feed{URL: "https://blog.steve.fi/index.rss",
+		Options: []configfile.Option{
+			{Name: "retry-delay", Value: "0"},
+			{Name: "delay", Value: "15"},
+		}}, logger, "unversioned")
+
+	if !b.backoff {
+		t.Errorf("expected backoff")
+	}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if b.wait(attempt) != expected {
+			t.Errorf("attempt %d: expected to wait %s, got %s", attempt, expected, b.wait(attempt))
+		}
+	}
+	if b.wait(20) != time.Minute {
+		t.Errorf("expected backoff to be limited to a minute, got %s", b.wait(20))
+	}
+	if n.backoff || n.wait(5) != 15*time.Second {
+		t.Errorf("unexpected delay %s", n.wait(5))
+	}
 }
 
 func TestRetry(t *testing.T) {
@@ -194,7 +263,7 @@ func TestRetry(t *testing.T) {
 			{Name: "moi", Value: "3"},
 		}}, logger, "unversioned")
 
-	if n.maxRetries != 33 {
+	if n.retries != 33 {
 		t.Errorf("failed to parse retry value")
 	}
 
@@ -204,12 +273,399 @@ func TestRetry(t *testing.T) {
 			{Name: "retry", Value: "steve"},
 		}}, logger, "unversioned")
 
-	if i.maxRetries != 3 {
+	if i.retries != 0 {
 		t.Errorf("bogus value changed our default")
 	}
 }
 
+// TestRetryFetch tests that failed fetches are retried.
+func TestRetryFetch(t *testing.T) {
+
+	for _, tst := range []struct {
+		failures int
+		retry    string
+		ok       bool
+	}{
+		{2, "2", true},
+		{3, "2", false},
+		{1, "0", false},
+		{0, "0", true},
+	} {
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests <= tst.failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintln(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Down</title></channel></rss>`)
+				return
+			}
+			fmt.Fprintln(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Up</title></channel></rss>`)
+		}))
+
+		obj := New(configfile.Feed{URL: ts.URL, Options: []configfile.Option{
+			{Name: "frequency", Value: "0"},
+			{Name: "retry", Value: tst.retry},
+		}}, logger, "unversioned")
+		obj.retryDelay = time.Millisecond
+
+		feed, err := obj.Fetch()
+		ts.Close()
+
+		if tst.ok && (err != nil || feed.Title != "Up") {
+			t.Fatalf("%d failures, retry %s: expected success, got %v", tst.failures, tst.retry, err)
+		}
+		if !tst.ok && (err == nil || !strings.Contains(err.Error(), "503")) {
+			t.Fatalf("%d failures, retry %s: expected failure, got %v", tst.failures, tst.retry, err)
+		}
+	}
+}
+
 // Make a HTTP-request against a local entry
+// TestTimeout tests that fetches from slow servers are abandoned.
+func TestTimeout(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprintln(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Slow</title></channel></rss>`)
+	}))
+	defer ts.Close()
+
+	for _, timeout := range []string{"1", "0", "-1", "bogus"} {
+		obj := New(configfile.Feed{URL: ts.URL, Options: []configfile.Option{
+			{Name: "frequency", Value: "0"},
+			{Name: "retry", Value: "0"},
+			{Name: "timeout", Value: timeout},
+		}}, logger, "unversioned")
+
+		// A timeout which isn't positive is ignored.
+		if timeout != "1" {
+			if obj.timeout != 0 {
+				t.Fatalf("timeout %s: unexpected timeout %s", timeout, obj.timeout)
+			}
+			continue
+		}
+
+		start := time.Now()
+		_, err := obj.Fetch()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+		if time.Since(start) >= 2*time.Second {
+			t.Fatalf("the timeout didn't fire before the server responded")
+		}
+	}
+}
+
+// TestCheckLinkTimeout tests that checking item links is subject to the
+// timeout of the feed.
+func TestCheckLinkTimeout(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	obj := New(configfile.Feed{URL: ts.URL, Options: []configfile.Option{
+		{Name: "timeout", Value: "1"},
+	}}, logger, "unversioned")
+
+	start := time.Now()
+	err := obj.CheckLink(ts.URL + "/item")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) >= 2*time.Second {
+		t.Fatalf("the timeout didn't fire before the server responded")
+	}
+}
+
+// TestFetchImageTimeout tests that fetching images is subject to the
+// timeout of the feed, including reading their content.
+func TestFetchImageTimeout(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	obj := New(configfile.Feed{URL: ts.URL, Options: []configfile.Option{
+		{Name: "timeout", Value: "1"},
+	}}, logger, "unversioned")
+
+	start := time.Now()
+	_, _, err := obj.FetchImage(ts.URL+"/image.png", 1024)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) >= 2*time.Second {
+		t.Fatalf("the timeout didn't fire before the server responded")
+	}
+}
+
+// TestBasicAuth tests fetching feeds which need HTTP Basic Authentication.
+func TestBasicAuth(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "steve" || pass != "s3cr3t:!" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, "Unauthorized")
+			return
+		}
+		fmt.Fprintln(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Private</title></channel></rss>`)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		opts []configfile.Option
+		ok   bool
+	}{
+		{nil, false},
+		{[]configfile.Option{{Name: "username", Value: "steve"}}, false},
+		{[]configfile.Option{{Name: "password", Value: "s3cr3t:!"}}, false},
+		{[]configfile.Option{{Name: "username", Value: "steve"}, {Name: "password", Value: "wrong"}}, false},
+		{[]configfile.Option{{Name: "username", Value: "steve"}, {Name: "password", Value: "s3cr3t:!"}}, true},
+	}
+
+	for i, tst := range tests {
+		opts := append([]configfile.Option{{Name: "frequency", Value: "0"}, {Name: "retry", Value: "0"}}, tst.opts...)
+
+		// The password is never logged.
+		buf := &strings.Builder{}
+		log := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		feed, err := New(configfile.Feed{URL: ts.URL, Options: opts}, log, "unversioned").Fetch()
+		if tst.ok && (err != nil || feed.Title != "Private") {
+			t.Fatalf("test %d: expected a successful fetch, got %v", i, err)
+		}
+		if !tst.ok && err == nil {
+			t.Fatalf("test %d: expected the fetch to fail", i)
+		}
+		if strings.Contains(buf.String(), "s3cr3t") {
+			t.Fatalf("test %d: the password was logged: %s", i, buf.String())
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken-value" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, "Unauthorized")
+			return
+		}
+		fmt.Fprintln(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Private</title></channel></rss>`)
+	}))
+	defer ts.Close()
+
+	t.Setenv("RSS2EMAIL_TEST_TOKEN", "t0ken-value")
+	t.Setenv("RSS2EMAIL_TEST_EMPTY", "")
+
+	tests := []struct {
+		opts []configfile.Option
+		ok   bool
+	}{
+		{[]configfile.Option{{Name: "bearer-token", Value: "t0ken-value"}}, true},
+		{[]configfile.Option{{Name: "bearer-token", Value: "wrong"}}, false},
+		{[]configfile.Option{{Name: "bearer-token", Value: "$RSS2EMAIL_TEST_TOKEN"}}, true},
+		{[]configfile.Option{{Name: "bearer-token", Value: "$RSS2EMAIL_TEST_EMPTY"}}, false},
+
+		// The token takes precedence over Basic Authentication.
+		{[]configfile.Option{
+			{Name: "username", Value: "steve"},
+			{Name: "password", Value: "secret"},
+			{Name: "bearer-token", Value: "$RSS2EMAIL_TEST_TOKEN"},
+		}, true},
+	}
+
+	for i, tst := range tests {
+		opts := append([]configfile.Option{{Name: "frequency", Value: "0"}, {Name: "retry", Value: "0"}}, tst.opts...)
+
+		// The token is never logged.
+		buf := &strings.Builder{}
+		log := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		feed, err := New(configfile.Feed{URL: ts.URL, Options: opts}, log, "unversioned").Fetch()
+		if tst.ok && (err != nil || feed.Title != "Private") {
+			t.Fatalf("test %d: expected a successful fetch, got %v", i, err)
+		}
+		if !tst.ok && err == nil {
+			t.Fatalf("test %d: expected the fetch to fail", i)
+		}
+		if strings.Contains(buf.String(), "t0ken") {
+			t.Fatalf("test %d: the token was logged: %s", i, buf.String())
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+
+	// A minimal proxy, which records the requests it is sent.
+	var requests []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		fmt.Fprintln(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Proxied</title></channel></rss>`)
+	}))
+	defer proxy.Close()
+
+	// Another proxy, which should never be used.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request via the global proxy: %s", r.URL)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer other.Close()
+
+	// The feed is only reachable through the proxy.
+	feedURL := "http://feed.invalid/rss.xml"
+	opts := []configfile.Option{{Name: "frequency", Value: "0"}}
+
+	// Per-feed
+	feed, err := New(configfile.Feed{URL: feedURL, Options: append(opts, configfile.Option{Name: "proxy", Value: proxy.URL})}, logger, "unversioned").Fetch()
+	if err != nil || feed.Title != "Proxied" {
+		t.Fatalf("expected a fetch via the proxy, got %v", err)
+	}
+
+	// Global
+	obj := New(configfile.Feed{URL: feedURL + "?global", Options: opts}, logger, "unversioned")
+	obj.SetDefaultProxy(proxy.URL)
+	_, err = obj.Fetch()
+	if err != nil {
+		t.Fatalf("expected a fetch via the global proxy, got %v", err)
+	}
+
+	// The per-feed setting overrides the global one.
+	obj = New(configfile.Feed{URL: feedURL + "?override", Options: append(opts, configfile.Option{Name: "proxy", Value: proxy.URL})}, logger, "unversioned")
+	obj.SetDefaultProxy(other.URL)
+	_, err = obj.Fetch()
+	if err != nil {
+		t.Fatalf("expected a fetch via the feed's proxy, got %v", err)
+	}
+
+	expected := []string{feedURL, feedURL + "?global", feedURL + "?override"}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected proxied requests %v", requests)
+	}
+
+	// Invalid proxies are ignored, with a warning.
+	for _, value := range []string{"ftp://proxy.example.com", "proxy.example.com:8080", "http://", "://bogus"} {
+		buf := &strings.Builder{}
+		log := slog.New(slog.NewTextHandler(buf, nil))
+
+		obj = New(configfile.Feed{URL: feedURL, Options: []configfile.Option{{Name: "proxy", Value: value}}}, log, "unversioned")
+		if obj.proxy != nil {
+			t.Fatalf("expected %q to be ignored", value)
+		}
+		if !strings.Contains(buf.String(), "ignoring invalid proxy") {
+			t.Fatalf("expected a warning about %q, got %s", value, buf.String())
+		}
+
+		// An invalid per-feed proxy still overrides the global one.
+		obj.SetDefaultProxy(proxy.URL)
+		if obj.proxy != nil {
+			t.Fatalf("expected the global proxy to be ignored for %q", value)
+		}
+	}
+
+	// socks5 is accepted
+	obj = New(configfile.Feed{URL: feedURL, Options: []configfile.Option{{Name: "proxy", Value: "socks5://127.0.0.1:1080"}}}, logger, "unversioned")
+	if obj.proxy == nil || obj.proxy.Scheme != "socks5" {
+		t.Fatalf("expected a socks5 proxy, got %v", obj.proxy)
+	}
+}
+
+func TestConditionalFetch(t *testing.T) {
+
+	// The cache is saved beneath our state directory.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	err := os.MkdirAll(filepath.Join(home, ".rss2email"), 0755)
+	if err != nil {
+		t.Fatalf("failed to create state directory: %s", err)
+	}
+
+	// The server only sends the headers on full responses, and records
+	// those of the conditional requests it is sent.
+	etag := `"v1"`
+	modified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	body := `<?xml version="1.0"?><rss version="2.0"><channel><title>Cached</title></channel></rss>`
+	var seen []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified)
+		fmt.Fprintln(w, body)
+	}))
+	defer ts.Close()
+
+	fetch := func() error {
+		_, err := New(configfile.Feed{URL: ts.URL, Options: []configfile.Option{{Name: "frequency", Value: "0"}}}, logger, "unversioned").Fetch()
+		return err
+	}
+
+	// Content which can't be parsed isn't cached, so it is fetched
+	// in full next time.
+	body = "not a feed"
+	if err := fetch(); !errors.Is(err, ErrParse) {
+		t.Fatalf("expected a parse error, got %v", err)
+	}
+	body = `<?xml version="1.0"?><rss version="2.0"><channel><title>Cached</title></channel></rss>`
+
+	if err := fetch(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The next fetches are conditional, and unchanged, even though
+	// the 304 response didn't repeat the headers.
+	for i := 0; i < 2; i++ {
+		if err := fetch(); err != ErrUnchanged {
+			t.Fatalf("expected the feed to be unchanged, got %v", err)
+		}
+	}
+
+	// New content replaces the headers.
+	etag = `"v2"`
+	if err := fetch(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := fetch(); err != ErrUnchanged {
+		t.Fatalf("expected the feed to be unchanged, got %v", err)
+	}
+
+	cond := `"v1"|` + modified
+	expected := []string{"|", "|", cond, cond, cond, `"v2"|` + modified}
+	if strings.Join(seen, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected conditional requests %q", seen)
+	}
+
+	// The headers were saved to disk, with no temporary files left.
+	data, err := os.ReadFile(filepath.Join(home, ".rss2email", "httpcache.json"))
+	if err != nil || !strings.Contains(string(data), `\"v2\"`) {
+		t.Fatalf("expected the cache to be saved, got %s %v", data, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(home, ".rss2email", "*.tmp"))
+	if len(files) != 0 {
+		t.Fatalf("unexpected temporary files %v", files)
+	}
+}
+
 func TestHTTPFetch(t *testing.T) {
 
 	// Setup a stub server
@@ -300,3 +756,81 @@ func TestHTTPFetchValid(t *testing.T) {
 		t.Fatalf("wrong feed count")
 	}
 }
+
+// TestJSONFeed tests fetching a JSON Feed, whose items look just like
+// those of other feeds.
+func TestJSONFeed(t *testing.T) {
+
+	feed := `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "JSON Example",
+  "home_page_url": "https://example.com/",
+  "items": [
+    {
+      "id": "item-1",
+      "url": "https://example.com/1",
+      "title": "First",
+      "content_html": "<p>Hello, World</p>",
+      "date_published": "2024-01-02T03:04:05Z",
+      "authors": [{"name": "Steve"}, {"name": "Other"}],
+      "tags": ["one", "two"]
+    },
+    {
+      "id": "item-2",
+      "url": "https://example.com/2",
+      "title": "Second",
+      "content_text": "Plain text"
+    }
+  ]
+}`
+
+	contentType := JSONFeedType + "; charset=utf-8"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		fmt.Fprint(w, feed)
+	}))
+	defer ts.Close()
+
+	obj := New(configfile.Feed{URL: ts.URL}, logger, "unversioned")
+	obj.readOnly = true
+
+	out, err := obj.Fetch()
+	if err != nil {
+		t.Fatalf("failed to fetch: %s", err)
+	}
+	if out.Title != "JSON Example" || len(out.Items) != 2 {
+		t.Fatalf("unexpected feed %v", out)
+	}
+
+	first := out.Items[0]
+	if first.GUID != "item-1" || first.Link != "https://example.com/1" || first.Title != "First" ||
+		first.Content != "<p>Hello, World</p>" || first.Author == nil || first.Author.Name != "Steve" ||
+		strings.Join(first.Categories, ",") != "one,two" {
+		t.Fatalf("unexpected item %v", first)
+	}
+	if first.PublishedParsed == nil || !first.PublishedParsed.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("unexpected publication date %v", first.PublishedParsed)
+	}
+	if out.Items[1].Content != "Plain text" {
+		t.Fatalf("expected content_text to be the content, got %q", out.Items[1].Content)
+	}
+
+	// Content which claims to be a JSON Feed must be one.
+	feed = "<rss></rss>"
+	obj = New(configfile.Feed{URL: ts.URL}, logger, "unversioned")
+	obj.readOnly = true
+	_, err = obj.Fetch()
+	if !errors.Is(err, ErrParse) {
+		t.Fatalf("expected a parse error, got %v", err)
+	}
+
+	// Otherwise a JSON Feed is recognized by its content.
+	feed = `{"version": "https://jsonfeed.org/version/1.1", "title": "Sniffed", "items": []}`
+	contentType = "text/plain"
+	obj = New(configfile.Feed{URL: ts.URL}, logger, "unversioned")
+	obj.readOnly = true
+	out, err = obj.Fetch()
+	if err != nil || out.Title != "Sniffed" {
+		t.Fatalf("failed to fetch JSON Feed served as text: %v %v", out, err)
+	}
+}
@@ -0,0 +1,147 @@
+package httpfetch
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+	jsonfeed "github.com/mmcdole/gofeed/json"
+	"github.com/mmcdole/gofeed/rss"
+	"github.com/skx/rss2email/withstate"
+)
+
+// JSONFeedType is the media type of a JSON Feed, as described at
+// https://jsonfeed.org/
+const JSONFeedType = "application/feed+json"
+
+// NewParser returns a feed parser which, unlike the default, preserves
+// the <source> element of items in aggregated feeds.  The publisher can
+// be retrieved via withstate.FeedItem.Source.
+func NewParser() *gofeed.Parser {
+	fp := gofeed.NewParser()
+	fp.RSSTranslator = &rssTranslator{}
+	fp.AtomTranslator = &atomTranslator{}
+	fp.JSONTranslator = &jsonTranslator{}
+	return fp
+}
+
+// parseFeed parses the given content, which was served with the given
+// Content-Type header.
+//
+// Content served as a JSON Feed is always parsed as one, otherwise we
+// look at the content to see whether it is RSS, Atom, or JSON Feed.  In
+// every case the items are the same gofeed.Item used for RSS and Atom, so
+// the filters treat them alike: the id of a JSON Feed item is its GUID,
+// its content_html, or content_text, is its content, and so on.
+func parseFeed(content string, contentType string) (*gofeed.Feed, error) {
+
+	if isJSONFeed(contentType) {
+		var p jsonfeed.Parser
+		feed, err := p.Parse(strings.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		return (&jsonTranslator{}).Translate(feed)
+	}
+
+	return NewParser().ParseString(content)
+}
+
+// isJSONFeed returns true if the given Content-Type header is that of a
+// JSON Feed, ignoring any parameters such as the charset.
+func isJSONFeed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.EqualFold(mediaType, JSONFeedType)
+}
+
+// rssTranslator is the default RSS translator, which also records the
+// <source> of each item.
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+// Translate is part of the gofeed.Translator interface.
+func (t *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Items are translated in order, so we can pair them up.
+	orig := feed.(*rss.Feed)
+	if len(orig.Items) == len(result.Items) {
+		for i, item := range orig.Items {
+			if item.Source != nil {
+				withstate.SetSource(result.Items[i], item.Source.Title, item.Source.URL)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// atomTranslator is the default Atom translator, which also records the
+// <source> of each entry.
+type atomTranslator struct {
+	gofeed.DefaultAtomTranslator
+}
+
+// Translate is part of the gofeed.Translator interface.
+func (t *atomTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+
+	result, err := t.DefaultAtomTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	orig := feed.(*atom.Feed)
+	if len(orig.Entries) == len(result.Items) {
+		for i, entry := range orig.Entries {
+			if entry.Source != nil {
+				withstate.SetSource(result.Items[i], entry.Source.Title, atomSourceURL(entry.Source))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// jsonTranslator is the default JSON Feed translator, which also sets the
+// author of each item from the "authors" of JSON Feed 1.1.
+type jsonTranslator struct {
+	gofeed.DefaultJSONTranslator
+}
+
+// Translate is part of the gofeed.Translator interface.
+func (t *jsonTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+
+	result, err := t.DefaultJSONTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range result.Items {
+		if item.Author == nil && len(item.Authors) > 0 {
+			item.Author = item.Authors[0]
+		}
+	}
+
+	return result, nil
+}
+
+// atomSourceURL returns the URL of the feed described by an Atom <source>
+// element, preferring the "self" link.
+func atomSourceURL(source *atom.Source) string {
+	url := ""
+	for _, link := range source.Links {
+		if link.Rel == "self" {
+			return link.Href
+		}
+		if url == "" {
+			url = link.Href
+		}
+	}
+	return url
+}
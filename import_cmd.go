@@ -1,14 +1,17 @@
 //
-// Import an OPML feedlist.
+// Import an OPML feedlist, or one from the Python rss2email.
 //
 
 package main
 
 import (
+	"bytes"
 	"encoding/xml"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/skx/rss2email/configfile"
 	"github.com/skx/subcommands"
@@ -23,11 +26,33 @@ type opml struct {
 
 type outline struct {
 	Text    string `xml:"text,attr"`
-	Title   string `xml:"title,attr"`
-	Type    string `xml:"type,attr"`
-	XMLURL  string `xml:"xmlUrl,attr"`
-	HTMLURL string `xml:"htmlUrl,attr"`
-	Favicon string `xml:"rssfr-favicon,attr"`
+	Title   string `xml:"title,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	XMLURL  string `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL string `xml:"htmlUrl,attr,omitempty"`
+	Favicon string `xml:"rssfr-favicon,attr,omitempty"`
+
+	// Outlines are those nested within this one, which is then
+	// usually a folder rather than a feed.
+	Outlines []outline `xml:"outline"`
+}
+
+// opmlFeeds returns the URLs of the feeds in the given outlines, and those
+// nested within them, in the order they appear.
+//
+// Both OPML 1.0 and 2.0 are handled, as they share the same structure.
+// Outlines with an "xmlUrl" are feeds, whatever their type, as older
+// files often don't have one.
+func opmlFeeds(outlines []outline) []string {
+
+	var feeds []string
+	for _, o := range outlines {
+		if url := strings.TrimSpace(o.XMLURL); url != "" {
+			feeds = append(feeds, url)
+		}
+		feeds = append(feeds, opmlFeeds(o.Outlines)...)
+	}
+	return feeds
 }
 
 // Structure for our options and state.
@@ -47,6 +72,14 @@ func (i *importCmd) Info() (string, string) {
 This command imports a series of feeds from the specified OPML
 file into the configuration file this application uses.
 
+Feeds within the folders of the OPML file are imported too.  Feeds which
+are already present in the configuration file are skipped, and left as
+they are, and the number of feeds added and skipped is shown.
+
+To migrate from the original, Python, rss2email you may also import its
+configuration file, or a plain list of feed URLs, one per line.  Only
+the URLs of the feeds are imported.
+
 To see details of the configuration file, including the location,
 please run:
 
@@ -78,6 +111,27 @@ func (i *importCmd) Execute(args []string) int {
 		return 1
 	}
 
+	// The feeds we have already, so that we can count duplicates.
+	known := make(map[string]bool)
+	for _, entry := range i.config.Entries() {
+		known[entry.URL] = true
+	}
+
+	added := 0
+	skipped := 0
+	add := func(file string, url string) {
+		if known[url] {
+			logger.Debug("Skipping duplicate entry from file", slog.String("file", file), slog.String("url", url))
+			skipped++
+			return
+		}
+
+		logger.Debug("Adding entry from file", slog.String("file", file), slog.String("url", url))
+		i.config.Add(url)
+		known[url] = true
+		added++
+	}
+
 	// For each file on the command-line
 	for _, file := range args {
 
@@ -89,6 +143,21 @@ func (i *importCmd) Execute(args []string) int {
 			continue
 		}
 
+		// Anything which isn't XML is a feed list from the
+		// Python rss2email.
+		if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+			legacy, err := configfile.ParseLegacy(bytes.NewReader(data))
+			if err != nil {
+				logger.Error("failed to parse feed list", slog.String("file", file), slog.String("error", err.Error()))
+				continue
+			}
+
+			for _, entry := range legacy.Entries() {
+				add(file, entry.URL)
+			}
+			continue
+		}
+
 		// Parse
 		o := opml{}
 		err = xml.Unmarshal(data, &o)
@@ -97,14 +166,9 @@ func (i *importCmd) Execute(args []string) int {
 			continue
 		}
 
-		for _, outline := range o.Outlines {
-
-			if outline.XMLURL != "" {
-				logger.Debug("Adding entry from file", slog.String("file", file), slog.String("url", outline.XMLURL))
-				i.config.Add(outline.XMLURL)
-			}
+		for _, url := range opmlFeeds(o.Outlines) {
+			add(file, url)
 		}
-
 	}
 
 	// Did we make a change?  Then add them.
@@ -114,6 +178,8 @@ func (i *importCmd) Execute(args []string) int {
 		return 1
 	}
 
+	fmt.Fprintf(out, "added %d feeds, skipped %d duplicates\n", added, skipped)
+
 	// All done.
 	return 0
 }
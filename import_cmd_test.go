@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/skx/rss2email/configfile"
@@ -77,3 +80,121 @@ https://example.net/
 	os.Remove(tmpfile.Name())
 	os.Remove(opml.Name())
 }
+
+func TestImportLegacy(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feeds.txt")
+	err := os.WriteFile(path, []byte("https://example.org/\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config file")
+	}
+
+	// The configuration file of the Python rss2email.
+	legacy := filepath.Join(dir, "rss2email.cfg")
+	err = os.WriteFile(legacy, []byte(`[DEFAULT]
+to = me@example.com
+
+[feed.one]
+url = https://example.org/
+
+[feed.two]
+url = https://example.net/feed.xml
+`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write legacy file")
+	}
+
+	im := importCmd{}
+	im.Arguments(nil)
+	config := configfile.NewWithPath(path)
+	im.config = config
+
+	if im.Execute([]string{legacy}) != 0 {
+		t.Fatalf("import failed")
+	}
+
+	entries, err := config.Parse()
+	if err != nil {
+		t.Fatalf("error parsing the updated config file: %s", err)
+	}
+	if len(entries) != 2 || entries[1].URL != "https://example.net/feed.xml" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+}
+
+func TestImportNested(t *testing.T) {
+
+	bak := out
+	buf := &bytes.Buffer{}
+	out = buf
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feeds.txt")
+	err := os.WriteFile(path, []byte("https://example.org/\n - tag: kept\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config file")
+	}
+
+	// Feeds nested within folders, along with one we have already and
+	// one which is in two folders.
+	opml := filepath.Join(dir, "feeds.opml")
+	err = os.WriteFile(opml, []byte(`<?xml version="1.0" encoding="utf-8"?>
+<opml version="2.0">
+<head><title>Subscriptions</title></head>
+<body>
+  <outline type="rss" text="Top" xmlUrl="https://example.com/top.xml"/>
+  <outline text="News">
+    <outline type="rss" text="Existing" xmlUrl="https://example.org/"/>
+    <outline type="rss" text="One" xmlUrl="https://example.com/one.xml"/>
+    <outline text="Local">
+      <outline type="rss" text="Two" xmlUrl="https://example.com/two.xml"/>
+    </outline>
+  </outline>
+  <outline text="Tech">
+    <outline type="rss" text="One again" xmlUrl="https://example.com/one.xml"/>
+  </outline>
+</body>
+</opml>
+`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write OPML file")
+	}
+
+	im := importCmd{}
+	im.Arguments(nil)
+	config := configfile.NewWithPath(path)
+	im.config = config
+
+	if im.Execute([]string{opml}) != 0 {
+		t.Fatalf("import failed")
+	}
+	if buf.String() != "added 3 feeds, skipped 2 duplicates\n" {
+		t.Fatalf("unexpected summary %q", buf.String())
+	}
+
+	entries, err := config.Parse()
+	if err != nil {
+		t.Fatalf("error parsing the updated config file: %s", err)
+	}
+
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+	}
+	expected := "https://example.org/,https://example.com/top.xml,https://example.com/one.xml,https://example.com/two.xml"
+	if strings.Join(urls, ",") != expected {
+		t.Fatalf("unexpected entries %v", urls)
+	}
+
+	// The existing feed keeps its options, and the new feeds have none.
+	if len(entries[0].Options) != 1 || entries[0].Options[0].Value != "kept" {
+		t.Fatalf("existing feed was modified %v", entries[0])
+	}
+	for _, entry := range entries[1:] {
+		if len(entry.Options) != 0 {
+			t.Fatalf("unexpected options %v", entry)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+//
+// Import seen-state from the JSON written by export-state.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Structure for our options and state.
+type importStateCmd struct {
+
+	// stateDB is the SQLite database holding our state, if any.
+	stateDB string
+}
+
+// Info is part of the subcommand-API.
+func (i *importStateCmd) Info() (string, string) {
+	return "import-state", `Import seen-state from JSON.
+
+This sub-command reads the JSON written by 'export-state', from the
+named file or from STDIN, and merges it into our state.  Items which
+we've already seen are left alone, so importing the same file twice
+changes nothing.
+
+The state is written to the BoltDB database in ~/.rss2email/state.db,
+unless '-state-db' names the SQLite database given to 'cron' or 'daemon'.
+
+The file is checked before anything is imported, and each feed is
+imported atomically.
+
+Examples:
+
+    $ rss2email import-state state.json
+    $ rss2email export-state | ssh host rss2email import-state
+    $ rss2email import-state -state-db /path/to/state.sqlite.db state.json
+`
+}
+
+// Arguments handles our flag-setup.
+func (i *importStateCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&i.stateDB, "state-db", "", "Write the state to the given SQLite database, rather than the default BoltDB one")
+}
+
+// readStateExport reads, and validates, the JSON written by export-state.
+//
+// The items of each feed are returned with the times they were seen,
+// which are zero if the export didn't include them.
+func readStateExport(r io.Reader) (stateExport, []map[string]time.Time, error) {
+
+	var export stateExport
+
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return export, nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	var times []map[string]time.Time
+	for _, feed := range export.Feeds {
+		if feed.URL == "" {
+			return export, nil, fmt.Errorf("feed without a url")
+		}
+
+		items := make(map[string]time.Time, len(feed.Items))
+		for _, item := range feed.Items {
+			if item.GUID == "" {
+				return export, nil, fmt.Errorf("item without a guid in feed %s", feed.URL)
+			}
+
+			var seen time.Time
+			if item.SeenAt != "" {
+				seen, err = time.Parse(time.RFC3339, item.SeenAt)
+				if err != nil {
+					return export, nil, fmt.Errorf("invalid seen_at for %s in feed %s: %w", item.GUID, feed.URL, err)
+				}
+			}
+			items[item.GUID] = seen
+		}
+		times = append(times, items)
+	}
+
+	return export, times, nil
+}
+
+// Entry-point.
+func (i *importStateCmd) Execute(args []string) int {
+
+	if len(args) > 1 {
+		fmt.Fprintf(out, "Usage: rss2email import-state [file]\n")
+		return 1
+	}
+
+	// Read from STDIN unless we're given a file.
+	var input io.Reader = os.Stdin
+	name := "STDIN"
+	if len(args) == 1 && args[0] != "-" {
+		name = args[0]
+
+		fh, err := os.Open(name)
+		if err != nil {
+			logger.Error("failed to open file", slog.String("path", name), slog.String("error", err.Error()))
+			return 1
+		}
+		defer fh.Close()
+		input = fh
+	}
+
+	export, times, err := readStateExport(input)
+	if err != nil {
+		logger.Error("failed to read state", slog.String("path", name), slog.String("error", err.Error()))
+		return 1
+	}
+
+	store, path, err := openStateStore(i.stateDB, true)
+	if err != nil {
+		logger.Error("failed to open state", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	defer store.Close()
+
+	imported := 0
+	existing := 0
+	for n, feed := range export.Feeds {
+
+		// Count the items we already have, which are left alone.
+		known, err := store.SeenTimes(feed.URL)
+		if err != nil {
+			logger.Error("failed to read items", slog.String("state", path), slog.String("feed", feed.URL), slog.String("error", err.Error()))
+			return 1
+		}
+		for guid := range times[n] {
+			if _, ok := known[guid]; ok {
+				existing++
+			} else {
+				imported++
+			}
+		}
+
+		err = store.RecordTimes(feed.URL, times[n])
+		if err != nil {
+			logger.Error("failed to write items", slog.String("state", path), slog.String("feed", feed.URL), slog.String("error", err.Error()))
+			return 1
+		}
+	}
+
+	fmt.Fprintf(out, "Imported %d entries from %d feeds, %d were already present\n", imported, len(export.Feeds), existing)
+	return 0
+}
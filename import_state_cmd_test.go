@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skx/rss2email/state"
+)
+
+// TestImportStateInvalid tests that bad input is rejected, without
+// importing anything.
+func TestImportStateInvalid(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	sqlite := filepath.Join(dir, "state.db")
+
+	for _, content := range []string{
+		`not json`,
+		`{"feeds": [{"items": [{"guid": "a"}]}]}`,
+		`{"feeds": [{"url": "https://example.com/", "items": [{"guid": ""}]}]}`,
+		`{"feeds": [{"url": "https://example.com/", "items": [{"guid": "a"}]},
+		            {"url": "https://example.net/", "items": [{"guid": "b", "seen_at": "yesterday"}]}]}`,
+	} {
+		file := filepath.Join(dir, "state.json")
+		err := os.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("failed to write file: %s", err)
+		}
+
+		out = &bytes.Buffer{}
+		i := importStateCmd{stateDB: sqlite}
+		if i.Execute([]string{file}) != 1 {
+			t.Fatalf("expected failure importing %s", content)
+		}
+	}
+
+	out = &bytes.Buffer{}
+	i := importStateCmd{stateDB: sqlite}
+	if i.Execute([]string{filepath.Join(dir, "missing.json")}) != 1 {
+		t.Fatalf("expected failure with a missing file")
+	}
+	if i.Execute([]string{"one", "two"}) != 1 {
+		t.Fatalf("expected failure with two files")
+	}
+
+	// Nothing was imported.
+	if _, err := os.Stat(sqlite); !os.IsNotExist(err) {
+		s, _ := state.OpenSQLite(sqlite)
+		feeds, _ := s.Feeds()
+		s.Close()
+		if len(feeds) != 0 {
+			t.Fatalf("unexpected feeds imported %v", feeds)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/skx/rss2email/configfile"
@@ -27,6 +28,10 @@ type listCmd struct {
 	// verbose controls whether our feed-list contains information
 	// about feed entries and their ages
 	verbose bool
+
+	// withOption restricts the list to feeds which have the given
+	// option set, either "name" or "name=value".
+	withOption string
 }
 
 // Arguments handles argument-flags we might have.
@@ -40,6 +45,9 @@ func (l *listCmd) Arguments(flags *flag.FlagSet) {
 
 	// Are we listing verbosely?
 	flags.BoolVar(&l.verbose, "verbose", false, "Show extra information about each feed (slow)?")
+
+	// Are we only listing some feeds?
+	flags.StringVar(&l.withOption, "with-option", "", "Only list feeds with the given option set, as 'name' or 'name=value'")
 }
 
 // Info is part of the subcommand-API
@@ -61,9 +69,14 @@ thus be slow - a simpler way of showing history would be to run:
 
     $ rss2email seen
 
+You can use '-with-option' to list only the feeds which have a particular
+per-feed option set, optionally to a specific value.
+
 Example:
 
     $ rss2email list
+    $ rss2email list -with-option exclude-older
+    $ rss2email list -with-option include-category=tech
 `
 }
 
@@ -118,6 +131,16 @@ func (l *listCmd) Execute(args []string) int {
 		return 1
 	}
 
+	// Restrict the feeds, if we should.
+	if l.withOption != "" {
+		name, value, found := strings.Cut(l.withOption, "=")
+		if found {
+			entries = l.config.FindFeedByOption(name, value)
+		} else {
+			entries = l.config.FindFeedsByOptionName(name)
+		}
+	}
+
 	// Show the feeds
 	for _, entry := range entries {
 
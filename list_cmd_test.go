@@ -69,3 +69,52 @@ https://example.net/index.rss
 
 	os.Remove(tmpfile.Name())
 }
+
+// TestListWithOption confirms that we can list only some feeds.
+func TestListWithOption(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	tmpfile, err := os.CreateTemp("", "example")
+	if err != nil {
+		t.Fatalf("Error creating temporary file")
+	}
+	defer os.Remove(tmpfile.Name())
+
+	err = os.WriteFile(tmpfile.Name(), []byte(`https://example.org/
+ - exclude-older: 7
+https://example.net/index.rss
+ - exclude-older: 30
+https://example.com/
+`), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	tests := map[string][]string{
+		"exclude-older":    {"https://example.org/", "https://example.net/index.rss"},
+		"exclude-older=30": {"https://example.net/index.rss"},
+		"tag":              {},
+	}
+
+	for opt, expected := range tests {
+		out = &bytes.Buffer{}
+
+		list := listCmd{config: configfile.NewWithPath(tmpfile.Name()), withOption: opt}
+		ret := list.Execute([]string{})
+		if ret != 0 {
+			t.Fatalf("unexpected error running list")
+		}
+
+		output := strings.Fields(out.(*bytes.Buffer).String())
+		if len(output) != len(expected) {
+			t.Fatalf("%s: unexpected output %v", opt, output)
+		}
+		for i := range expected {
+			if output[i] != expected[i] {
+				t.Fatalf("%s: unexpected output %v", opt, output)
+			}
+		}
+	}
+}
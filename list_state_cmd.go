@@ -0,0 +1,129 @@
+//
+// List the items of our seen-state, with the times they were seen.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Structure for our options and state.
+type listStateCmd struct {
+
+	// feed restricts the output to a single feed, if set.
+	feed string
+
+	// sort is the order in which the items of each feed are shown.
+	sort string
+
+	// stateDB is the SQLite database holding our state, if any.
+	stateDB string
+}
+
+// Info is part of the subcommand-API.
+func (l *listStateCmd) Info() (string, string) {
+	return "list-state", `List the seen-state, with the time each item was seen.
+
+This sub-command shows every item we've seen, grouped by feed, with the
+time it was seen, which is useful to find out why an item was, or wasn't,
+sent.  The state is only read, never modified.
+
+The items of each feed are shown with the most recently seen first, or
+sorted by their GUID with '-sort item'.  Items recorded by older releases
+have no time, and are shown last.  The titles of items aren't recorded,
+so only their GUIDs, usually their links, can be shown.
+
+Use '-feed' to show only the items of a single feed.
+
+The state is the BoltDB database in ~/.rss2email/state.db, unless
+'-state-db' names the SQLite database given to 'cron' or 'daemon'.
+
+Examples:
+
+    $ rss2email list-state
+    $ rss2email list-state -feed https://example.com/feed.xml
+    $ rss2email list-state -sort item
+`
+}
+
+// Arguments handles our flag-setup.
+func (l *listStateCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&l.feed, "feed", "", "Only show the items of the given feed")
+	f.StringVar(&l.sort, "sort", "date", "Sort the items of each feed by 'date', most recent first, or by 'item'")
+	f.StringVar(&l.stateDB, "state-db", "", "Read the state from the given SQLite database, rather than the default BoltDB one")
+}
+
+// Entry-point.
+func (l *listStateCmd) Execute(args []string) int {
+
+	if l.sort != "" && l.sort != "date" && l.sort != "item" {
+		fmt.Fprintf(out, "The -sort flag must be 'date' or 'item', not '%s'\n", l.sort)
+		return 1
+	}
+
+	store, path, err := openStateStore(l.stateDB, false)
+	if err != nil {
+		logger.Error("failed to open state", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	defer store.Close()
+
+	feeds, err := store.Feeds()
+	if err != nil {
+		logger.Error("failed to read feeds", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	sort.Strings(feeds)
+
+	found := false
+	for _, feed := range feeds {
+
+		if l.feed != "" && feed != l.feed {
+			continue
+		}
+		found = true
+
+		times, err := store.SeenTimes(feed)
+		if err != nil {
+			logger.Error("failed to read items", slog.String("state", path), slog.String("feed", feed), slog.String("error", err.Error()))
+			return 1
+		}
+
+		items := make([]string, 0, len(times))
+		for guid := range times {
+			items = append(items, guid)
+		}
+		sort.Slice(items, func(i, j int) bool {
+			a, b := times[items[i]], times[items[j]]
+			if l.sort != "item" && !a.Equal(b) {
+				return a.After(b)
+			}
+			return items[i] < items[j]
+		})
+
+		fmt.Fprintf(out, "%s (%d items)\n", feed, len(items))
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "\tSEEN\tGUID\n")
+		for _, guid := range items {
+			seen := "unknown"
+			if !times[guid].IsZero() {
+				seen = times[guid].UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "\t%s\t%s\n", seen, guid)
+		}
+		w.Flush()
+	}
+
+	if l.feed != "" && !found {
+		fmt.Fprintf(out, "%s has no recorded items\n", l.feed)
+	}
+
+	return 0
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestListState tests listing the seen-state, with each backend.
+func TestListState(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	os.MkdirAll(filepath.Join(dir, ".rss2email"), 0755)
+
+	feed := "https://example.com/feed"
+	other := "https://example.org/feed"
+
+	for _, stateDB := range []string{"", filepath.Join(dir, "state.sqlite.db")} {
+
+		store, _, err := openStateStore(stateDB, true)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		err = store.RecordTimes(feed, map[string]time.Time{
+			"https://example.com/1": time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC),
+			"https://example.com/2": time.Date(2024, time.March, 11, 12, 0, 0, 0, time.UTC),
+		})
+		if err == nil {
+			err = store.RecordTimes(other, map[string]time.Time{"https://example.org/1": {}})
+		}
+		if err != nil {
+			t.Fatalf("failed to record items: %s", err)
+		}
+		store.Close()
+
+		out = &bytes.Buffer{}
+		l := listStateCmd{stateDB: stateDB, sort: "date"}
+		if l.Execute(nil) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+
+		output := out.(*bytes.Buffer).String()
+		for _, expected := range []string{
+			feed + " (2 items)",
+			"2024-03-11T12:00:00Z  https://example.com/2",
+			"2024-03-10T12:00:00Z  https://example.com/1",
+			other + " (1 items)",
+			"unknown  https://example.org/1",
+		} {
+			if !strings.Contains(output, expected) {
+				t.Fatalf("expected '%s', got:\n%s", expected, output)
+			}
+		}
+
+		// The most recent is first.
+		if strings.Index(output, "example.com/2") > strings.Index(output, "example.com/1") {
+			t.Fatalf("expected the most recent item first:\n%s", output)
+		}
+
+		// Unless we sort by item.
+		out = &bytes.Buffer{}
+		l = listStateCmd{stateDB: stateDB, sort: "item"}
+		if l.Execute(nil) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+		output = out.(*bytes.Buffer).String()
+		if strings.Index(output, "example.com/2") < strings.Index(output, "example.com/1") {
+			t.Fatalf("expected items sorted by GUID:\n%s", output)
+		}
+
+		// Restricted to one feed.
+		out = &bytes.Buffer{}
+		l = listStateCmd{stateDB: stateDB, feed: other}
+		if l.Execute(nil) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+		output = out.(*bytes.Buffer).String()
+		if !strings.Contains(output, "https://example.org/1") || strings.Contains(output, feed) {
+			t.Fatalf("unexpected output for one feed:\n%s", output)
+		}
+
+		// A feed we've not seen.
+		out = &bytes.Buffer{}
+		l = listStateCmd{stateDB: stateDB, feed: "https://example.net/"}
+		if l.Execute(nil) != 0 || !strings.Contains(out.(*bytes.Buffer).String(), "no recorded items") {
+			t.Fatalf("unexpected output: %s", out)
+		}
+
+		// A bogus order.
+		out = &bytes.Buffer{}
+		l = listStateCmd{stateDB: stateDB, sort: "title"}
+		if l.Execute(nil) != 1 {
+			t.Fatalf("expected failure with a bogus -sort")
+		}
+
+		// Nothing was changed.
+		store, _, err = openStateStore(stateDB, false)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		items, err := store.Items(feed)
+		store.Close()
+		if err != nil || len(items) != 2 {
+			t.Fatalf("expected the items to remain, got %v: %v", items, err)
+		}
+	}
+}
@@ -158,19 +158,34 @@ func main() {
 	// Register each of our subcommands.
 	//
 	subcommands.Register(&addCmd{})
+	subcommands.Register(&addFeedCmd{})
 	subcommands.Register(&checkCmd{})
 	subcommands.Register(&cronCmd{})
 	subcommands.Register(&configCmd{})
+	subcommands.Register(&countCmd{})
 	subcommands.Register(&daemonCmd{})
 	subcommands.Register(&delCmd{})
 	subcommands.Register(&exportCmd{})
+	subcommands.Register(&exportStateCmd{})
+	subcommands.Register(&generateConfigCmd{})
+	subcommands.Register(&healthCheckCmd{})
 	subcommands.Register(&importCmd{})
+	subcommands.Register(&importStateCmd{})
 	subcommands.Register(&listCmd{})
 	subcommands.Register(&listDefaultTemplateCmd{})
+	subcommands.Register(&listStateCmd{})
+	subcommands.Register(&migrateStateCmd{})
+	subcommands.Register(&previewBrowserCmd{})
+	subcommands.Register(&purgeStateCmd{})
+	subcommands.Register(&removeFeedCmd{})
+	subcommands.Register(&resetCircuitBreakerCmd{})
+	subcommands.Register(&resetFeedCmd{})
 	subcommands.Register(&seenCmd{})
 	subcommands.Register(&statusCmd{})
 	subcommands.Register(&testCmd{})
+	subcommands.Register(&testFeedCmd{})
 	subcommands.Register(&unseeCmd{})
+	subcommands.Register(&validateCmd{})
 	subcommands.Register(&versionCmd{})
 
 	//
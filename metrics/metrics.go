@@ -0,0 +1,161 @@
+// Package metrics exports the statistics of our feed processing in the
+// Prometheus text format, via a HTTP server.
+//
+// A Server is given to the processor as its MetricsRecorder, and the
+// following metrics are available from its /metrics endpoint:
+//
+//	rss2email_feeds_processed_total
+//	rss2email_items_seen_total
+//	rss2email_items_skipped_total{reason}
+//	rss2email_emails_sent_total
+//	rss2email_fetch_errors_total{feed_url}
+//	rss2email_last_run_duration_seconds
+//
+// The counters accumulate across runs, so a single Server should be used
+// for the lifetime of the process.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/skx/rss2email/processor"
+)
+
+// Server holds our metrics, and the HTTP server which exports them.
+type Server struct {
+
+	// feedsProcessed counts the feeds each run processed.
+	feedsProcessed prometheus.Counter
+
+	// itemsSeen counts the items found in those feeds, whether they
+	// were new or not.
+	itemsSeen prometheus.Counter
+
+	// itemsSkipped counts the new items which weren't delivered,
+	// labelled by the filter which excluded them.
+	itemsSkipped *prometheus.CounterVec
+
+	// emailsSent counts the items which were delivered successfully.
+	emailsSent prometheus.Counter
+
+	// fetchErrors counts the failures to fetch, or parse, each feed.
+	fetchErrors *prometheus.CounterVec
+
+	// lastRunDuration is the time the most recent run took.
+	lastRunDuration prometheus.Gauge
+
+	// listener is the socket our HTTP server accepts connections on.
+	listener net.Listener
+
+	// server serves our metrics, until we're closed.
+	server *http.Server
+}
+
+// New creates our metrics, and starts a HTTP server exporting them on the
+// given address, such as ":9090", in the background.
+//
+// An error is returned if we can't listen on the address.  Errors serving
+// requests afterwards are logged via the given logger.
+func New(addr string, logger *slog.Logger) (*Server, error) {
+
+	s := &Server{
+		feedsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rss2email_feeds_processed_total",
+			Help: "The number of feeds which have been processed.",
+		}),
+		itemsSeen: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rss2email_items_seen_total",
+			Help: "The number of items found in the feeds which have been processed.",
+		}),
+		itemsSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rss2email_items_skipped_total",
+			Help: "The number of new items which were not delivered, by the filter which excluded them.",
+		}, []string{"reason"}),
+		emailsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rss2email_emails_sent_total",
+			Help: "The number of items which were delivered successfully.",
+		}),
+		fetchErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rss2email_fetch_errors_total",
+			Help: "The number of failures to fetch, or parse, each feed.",
+		}, []string{"feed_url"}),
+		lastRunDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rss2email_last_run_duration_seconds",
+			Help: "The time taken by the most recent run, in seconds.",
+		}),
+	}
+
+	// We use our own registry, rather than the global one, so that
+	// only our metrics are exported.
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s.feedsProcessed, s.itemsSeen, s.itemsSkipped, s.emailsSent, s.fetchErrors, s.lastRunDuration)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server failed",
+				slog.String("addr", addr),
+				slog.String("error", err.Error()))
+		}
+	}()
+
+	return s, nil
+}
+
+// Addr returns the address our HTTP server is listening on, which is
+// useful if we were asked to listen on port zero.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// RecordRun updates our metrics with the statistics of a run, it is part
+// of the processor.MetricsRecorder interface.
+func (s *Server) RecordRun(stats map[string]processor.FeedStatistics, duration time.Duration) {
+
+	for url, feed := range stats {
+		s.feedsProcessed.Inc()
+		s.itemsSeen.Add(float64(feed.ItemsFetched))
+		s.emailsSent.Add(float64(feed.ItemsSent))
+
+		for reason, count := range feed.ItemsSkippedByFilter {
+			s.itemsSkipped.WithLabelValues(reason).Add(float64(count))
+		}
+
+		if feed.FetchError != nil {
+			s.fetchErrors.WithLabelValues(url).Inc()
+		}
+	}
+
+	s.lastRunDuration.Set(duration.Seconds())
+}
+
+// Close shuts down our HTTP server, waiting briefly for any requests
+// which are in progress.
+func (s *Server) Close() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/processor"
+	"github.com/skx/rss2email/withstate"
+)
+
+// discardOutput accepts every item it is given.
+type discardOutput struct{}
+
+func (d *discardOutput) Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+	return nil
+}
+
+func (d *discardOutput) Close() error {
+	return nil
+}
+
+// TestMetrics tests that the statistics of a run are exported.
+func TestMetrics(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>First</title><link>https://example.com/1</link></item>
+<item><title>Second</title><link>https://example.com/2</link></item>
+<item><title>Sponsored</title><link>https://example.com/3</link></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	server, err := New("127.0.0.1:0", logger)
+	if err != nil {
+		t.Fatalf("failed to start metrics server: %s", err)
+	}
+	defer server.Close()
+
+	p, err := processor.New(processor.ProcessorConfig{Send: true})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	options := []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "sleep", Value: "0"},
+	}
+	p.SetLogger(logger)
+	p.SetOutput(&discardOutput{})
+	p.SetMetrics(server)
+	p.SetFeeds([]configfile.Feed{
+		{URL: ts.URL + "/feed", Options: append(options, configfile.Option{Name: "exclude-title", Value: "Sponsored"})},
+		{URL: ts.URL + "/broken", Options: options},
+	})
+
+	p.ProcessFeeds(nil)
+
+	resp, err := http.Get("http://" + server.Addr() + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to fetch metrics: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %s", err)
+	}
+
+	for _, expected := range []string{
+		"rss2email_feeds_processed_total 2",
+		"rss2email_items_seen_total 3",
+		`rss2email_items_skipped_total{reason="pattern"} 1`,
+		"rss2email_emails_sent_total 2",
+		`rss2email_fetch_errors_total{feed_url="` + ts.URL + `/broken"} 1`,
+		"rss2email_last_run_duration_seconds ",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("expected %q in the metrics, got %s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+//
+// Copy our seen-state between backends.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/skx/rss2email/state"
+)
+
+// progressEvery is the number of entries after which we report progress.
+const progressEvery = 1000
+
+// Structure for our options and state.
+type migrateStateCmd struct {
+
+	// from is the specification of the store to read from.
+	from string
+
+	// to is the specification of the store to write to.
+	to string
+
+	// dryRun causes us to report what we'd migrate, without writing.
+	dryRun bool
+}
+
+// Info is part of the subcommand-API.
+func (m *migrateStateCmd) Info() (string, string) {
+	return "migrate-state", `Copy the seen-state from one backend to another.
+
+This sub-command reads every entry from the source state backend, and
+writes it to the destination, along with the time it was seen.  The
+fingerprint of each feed's options, its count of consecutive failures,
+and the "updated" timestamps of its items are copied too.  Each store is
+given as a backend-type and path:
+
+    bolt:/path/to/state.db     The BoltDB file we've always used, the
+                               'file' prefix is an alias for this.
+    sqlite:/path/to/state.db   A SQLite database.
+
+The source defaults to the BoltDB database in ~/.rss2email/state.db.
+
+Entries which already exist in the destination are left alone, as are
+the fingerprints, failures and timestamps it already has, so the
+migration may safely be repeated, or resumed if it fails part-way.
+
+Examples:
+
+    $ rss2email migrate-state -to sqlite:./state.db
+    $ rss2email migrate-state -from file:./state.db -to sqlite:./state.db
+    $ rss2email migrate-state -dry-run -to sqlite:./state.db
+`
+}
+
+// Arguments handles our flag-setup.
+func (m *migrateStateCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&m.from, "from", "bolt:"+state.DefaultPath(), "The state backend to read from")
+	f.StringVar(&m.to, "to", "", "The state backend to write to, such as 'sqlite:/path/to/state.db'")
+	f.BoolVar(&m.dryRun, "dry-run", false, "Report what would be migrated, without writing anything")
+}
+
+// Entry-point.
+func (m *migrateStateCmd) Execute(args []string) int {
+
+	if m.to == "" {
+		fmt.Fprintf(out, "Please specify the destination with -to, for example 'sqlite:./state.db'.\n")
+		return 1
+	}
+
+	fromKind, fromPath, err := state.ParseSpec(m.from)
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return 1
+	}
+	toKind, toPath, err := state.ParseSpec(m.to)
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return 1
+	}
+	if fromKind == toKind && fromPath == toPath {
+		fmt.Fprintf(out, "The source and destination are the same.\n")
+		return 1
+	}
+
+	// Opening a store creates it, which we don't want for our source.
+	if _, err = os.Stat(fromPath); err != nil {
+		logger.Error("failed to find source state", slog.String("state", m.from), slog.String("error", err.Error()))
+		return 1
+	}
+
+	src, err := state.Open(m.from)
+	if err != nil {
+		logger.Error("failed to open source state", slog.String("state", m.from), slog.String("error", err.Error()))
+		return 1
+	}
+	defer src.Close()
+
+	feeds, err := src.Feeds()
+	if err != nil {
+		logger.Error("failed to read feeds", slog.String("state", m.from), slog.String("error", err.Error()))
+		return 1
+	}
+
+	if m.dryRun {
+		total := 0
+		for _, feed := range feeds {
+			items, err := src.Items(feed)
+			if err != nil {
+				logger.Error("failed to read items", slog.String("state", m.from), slog.String("feed", feed), slog.String("error", err.Error()))
+				return 1
+			}
+			fmt.Fprintf(out, "%s: %d entries\n", feed, len(items))
+			total += len(items)
+		}
+		fmt.Fprintf(out, "Would migrate %d entries from %d feeds to %s\n", total, len(feeds), m.to)
+		return 0
+	}
+
+	dst, err := state.Open(m.to)
+	if err != nil {
+		logger.Error("failed to open destination state", slog.String("state", m.to), slog.String("error", err.Error()))
+		return 1
+	}
+	defer dst.Close()
+
+	total := 0
+	for _, feed := range feeds {
+
+		seen, err := src.SeenTimes(feed)
+		if err != nil {
+			logger.Error("failed to read items", slog.String("state", m.from), slog.String("feed", feed), slog.String("error", err.Error()))
+			return 1
+		}
+
+		// Sort the items, so that the batches are the same each time.
+		items := make([]string, 0, len(seen))
+		for item := range seen {
+			items = append(items, item)
+		}
+		sort.Strings(items)
+
+		err = migrateFeedState(src, dst, feed, items)
+		if err != nil {
+			logger.Error("failed to copy feed state", slog.String("state", m.to), slog.String("feed", feed), slog.String("error", err.Error()))
+			return 1
+		}
+
+		// Write in batches which end on a multiple of progressEvery,
+		// so we can report progress as we go.  Each batch is written
+		// atomically, so a failure never leaves a partial entry.
+		for len(items) > 0 {
+			n := min(len(items), progressEvery-total%progressEvery)
+
+			batch := make(map[string]time.Time, n)
+			for _, item := range items[:n] {
+				batch[item] = seen[item]
+			}
+
+			err = dst.RecordTimes(feed, batch)
+			if err != nil {
+				logger.Error("failed to write items", slog.String("state", m.to), slog.String("feed", feed), slog.String("error", err.Error()))
+				fmt.Fprintf(out, "Migrated %d entries before failing\n", total)
+				return 1
+			}
+
+			items = items[n:]
+			total += n
+			if total%progressEvery == 0 {
+				fmt.Fprintf(out, "Migrated %d entries\n", total)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "Migrated %d entries from %d feeds to %s\n", total, len(feeds), m.to)
+	return 0
+}
+
+// migrateFeedState copies the fingerprint and failures of the given feed,
+// and the "updated" timestamps of its items, unless the destination already
+// has them.
+func migrateFeedState(src state.Store, dst state.Store, feed string, items []string) error {
+
+	fingerprint, err := src.Fingerprint(feed)
+	if err != nil {
+		return err
+	}
+	existing, err := dst.Fingerprint(feed)
+	if err != nil {
+		return err
+	}
+	if fingerprint != "" && existing == "" {
+		err = dst.SetFingerprint(feed, fingerprint)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The count can only be increased one failure at a time.
+	failures, at, err := src.Failures(feed)
+	if err != nil {
+		return err
+	}
+	current, _, err := dst.Failures(feed)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		for i := 0; i < failures; i++ {
+			_, err = dst.RecordFailure(feed, at)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, item := range items {
+		updated, err := src.Updated(feed, item)
+		if err != nil {
+			return err
+		}
+		if updated == "" {
+			continue
+		}
+		existing, err := dst.Updated(feed, item)
+		if err != nil {
+			return err
+		}
+		if existing == "" {
+			err = dst.SetUpdated(feed, item, updated)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
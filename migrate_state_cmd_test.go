@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skx/rss2email/state"
+)
+
+// TestMigrateState tests copying state between backends.
+func TestMigrateState(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	from := "file:" + filepath.Join(dir, "state.db")
+	to := "sqlite:" + filepath.Join(dir, "state.sqlite")
+
+	// Populate our source with enough items to report progress.
+	src, err := state.Open(from)
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	var items []string
+	for i := 0; i < 1500; i++ {
+		items = append(items, fmt.Sprintf("https://example.com/%d", i))
+	}
+	err = src.Record("https://example.com/feed", items...)
+	if err != nil {
+		t.Fatalf("failed to record state: %s", err)
+	}
+
+	// One feed has items seen long ago, and a fingerprint, failures
+	// and an updated timestamp to be copied.
+	seen := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	failed := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	err = src.RecordTimes("https://example.net/feed", map[string]time.Time{"https://example.net/1": seen})
+	if err == nil {
+		err = src.SetFingerprint("https://example.net/feed", "abc123")
+	}
+	if err == nil {
+		_, err = src.RecordFailure("https://example.net/feed", failed)
+	}
+	if err == nil {
+		_, err = src.RecordFailure("https://example.net/feed", failed)
+	}
+	if err == nil {
+		err = src.SetUpdated("https://example.net/feed", "https://example.net/1", "2020-01-02T00:00:00Z")
+	}
+	if err != nil {
+		t.Fatalf("failed to record state: %s", err)
+	}
+	src.Close()
+
+	// A dry-run reports, without creating the destination.
+	out = &bytes.Buffer{}
+	m := migrateStateCmd{from: from, to: to, dryRun: true}
+	if m.Execute(nil) != 0 {
+		t.Fatalf("unexpected failure: %s", out)
+	}
+	if !strings.Contains(out.(*bytes.Buffer).String(), "Would migrate 1501 entries from 2 feeds") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+
+	// Migrating twice doesn't duplicate anything.
+	for i := 0; i < 2; i++ {
+		out = &bytes.Buffer{}
+		m = migrateStateCmd{from: from, to: to}
+		if m.Execute(nil) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+		output := out.(*bytes.Buffer).String()
+		if !strings.Contains(output, "Migrated 1000 entries\n") ||
+			!strings.Contains(output, "Migrated 1501 entries from 2 feeds") {
+			t.Fatalf("unexpected output: %s", output)
+		}
+	}
+
+	dst, err := state.Open(to)
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	defer dst.Close()
+
+	found, err := dst.Items("https://example.com/feed")
+	if err != nil {
+		t.Fatalf("failed to read state: %s", err)
+	}
+	if len(found) != 1500 {
+		t.Fatalf("unexpected item count %d", len(found))
+	}
+
+	// The time each item was seen survives.
+	times, err := dst.SeenTimes("https://example.net/feed")
+	if err != nil {
+		t.Fatalf("failed to read state: %s", err)
+	}
+	if !times["https://example.net/1"].Equal(seen) {
+		t.Fatalf("unexpected seen time %v", times)
+	}
+
+	// As does the rest of the feed's state, without being doubled up
+	// by the second migration.
+	fingerprint, _ := dst.Fingerprint("https://example.net/feed")
+	failures, at, _ := dst.Failures("https://example.net/feed")
+	updated, _ := dst.Updated("https://example.net/feed", "https://example.net/1")
+	if fingerprint != "abc123" || failures != 2 || !at.Equal(failed) || updated != "2020-01-02T00:00:00Z" {
+		t.Fatalf("unexpected feed state %q %d %v %q", fingerprint, failures, at, updated)
+	}
+
+	// Bogus arguments are reported.
+	for _, m := range []migrateStateCmd{
+		{from: from},
+		{from: from, to: from},
+		{from: "bogus", to: to},
+		{from: "sqlite:" + filepath.Join(dir, "missing.db"), to: to},
+	} {
+		out = &bytes.Buffer{}
+		if m.Execute(nil) != 1 {
+			t.Fatalf("expected failure with %v", m)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+//
+// Preview the email for a feed item in a web browser.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/skx/rss2email/config"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/httpfetch"
+	"github.com/skx/rss2email/processor"
+	"github.com/skx/rss2email/processor/emailer"
+)
+
+var (
+	// openBrowser opens the given file in the user's browser, it is
+	// replaced in our tests.
+	openBrowser = func(path string) error {
+		return browserCommand(runtime.GOOS, path).Run()
+	}
+
+	// previewLifetime is how long we wait before removing the preview,
+	// to give the browser time to load it.
+	previewLifetime = 60 * time.Second
+)
+
+// Structure for our options and state.
+type previewBrowserCmd struct {
+
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+
+	// feed is the URL of the feed to preview.
+	feed string
+
+	// item is the index of the item within the feed.
+	item int
+
+	// to is the recipient address used when rendering.
+	to string
+
+	// cleanup removes the preview as soon as the browser has been
+	// launched, rather than after previewLifetime.
+	cleanup bool
+}
+
+// Info is part of the subcommand-API.
+func (p *previewBrowserCmd) Info() (string, string) {
+	return "preview-browser", `Open the email for a feed item in your web browser.
+
+This sub-command fetches the given feed, renders the HTML part of the
+email which would be sent for one of its items, and opens it in your
+default web browser.  This lets you see how your templates will look
+without sending anything.
+
+If the feed is present in your configuration file then its options,
+such as the template and the tag, are used.
+
+The preview is written to a temporary file, /tmp/rss2email-preview-*.html,
+which is removed after 60 seconds.  With -cleanup it is removed as soon
+as the browser has been launched instead.
+
+No state is updated, and no emails are sent.
+
+Examples:
+
+    $ rss2email preview-browser -feed https://blog.example.com/feed.xml
+    $ rss2email preview-browser -feed https://blog.example.com/feed.xml -item 2
+`
+}
+
+// Arguments handles our flag-setup.
+func (p *previewBrowserCmd) Arguments(f *flag.FlagSet) {
+	p.config = configfile.New()
+
+	f.StringVar(&p.feed, "feed", "", "The URL of the feed to preview")
+	f.IntVar(&p.item, "item", 0, "The index of the item to preview, starting from zero")
+	f.StringVar(&p.to, "to", "preview@example.com", "The recipient address to use when rendering the email")
+	f.BoolVar(&p.cleanup, "cleanup", false, "Remove the preview once the browser has been launched, rather than after 60 seconds")
+}
+
+// browserCommand returns the command which opens the given file with the
+// default application on the given operating system.
+func browserCommand(goos string, path string) *exec.Cmd {
+	switch goos {
+	case "darwin":
+		return exec.Command("open", path)
+	case "windows":
+		return exec.Command("cmd", "/c", "start", "", path)
+	default:
+		return exec.Command("xdg-open", path)
+	}
+}
+
+// Entry-point.
+func (p *previewBrowserCmd) Execute(args []string) int {
+
+	if p.feed == "" {
+		fmt.Fprintf(out, "Please specify the feed to preview with -feed.\n")
+		return 1
+	}
+
+	// Use the settings of the feed, if it is one we're watching.
+	entry := configfile.Feed{URL: p.feed}
+	entries, err := p.config.Parse()
+	if err != nil {
+		fmt.Fprintf(out, "Error parsing config: %s\n", err.Error())
+		return 1
+	}
+	for _, e := range entries {
+		if e.URL == p.feed {
+			entry = e
+		}
+	}
+
+	// Fetch the feed in full, and without updating the cache, so that
+	// our next run still sees the new items.
+	helper := httpfetch.New(entry, logger, version)
+	helper.SetBypassCache(true)
+	helper.SetReadOnly(true)
+	feed, err := helper.Fetch()
+	if err != nil {
+		logger.Error("failed to fetch feed", slog.String("feed", p.feed), slog.String("error", err.Error()))
+		return 1
+	}
+
+	if p.item < 0 || p.item >= len(feed.Items) {
+		fmt.Fprintf(out, "Item %d not found, the feed contains %d items.\n", p.item, len(feed.Items))
+		return 1
+	}
+
+	// The template may use the values from the template-variable-file.
+	appConfig, cErr := config.Load()
+	if cErr != nil {
+		appConfig = &config.Config{}
+	}
+
+	proc, err := processor.New(processor.ProcessorConfig{TemplateVariables: templateVariables(appConfig)})
+	if err != nil {
+		logger.Error("failed to create feed processor", slog.String("error", err.Error()))
+		return 1
+	}
+	defer proc.Close()
+	proc.SetLogger(logger)
+
+	msg, err := proc.Preview(entry, feed, feed.Items[p.item], p.to)
+	if err != nil {
+		logger.Error("failed to render email", slog.String("feed", p.feed), slog.String("error", err.Error()))
+		return 1
+	}
+
+	html, err := emailer.HTMLPart(msg)
+	if err != nil {
+		logger.Error("failed to find HTML in email", slog.String("feed", p.feed), slog.String("error", err.Error()))
+		return 1
+	}
+
+	tmp, err := os.CreateTemp("", "rss2email-preview-*.html")
+	if err != nil {
+		logger.Error("failed to create preview", slog.String("error", err.Error()))
+		return 1
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(html)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		logger.Error("failed to write preview", slog.String("path", tmp.Name()), slog.String("error", err.Error()))
+		return 1
+	}
+
+	fmt.Fprintf(out, "Opening %s\n", tmp.Name())
+	err = openBrowser(tmp.Name())
+	if err != nil {
+		logger.Error("failed to open browser", slog.String("path", tmp.Name()), slog.String("error", err.Error()))
+		return 1
+	}
+
+	// The browser might load the file after its launcher exits, so
+	// we keep it around for a while unless asked not to.
+	if !p.cleanup {
+		time.Sleep(previewLifetime)
+	}
+
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+func TestPreviewBrowser(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	out = &bytes.Buffer{}
+	defer func() { out = bak }()
+
+	// Don't use any template the user has installed.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".rss2email"), 0755); err != nil {
+		t.Fatalf("failed to create state directory: %s", err)
+	}
+
+	// Record what we'd have shown, rather than launching a browser.
+	var opened, content string
+	openBak := openBrowser
+	openBrowser = func(path string) error {
+		opened = path
+		data, err := os.ReadFile(path)
+		content = string(data)
+		return err
+	}
+	defer func() { openBrowser = openBak }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0">
+<channel>
+<title>Test Feed</title>
+<item><title>First</title><link>https://example.com/1</link><description>One</description></item>
+<item><title>Second</title><link>https://example.com/2</link><description>&lt;p&gt;Two &lt;img src="https://example.com/2.png" alt="pic"&gt;&lt;/p&gt;</description></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	tmpfile, err := os.CreateTemp("", "example")
+	if err != nil {
+		t.Fatalf("Error creating temporary file")
+	}
+	defer os.Remove(tmpfile.Name())
+
+	err = os.WriteFile(tmpfile.Name(), []byte(ts.URL+"\n - content-transform: images-to-text\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	p := previewBrowserCmd{config: configfile.NewWithPath(tmpfile.Name()), feed: ts.URL, item: 1, cleanup: true}
+	if p.Execute(nil) != 0 {
+		t.Fatalf("unexpected failure: %s", out)
+	}
+
+	if !strings.HasPrefix(opened, os.TempDir()) || !strings.Contains(opened, "rss2email-preview-") || !strings.HasSuffix(opened, ".html") {
+		t.Fatalf("unexpected preview path %s", opened)
+	}
+	if !strings.Contains(content, `<a href="https://example.com/2">Second</a>`) {
+		t.Fatalf("preview didn't contain the link: %s", content)
+	}
+
+	// The feed options were applied.
+	if !strings.Contains(content, "[Image: pic]") {
+		t.Fatalf("preview didn't use the feed options: %s", content)
+	}
+
+	// The HTTP cache wasn't updated, which would hold back the items
+	// from the next run.
+	if _, err = os.Stat(filepath.Join(home, ".rss2email", "httpcache.json")); !os.IsNotExist(err) {
+		t.Fatalf("preview updated the HTTP cache")
+	}
+
+	// The preview is removed afterwards.
+	if _, err = os.Stat(opened); !os.IsNotExist(err) {
+		t.Fatalf("preview was not removed")
+	}
+
+	// Missing feeds, and items, are errors.
+	for _, p := range []previewBrowserCmd{
+		{config: configfile.NewWithPath(tmpfile.Name())},
+		{config: configfile.NewWithPath(tmpfile.Name()), feed: ts.URL, item: 2},
+	} {
+		if p.Execute(nil) != 1 {
+			t.Fatalf("expected failure with %v", p)
+		}
+	}
+}
+
+func TestBrowserCommand(t *testing.T) {
+
+	tests := map[string]string{
+		"linux":   "xdg-open /tmp/x.html",
+		"darwin":  "open /tmp/x.html",
+		"windows": "cmd /c start  /tmp/x.html",
+	}
+
+	for goos, expected := range tests {
+		cmd := browserCommand(goos, "/tmp/x.html")
+		got := strings.Join(cmd.Args, " ")
+		if got != expected {
+			t.Fatalf("%s: got %q, expected %q", goos, got, expected)
+		}
+	}
+}
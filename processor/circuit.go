@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/skx/rss2email/httpfetch"
+)
+
+// defaultCircuitBreakerReset is how long we wait before trying a feed
+// which has failed too many times in a row again, unless we're told
+// otherwise.
+const defaultCircuitBreakerReset = 24 * time.Hour
+
+// circuitOpen returns true if the feed has failed to be fetched so many
+// times in a row that we shouldn't try it again yet.
+//
+// Once the reset period has passed since the last failure we try again,
+// and another failure means waiting for the reset period once more.
+func (p *Processor) circuitOpen(logger *slog.Logger, feed string) bool {
+
+	if p.circuitThreshold == 0 {
+		return false
+	}
+
+	count, last, err := p.store.Failures(feed)
+	if err != nil {
+		logger.Warn("failed to read the failures of feed, fetching it anyway",
+			slog.String("error", err.Error()))
+		return false
+	}
+
+	if count < p.circuitThreshold || p.clock().Sub(last) >= p.circuitReset {
+		return false
+	}
+
+	logger.Debug("skipping feed which has failed repeatedly",
+		slog.Int("consecutive_failures", count),
+		slog.Time("last_failure", last),
+		slog.Time("retry_after", last.Add(p.circuitReset)))
+	return true
+}
+
+// recordFetch updates the count of consecutive failures of the feed, after
+// an attempt to fetch it which returned the given error.
+//
+// A feed which was unchanged was fetched successfully.  Problems updating
+// the count are logged, as they don't stop us processing the feed.
+func (p *Processor) recordFetch(logger *slog.Logger, feed string, fetchErr error) {
+
+	if p.circuitThreshold == 0 || p.dryRun != nil {
+		return
+	}
+
+	if fetchErr == nil || errors.Is(fetchErr, httpfetch.ErrUnchanged) {
+		count, _, err := p.store.Failures(feed)
+		if err == nil && count > 0 {
+			logger.Info("feed fetched successfully, after failing",
+				slog.Int("consecutive_failures", count))
+			err = p.store.ResetFailures(feed)
+		}
+		if err != nil {
+			logger.Warn("failed to reset the failures of feed",
+				slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	count, err := p.store.RecordFailure(feed, p.clock())
+	if err != nil {
+		logger.Warn("failed to record the failure of feed",
+			slog.String("error", err.Error()))
+		return
+	}
+
+	// We only warn once, as the feed's failure has already been
+	// reported and it won't be fetched next time.
+	if count == p.circuitThreshold {
+		logger.Warn("feed has failed repeatedly, it will not be fetched again until the reset period has passed",
+			slog.Int("consecutive_failures", count),
+			slog.Duration("reset", p.circuitReset))
+	}
+}
@@ -0,0 +1,206 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/skx/rss2email/config"
+	"github.com/skx/rss2email/state"
+)
+
+// ProcessorConfig holds the global settings of a Processor.
+//
+// The zero value is a safe default: nothing is delivered, and state is
+// held in a temporary database which is discarded when the processor is
+// closed.
+type ProcessorConfig struct {
+
+	// Send controls whether new items are delivered, when false we
+	// just record them as having been seen.
+	Send bool `json:"send" yaml:"send"`
+
+	// StatePath is the path to the BoltDB database we use to record
+	// the items we've seen.  If empty a temporary database is used,
+	// see DefaultStatePath for the usual location.
+	StatePath string `json:"state_path" yaml:"state_path"`
+
+	// StateDB is the path to a SQLite database, which is used to
+	// record the items we've seen instead of the BoltDB database at
+	// StatePath.  The path ":memory:" gives a database which is held
+	// in memory.
+	StateDB string `json:"state_db" yaml:"state_db"`
+
+	// StatusPath is the file a summary of each run which processed
+	// every feed is written to, as a RunStatus.  If empty no status is
+	// recorded, see DefaultStatusPath for the usual location.
+	StatusPath string `json:"status_path" yaml:"status_path"`
+
+	// LockTimeout is how long we wait for another process using the
+	// same state to finish, before New fails with ErrLocked.  It
+	// defaults to five seconds.
+	LockTimeout time.Duration `json:"lock_timeout" yaml:"lock_timeout"`
+
+	// CircuitBreakerThreshold is the number of times in a row a feed
+	// may fail to be fetched, or parsed, before we stop trying it
+	// until CircuitBreakerReset has passed.  Zero means we always try.
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold" yaml:"circuit_breaker_threshold"`
+
+	// CircuitBreakerReset is how long after its last failure we try a
+	// feed which reached CircuitBreakerThreshold again, it defaults to
+	// one day.
+	CircuitBreakerReset time.Duration `json:"circuit_breaker_reset" yaml:"circuit_breaker_reset"`
+
+	// Recipients are the addresses new items are emailed to by
+	// ProcessOnce, for feeds which don't have a "notify" option.
+	Recipients []string `json:"recipients,omitempty" yaml:"recipients,omitempty"`
+
+	// DefaultFrom is the sender address for emails, for feeds which
+	// don't have a "from" option.
+	DefaultFrom string `json:"default_from" yaml:"default_from"`
+
+	// SMTP contains the settings used to send email, if nil they are
+	// read from the application configuration file and environment.
+	SMTP *config.SMTPConfig `json:"smtp,omitempty" yaml:"smtp,omitempty"`
+
+	// ResetOnConfigChange causes the items we've seen in a feed to be
+	// forgotten when its options change, so that it is treated as a
+	// new feed.  Otherwise we just log a warning.
+	ResetOnConfigChange bool `json:"reset_on_config_change" yaml:"reset_on_config_change"`
+
+	// Backfill is the number of items to send from feeds which have
+	// never been processed, zero means all of them.
+	Backfill int `json:"backfill" yaml:"backfill"`
+
+	// RateLimit is the maximum number of items delivered per second,
+	// across all feeds.  Zero means there is no limit.
+	RateLimit float64 `json:"rate_limit" yaml:"rate_limit"`
+
+	// RateBurst is the number of items which may be delivered at once
+	// before RateLimit applies, it defaults to one.
+	RateBurst int `json:"rate_burst" yaml:"rate_burst"`
+
+	// Maildir is the path to a Maildir which emails are delivered
+	// into, rather than being sent via SMTP or sendmail.  It is created
+	// if it doesn't exist.
+	Maildir string `json:"maildir" yaml:"maildir"`
+
+	// Mbox is the path to an mbox file which emails are appended to,
+	// rather than being sent via SMTP or sendmail.  It is created if it
+	// doesn't exist, and can't be combined with Maildir.
+	Mbox string `json:"mbox" yaml:"mbox"`
+
+	// SMTPPoolSize is the number of SMTP connections which are kept
+	// open, and reused, between emails.  Zero means a single connection,
+	// which is reused for every email of a run, and closed by Close.
+	SMTPPoolSize int `json:"smtp_pool_size" yaml:"smtp_pool_size"`
+
+	// SMTPIdleTimeout is the time after which unused pooled
+	// connections are closed, it defaults to thirty seconds.
+	SMTPIdleTimeout time.Duration `json:"smtp_idle_timeout" yaml:"smtp_idle_timeout"`
+
+	// MaxEmailsPerRun is the number of items which are delivered by a
+	// single run, across all feeds.  Further new items aren't recorded
+	// as seen, so they're delivered by a later run.  Zero means there
+	// is no limit.
+	MaxEmailsPerRun int `json:"max_emails_per_run" yaml:"max_emails_per_run"`
+
+	// Proxy is the URL of the proxy feeds are fetched through, unless
+	// they have their own "proxy" option.  If it is empty the
+	// environment settings are used.
+	Proxy string `json:"proxy" yaml:"proxy"`
+
+	// WebhookURL is the URL new items are POSTed to, as JSON, in
+	// addition to being delivered, for feeds which don't have their
+	// own "webhook-url" option.  It must be http:// or https://.
+	WebhookURL string `json:"webhook_url" yaml:"webhook_url"`
+
+	// WebhookSecret is the key used to sign the payloads POSTed to
+	// WebhookURL, for feeds which don't have their own
+	// "webhook-secret" option.  If empty they aren't signed.
+	WebhookSecret string `json:"webhook_secret" yaml:"webhook_secret"`
+
+	// Concurrency is the number of feeds which are processed at once,
+	// each fetching its feed and sending its emails independently.
+	// Zero, or one, processes them one after another.
+	Concurrency int `json:"concurrency" yaml:"concurrency"`
+
+	// TemplateVariables are the values which are available to email
+	// templates as ".Vars", usually read from the file named by the
+	// application configuration's template-variable-file.
+	TemplateVariables map[string]any `json:"template_variables,omitempty" yaml:"template_variables,omitempty"`
+
+	// Version is the version of our application, which is sent in
+	// the default User-Agent.
+	Version string `json:"version" yaml:"version"`
+}
+
+// DefaultStatePath returns the path to the state database beneath our
+// state directory, which is what our commands use.
+func DefaultStatePath() string {
+	return filepath.Join(state.Directory(), "state.db")
+}
+
+// validate reports the first problem found with the configuration.
+func (c ProcessorConfig) validate() error {
+
+	if c.Backfill < 0 {
+		return fmt.Errorf("backfill must not be negative, got %d", c.Backfill)
+	}
+
+	if c.MaxEmailsPerRun < 0 {
+		return fmt.Errorf("max emails per run must not be negative, got %d", c.MaxEmailsPerRun)
+	}
+
+	if c.LockTimeout < 0 {
+		return fmt.Errorf("lock timeout must not be negative, got %s", c.LockTimeout)
+	}
+
+	if c.CircuitBreakerThreshold < 0 || c.CircuitBreakerReset < 0 {
+		return fmt.Errorf("circuit breaker threshold %d, reset %s, must not be negative", c.CircuitBreakerThreshold, c.CircuitBreakerReset)
+	}
+
+	if c.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative, got %d", c.Concurrency)
+	}
+
+	if c.RateLimit < 0 || c.RateBurst < 0 {
+		return fmt.Errorf("rate limit %g, burst %d, must not be negative", c.RateLimit, c.RateBurst)
+	}
+
+	if c.SMTPPoolSize < 0 || c.SMTPIdleTimeout < 0 {
+		return fmt.Errorf("SMTP pool size %d, idle timeout %s, must not be negative", c.SMTPPoolSize, c.SMTPIdleTimeout)
+	}
+
+	if c.Maildir != "" && c.Mbox != "" {
+		return errors.New("emails can't be delivered to both a maildir and an mbox")
+	}
+
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook URL '%s', expected http:// or https://", c.WebhookURL)
+		}
+	}
+
+	if c.DefaultFrom != "" {
+		_, err := mail.ParseAddress(c.DefaultFrom)
+		if err != nil {
+			return fmt.Errorf("invalid default from address '%s': %w", c.DefaultFrom, err)
+		}
+	}
+
+	if c.SMTP != nil {
+		if c.SMTP.Host == "" {
+			return errors.New("SMTP settings given without a host")
+		}
+		if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+			return fmt.Errorf("SMTP port %d is invalid (must be 1-65535)", c.SMTP.Port)
+		}
+	}
+
+	return nil
+}
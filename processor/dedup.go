@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/withstate"
+)
+
+// The ways in which we decide whether an item has been seen before.
+const (
+	// dedupDefault keys our state by the item link, as it has been
+	// since the beginning.
+	dedupDefault = iota
+
+	// dedupLink keys our state by the normalized item link, so that
+	// changes to the GUID, or the fragment, don't cause a resend.
+	dedupLink
+
+	// dedupLinkOrGUID records both the normalized link and the GUID,
+	// an item is seen if either has been seen before.
+	dedupLinkOrGUID
+)
+
+// The prefixes of the keys we store in our state database.
+//
+// Keys stored in the default way are raw URLs, so these prefixes mean
+// the different kinds of key can co-exist in the same bucket.
+const (
+	linkKeyPrefix = "link:"
+	guidKeyPrefix = "guid:"
+)
+
+// dedupMode returns the deduplication strategy configured for the feed.
+func dedupMode(entry configfile.Feed) int {
+
+	mode := dedupDefault
+
+	for _, opt := range entry.Options {
+
+		// downcase the value
+		val := strings.ToLower(opt.Value)
+		if val != "yes" && val != "true" {
+			continue
+		}
+
+		switch opt.Name {
+		case "deduplicate-by-link":
+			if mode == dedupDefault {
+				mode = dedupLink
+			}
+		case "deduplicate-by-link-or-guid":
+			mode = dedupLinkOrGUID
+		}
+	}
+
+	return mode
+}
+
+// normalizeLink converts a link to the form we use for deduplication: it
+// is lowercased and any fragment, and trailing slash, is removed.
+func normalizeLink(link string) string {
+
+	link = strings.ToLower(strings.TrimSpace(link))
+
+	u, err := url.Parse(link)
+	if err == nil {
+		u.Fragment = ""
+		u.RawFragment = ""
+		link = u.String()
+	} else if i := strings.Index(link, "#"); i >= 0 {
+		link = link[:i]
+	}
+
+	return strings.TrimSuffix(link, "/")
+}
+
+// stateKeys returns the keys under which the given item is recorded in
+// our state database.
+func stateKeys(mode int, item withstate.FeedItem) []string {
+
+	switch mode {
+	case dedupLink:
+		return []string{linkKeyPrefix + normalizeLink(item.Link)}
+	case dedupLinkOrGUID:
+		keys := []string{linkKeyPrefix + normalizeLink(item.Link)}
+		if item.GUID != "" {
+			keys = append(keys, guidKeyPrefix+item.GUID)
+		}
+		return keys
+	default:
+		return []string{item.Link}
+	}
+}
+
+// seenItemKeys returns true if the item has been seen under any of the
+// given keys.
+//
+// When a feed has been switched away from the default strategy we also
+// look for the key we used to record, otherwise changing the option would
+// cause every item to be resent.
+func (p *Processor) seenItemKeys(feed string, mode int, item withstate.FeedItem, keys []string) bool {
+
+	if mode != dedupDefault {
+		keys = append(keys, item.Link)
+	}
+
+	for _, key := range keys {
+		if p.seenItem(feed, key) {
+			return true
+		}
+	}
+
+	return false
+}
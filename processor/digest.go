@@ -0,0 +1,151 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strings"
+
+	"github.com/k3a/html2text"
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/processor/emailer"
+	"github.com/skx/rss2email/withstate"
+)
+
+// digestDescriptionLength is the number of characters of each item's
+// content which are shown in a digest.
+const digestDescriptionLength = 300
+
+// digestSubject is the subject of digest emails, unless the feed has its
+// own subject-template.  It is known to be valid.
+var digestSubject, _ = emailer.ParseSubjectTemplate("[{{.FeedTitle}}] {{.ItemTitle}}")
+
+// digestBody is the HTML listing the items in a digest.
+var digestBody = template.Must(template.New("digest").Parse(`{{range $i, $item := .}}{{if $i}}<hr>
+{{end}}<h2><a href="{{$item.Link}}">{{$item.Title}}</a></h2>
+{{- if or $item.Author $item.Date}}
+<p>{{$item.Author}}{{if and $item.Author $item.Date}} - {{end}}{{$item.Date}}</p>
+{{- end}}
+{{- if $item.Description}}
+<p>{{$item.Description}}</p>
+{{- end}}
+{{end}}`))
+
+// digestEntry is a single item, as shown in a digest.
+type digestEntry struct {
+	Title       string
+	Link        string
+	Author      string
+	Date        string
+	Description string
+}
+
+// digest collects the new items of a feed with the "digest" option, so
+// they can be sent as a single email once they've all been found.
+type digest struct {
+	entries []digestEntry
+
+	// ids holds the GUIDs, or links, of the items, so that each
+	// digest has its own Message-ID.
+	ids []string
+}
+
+// add appends the given item, and its content, to the digest.
+func (d *digest) add(item withstate.FeedItem, content string) {
+
+	authors := itemAuthors(item.Item)
+	author := ""
+	if len(authors) > 0 {
+		author = authors[0]
+	}
+
+	d.entries = append(d.entries, digestEntry{
+		Title:       item.Title,
+		Link:        item.Link,
+		Author:      author,
+		Date:        item.Published,
+		Description: truncate(strings.Join(strings.Fields(plainText(content, "")), " "), digestDescriptionLength),
+	})
+
+	id := item.GUID
+	if id == "" {
+		id = item.Link
+	}
+	d.ids = append(d.ids, id)
+}
+
+// Len returns the number of items in the digest.
+func (d *digest) Len() int {
+	return len(d.entries)
+}
+
+// item returns a feed item containing the entire digest, which can be
+// sent like any other.
+func (d *digest) item(entry configfile.Feed, feed *gofeed.Feed, tag string) (withstate.FeedItem, error) {
+
+	buf := &bytes.Buffer{}
+	err := digestBody.Execute(buf, d.entries)
+	if err != nil {
+		return withstate.FeedItem{}, err
+	}
+
+	title := "1 new item"
+	if d.Len() != 1 {
+		title = fmt.Sprintf("%d new items", d.Len())
+	}
+
+	xp := &gofeed.Item{
+		Title:   title,
+		Link:    feed.Link,
+		GUID:    "digest:" + strings.Join(d.ids, " "),
+		Content: buf.String(),
+	}
+
+	return withstate.FeedItem{Item: xp, FeedURL: entry.URL, FeedName: feed.Title, Tag: tag}, nil
+}
+
+// deliverDigest sends the given digest as a single email, or writes it to
+// our output as a single item.
+func (p *Processor) deliverDigest(logger *slog.Logger, entry configfile.Feed, feed *gofeed.Feed, tag string, d *digest, recipients []string) error {
+
+	item, err := d.item(entry, feed, tag)
+	if err != nil {
+		return err
+	}
+
+	p.waitForDelivery(logger)
+
+	if p.output != nil {
+		return p.deliverOutput(entry.URL, feed, item)
+	}
+
+	content := item.RawContent()
+
+	helper := p.newEmailer(entry, feed, item, logger)
+	if p.subjectTemplate(entry) == nil {
+		helper.SetSubjectTemplate(digestSubject)
+	}
+
+	logger.Debug("sending digest",
+		slog.Int("items", d.Len()))
+
+	return helper.Sendmail(recipients, html2text.HTML2Text(content), content)
+}
+
+// truncate shortens the given text to no more than the given number of
+// characters, breaking between words where possible.
+func truncate(text string, length int) string {
+
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	cut := string(runes[:length])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,182 @@
+package emailer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/skx/rss2email/config"
+)
+
+// batchMessage is a single email which has been added to a Batch.
+type batchMessage struct {
+
+	// item identifies the call to Queue which added the message, so
+	// that we can report which items failed.
+	item int
+
+	// to is the recipient of the message.
+	to string
+
+	// msg is the rendered email.
+	msg []byte
+}
+
+// Batch collects the emails for a feed, so that they can all be sent
+// over a single SMTP connection, rather than connecting to the server for
+// each of them.
+//
+// SMTP needs a separate transaction, with its own MAIL FROM and RCPT TO,
+// for each message, but the handshake and authentication happen only once.
+type Batch struct {
+
+	// logger is used to report our progress.
+	logger *slog.Logger
+
+	// settings are those of the server we send to, which are taken
+	// from the first email queued.
+	settings config.SMTPConfig
+
+	// recipients holds each recipient, in the order they were first
+	// seen, so that their messages are sent together.
+	recipients []string
+
+	// messages holds the queued emails.
+	messages []batchMessage
+
+	// items is the number of calls to Queue.
+	items int
+}
+
+// NewBatch creates a new, empty, batch.
+func NewBatch(log *slog.Logger) *Batch {
+	return &Batch{logger: log}
+}
+
+// Len returns the number of items which have been queued.
+func (b *Batch) Len() int {
+	return b.items
+}
+
+// Queue renders the email for each of the given addresses, and adds them
+// to the batch to be sent later.
+//
+// If we're not sending via SMTP there is no connection to share, so the
+// emails are sent immediately, as by Sendmail.
+func (e *Emailer) Queue(b *Batch, addresses []string, textstr string, htmlstr string) error {
+
+	if e.custom != nil || !e.isSMTP() {
+		return e.Sendmail(addresses, textstr, htmlstr)
+	}
+
+	if len(addresses) < 1 {
+		e.logger.Error("missing recipient address")
+		return errors.New("empty recipient address, did you not setup a recipient?")
+	}
+
+	// Render everything first, so that a template failure doesn't
+	// leave the item half-queued.
+	var queued []batchMessage
+	for _, addr := range addresses {
+		msg, err := e.Render(addr, textstr, htmlstr)
+		if err != nil {
+			return err
+		}
+		queued = append(queued, batchMessage{item: b.items, to: addr, msg: msg})
+	}
+
+	if len(b.messages) == 0 {
+		b.settings = e.cfg.SMTP
+	}
+
+	for _, m := range queued {
+		known := false
+		for _, r := range b.recipients {
+			if r == m.to {
+				known = true
+				break
+			}
+		}
+		if !known {
+			b.recipients = append(b.recipients, m.to)
+		}
+	}
+
+	b.messages = append(b.messages, queued...)
+	b.items++
+
+	e.logger.Debug("email queued",
+		slog.Int("recipients", len(addresses)),
+		slog.Int("batch_size", len(b.messages)))
+
+	return nil
+}
+
+// Send delivers all the queued emails, grouped by recipient, over a
+// single SMTP connection, and empties the batch.
+//
+// It returns the number of items for which any email failed to be sent,
+// along with the errors which caused that.
+func (b *Batch) Send() (int, error) {
+
+	messages, recipients, items := b.messages, b.recipients, b.items
+	b.messages, b.recipients, b.items = nil, nil, 0
+
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	client, err := dial(b.settings)
+	if err != nil {
+		b.logger.Error("failed to connect to send batch",
+			slog.Int("items", items),
+			slog.String("error", err.Error()))
+		return items, err
+	}
+
+	failed := make(map[int]bool)
+	var errs []error
+
+	for _, to := range recipients {
+		for _, m := range messages {
+			if m.to != to {
+				continue
+			}
+
+			// Once the connection is broken the remaining
+			// messages can't be sent.
+			if client == nil {
+				failed[m.item] = true
+				continue
+			}
+
+			// As with sendSMTP the recipient is also the sender.
+			err := deliver(client, m.to, []string{m.to}, m.msg)
+			if err == nil {
+				continue
+			}
+
+			failed[m.item] = true
+			errs = append(errs, fmt.Errorf("failed to send to %s: %w", m.to, err))
+
+			// Reset the transaction, so that the connection can be
+			// used for the next message.
+			if client.Reset() != nil {
+				client.Close()
+				client = nil
+			}
+		}
+	}
+
+	if client != nil {
+		client.Quit()
+	}
+
+	b.logger.Debug("batch sent",
+		slog.Int("items", items),
+		slog.Int("emails", len(messages)),
+		slog.Int("recipients", len(recipients)),
+		slog.Int("failed_items", len(failed)))
+
+	return len(failed), errors.Join(errs...)
+}
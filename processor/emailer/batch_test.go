@@ -0,0 +1,70 @@
+package emailer
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+)
+
+// TestBatch tests that batched emails share one connection.
+func TestBatch(t *testing.T) {
+
+	f := newFakeSMTP(t)
+
+	batch := NewBatch(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, title := range []string{"One", "Two"} {
+		e := New(&gofeed.Feed{Title: "Example Feed"},
+			withstate.FeedItem{Item: &gofeed.Item{Title: title}},
+			nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+		e.SetSMTP(f.settings())
+		e.SetTemplate(template.Must(template.New("test").Parse("To: {{.To}}\r\nSubject: {{.Subject}}\r\n\r\nBody\r\n")))
+
+		err := e.Queue(batch, []string{"a@example.com", "b@example.com"}, "Body", "Body")
+		if err != nil {
+			t.Fatalf("failed to queue: %s", err)
+		}
+	}
+
+	if batch.Len() != 2 {
+		t.Fatalf("expected two items to be queued, got %d", batch.Len())
+	}
+
+	f.mutex.Lock()
+	if f.connections != 0 {
+		t.Fatalf("nothing should be sent until the batch is")
+	}
+	f.mutex.Unlock()
+
+	failed, err := batch.Send()
+	if err != nil || failed != 0 {
+		t.Fatalf("failed to send batch: %d %v", failed, err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.connections != 1 || len(f.messages) != 4 {
+		t.Fatalf("expected four messages over one connection, got %d over %d", len(f.messages), f.connections)
+	}
+
+	// The messages for each recipient are sent together.
+	expected := []string{"a@example.com One", "a@example.com Two", "b@example.com One", "b@example.com Two"}
+	for i, msg := range f.messages {
+		msg = strings.TrimPrefix(msg, strings.ReplaceAll(autoReplyHeaders, "\n", "\r\n"))
+		got := strings.TrimPrefix(strings.Split(msg, "\r\n")[0], "To: ") + " " +
+			strings.TrimPrefix(strings.Split(msg, "\r\n")[1], "Subject: ")
+		if got != expected[i] {
+			t.Fatalf("message %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+
+	// The batch is now empty.
+	if batch.Len() != 0 {
+		t.Fatalf("expected an empty batch after sending")
+	}
+}
@@ -0,0 +1,105 @@
+package emailer
+
+import (
+	"bytes"
+	"log/slog"
+	"text/template"
+
+	"github.com/k3a/html2text"
+)
+
+// BodyData holds the values a "body-template" is executed with.
+type BodyData struct {
+
+	// FeedTitle is the title of the feed the item came from.
+	FeedTitle string
+
+	// FeedURL is the URL the feed was fetched from.
+	FeedURL string
+
+	// ItemTitle is the title of the item.
+	ItemTitle string
+
+	// ItemLink is the link of the item.
+	ItemLink string
+
+	// ItemAuthor is the name of the item's first author.
+	ItemAuthor string
+
+	// ItemDate is the publication date of the item, as the feed gave
+	// it.
+	ItemDate string
+
+	// ItemBody is the HTML content of the item, which would otherwise
+	// be the body of the email.
+	ItemBody string
+
+	// ItemCategories are the categories of the item.
+	ItemCategories []string
+
+	// ItemGUID is the unique ID of the item.
+	ItemGUID string
+}
+
+// ParseBodyTemplate compiles the template of a "body-template" option.
+//
+// The template is executed with a BodyData for each item, and its output
+// is the HTML body of the email, from which the plain-text body is also
+// generated.  Values which an item doesn't have are empty.
+func ParseBodyTemplate(text string) (*template.Template, error) {
+	return template.New("body").Funcs(funcMap).Parse(text)
+}
+
+// SetBodyTemplate causes the body of the email to be rendered from the
+// given template, rather than being the content of the item.
+func (e *Emailer) SetBodyTemplate(tmpl *template.Template) {
+	e.body = tmpl
+}
+
+// bodyData returns the values the body template is executed with.
+func (e *Emailer) bodyData(htmlstr string) BodyData {
+
+	data := BodyData{
+		FeedURL:        e.item.FeedURL,
+		ItemTitle:      e.item.Title,
+		ItemLink:       e.item.Link,
+		ItemDate:       e.item.Published,
+		ItemBody:       htmlstr,
+		ItemCategories: e.item.Categories,
+		ItemGUID:       e.item.GUID,
+	}
+
+	if e.feed != nil {
+		data.FeedTitle = e.feed.Title
+	}
+
+	if len(e.item.Authors) > 0 && e.item.Authors[0] != nil {
+		data.ItemAuthor = e.item.Authors[0].Name
+	} else if e.item.Author != nil {
+		data.ItemAuthor = e.item.Author.Name
+	}
+
+	return data
+}
+
+// applyBody returns the text and HTML bodies of the email, which are
+// rendered from our body template, if we have one.
+//
+// If the template fails the bodies are returned unchanged, so the email
+// contains the item's content.
+func (e *Emailer) applyBody(textstr string, htmlstr string) (string, string) {
+
+	if e.body == nil {
+		return textstr, htmlstr
+	}
+
+	buf := &bytes.Buffer{}
+	err := e.body.Execute(buf, e.bodyData(htmlstr))
+	if err != nil {
+		e.logger.Warn("failed to render body-template, using the item's content",
+			slog.String("error", err.Error()))
+		return textstr, htmlstr
+	}
+
+	return html2text.HTML2Text(buf.String()), buf.String()
+}
@@ -13,6 +13,8 @@ package emailer
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html"
@@ -20,7 +22,6 @@ import (
 	"log/slog"
 	"mime/quotedprintable"
 	"net/mail"
-	"net/smtp"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -57,6 +58,29 @@ type Emailer struct {
 
 	// cfg holds the application configuration (SMTP settings, etc.)
 	cfg *config.Config
+
+	// template is a template to use in preference to the one stored
+	// in our state directory, if non-nil.
+	template *template.Template
+
+	// pool holds SMTP connections which may be reused, if non-nil.
+	pool *Pool
+
+	// vars holds the user's own values, which are available to the
+	// template as ".Vars".
+	vars map[string]any
+
+	// subject is used to render the subject of the email, replacing
+	// the one in the template, if non-nil.
+	subject *template.Template
+
+	// body is used to render the body of the email, replacing the
+	// content of the item, if non-nil.
+	body *template.Template
+
+	// custom delivers our emails, instead of SMTP or sendmail, if
+	// non-nil.
+	custom Sender
 }
 
 // New creates a new Emailer object.
@@ -107,7 +131,49 @@ func split(in string, delim string) []string {
 	return strings.Split(in, delim)
 }
 
+// SetPool causes emails sent via SMTP to use connections from the given
+// pool, rather than connecting to the server for each one.
+func (e *Emailer) SetPool(pool *Pool) {
+	e.pool = pool
+}
+
+// SetVariables sets the values available to the template as ".Vars",
+// which come from the template-variable-file.
+//
+// They are kept apart from the values we populate ourselves, so that
+// they can never replace them.
+func (e *Emailer) SetVariables(vars map[string]any) {
+	e.vars = vars
+}
+
+// SetSMTP replaces the SMTP settings from the application configuration
+// with the given ones.
+func (e *Emailer) SetSMTP(smtp config.SMTPConfig) {
+	e.cfg.SMTP = smtp
+}
+
+// funcMap allows exporting functions to the template
+var funcMap = template.FuncMap{
+	"env":              env,
+	"quoteprintable":   toQuotedPrintable,
+	"split":            split,
+	"encodeHeader":     encodeHeader,
+	"makeListIdHeader": makeListIdHeader,
+}
+
+// SetTemplate sets the template to use for this email.
+//
+// A per-feed template, via the "template" option, still takes precedence
+// over this.
+func (e *Emailer) SetTemplate(tmpl *template.Template) {
+	e.template = tmpl
+}
+
 // loadTemplate loads the template used for sending the email notification.
+//
+// In order of preference we use the per-feed template, the one set via
+// SetTemplate, the override in our state directory, and finally our
+// embedded default.
 func (e *Emailer) loadTemplate() (*template.Template, error) {
 
 	// Load the default template from the embedded resource.
@@ -119,13 +185,25 @@ func (e *Emailer) loadTemplate() (*template.Template, error) {
 	// The path to the overridden template
 	override := filepath.Join(stateDir, "email.tmpl")
 
-	// If a per feed template was set, get it here.
+	// If a per feed template was set, and exists, get it here.
+	perFeed := false
 	for _, opt := range e.opts {
 		if opt.Name == "template" {
-			override = filepath.Join(stateDir, opt.Value)
+			path := filepath.Join(stateDir, opt.Value)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				e.logger.Debug("per-feed template not found, ignoring it",
+					slog.String("file", path))
+				continue
+			}
+			override = path
+			perFeed = true
 		}
 	}
 
+	if e.template != nil && !perFeed {
+		return e.template, nil
+	}
+
 	// If the file exists, use it.
 	_, err := os.Stat(override)
 	if !os.IsNotExist(err) {
@@ -140,18 +218,57 @@ func (e *Emailer) loadTemplate() (*template.Template, error) {
 		}
 	}
 
-	//
-	// Function map allows exporting functions to the template
-	//
-	funcMap := template.FuncMap{
-		"env":              env,
-		"quoteprintable":   toQuotedPrintable,
-		"split":            split,
-		"encodeHeader":     encodeHeader,
-		"makeListIdHeader": makeListIdHeader,
+	tmpl, err := template.New("email.tmpl").Funcs(funcMap).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %s", override, err.Error())
 	}
 
-	tmpl := template.Must(template.New("email.tmpl").Funcs(funcMap).Parse(string(content)))
+	return tmpl, nil
+}
+
+// ParseTemplate parses the given email template, and ensures that it
+// renders without error for a sample feed item.
+//
+// A template which produces no output is rejected.
+func ParseTemplate(content string) (*template.Template, error) {
+
+	tmpl, err := template.New("email.tmpl").Funcs(funcMap).Parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Populate the template with sample data.
+	sample := &Emailer{
+		feed: &gofeed.Feed{
+			Title: "Example Feed",
+			Link:  "https://example.com/",
+		},
+		item: withstate.FeedItem{
+			Item: &gofeed.Item{
+				Title:   "Example Item",
+				Link:    "https://example.com/item",
+				GUID:    "https://example.com/item",
+				Content: "<p>Example content</p>",
+			},
+			Tag: "example",
+		},
+		defaultFrom: "sender@example.com",
+	}
+
+	x, err := sample.templateData("recipient@example.com", "Example content", "<p>Example content</p>")
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, x)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %s", err)
+	}
+
+	if strings.TrimSpace(buf.String()) == "" {
+		return nil, errors.New("template produced no output")
+	}
 
 	return tmpl, nil
 }
@@ -211,10 +328,6 @@ func makeListIdHeader(s string) string {
 	return sh + ".localhost"
 }
 
-// Sendmail is a simple function that emails the given address.
-//
-// We send a MIME message with both a plain-text and a HTML-version of the
-// message.  This should be nicer for users.
 // sendRetryConfig controls retry behavior for transient send failures.
 const (
 	maxSendRetries    = 3
@@ -303,6 +416,167 @@ func (e *Emailer) sendWithRetry(desc string, sendFn func() error) error {
 	return fmt.Errorf("send failed after %d attempts: %w", maxSendRetries+1, err)
 }
 
+// templateParms is the structure we use to populate our email template.
+type templateParms struct {
+	Feed      string
+	FeedTitle string
+	From      string
+	FromAddr  string
+	HTML      string
+	Link      string
+	MessageID string
+	Subject   string
+	Tag       string
+	Text      string
+	To        string
+
+	// Values from the template-variable-file, if any
+	Vars map[string]any
+
+	// In case people need access to fields
+	// we've not wrapped/exported explicitly
+	RSSFeed *gofeed.Feed
+	RSSItem withstate.FeedItem
+}
+
+// templateData returns the values used to render the email for the
+// given recipient.
+func (e *Emailer) templateData(addr string, textstr string, htmlstr string) (templateParms, error) {
+
+	var err error
+
+	//
+	// Populate it appropriately.
+	//
+	var x templateParms
+	x.Feed = e.feed.Link
+	x.FeedTitle = e.feed.Title
+
+	// Check if a custom from address was configured
+	from := addr // Default to recipient address
+	if e.defaultFrom != "" {
+		from = e.defaultFrom // Use default from if set
+	}
+	for _, opt := range e.opts {
+		if opt.Name == "from" {
+			// Per-feed from overrides default, if it is valid.
+			parsed, err := mail.ParseAddress(opt.Value)
+			if err != nil {
+				e.logger.Warn("ignoring invalid 'from' address",
+					slog.String("from", opt.Value),
+					slog.String("fallback", from),
+					slog.String("error", err.Error()))
+				break
+			}
+			from = parsed.Address
+			break
+		}
+	}
+	x.FromAddr = from
+	x.From = fmt.Sprintf("\"%s\" <%s>", e.feed.Title, from)
+
+	x.Link = e.item.Link
+	x.MessageID = e.item.MessageID()
+	x.Subject = e.item.Title
+	x.To = addr
+	x.RSSFeed = e.feed
+	x.RSSItem = e.item
+	x.Tag = e.item.Tag
+	x.Vars = e.vars
+
+	// The real meat of the mail is the text & HTML
+	// parts.  They need to be encoded, unconditionally.
+	x.Text, err = toQuotedPrintable(textstr)
+	if err != nil {
+		return x, err
+	}
+	x.HTML, err = toQuotedPrintable(html.UnescapeString(htmlstr))
+	if err != nil {
+		return x, err
+	}
+
+	return x, nil
+}
+
+// Render returns the email which would be sent to the given address,
+// by populating our template, without sending it.
+func (e *Emailer) Render(addr string, textstr string, htmlstr string) ([]byte, error) {
+
+	//
+	// Populate the data for our template, after rendering the body
+	// from its own template, if there is one.
+	//
+	textstr, htmlstr = e.applyBody(textstr, htmlstr)
+	multipart := e.isMultipart()
+	if multipart {
+		textstr = plainText(htmlstr)
+	}
+	x, err := e.templateData(addr, textstr, htmlstr)
+	if err != nil {
+		return nil, err
+	}
+
+	//
+	// Render the template into the buffer, after any priority,
+	// auto-reply, and unsubscribe headers, which apply whatever
+	// template is in use.
+	//
+	buf := &bytes.Buffer{}
+	buf.WriteString(e.priorityHeaders())
+	if e.cfg.SuppressAutoReplies() {
+		buf.WriteString(autoReplyHeaders)
+	}
+	buf.WriteString(e.unsubscribeHeader(x.FromAddr))
+
+	//
+	// Multipart emails are built without a template, otherwise we
+	// load the template we're going to render.
+	//
+	if multipart {
+		renderMultipart(buf, x)
+		return e.applySubject(buf.Bytes()), nil
+	}
+
+	t, err := e.loadTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.Execute(buf, x)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.applySubject(buf.Bytes()), nil
+}
+
+// autoReplyHeaders are added to our emails, unless "auto-reply-suppress" is
+// false, so that they don't trigger vacation messages and other
+// auto-responders, in the same way as mailing list software.
+const autoReplyHeaders = "Precedence: bulk\nX-Auto-Response-Suppress: OOF, AutoReply\nAuto-Submitted: auto-generated\n"
+
+// unsubscribeHeader returns the List-Unsubscribe header to add to our
+// emails, if the "list-unsubscribe" setting is enabled.
+//
+// The header is a mailto: URI of the given sender address, whose subject
+// identifies the feed by a hash of its URL, so that a reply can be matched
+// to the feed which should be removed.
+func (e *Emailer) unsubscribeHeader(from string) string {
+
+	if !e.cfg.ListUnsubscribe || from == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(e.item.FeedURL))
+	subject := "unsubscribe:" + hex.EncodeToString(sum[:16])
+
+	return "List-Unsubscribe: <mailto:" + from + "?subject=" + subject + ">\n"
+}
+
+// Sendmail is a simple function that emails the given address.
+//
+// We send a MIME message with both a plain-text and a HTML-version of the
+// message.  This should be nicer for users.
 func (e *Emailer) Sendmail(addresses []string, textstr string, htmlstr string) error {
 
 	var err error
@@ -324,138 +598,39 @@ func (e *Emailer) Sendmail(addresses []string, textstr string, htmlstr string) e
 	for _, addr := range addresses {
 
 		//
-		// Here is a temporary structure we'll use to popular our email
-		// template.
-		//
-		type TemplateParms struct {
-			Feed      string
-			FeedTitle string
-			From      string
-			FromAddr  string
-			HTML      string
-			Link      string
-			Subject   string
-			Tag       string
-			Text      string
-			To        string
-
-			// In case people need access to fields
-			// we've not wrapped/exported explicitly
-			RSSFeed *gofeed.Feed
-			RSSItem withstate.FeedItem
-		}
-
-		//
-		// Populate it appropriately.
-		//
-		var x TemplateParms
-		x.Feed = e.feed.Link
-		x.FeedTitle = e.feed.Title
-
-		// Check if a custom from address was configured
-		from := addr // Default to recipient address
-		if e.defaultFrom != "" {
-			from = e.defaultFrom // Use default from if set
-		}
-		for _, opt := range e.opts {
-			if opt.Name == "from" {
-				from = opt.Value // Per-feed from overrides default
-				break
-			}
-		}
-		x.FromAddr = from
-		x.From = fmt.Sprintf("\"%s\" <%s>", e.feed.Title, from)
-
-		x.Link = e.item.Link
-		x.Subject = e.item.Title
-		x.To = addr
-		x.RSSFeed = e.feed
-		x.RSSItem = e.item
-		x.Tag = e.item.Tag
-
-		// The real meat of the mail is the text & HTML
-		// parts.  They need to be encoded, unconditionally.
-		x.Text, err = toQuotedPrintable(textstr)
-		if err != nil {
-			return err
-		}
-		x.HTML, err = toQuotedPrintable(html.UnescapeString(htmlstr))
-		if err != nil {
-			return err
-		}
-
-		//
-		// Load the template we're going to render.
+		// Render the email for this recipient.
 		//
-		var t *template.Template
-		t, err = e.loadTemplate()
+		var msg []byte
+		msg, err = e.Render(addr, textstr, htmlstr)
 		if err != nil {
 			return err
 		}
 
 		//
-		// Render the template into the buffer.
+		// Deliver it.
 		//
-		buf := &bytes.Buffer{}
-		err = t.Execute(buf, x)
-		if err != nil {
-			return err
-		}
-
-		//
-		// Are we sending via SMTP?
-		//
-		if e.isSMTP() {
-
-			e.logger.Debug("preparing to send email",
-				slog.String("to", addr),
-				slog.String("method", "smtp"))
-
-			err := e.sendWithRetry(fmt.Sprintf("smtp→%s", addr), func() error {
-				return e.sendSMTP(addr, buf.Bytes())
-			})
-			if err != nil {
-
-				e.logger.Error("error sending email",
-					slog.String("to", addr),
-					slog.String("method", "smtp"),
-					slog.String("error", err.Error()))
-
-				return err
-			}
-
-			e.logger.Debug("email sent",
-				slog.String("to", addr),
-				slog.String("method", "smtp"))
-
-		} else {
-
-			from := extractFromHeader(buf.Bytes())
-			if from == "" {
-				from = fmt.Sprintf("\"%s\" <%s>", e.feed.Title, addr) // fallback if extraction fails
-			}
+		method, sender := e.sender()
 
-			e.logger.Debug("preparing to send email",
-				slog.String("from", from),
-				slog.String("to", addr),
-				slog.String("method", "sendmail"))
+		e.logger.Debug("preparing to send email",
+			slog.String("to", addr),
+			slog.String("method", method))
 
-			err := e.sendWithRetry(fmt.Sprintf("sendmail→%s", addr), func() error {
-				return e.sendSendmail(addr, from, buf.Bytes())
-			})
-			if err != nil {
-				e.logger.Error("error sending email",
-					slog.String("to", addr),
-					slog.String("method", "sendmail"),
-					slog.String("error", err.Error()))
-				return err
-			}
+		err = e.sendWithRetry(fmt.Sprintf("%s→%s", method, addr), func() error {
+			return sender.Send(addr, msg)
+		})
+		if err != nil {
 
-			e.logger.Debug("email sent",
+			e.logger.Error("error sending email",
 				slog.String("to", addr),
-				slog.String("method", "sendmail"))
+				slog.String("method", method),
+				slog.String("error", err.Error()))
 
+			return err
 		}
+
+		e.logger.Debug("email sent",
+			slog.String("to", addr),
+			slog.String("method", method))
 	}
 
 	e.logger.Debug("emails sent",
@@ -516,21 +691,12 @@ func (e *Emailer) isSMTP() bool {
 // via SMTP.
 func (e *Emailer) sendSMTP(to string, content []byte) error {
 
-	host := e.cfg.SMTP.Host
-	p := e.cfg.SMTP.Port
-	user := e.cfg.SMTP.Username
-	pass := e.cfg.SMTP.Password
-
-	// Authenticate
-	auth := smtp.PlainAuth("", user, pass, host)
-
-	// Get the mailserver
-	addr := fmt.Sprintf("%s:%d", host, p)
-
-	// Send the mail
-	err := smtp.SendMail(addr, auth, to, []string{to}, content)
+	// Use a pooled connection, if we can.
+	if e.pool != nil {
+		return e.pool.Send(e.cfg.SMTP, to, []string{to}, content)
+	}
 
-	return err
+	return SendSMTP(e.cfg.SMTP, to, []string{to}, content)
 }
 
 // sendSendmail sends the content of the email to the destination address
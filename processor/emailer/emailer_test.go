@@ -1,9 +1,19 @@
 package emailer
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"io"
+	"log/slog"
 	"strings"
 	"testing"
+	"text/template"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/configfile"
+	emailtemplate "github.com/skx/rss2email/template"
+	"github.com/skx/rss2email/withstate"
 )
 
 func TestMakeListIdHeader(t *testing.T) {
@@ -81,3 +91,343 @@ func TestIsTransientError(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTemplate(t *testing.T) {
+
+	// The default template must be valid.
+	_, err := ParseTemplate(string(emailtemplate.EmailTemplate()))
+	if err != nil {
+		t.Fatalf("default template is invalid: %s", err)
+	}
+
+	tests := []struct {
+		content string
+		valid   bool
+	}{
+		{"Subject: {{.Subject}}\n\n{{.Text}}", true},
+		{"To: {{.To}} {{.RSSItem.GUID}}", true},
+		{"Subject: {{.Subject", false},
+		{"Subject: {{.Missing}}", false},
+		{"{{/* nothing */}}\n  \n", false},
+		{"", false},
+	}
+
+	for _, tst := range tests {
+		_, err := ParseTemplate(tst.content)
+		if tst.valid && err != nil {
+			t.Fatalf("unexpected error for %q: %s", tst.content, err)
+		}
+		if !tst.valid && err == nil {
+			t.Fatalf("expected error for %q", tst.content)
+		}
+	}
+}
+
+// TestMissingFeedTemplate tests that a per-feed template which doesn't
+// exist falls back to the template set via SetTemplate.
+func TestMissingFeedTemplate(t *testing.T) {
+
+	t.Setenv("HOME", t.TempDir())
+
+	e := New(&gofeed.Feed{Title: "Example Feed", Link: "https://example.com/"},
+		withstate.FeedItem{Item: &gofeed.Item{Title: "Example", Link: "https://example.com/1"}},
+		[]configfile.Option{{Name: "template", Value: "missing.tmpl"}},
+		slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+
+	tmpl := template.Must(template.New("test").Parse("Subject: Configured\n\nBody\n"))
+	e.SetTemplate(tmpl)
+
+	got, err := e.loadTemplate()
+	if err != nil {
+		t.Fatalf("failed to load template: %s", err)
+	}
+	if got != tmpl {
+		t.Fatalf("expected the configured template")
+	}
+}
+
+func TestHTMLPart(t *testing.T) {
+
+	tmpl, err := ParseTemplate(string(emailtemplate.EmailTemplate()))
+	if err != nil {
+		t.Fatalf("default template is invalid: %s", err)
+	}
+
+	e := New(&gofeed.Feed{Title: "Example Feed", Link: "https://example.com/"},
+		withstate.FeedItem{Item: &gofeed.Item{Title: "Café", Link: "https://example.com/item"}},
+		nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	e.SetTemplate(tmpl)
+
+	msg, err := e.Render("user@example.com", "Some text", `<p class="x">Some <b>HTML</b>, `+strings.Repeat("long ", 30)+`</p>`)
+	if err != nil {
+		t.Fatalf("failed to render: %s", err)
+	}
+
+	html, err := HTMLPart(msg)
+	if err != nil {
+		t.Fatalf("failed to find HTML: %s", err)
+	}
+
+	// The quoted-printable encoding has been removed.
+	for _, want := range []string{`<a href="https://example.com/item">Café</a>`, `<p class="x">Some <b>HTML</b>, long`} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("HTML didn't contain %q: %s", want, html)
+		}
+	}
+	if strings.Contains(html, "Some text") {
+		t.Fatalf("found the text part: %s", html)
+	}
+
+	// A message with no HTML is an error.
+	_, err = HTMLPart([]byte("Subject: test\nContent-Type: text/plain\n\nHello\n"))
+	if err == nil {
+		t.Fatalf("expected error with no HTML part")
+	}
+}
+
+func TestPriority(t *testing.T) {
+
+	tests := map[string]string{
+		"high":   "X-Priority: 1 (Highest)\nImportance: high\n",
+		"Low":    "X-Priority: 5 (Lowest)\nImportance: low\n",
+		"normal": "",
+		"bogus":  "",
+		"":       "",
+	}
+
+	for value, expected := range tests {
+		var opts []configfile.Option
+		if value != "" {
+			opts = []configfile.Option{{Name: "email-priority", Value: value}}
+		}
+
+		e := New(&gofeed.Feed{Title: "Example Feed"},
+			withstate.FeedItem{Item: &gofeed.Item{Title: "Example"}},
+			opts, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+		e.SetTemplate(template.Must(template.New("test").Parse("Subject: {{.Subject}}\n\nBody\n")))
+
+		msg, err := e.Render("user@example.com", "Body", "Body")
+		if err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+
+		if string(msg) != expected+autoReplyHeaders+"Subject: Example\n\nBody\n" {
+			t.Fatalf("%s: unexpected message %q", value, msg)
+		}
+	}
+}
+
+func TestFrom(t *testing.T) {
+
+	tests := []struct {
+		opts     []configfile.Option
+		expected string
+	}{
+		{nil, "default@example.com"},
+		{[]configfile.Option{{Name: "from", Value: "project@example.com"}}, "project@example.com"},
+		{[]configfile.Option{{Name: "from", Value: "Project <project@example.com>"}}, "project@example.com"},
+		{[]configfile.Option{{Name: "from", Value: "not an address"}}, "default@example.com"},
+	}
+
+	for _, tst := range tests {
+		e := New(&gofeed.Feed{Title: "Example Feed"},
+			withstate.FeedItem{Item: &gofeed.Item{Title: "Example"}},
+			tst.opts, slog.New(slog.NewTextHandler(io.Discard, nil)), "default@example.com")
+		e.SetTemplate(template.Must(template.New("test").Parse("From: {{.From}}\n\nBody\n")))
+
+		msg, err := e.Render("user@example.com", "Body", "Body")
+		if err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+
+		if string(msg) != autoReplyHeaders+`From: "Example Feed" <`+tst.expected+">\n\nBody\n" {
+			t.Fatalf("%v: unexpected message %q", tst.opts, msg)
+		}
+	}
+}
+
+func TestListUnsubscribe(t *testing.T) {
+
+	for _, enabled := range []bool{true, false} {
+		e := New(&gofeed.Feed{Title: "Example Feed"},
+			withstate.FeedItem{Item: &gofeed.Item{Title: "Example"}, FeedURL: "https://example.com/feed.xml"},
+			nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "sender@example.com")
+		e.cfg.ListUnsubscribe = enabled
+		e.SetTemplate(template.Must(template.New("test").Parse("Subject: {{.Subject}}\n\nBody\n")))
+
+		msg, err := e.Render("user@example.com", "Body", "Body")
+		if err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+
+		expected := "Subject: Example\n\nBody\n"
+		if enabled {
+			sum := sha256.Sum256([]byte("https://example.com/feed.xml"))
+			expected = "List-Unsubscribe: <mailto:sender@example.com?subject=unsubscribe:" +
+				hex.EncodeToString(sum[:16]) + ">\n" + expected
+		}
+		expected = autoReplyHeaders + expected
+		if string(msg) != expected {
+			t.Fatalf("list-unsubscribe %t: unexpected message %q", enabled, msg)
+		}
+	}
+}
+
+func TestAutoReplySuppress(t *testing.T) {
+
+	enabled, disabled := true, false
+	for _, setting := range []*bool{nil, &enabled, &disabled} {
+		e := New(&gofeed.Feed{Title: "Example Feed"},
+			withstate.FeedItem{Item: &gofeed.Item{Title: "Example"}},
+			nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+		e.cfg.AutoReplySuppress = setting
+		e.SetTemplate(template.Must(template.New("test").Parse("Subject: {{.Subject}}\n\nBody\n")))
+
+		msg, err := e.Render("user@example.com", "Body", "Body")
+		if err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+
+		// The headers are added unless they're disabled.
+		expected := "Precedence: bulk\nX-Auto-Response-Suppress: OOF, AutoReply\nAuto-Submitted: auto-generated\nSubject: Example\n\nBody\n"
+		if setting == &disabled {
+			expected = "Subject: Example\n\nBody\n"
+		}
+		if string(msg) != expected {
+			t.Fatalf("auto-reply-suppress %v: unexpected message %q", setting, msg)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+
+	e := New(&gofeed.Feed{Title: "Example Feed"},
+		withstate.FeedItem{Item: &gofeed.Item{Title: "Example"}},
+		nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	e.SetTemplate(template.Must(template.New("test").Parse("Subject: {{.Subject}}\n\n{{.Vars.Subject}} {{.Vars.signature}}\n")))
+
+	// A variable with the same name as one of ours doesn't replace it.
+	e.SetVariables(map[string]any{"signature": "Steve", "Subject": "Other"})
+
+	msg, err := e.Render("user@example.com", "Body", "Body")
+	if err != nil {
+		t.Fatalf("failed to render: %s", err)
+	}
+
+	if string(msg) != autoReplyHeaders+"Subject: Example\n\nOther Steve\n" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestSubjectTemplate(t *testing.T) {
+
+	tests := []struct {
+		subject  string
+		expected string
+	}{
+		{"[{{.FeedTitle}}] {{.ItemTitle}}", "[Example Feed] Example"},
+		{"{{.ItemTitle}} by {{.ItemAuthor}} ({{.ItemDate}})", "Example by Steve (Mon, 01 Jan 2024 00:00:00 UTC)"},
+		{"{{.ItemLink}}", "https://example.com/1"},
+
+		// Missing fields are empty, and newlines can't break the header.
+		{"{{.ItemTitle}}{{.Missing}}\n!", "Example !"},
+
+		// Failures keep the subject from the template.
+		{`{{template "missing"}}`, "Original"},
+	}
+
+	for _, tst := range tests {
+		e := New(&gofeed.Feed{Title: "Example Feed"},
+			withstate.FeedItem{Item: &gofeed.Item{Title: "Example", Link: "https://example.com/1",
+				Published: "Mon, 01 Jan 2024 00:00:00 UTC", Author: &gofeed.Person{Name: "Steve"}}},
+			nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+		e.SetTemplate(template.Must(template.New("test").Parse("To: {{.To}}\r\nSubject: Original\r\n continued\r\n\r\nSubject: Body\r\n")))
+
+		tmpl, err := ParseSubjectTemplate(tst.subject)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", tst.subject, err)
+		}
+		e.SetSubjectTemplate(tmpl)
+
+		msg, err := e.Render("user@example.com", "Body", "Body")
+		if err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+
+		expected := "To: user@example.com\r\nSubject: " + tst.expected + "\r\n\r\nSubject: Body\r\n"
+		if tst.expected == "Original" {
+			expected = "To: user@example.com\r\nSubject: Original\r\n continued\r\n\r\nSubject: Body\r\n"
+		}
+		if string(msg) != autoReplyHeaders+expected {
+			t.Fatalf("%s: unexpected message %q", tst.subject, msg)
+		}
+	}
+
+	// Invalid templates can't be parsed.
+	_, err := ParseSubjectTemplate("{{.ItemTitle")
+	if err == nil {
+		t.Fatalf("expected an error parsing a broken template")
+	}
+}
+
+func TestBodyTemplate(t *testing.T) {
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{"<p>{{.FeedTitle}}: {{.ItemTitle}}</p>{{.ItemBody}}", "<p>Example Feed: Example</p><b>Body</b>"},
+		{"{{.ItemAuthor}} {{.ItemDate}} {{.ItemGUID}}", "Steve Mon, 01 Jan 2024 00:00:00 UTC guid-1"},
+		{"{{.ItemLink}} {{.FeedURL}}", "https://example.com/1 https://example.com/feed"},
+		{"{{range .ItemCategories}}[{{.}}]{{end}}", "[one][two]"},
+
+		// Failures keep the content of the item.
+		{"{{.Missing}}", "<b>Body</b>"},
+		{`{{template "missing"}}`, "<b>Body</b>"},
+	}
+
+	for _, tst := range tests {
+		e := New(&gofeed.Feed{Title: "Example Feed"},
+			withstate.FeedItem{Item: &gofeed.Item{Title: "Example", Link: "https://example.com/1",
+				Published: "Mon, 01 Jan 2024 00:00:00 UTC", Author: &gofeed.Person{Name: "Steve"},
+				GUID: "guid-1", Categories: []string{"one", "two"}},
+				FeedURL: "https://example.com/feed"},
+			nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+		e.SetTemplate(template.Must(template.New("test").Parse("{{.HTML}}\n")))
+
+		tmpl, err := ParseBodyTemplate(tst.body)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", tst.body, err)
+		}
+		e.SetBodyTemplate(tmpl)
+
+		msg, err := e.Render("user@example.com", "Body", "<b>Body</b>")
+		if err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+		if string(msg) != autoReplyHeaders+tst.expected+"\n" {
+			t.Fatalf("%s: unexpected message %q", tst.body, msg)
+		}
+	}
+
+	// Items without authors, or categories, render them as empty.
+	e := New(&gofeed.Feed{Title: "Example Feed"},
+		withstate.FeedItem{Item: &gofeed.Item{Title: "Example"}},
+		nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	e.SetTemplate(template.Must(template.New("test").Parse("{{.Text}}|{{.HTML}}\n")))
+	e.SetBodyTemplate(template.Must(ParseBodyTemplate("<p>[{{.ItemAuthor}}]{{range .ItemCategories}}x{{end}}[{{.ItemLink}}]</p>")))
+
+	msg, err := e.Render("user@example.com", "Body", "<b>Body</b>")
+	if err != nil {
+		t.Fatalf("failed to render: %s", err)
+	}
+	if string(msg) != autoReplyHeaders+"[][]|<p>[][]</p>\n" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+
+	// Invalid templates can't be parsed.
+	_, err = ParseBodyTemplate("{{.ItemTitle")
+	if err == nil {
+		t.Fatalf("expected an error parsing a broken template")
+	}
+}
@@ -0,0 +1,74 @@
+package emailer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// HTMLPart returns the decoded text/html body of the given email, as
+// rendered by our template, searching within any multipart sections.
+func HTMLPart(msg []byte) (string, error) {
+
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return "", err
+	}
+
+	body, found, err := findHTML(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", errors.New("email has no text/html part")
+	}
+	return body, nil
+}
+
+// findHTML returns the body of the first text/html part within the given
+// content, which has the specified type and transfer-encoding.
+func findHTML(contentType string, encoding string, body io.Reader) (string, bool, error) {
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false, nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", false, nil
+			}
+			if err != nil {
+				return "", false, err
+			}
+
+			// Quoted-printable parts are decoded for us, and
+			// their encoding header removed.
+			html, found, err := findHTML(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil || found {
+				return html, found, err
+			}
+		}
+	}
+
+	if mediaType != "text/html" {
+		return "", false, nil
+	}
+
+	if strings.EqualFold(encoding, "quoted-printable") {
+		body = quotedprintable.NewReader(body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", false, err
+	}
+	return string(content), true, nil
+}
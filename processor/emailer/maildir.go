@@ -0,0 +1,108 @@
+package emailer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maildirDeliveries counts the emails delivered by this process, so that
+// the names of the files we create are unique even when several are
+// written within the same microsecond.
+var maildirDeliveries atomic.Uint64
+
+// Maildir is a Sender which delivers emails by writing them into a local
+// Maildir, for a mail client to read, rather than sending them.
+//
+// Each email is written to the "tmp/" directory and then moved into
+// "new/", so that it is never seen half-written.  The files are named by
+// the usual "time.pid.host" convention, with the middle part extended as
+// "M<microseconds>P<pid>Q<count>" to make the names unique.
+type Maildir struct {
+
+	// path is the top-level directory of the Maildir.
+	path string
+
+	// host is our hostname, with the characters which aren't allowed
+	// in the names of the files escaped.
+	host string
+
+	// now returns the current time, it is replaced in our tests.
+	now func() time.Time
+}
+
+// NewMaildir returns a Sender which delivers to the Maildir at the given
+// path, creating it, along with its "new/", "cur/", and "tmp/"
+// directories, if necessary.
+func NewMaildir(path string) (*Maildir, error) {
+
+	if path == "" {
+		return nil, errors.New("empty maildir path")
+	}
+
+	for _, dir := range []string{"new", "cur", "tmp"} {
+		err := os.MkdirAll(filepath.Join(path, dir), 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+
+	// These would break the name, or its "info" suffix.
+	host = strings.ReplaceAll(host, "/", `\057`)
+	host = strings.ReplaceAll(host, ":", `\072`)
+
+	return &Maildir{path: path, host: host, now: time.Now}, nil
+}
+
+// Send is part of the Sender interface, it writes the given email into
+// the "new/" directory of the Maildir.
+//
+// The recipient isn't used, as every email is delivered to the same
+// Maildir, but it is present in the email's headers.
+func (m *Maildir) Send(to string, msg []byte) error {
+
+	name := m.filename()
+	tmp := filepath.Join(m.path, "tmp", name)
+
+	// Refuse to replace an existing file, in case our name wasn't
+	// unique after all.
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(msg)
+	if err == nil {
+		err = file.Sync()
+	}
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(m.path, "new", name))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to deliver to maildir %s: %w", m.path, err)
+	}
+
+	return nil
+}
+
+// filename returns a unique name for a new email.
+func (m *Maildir) filename() string {
+
+	now := m.now()
+	count := maildirDeliveries.Add(1)
+
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), count, m.host)
+}
@@ -0,0 +1,117 @@
+package emailer
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+)
+
+func TestMaildir(t *testing.T) {
+
+	// The Maildir, and its parent, are created.
+	path := filepath.Join(t.TempDir(), "mail", "feeds")
+	m, err := NewMaildir(path)
+	if err != nil {
+		t.Fatalf("failed to create maildir: %s", err)
+	}
+	for _, dir := range []string{"new", "cur", "tmp"} {
+		info, err := os.Stat(filepath.Join(path, dir))
+		if err != nil || !info.IsDir() {
+			t.Fatalf("expected the %s directory to exist: %v", dir, err)
+		}
+	}
+
+	// Creating it again is fine.
+	_, err = NewMaildir(path)
+	if err != nil {
+		t.Fatalf("failed to open existing maildir: %s", err)
+	}
+
+	_, err = NewMaildir("")
+	if err == nil {
+		t.Fatalf("expected an error with an empty path")
+	}
+
+	// Emails written within the same instant get unique names.
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 5000, time.UTC)
+	m.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		err = m.Send("user@example.com", []byte(fmt.Sprintf("Subject: %d\n\nBody\n", i)))
+		if err != nil {
+			t.Fatalf("failed to deliver: %s", err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(path, "new"))
+	if err != nil || len(files) != 3 {
+		t.Fatalf("expected 3 emails, got %d: %v", len(files), err)
+	}
+	name := regexp.MustCompile(fmt.Sprintf(`^1710072000\.M5P%dQ\d+\.%s$`, os.Getpid(), regexp.QuoteMeta(m.host)))
+	for _, file := range files {
+		if !name.MatchString(file.Name()) {
+			t.Fatalf("unexpected name %s", file.Name())
+		}
+	}
+
+	// Nothing is left behind in tmp.
+	files, _ = os.ReadDir(filepath.Join(path, "tmp"))
+	if len(files) != 0 {
+		t.Fatalf("unexpected files in tmp: %v", files)
+	}
+}
+
+func TestMaildirSender(t *testing.T) {
+
+	m, err := NewMaildir(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create maildir: %s", err)
+	}
+
+	e := New(&gofeed.Feed{Title: "Example Feed"},
+		withstate.FeedItem{Item: &gofeed.Item{Title: "Example"}},
+		nil, slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	e.SetTemplate(template.Must(template.New("test").Parse("To: {{.To}}\nSubject: {{.Subject}}\n\nBody\n")))
+	e.SetSender(m)
+
+	// We get a file for each recipient, and the email is what we'd
+	// have sent.
+	err = e.Sendmail([]string{"one@example.com", "two@example.com"}, "Body", "Body")
+	if err != nil {
+		t.Fatalf("failed to deliver: %s", err)
+	}
+
+	files, err := os.ReadDir(filepath.Join(m.path, "new"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected 2 emails, got %d: %v", len(files), err)
+	}
+
+	found := map[string]bool{}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(m.path, "new", file.Name()))
+		if err != nil {
+			t.Fatalf("failed to read email: %s", err)
+		}
+		found[string(data)] = true
+	}
+	for _, to := range []string{"one@example.com", "two@example.com"} {
+		if !found[autoReplyHeaders+"To: "+to+"\nSubject: Example\n\nBody\n"] {
+			t.Fatalf("missing email to %s: %v", to, found)
+		}
+	}
+
+	// Queuing delivers immediately, as there's no connection to share.
+	b := NewBatch(e.logger)
+	err = e.Queue(b, []string{"three@example.com"}, "Body", "Body")
+	if err != nil || b.Len() != 0 {
+		t.Fatalf("expected immediate delivery, got %d queued: %v", b.Len(), err)
+	}
+}
@@ -0,0 +1,99 @@
+package emailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// mboxFromLine matches the lines of an email which would be mistaken for
+// the separator between emails, along with those which have already been
+// quoted, so that quoting can be reversed unambiguously.
+var mboxFromLine = regexp.MustCompile(`(?m)^(>*From )`)
+
+// Mbox is a Sender which delivers emails by appending them to a single
+// file in the Unix mbox format, which mail clients such as mutt can read.
+//
+// Each email is preceded by a "From " separator line, giving the sender
+// and the time it was delivered, and followed by a blank line.  Lines of
+// the email which begin with "From ", possibly after some '>' characters,
+// have another '>' added, as in the "mboxrd" variant of the format.
+type Mbox struct {
+
+	// path is the file we append to.
+	path string
+
+	// mutex ensures that only one of our goroutines appends at once.
+	mutex sync.Mutex
+
+	// now returns the current time, it is replaced in our tests.
+	now func() time.Time
+}
+
+// NewMbox returns a Sender which appends to the mbox file at the given
+// path, creating it, and the directory containing it, if necessary.
+func NewMbox(path string) (*Mbox, error) {
+
+	if path == "" {
+		return nil, errors.New("empty mbox path")
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure we can write to the file now, rather than failing
+	// when we've an email to deliver.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	return &Mbox{path: path, now: time.Now}, nil
+}
+
+// Send is part of the Sender interface, it appends the given email to the
+// mbox file.
+//
+// The email is written with a single call to Write, on a file opened
+// with O_APPEND, so that it is never interleaved with another.
+func (m *Mbox) Send(to string, msg []byte) error {
+
+	from := extractFromHeader(msg)
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From %s %s\n", from, m.now().UTC().Format(time.ANSIC))
+	buf.Write(mboxFromLine.ReplaceAll(msg, []byte(">$1")))
+	if !bytes.HasSuffix(msg, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(buf.Bytes())
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("failed to deliver to mbox %s: %w", m.path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package emailer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readMbox is a simple mbox reader, it returns the separator line and the
+// unquoted content of each email in the given file.
+func readMbox(t *testing.T, path string) ([]string, []string) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open mbox: %s", err)
+	}
+	defer file.Close()
+
+	var separators, emails []string
+	var current []string
+
+	flush := func() {
+		if separators != nil {
+			// Each email is followed by a blank line.
+			if len(current) == 0 || current[len(current)-1] != "" {
+				t.Fatalf("email isn't followed by a blank line: %q", current)
+			}
+			emails = append(emails, strings.Join(current[:len(current)-1], "\n")+"\n")
+		}
+		current = nil
+	}
+
+	quoted := regexp.MustCompile(`^>(>*From )`)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") {
+			flush()
+			separators = append(separators, line)
+			continue
+		}
+		if separators == nil {
+			t.Fatalf("mbox doesn't begin with a separator: %q", line)
+		}
+		current = append(current, quoted.ReplaceAllString(line, "$1"))
+	}
+	flush()
+
+	return separators, emails
+}
+
+func TestMbox(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "archive", "feeds.mbox")
+	m, err := NewMbox(path)
+	if err != nil {
+		t.Fatalf("failed to create mbox: %s", err)
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Fatalf("expected the mbox to be created: %s", err)
+	}
+
+	_, err = NewMbox("")
+	if err == nil {
+		t.Fatalf("expected an error with an empty path")
+	}
+
+	m.now = func() time.Time { return time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC) }
+
+	emails := []string{
+		"From: \"Feed\" <feed@example.com>\nSubject: One\n\nFirst body\n",
+		"From: feed@example.com\nSubject: Two\n\nFrom here on\n>From quoted\nnot From\n",
+		"Subject: Three\n\nNo trailing newline",
+	}
+	for _, msg := range emails {
+		err = m.Send("user@example.com", []byte(msg))
+		if err != nil {
+			t.Fatalf("failed to deliver: %s", err)
+		}
+	}
+
+	// The separators give the sender and time, and the emails are
+	// unchanged once read back.
+	separators, got := readMbox(t, path)
+	expected := []string{
+		"From feed@example.com Sun Mar 10 12:00:00 2024",
+		"From feed@example.com Sun Mar 10 12:00:00 2024",
+		"From MAILER-DAEMON Sun Mar 10 12:00:00 2024",
+	}
+	if strings.Join(separators, "|") != strings.Join(expected, "|") {
+		t.Fatalf("unexpected separators %q", separators)
+	}
+	emails[2] += "\n"
+	if strings.Join(got, "|") != strings.Join(emails, "|") {
+		t.Fatalf("unexpected emails %q", got)
+	}
+
+	// The body lines were quoted in the file itself.
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "\n>From here on\n>>From quoted\nnot From\n") {
+		t.Fatalf("body wasn't quoted: %q", data)
+	}
+}
+
+func TestMboxConcurrent(t *testing.T) {
+
+	m, err := NewMbox(filepath.Join(t.TempDir(), "feeds.mbox"))
+	if err != nil {
+		t.Fatalf("failed to create mbox: %s", err)
+	}
+
+	// Emails delivered at once aren't interleaved.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := fmt.Sprintf("Subject: %d\n\n%s\n", i, strings.Repeat("body\n", 100))
+			if err := m.Send("user@example.com", []byte(msg)); err != nil {
+				t.Errorf("failed to deliver: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, got := readMbox(t, m.path)
+	if len(got) != 20 {
+		t.Fatalf("expected 20 emails, got %d", len(got))
+	}
+	for _, msg := range got {
+		if strings.Count(msg, "Subject:") != 1 || strings.Count(msg, "body\n") != 100 {
+			t.Fatalf("unexpected email %q", msg)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package emailer
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"mime/multipart"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/k3a/html2text"
+)
+
+// isMultipart returns true if the "multipart" option of the feed is set,
+// in which case our emails are built as a simple multipart/alternative
+// message, rather than from the email template.
+func (e *Emailer) isMultipart() bool {
+
+	enabled := false
+	for _, opt := range e.opts {
+		if opt.Name == "multipart" {
+			val := strings.ToLower(strings.TrimSpace(opt.Value))
+			enabled = val == "yes" || val == "true"
+		}
+	}
+	return enabled
+}
+
+// plainText converts the given HTML to plain text, for the text/plain part
+// of a multipart email.
+//
+// Links are preserved as "[title](url)", so that they can still be
+// followed by those who read the plain text.
+func plainText(htmlstr string) string {
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlstr))
+	if err != nil {
+		return html2text.HTML2Text(htmlstr)
+	}
+
+	doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		title := strings.TrimSpace(a.Text())
+		if title == "" {
+			title = href
+		}
+		a.ReplaceWithHtml(html.EscapeString("[" + title + "](" + href + ")"))
+	})
+
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		return html2text.HTML2Text(htmlstr)
+	}
+	return html2text.HTML2Text(body)
+}
+
+// renderMultipart writes the email, with the same headers as our default
+// template, as a multipart/alternative message with a text/plain and a
+// text/html part.
+//
+// The parts are already quoted-printable encoded, and each email has a
+// new, random, boundary.
+func renderMultipart(buf *bytes.Buffer, x templateParms) {
+
+	// The writer is only used to generate a boundary, the parts are
+	// written with the same line endings as our templates.
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\n", boundary)
+	fmt.Fprintf(buf, "From: %s\n", x.From)
+	fmt.Fprintf(buf, "To: %s\n", x.To)
+	fmt.Fprintf(buf, "Message-ID: %s\n", x.MessageID)
+	if x.Tag != "" {
+		fmt.Fprintf(buf, "Subject: [rss2email] %s %s\n", encodeHeader(x.Tag), encodeHeader(x.Subject))
+		fmt.Fprintf(buf, "X-RSS-Tags: %s\n", x.Tag)
+	} else {
+		fmt.Fprintf(buf, "Subject: [rss2email] %s\n", encodeHeader(x.Subject))
+	}
+	fmt.Fprintf(buf, "X-RSS-Link: %s\n", x.Link)
+	fmt.Fprintf(buf, "X-RSS-Feed: %s\n", x.Feed)
+	fmt.Fprintf(buf, "X-RSS-GUID: %s\n", x.RSSItem.GUID)
+	fmt.Fprintf(buf, "List-ID: %s\n", makeListIdHeader(x.Feed))
+	fmt.Fprintf(buf, "Mime-Version: 1.0\n")
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", x.Text},
+		{"text/html", x.HTML},
+	} {
+		fmt.Fprintf(buf, "\n--%s\n", boundary)
+		fmt.Fprintf(buf, "Content-Type: %s; charset=UTF-8\n", part.contentType)
+		fmt.Fprintf(buf, "Content-Transfer-Encoding: quoted-printable\n\n")
+		fmt.Fprintf(buf, "%s\n", part.body)
+	}
+	fmt.Fprintf(buf, "\n--%s--\n", boundary)
+}
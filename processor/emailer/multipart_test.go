@@ -0,0 +1,105 @@
+package emailer
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/withstate"
+)
+
+func TestPlainText(t *testing.T) {
+
+	tests := map[string]string{
+		`<p>Read <a href="https://example.com/1">the post</a>.</p>`: "Read [the post](https://example.com/1).",
+		`<a href="https://example.com/2"></a>`:                      "[https://example.com/2](https://example.com/2)",
+		`<b>Bold</b> &amp; <i>italic</i>`:                           "Bold & italic",
+	}
+
+	for input, expected := range tests {
+		got := plainText(input)
+		if got != expected {
+			t.Fatalf("plainText(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestMultipart(t *testing.T) {
+
+	e := New(&gofeed.Feed{Title: "Example Feed", Link: "https://example.com/"},
+		withstate.FeedItem{Item: &gofeed.Item{Title: "Example", Link: "https://example.com/1"}},
+		[]configfile.Option{{Name: "multipart", Value: "true"}},
+		slog.New(slog.NewTextHandler(io.Discard, nil)), "sender@example.com")
+
+	content := `<p>Hello <a href="https://example.com/1">world</a></p>`
+
+	boundaries := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg, err := e.Render("user@example.com", "ignored", content)
+		if err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+
+		m, err := mail.ReadMessage(bytes.NewReader(msg))
+		if err != nil {
+			t.Fatalf("failed to parse email: %s", err)
+		}
+		if m.Header.Get("Subject") != "[rss2email] Example" || m.Header.Get("To") != "user@example.com" {
+			t.Fatalf("unexpected headers %v", m.Header)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/alternative" {
+			t.Fatalf("unexpected content-type %q", m.Header.Get("Content-Type"))
+		}
+		boundaries[params["boundary"]] = true
+
+		// There are two parts, the plain text and the HTML.
+		var types, bodies []string
+		reader := multipart.NewReader(m.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read part: %s", err)
+			}
+			data, err := io.ReadAll(quotedprintable.NewReader(part))
+			if err != nil {
+				t.Fatalf("failed to read part: %s", err)
+			}
+			types = append(types, part.Header.Get("Content-Type"))
+			bodies = append(bodies, strings.TrimSpace(string(data)))
+		}
+
+		if strings.Join(types, "|") != "text/plain; charset=UTF-8|text/html; charset=UTF-8" {
+			t.Fatalf("unexpected parts %q", types)
+		}
+		if bodies[0] != "Hello [world](https://example.com/1)" || strings.ContainsAny(bodies[0], "<>") {
+			t.Fatalf("unexpected plain text %q", bodies[0])
+		}
+		if bodies[1] != content {
+			t.Fatalf("unexpected HTML %q", bodies[1])
+		}
+
+		// The HTML can be found, as for our templates.
+		html, err := HTMLPart(msg)
+		if err != nil || strings.TrimSpace(html) != content {
+			t.Fatalf("unexpected HTML part %q: %v", html, err)
+		}
+	}
+
+	// Each email has its own boundary.
+	if len(boundaries) != 2 {
+		t.Fatalf("expected a new boundary for each email, got %v", boundaries)
+	}
+}
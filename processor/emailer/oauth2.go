@@ -0,0 +1,125 @@
+package emailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"time"
+
+	"github.com/skx/rss2email/config"
+	"golang.org/x/oauth2"
+)
+
+// tokenTimeout is the maximum time we'll wait for the token endpoint to
+// give us an access token.
+var tokenTimeout = 30 * time.Second
+
+// smtpAuth returns the authentication to use for the given server, which
+// is PLAIN with the password unless OAuth2 is configured.
+//
+// For OAuth2 the refresh token is exchanged for a new access token, so
+// this is called each time we connect.
+func smtpAuth(settings config.SMTPConfig) (smtp.Auth, error) {
+
+	if !settings.UsesOAuth2() {
+		return smtp.PlainAuth("", settings.Username, settings.Password, settings.Host), nil
+	}
+
+	token, err := oauth2Token(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &xoauth2Auth{username: settings.Username, token: token, host: settings.Host}, nil
+}
+
+// oauth2Token exchanges the configured refresh token for an access token.
+//
+// A refresh which is refused with a 401 status is retried once, as that
+// is sometimes transient.
+func oauth2Token(settings config.SMTPConfig) (string, error) {
+
+	cfg := &oauth2.Config{
+		ClientID:     settings.OAuth2ClientID,
+		ClientSecret: settings.OAuth2ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			TokenURL: settings.OAuth2TokenURL,
+
+			// Both Google and Microsoft accept the client
+			// credentials in the request body.
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: tokenTimeout})
+
+	var token *oauth2.Token
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		token, err = cfg.TokenSource(ctx, &oauth2.Token{RefreshToken: settings.OAuth2RefreshToken}).Token()
+		if !unauthorized(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain OAuth2 access token from %s: %w", settings.OAuth2TokenURL, err)
+	}
+	return token.AccessToken, nil
+}
+
+// unauthorized returns true if the given error is the token endpoint
+// refusing our request with a 401 status.
+func unauthorized(err error) bool {
+	var retrieve *oauth2.RetrieveError
+	return errors.As(err, &retrieve) && retrieve.Response != nil && retrieve.Response.StatusCode == http.StatusUnauthorized
+}
+
+// xoauth2Auth implements the XOAUTH2 SASL mechanism, used by Gmail and
+// Office365, which authenticates with an OAuth2 access token.
+type xoauth2Auth struct {
+
+	// username is the address of the account.
+	username string
+
+	// token is the access token.
+	token string
+
+	// host is the server we expect to be talking to.
+	host string
+}
+
+// Start begins the exchange, sending the username and access token.
+//
+// As with smtp.PlainAuth the token is only sent over an encrypted
+// connection, or to the local host.
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	resp := "user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"
+	return "XOAUTH2", []byte(resp), nil
+}
+
+// Next handles the server's challenge, which for XOAUTH2 only happens
+// when the token is refused.  The challenge describes the error, and we
+// reply with an empty response so that the server finishes with its
+// failure status.
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// isLocalhost returns true if the given server name is the local host, to
+// which credentials may be sent unencrypted.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,163 @@
+package emailer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skx/rss2email/config"
+)
+
+// fakeTokenEndpoint is an OAuth2 token endpoint, which refuses its first
+// few requests with a 401 status, and gives out an access token after
+// that.
+type fakeTokenEndpoint struct {
+	mutex sync.Mutex
+
+	// requests is the number of requests we've received.
+	requests int
+
+	// refuse is the number of requests to refuse.
+	refuse int
+
+	// token is the access token we give out.
+	token string
+}
+
+// newFakeTokenEndpoint starts the endpoint, returning it and its URL.
+func newFakeTokenEndpoint(t *testing.T, token string, refuse int) (*fakeTokenEndpoint, string) {
+
+	f := &fakeTokenEndpoint{token: token, refuse: refuse}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		f.requests++
+		refused := f.requests <= f.refuse
+		f.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if refused {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh" ||
+			r.FormValue("client_id") != "id" || r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": f.token,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	return f, ts.URL
+}
+
+// oauth2Settings returns the settings to reach the given server, using
+// the given token endpoint.
+func oauth2Settings(f *fakeSMTP, tokenURL string) config.SMTPConfig {
+	settings := f.settings()
+	settings.Password = ""
+	settings.Auth = config.AuthOAuth2
+	settings.OAuth2TokenURL = tokenURL
+	settings.OAuth2ClientID = "id"
+	settings.OAuth2ClientSecret = "secret"
+	settings.OAuth2RefreshToken = "refresh"
+	return settings
+}
+
+// TestXOAUTH2 tests that we exchange our refresh token for an access
+// token, retrying once when refused, and authenticate with it.
+func TestXOAUTH2(t *testing.T) {
+
+	endpoint, tokenURL := newFakeTokenEndpoint(t, "access", 1)
+
+	f := newFakeSMTP(t)
+	f.bearer = "access"
+
+	settings := oauth2Settings(f, tokenURL)
+
+	err := SendSMTP(settings, "from@example.com", []string{"to@example.com"}, []byte("Subject: one\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	// The pool connects the same way.
+	pool := NewPool(1, time.Minute)
+	defer pool.Close()
+
+	err = pool.Send(settings, "from@example.com", []string{"to@example.com"}, []byte("Subject: two\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.messages) != 2 {
+		t.Fatalf("expected two messages, got %v", f.messages)
+	}
+
+	// One refusal, then a token for each connection.
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	if endpoint.requests != 3 {
+		t.Fatalf("expected 3 token requests, got %d", endpoint.requests)
+	}
+}
+
+// TestXOAUTH2Refused tests that the server refusing our access token is
+// reported.
+func TestXOAUTH2Refused(t *testing.T) {
+
+	_, tokenURL := newFakeTokenEndpoint(t, "expired", 0)
+
+	f := newFakeSMTP(t)
+	f.bearer = "access"
+
+	err := SendSMTP(oauth2Settings(f, tokenURL), "from@example.com", []string{"to@example.com"}, []byte("Subject: one\r\n\r\nBody\r\n"))
+
+	var reply *textproto.Error
+	if !errors.As(err, &reply) || reply.Code != 535 {
+		t.Fatalf("expected the token to be refused, got %v", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.messages) != 0 {
+		t.Fatalf("expected no messages, got %v", f.messages)
+	}
+}
+
+// TestOAuth2TokenRefused tests that the token endpoint refusing us is
+// only retried once.
+func TestOAuth2TokenRefused(t *testing.T) {
+
+	endpoint, tokenURL := newFakeTokenEndpoint(t, "access", 5)
+
+	f := newFakeSMTP(t)
+	f.bearer = "access"
+
+	err := SendSMTP(oauth2Settings(f, tokenURL), "from@example.com", []string{"to@example.com"}, []byte("Subject: one\r\n\r\nBody\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Fatalf("expected the refresh to fail, got %v", err)
+	}
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	if endpoint.requests != 2 {
+		t.Fatalf("expected 2 token requests, got %d", endpoint.requests)
+	}
+}
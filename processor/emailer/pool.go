@@ -0,0 +1,362 @@
+package emailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/skx/rss2email/config"
+)
+
+// DefaultIdleTimeout is the time after which unused connections in a Pool
+// are closed, if no other value is given.
+const DefaultIdleTimeout = 30 * time.Second
+
+// acquireTimeout is the maximum time we'll wait for a connection to become
+// available, when all of them are in use.
+var acquireTimeout = time.Minute
+
+// pooledClient is a connection held by a Pool.
+type pooledClient struct {
+
+	// client is the connection to the server.
+	client *smtp.Client
+
+	// settings are those which were used to make the connection,
+	// so that we don't reuse it for a different server.
+	settings config.SMTPConfig
+
+	// lastUsed is the time the connection was returned to the pool.
+	lastUsed time.Time
+}
+
+// Pool maintains a set of authenticated SMTP connections, which are
+// reused for successive emails rather than connecting to the server for
+// each one.
+//
+// A Pool is safe for concurrent use.  Connections which fail, or which
+// have been idle for too long, are closed and replaced transparently, and
+// a message whose connection drops while it is sent is retried once over
+// a new connection.
+type Pool struct {
+
+	// slots limits the number of connections in use at once.
+	slots chan struct{}
+
+	// mutex protects idle, and closed.
+	mutex sync.Mutex
+
+	// idle holds the connections not currently in use.
+	idle []*pooledClient
+
+	// timeout is the time after which idle connections are closed.
+	timeout time.Duration
+
+	// closed is true once Close has been called.
+	closed bool
+
+	// done stops our reaper.
+	done chan struct{}
+}
+
+// NewPool creates a pool which holds up to size connections, closing
+// those which have been idle for longer than the given timeout.
+//
+// A timeout of zero means DefaultIdleTimeout.
+func NewPool(size int, timeout time.Duration) *Pool {
+
+	if size < 1 {
+		size = 1
+	}
+	if timeout <= 0 {
+		timeout = DefaultIdleTimeout
+	}
+
+	p := &Pool{
+		slots:   make(chan struct{}, size),
+		timeout: timeout,
+		done:    make(chan struct{}),
+	}
+
+	go p.reap()
+
+	return p
+}
+
+// reap periodically closes connections which have been idle for too long,
+// so that they're not left open between polls.
+func (p *Pool) reap() {
+
+	ticker := time.NewTicker(p.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mutex.Lock()
+			keep := p.idle[:0]
+			for _, pc := range p.idle {
+				if time.Since(pc.lastUsed) > p.timeout {
+					pc.client.Close()
+				} else {
+					keep = append(keep, pc)
+				}
+			}
+			p.idle = keep
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// Send delivers the given message via the server described by settings,
+// using a pooled connection if one is available.
+func (p *Pool) Send(settings config.SMTPConfig, from string, to []string, msg []byte) error {
+
+	// Wait for a slot, so that we don't exceed our size.
+	select {
+	case p.slots <- struct{}{}:
+	case <-time.After(acquireTimeout):
+		return fmt.Errorf("timed out waiting for an SMTP connection after %s", acquireTimeout)
+	}
+	defer func() { <-p.slots }()
+
+	pc, err := p.acquire(settings)
+	if err != nil {
+		return err
+	}
+
+	err = deliver(pc.client, from, to, msg)
+	if err != nil && dropped(err) {
+		// The connection was lost, rather than the server refusing
+		// the message, so we reconnect and try once more.
+		pc.client.Close()
+
+		var client *smtp.Client
+		client, err = dial(settings)
+		if err != nil {
+			return err
+		}
+		pc = &pooledClient{client: client, settings: settings}
+		err = deliver(pc.client, from, to, msg)
+	}
+	if err != nil {
+		// The connection might be in an unknown state, so discard
+		// it rather than returning it to the pool.
+		pc.client.Close()
+		return err
+	}
+
+	p.release(pc)
+	return nil
+}
+
+// dropped returns true if the given error, from delivering a message,
+// means the connection was lost rather than that the server replied with
+// an error, which would be the same the second time around.
+func dropped(err error) bool {
+	var reply *textproto.Error
+	return !errors.As(err, &reply)
+}
+
+// acquire returns an idle connection to the given server, or makes a new
+// one.
+func (p *Pool) acquire(settings config.SMTPConfig) (*pooledClient, error) {
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, errors.New("SMTP pool is closed")
+	}
+
+	var found *pooledClient
+	keep := p.idle[:0]
+	for _, pc := range p.idle {
+		switch {
+		case found == nil && pc.settings == settings && time.Since(pc.lastUsed) <= p.timeout:
+			found = pc
+		case pc.settings != settings || time.Since(pc.lastUsed) > p.timeout:
+			pc.client.Close()
+		default:
+			keep = append(keep, pc)
+		}
+	}
+	p.idle = keep
+	p.mutex.Unlock()
+
+	// Ensure the connection is still alive, the server might
+	// have closed it.
+	if found != nil {
+		if found.client.Noop() == nil {
+			return found, nil
+		}
+		found.client.Close()
+	}
+
+	client, err := dial(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledClient{client: client, settings: settings}, nil
+}
+
+// release returns a connection to the pool, once it has been used.
+func (p *Pool) release(pc *pooledClient) {
+
+	pc.lastUsed = time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		pc.client.Quit()
+		return
+	}
+	p.idle = append(p.idle, pc)
+}
+
+// Close closes all the connections in the pool, and stops it from being
+// used further.
+func (p *Pool) Close() error {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	for _, pc := range p.idle {
+		pc.client.Quit()
+	}
+	p.idle = nil
+
+	return nil
+}
+
+// rootCAs is the pool of certificates against which we validate the
+// server, nil means the system pool.  It exists for testing.
+var rootCAs *x509.CertPool
+
+// SendSMTP delivers the given message via the server described by
+// settings, over a connection which is made for it and closed afterwards.
+func SendSMTP(settings config.SMTPConfig, from string, to []string, msg []byte) error {
+
+	client, err := dial(settings)
+	if err != nil {
+		return err
+	}
+
+	err = deliver(client, from, to, msg)
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dial connects and authenticates to the given server, in the same way as
+// smtp.SendMail.  As there, a server which doesn't offer authentication is
+// an error when we have credentials, rather than our emails being sent
+// without them.
+//
+// If the settings ask for SMTPS the connection uses TLS from the start,
+// rather than being upgraded by STARTTLS.
+func dial(settings config.SMTPConfig) (*smtp.Client, error) {
+
+	addr := fmt.Sprintf("%s:%d", settings.Host, settings.Port)
+	cfg := &tls.Config{ServerName: settings.Host, RootCAs: rootCAs}
+
+	var client *smtp.Client
+	if settings.SMTPS {
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err != nil {
+			return nil, err
+		}
+		client, err = smtp.NewClient(conn, settings.Host)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		var err error
+		client, err = smtp.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := client.Hello("localhost")
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !settings.SMTPS {
+		err = client.StartTLS(cfg)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	ok, _ := client.Extension("AUTH")
+	if !ok && settings.Username != "" {
+		client.Close()
+		return nil, errors.New("smtp: server doesn't support AUTH")
+	}
+	if ok {
+		var auth smtp.Auth
+		auth, err = smtpAuth(settings)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		err = client.Auth(auth)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// deliver sends a single message over an established connection.
+func deliver(client *smtp.Client, from string, to []string, msg []byte) error {
+
+	err := client.Mail(from)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range to {
+		err = client.Rcpt(addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = bytes.NewReader(msg).WriteTo(w)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
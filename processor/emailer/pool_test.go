@@ -0,0 +1,422 @@
+package emailer
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skx/rss2email/config"
+)
+
+// fakeSMTP is a minimal SMTP server, which records what it receives.
+type fakeSMTP struct {
+	listener net.Listener
+
+	mutex       sync.Mutex
+	connections int
+	messages    []string
+	conns       []net.Conn
+
+	// drops is the number of times to drop the connection, rather
+	// than accepting a message.
+	drops int
+
+	// reject causes every recipient to be refused.
+	reject bool
+
+	// tls is used to encrypt each connection from the start, as for
+	// SMTPS, if non-nil.
+	tls *tls.Config
+
+	// noAuth causes the server not to offer authentication.
+	noAuth bool
+
+	// bearer is the access token we accept with XOAUTH2, which is the
+	// only mechanism offered if it is non-empty.
+	bearer string
+
+	// auth is the authentication we received, if any.
+	auth string
+}
+
+// newFakeSMTP starts a server on a random local port.
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	return startFakeSMTP(t, nil)
+}
+
+// newFakeSMTPS starts a server on a random local port, which expects TLS
+// from the start, using the certificate of the HTTP test-server.
+func newFakeSMTPS(t *testing.T) *fakeSMTP {
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(ts.Close)
+
+	rootCAs = ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	t.Cleanup(func() { rootCAs = nil })
+
+	return startFakeSMTP(t, &tls.Config{Certificates: ts.TLS.Certificates})
+}
+
+// startFakeSMTP starts a server on a random local port, using TLS if the
+// configuration is non-nil.
+func startFakeSMTP(t *testing.T, cfg *tls.Config) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	f := &fakeSMTP{listener: l, tls: cfg}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if f.tls != nil {
+				conn = tls.Server(conn, f.tls)
+			}
+			f.mutex.Lock()
+			f.connections++
+			f.conns = append(f.conns, conn)
+			f.mutex.Unlock()
+			go f.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { l.Close() })
+	return f
+}
+
+// settings returns the SMTP settings to connect to the server.
+func (f *fakeSMTP) settings() config.SMTPConfig {
+	host, port, _ := net.SplitHostPort(f.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return config.SMTPConfig{Host: host, Port: p, Username: "user", Password: "pass"}
+}
+
+// dropAll closes every connection, as a server timing out would.
+func (f *fakeSMTP) dropAll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		f.mutex.Lock()
+		drop := strings.HasPrefix(cmd, "MAIL") && f.drops > 0
+		if drop {
+			f.drops--
+		}
+		reject := f.reject
+		bearer := f.bearer
+		f.mutex.Unlock()
+
+		switch {
+		case drop:
+			return
+		case strings.HasPrefix(cmd, "RCPT") && reject:
+			reply("550 no such user")
+		case strings.HasPrefix(cmd, "EHLO") && bearer != "":
+			reply("250-localhost")
+			reply("250 AUTH XOAUTH2")
+		case strings.HasPrefix(cmd, "EHLO") && f.noAuth:
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			f.mutex.Lock()
+			f.auth = strings.TrimSpace(line)
+			f.mutex.Unlock()
+
+			// An unexpected token gets the error challenge, and then
+			// the failure, as Gmail would give.
+			if bearer != "" && f.auth != "AUTH XOAUTH2 "+base64.StdEncoding.EncodeToString([]byte("user=user\x01auth=Bearer "+bearer+"\x01\x01")) {
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte(`{"status":"401","schemes":"Bearer","scope":"https://mail.google.com/"}`)))
+				if _, err := r.ReadString('\n'); err != nil {
+					return
+				}
+				reply("535 5.7.8 Username and Password not accepted")
+				continue
+			}
+			reply("235 authenticated")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			f.mutex.Lock()
+			f.messages = append(f.messages, msg.String())
+			f.mutex.Unlock()
+			reply("250 accepted")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// TestPool tests that connections are reused.
+func TestPool(t *testing.T) {
+
+	f := newFakeSMTP(t)
+
+	pool := NewPool(2, time.Minute)
+	defer pool.Close()
+
+	for i := 0; i < 5; i++ {
+		err := pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, []byte("Subject: "+strconv.Itoa(i)+"\r\n\r\nBody\r\n"))
+		if err != nil {
+			t.Fatalf("failed to send: %s", err)
+		}
+	}
+
+	f.mutex.Lock()
+	if f.connections != 1 || len(f.messages) != 5 {
+		t.Fatalf("expected five messages over one connection, got %d over %d", len(f.messages), f.connections)
+	}
+	f.mutex.Unlock()
+
+	// If the server drops the connection we reconnect.
+	f.dropAll()
+
+	err := pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, []byte("Subject: again\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send after reconnection: %s", err)
+	}
+
+	f.mutex.Lock()
+	if f.connections != 2 || len(f.messages) != 6 {
+		t.Fatalf("expected a reconnection, got %d messages over %d connections", len(f.messages), f.connections)
+	}
+	f.mutex.Unlock()
+
+	// Once closed the pool can't be used.
+	pool.Close()
+	err = pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, []byte("Subject: closed\r\n\r\n"))
+	if err == nil {
+		t.Fatalf("expected an error using a closed pool")
+	}
+}
+
+// TestPoolConcurrent tests that the pool never exceeds its size.
+func TestPoolConcurrent(t *testing.T) {
+
+	f := newFakeSMTP(t)
+
+	pool := NewPool(2, time.Minute)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, []byte("Subject: hi\r\n\r\nBody\r\n"))
+			if err != nil {
+				t.Errorf("failed to send: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.connections > 2 || len(f.messages) != 10 {
+		t.Fatalf("expected ten messages over at most two connections, got %d over %d", len(f.messages), f.connections)
+	}
+}
+
+// TestPoolIdle tests that idle connections are closed.
+func TestPoolIdle(t *testing.T) {
+
+	f := newFakeSMTP(t)
+
+	pool := NewPool(1, 50*time.Millisecond)
+	defer pool.Close()
+
+	err := pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, []byte("Subject: hi\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	pool.mutex.Lock()
+	idle := len(pool.idle)
+	pool.mutex.Unlock()
+	if idle != 0 {
+		t.Fatalf("expected the idle connection to be reaped")
+	}
+
+	err = pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, []byte("Subject: hi\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.connections != 2 {
+		t.Fatalf("expected a new connection after the idle timeout, got %d", f.connections)
+	}
+}
+
+// TestPoolRetry tests that a message is retried, once, over a new
+// connection if the connection drops while it is being sent.
+func TestPoolRetry(t *testing.T) {
+
+	f := newFakeSMTP(t)
+
+	pool := NewPool(1, time.Minute)
+	defer pool.Close()
+
+	msg := []byte("Subject: hi\r\n\r\nBody\r\n")
+	err := pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, msg)
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	// The connection passes its health-check, but drops while the
+	// message is sent.
+	f.mutex.Lock()
+	f.drops = 1
+	f.mutex.Unlock()
+
+	err = pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, msg)
+	if err != nil {
+		t.Fatalf("failed to send after the connection dropped: %s", err)
+	}
+
+	f.mutex.Lock()
+	if f.connections != 2 || len(f.messages) != 2 {
+		t.Fatalf("expected two messages over two connections, got %d over %d", len(f.messages), f.connections)
+	}
+
+	// We only retry once.
+	f.drops = 2
+	f.mutex.Unlock()
+
+	err = pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, msg)
+	if err == nil {
+		t.Fatalf("expected an error when the retry drops too")
+	}
+
+	f.mutex.Lock()
+	if f.connections != 3 || len(f.messages) != 2 {
+		t.Fatalf("expected a single retry, got %d messages over %d connections", len(f.messages), f.connections)
+	}
+
+	// A message the server refuses isn't retried.
+	f.reject = true
+	f.mutex.Unlock()
+
+	err = pool.Send(f.settings(), "from@example.com", []string{"to@example.com"}, msg)
+	if err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Fatalf("expected the server's refusal, got %v", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.connections != 4 {
+		t.Fatalf("expected no retry of a refused message, got %d connections", f.connections)
+	}
+}
+
+// TestSMTPS tests that messages are delivered over connections which use
+// TLS from the start, both pooled and not.
+func TestSMTPS(t *testing.T) {
+
+	f := newFakeSMTPS(t)
+
+	settings := f.settings()
+	settings.SMTPS = true
+
+	err := SendSMTP(settings, "from@example.com", []string{"to@example.com"}, []byte("Subject: one\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	pool := NewPool(1, time.Minute)
+	defer pool.Close()
+
+	err = pool.Send(settings, "from@example.com", []string{"to@example.com"}, []byte("Subject: two\r\n\r\nBody\r\n"))
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.connections != 2 || len(f.messages) != 2 || !strings.Contains(f.messages[1], "Subject: two") {
+		t.Fatalf("expected two messages over two connections, got %v over %d", f.messages, f.connections)
+	}
+
+	// We authenticated, which the client only does once encrypted.
+	expected := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00user\x00pass"))
+	if f.auth != expected {
+		t.Fatalf("unexpected authentication %q", f.auth)
+	}
+}
+
+// TestNoAuth tests that a server which doesn't offer authentication is an
+// error when we have credentials, as it is for smtp.SendMail.
+func TestNoAuth(t *testing.T) {
+
+	f := newFakeSMTP(t)
+	f.noAuth = true
+
+	msg := []byte("Subject: one\r\n\r\nBody\r\n")
+
+	err := SendSMTP(f.settings(), "from@example.com", []string{"to@example.com"}, msg)
+	if err == nil || !strings.Contains(err.Error(), "doesn't support AUTH") {
+		t.Fatalf("expected an error without AUTH, got %v", err)
+	}
+
+	// Without credentials there's nothing to authenticate.
+	settings := f.settings()
+	settings.Username = ""
+	settings.Password = ""
+	err = SendSMTP(settings, "from@example.com", []string{"to@example.com"}, msg)
+	if err != nil {
+		t.Fatalf("failed to send: %s", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.messages) != 1 {
+		t.Fatalf("expected one message, got %v", f.messages)
+	}
+}
@@ -0,0 +1,36 @@
+package emailer
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// priorities maps the values of the "email-priority" option to the headers
+// we add for them.  A "normal" priority adds nothing, so that most emails
+// are left alone.
+var priorities = map[string]string{
+	"high":   "X-Priority: 1 (Highest)\nImportance: high\n",
+	"normal": "",
+	"low":    "X-Priority: 5 (Lowest)\nImportance: low\n",
+}
+
+// priorityHeaders returns the headers to add to our emails, according to
+// the "email-priority" option of the feed.
+func (e *Emailer) priorityHeaders() string {
+
+	for _, opt := range e.opts {
+		if opt.Name != "email-priority" {
+			continue
+		}
+
+		headers, ok := priorities[strings.ToLower(strings.TrimSpace(opt.Value))]
+		if !ok {
+			e.logger.Warn("ignoring unknown email-priority",
+				slog.String("email-priority", opt.Value))
+			return ""
+		}
+		return headers
+	}
+
+	return ""
+}
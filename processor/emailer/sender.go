@@ -0,0 +1,60 @@
+package emailer
+
+import (
+	"fmt"
+)
+
+// Sender delivers emails which have been rendered, allowing the method of
+// delivery to be chosen independently of how the emails are built.
+//
+// We send via SMTP, if it is configured, or via /usr/sbin/sendmail, unless
+// another Sender, such as a Maildir or an Mbox, is given to SetSender.
+type Sender interface {
+
+	// Send delivers the given email, which is formatted as described by
+	// RFC 2822, to the given recipient.
+	Send(to string, msg []byte) error
+}
+
+// SenderFunc allows an ordinary function to be used as a Sender.
+type SenderFunc func(to string, msg []byte) error
+
+// Send is part of the Sender interface, it calls f(to, msg).
+func (f SenderFunc) Send(to string, msg []byte) error {
+	return f(to, msg)
+}
+
+// SetSender causes emails to be delivered by the given Sender, rather than
+// via SMTP or sendmail.
+func (e *Emailer) SetSender(s Sender) {
+	e.custom = s
+}
+
+// sender returns the Sender our emails are delivered by, along with the
+// name of the method it uses, for our logs.
+func (e *Emailer) sender() (string, Sender) {
+
+	switch e.custom.(type) {
+	case nil:
+	case *Maildir:
+		return "maildir", e.custom
+	case *Mbox:
+		return "mbox", e.custom
+	case *feedWriter:
+		return "writer", e.custom
+	default:
+		return "custom", e.custom
+	}
+
+	if e.isSMTP() {
+		return "smtp", SenderFunc(e.sendSMTP)
+	}
+
+	return "sendmail", SenderFunc(func(to string, msg []byte) error {
+		from := extractFromHeader(msg)
+		if from == "" {
+			from = fmt.Sprintf("\"%s\" <%s>", e.feed.Title, to) // fallback if extraction fails
+		}
+		return e.sendSendmail(to, from, msg)
+	})
+}
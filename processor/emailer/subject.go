@@ -0,0 +1,107 @@
+package emailer
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"text/template"
+)
+
+// ParseSubjectTemplate compiles the value of a "subject-template" option.
+//
+// The template is executed with the FeedTitle, ItemTitle, ItemAuthor,
+// ItemDate, and ItemLink of each item.  Fields which aren't available, or
+// which don't exist, are rendered as the empty string.
+func ParseSubjectTemplate(text string) (*template.Template, error) {
+	return template.New("subject").Option("missingkey=zero").Parse(text)
+}
+
+// SetSubjectTemplate causes the subject of the email to be rendered from
+// the given template, rather than the one in the email template.
+func (e *Emailer) SetSubjectTemplate(tmpl *template.Template) {
+	e.subject = tmpl
+}
+
+// subjectData returns the values the subject template is executed with.
+func (e *Emailer) subjectData() map[string]string {
+
+	author := ""
+	if len(e.item.Authors) > 0 && e.item.Authors[0] != nil {
+		author = e.item.Authors[0].Name
+	} else if e.item.Author != nil {
+		author = e.item.Author.Name
+	}
+
+	feedTitle := ""
+	if e.feed != nil {
+		feedTitle = e.feed.Title
+	}
+
+	return map[string]string{
+		"FeedTitle":  feedTitle,
+		"ItemTitle":  e.item.Title,
+		"ItemAuthor": author,
+		"ItemDate":   e.item.Published,
+		"ItemLink":   e.item.Link,
+	}
+}
+
+// applySubject replaces the Subject header of the rendered message with
+// the output of our subject template, if we have one.
+//
+// If the template fails the message is returned unchanged, so the email
+// keeps the subject from the email template.
+func (e *Emailer) applySubject(msg []byte) []byte {
+
+	if e.subject == nil {
+		return msg
+	}
+
+	buf := &bytes.Buffer{}
+	err := e.subject.Execute(buf, e.subjectData())
+	if err != nil {
+		e.logger.Warn("failed to render subject-template, using the default subject",
+			slog.String("error", err.Error()))
+		return msg
+	}
+
+	// Headers can't span lines.
+	subject := strings.Join(strings.Fields(buf.String()), " ")
+
+	return replaceSubject(msg, "Subject: "+encodeHeader(subject))
+}
+
+// replaceSubject replaces the Subject header, and any continuation lines,
+// in the headers of the given message.
+func replaceSubject(msg []byte, header string) []byte {
+
+	lines := strings.SplitAfter(string(msg), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+
+		// The end of the headers.
+		if line == "" {
+			break
+		}
+
+		if !strings.HasPrefix(strings.ToLower(line), "subject:") {
+			continue
+		}
+
+		// Keep the line-ending the template used.
+		ending := lines[i][len(line):]
+
+		end := i + 1
+		for end < len(lines) && (strings.HasPrefix(lines[end], " ") || strings.HasPrefix(lines[end], "\t")) {
+			end++
+		}
+
+		out := append([]string{}, lines[:i]...)
+		out = append(out, header+ending)
+		out = append(out, lines[end:]...)
+		return []byte(strings.Join(out, ""))
+	}
+
+	return msg
+}
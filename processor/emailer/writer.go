@@ -0,0 +1,68 @@
+package emailer
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// FeedHeader is the header which identifies the feed an email came from,
+// it is added to the emails written by a Writer.
+const FeedHeader = "X-rss2email-feed"
+
+// Writer writes emails one after another to an io.Writer, such as STDOUT
+// or a file, for use by scripts or to preview what would be sent.
+//
+// Each email is preceded by a FeedHeader naming the feed it came from,
+// and is followed by a blank line.  The emails written by concurrent
+// feeds are never interleaved.
+type Writer struct {
+
+	// mutex ensures we write one email at a time.
+	mutex sync.Mutex
+
+	// w is where the emails are written.
+	w io.Writer
+}
+
+// NewWriter returns a Writer which writes emails to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// For returns a Sender which writes the emails of the feed with the given
+// URL.
+func (w *Writer) For(feedURL string) Sender {
+	return &feedWriter{writer: w, feedURL: feedURL}
+}
+
+// feedWriter is the Sender returned by Writer.For.
+type feedWriter struct {
+
+	// writer is the Writer we write to.
+	writer *Writer
+
+	// feedURL is the value of the FeedHeader of each email.
+	feedURL string
+}
+
+// Send is part of the Sender interface, it writes the given email, with
+// its FeedHeader, in a single call to Write.
+//
+// The recipient is present in the email's headers.
+func (f *feedWriter) Send(to string, msg []byte) error {
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(FeedHeader + ": " + encodeHeader(f.feedURL) + "\n")
+	buf.Write(msg)
+	if !bytes.HasSuffix(msg, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	f.writer.mutex.Lock()
+	defer f.writer.mutex.Unlock()
+
+	_, err := f.writer.w.Write(buf.Bytes())
+	return err
+}
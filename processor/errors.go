@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The phases of processing, in which a FeedError might occur.
+const (
+	// PhaseFetch is used for errors retrieving a remote feed.
+	PhaseFetch = "fetch"
+
+	// PhaseParse is used for errors parsing a feed which was retrieved.
+	PhaseParse = "parse"
+
+	// PhaseFilter is used for errors applying the per-feed filters.
+	PhaseFilter = "filter"
+
+	// PhaseDeliver is used for errors delivering feed items.
+	PhaseDeliver = "deliver"
+
+	// PhaseState is used for errors reading or updating our state.
+	PhaseState = "state"
+
+	// PhaseTemplate is used for errors loading a per-feed email template.
+	PhaseTemplate = "template"
+)
+
+// FeedError is the type of all errors returned by ProcessFeeds, it allows
+// callers to determine which feed failed, and why.
+//
+// Use errors.As to get at the details, the underlying cause is available
+// via errors.Unwrap.
+type FeedError struct {
+
+	// FeedURL is the URL of the feed which failed.
+	FeedURL string
+
+	// Phase is the stage of processing which failed, one of the
+	// Phase-constants.
+	Phase string
+
+	// Cause is the underlying error.
+	Cause error
+
+	// Retryable is true if the failure looks like a transient one,
+	// which might succeed on the next run.
+	Retryable bool
+
+	// ItemGUID is the GUID of the feed item which failed, this is
+	// empty for errors which relate to the feed as a whole.
+	ItemGUID string
+}
+
+// Error is part of the error-interface.
+//
+// An error without a cause is described by its phase alone.
+func (e *FeedError) Error() string {
+	if e.Cause == nil {
+		if e.FeedURL == "" {
+			return fmt.Sprintf("%s failed", e.Phase)
+		}
+		return fmt.Sprintf("%s failed for %s", e.Phase, e.FeedURL)
+	}
+	if e.FeedURL == "" {
+		return e.Cause.Error()
+	}
+	if e.ItemGUID != "" {
+		return fmt.Sprintf("error processing %s [%s] - %s", e.FeedURL, e.ItemGUID, e.Cause)
+	}
+	return fmt.Sprintf("error processing %s - %s", e.FeedURL, e.Cause)
+}
+
+// Unwrap returns the underlying cause of the error.
+func (e *FeedError) Unwrap() error {
+	return e.Cause
+}
+
+// MarshalJSON converts the error to JSON, with the cause replaced by its
+// string representation.
+func (e *FeedError) MarshalJSON() ([]byte, error) {
+
+	cause := ""
+	if e.Cause != nil {
+		cause = e.Cause.Error()
+	}
+
+	return json.Marshal(struct {
+		FeedURL   string `json:"feed_url"`
+		Phase     string `json:"phase"`
+		Cause     string `json:"error"`
+		Retryable bool   `json:"retryable"`
+		ItemGUID  string `json:"item_guid,omitempty"`
+	}{
+		FeedURL:   e.FeedURL,
+		Phase:     e.Phase,
+		Cause:     cause,
+		Retryable: e.Retryable,
+		ItemGUID:  e.ItemGUID,
+	})
+}
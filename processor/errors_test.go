@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// TestFeedErrorUnwrap ensures our error-type works with the standard
+// library helpers.
+func TestFeedErrorUnwrap(t *testing.T) {
+
+	cause := errors.New("oops")
+
+	var err error = &FeedError{FeedURL: "https://example.com/", Phase: PhaseFetch, Cause: cause}
+	wrapped := fmt.Errorf("wrapped: %w", err)
+
+	var fe *FeedError
+	if !errors.As(wrapped, &fe) {
+		t.Fatalf("failed to find FeedError")
+	}
+	if fe.Phase != PhaseFetch {
+		t.Fatalf("wrong phase %s", fe.Phase)
+	}
+	if !errors.Is(wrapped, cause) {
+		t.Fatalf("failed to unwrap to the cause")
+	}
+	if !strings.Contains(err.Error(), "https://example.com/") {
+		t.Fatalf("error doesn't mention the feed: %s", err.Error())
+	}
+}
+
+// TestFeedErrorJSON ensures our error-type can be serialized.
+func TestFeedErrorJSON(t *testing.T) {
+
+	err := &FeedError{FeedURL: "https://example.com/", Phase: PhaseDeliver, Cause: errors.New("failed"), ItemGUID: "1234"}
+
+	data, jErr := json.Marshal(err)
+	if jErr != nil {
+		t.Fatalf("failed to marshal: %s", jErr)
+	}
+
+	var out map[string]interface{}
+	jErr = json.Unmarshal(data, &out)
+	if jErr != nil {
+		t.Fatalf("failed to unmarshal: %s", jErr)
+	}
+
+	if out["feed_url"] != "https://example.com/" ||
+		out["phase"] != PhaseDeliver ||
+		out["error"] != "failed" ||
+		out["item_guid"] != "1234" ||
+		out["retryable"] != false {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+}
+
+// TestFeedErrorNoCause ensures an error without a cause can still be
+// logged and serialized.
+func TestFeedErrorNoCause(t *testing.T) {
+
+	err := &FeedError{FeedURL: "https://example.com/", Phase: PhaseFetch}
+	if err.Error() != "fetch failed for https://example.com/" {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+
+	err = &FeedError{Phase: PhaseState}
+	if err.Error() != "state failed" {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+
+	data, jErr := json.Marshal(err)
+	if jErr != nil {
+		t.Fatalf("failed to marshal: %s", jErr)
+	}
+	if !strings.Contains(string(data), `"error":""`) {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+}
+
+// TestProcessFeedsErrors ensures the errors from ProcessFeeds identify
+// the phase which failed.
+func TestProcessFeedsErrors(t *testing.T) {
+	setupTestHome(t)
+
+	// A server which doesn't return a feed.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, client")
+	}))
+	defer ts.Close()
+
+	// A server which is gone.
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	gone.Close()
+
+	opts := []configfile.Option{
+		{Name: "retry", Value: "1"},
+		{Name: "delay", Value: "0"},
+	}
+
+	tests := []struct {
+		url       string
+		phase     string
+		retryable bool
+	}{
+		{ts.URL, PhaseParse, false},
+		{gone.URL, PhaseFetch, true},
+	}
+
+	for _, tst := range tests {
+
+		p, err := New(ProcessorConfig{})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetFeeds([]configfile.Feed{{URL: tst.url, Options: opts}})
+
+		errs := p.ProcessFeeds([]string{"user@example.com"})
+		p.Close()
+
+		if len(errs) != 1 {
+			t.Fatalf("expected one error, got %d", len(errs))
+		}
+
+		var fe *FeedError
+		if !errors.As(errs[0], &fe) {
+			t.Fatalf("error wasn't a FeedError: %v", errs[0])
+		}
+		if fe.FeedURL != tst.url {
+			t.Fatalf("wrong URL in error: %s", fe.FeedURL)
+		}
+		if fe.Phase != tst.phase {
+			t.Fatalf("expected phase %s, got %s", tst.phase, fe.Phase)
+		}
+		if fe.Retryable != tst.retryable {
+			t.Fatalf("unexpected retryable setting for %s", tst.phase)
+		}
+	}
+}
+
+// TestProcessFeedsCancelled ensures the errors from a cancelled run are
+// FeedErrors too, which may be retried.
+func TestProcessFeedsCancelled(t *testing.T) {
+	setupTestHome(t)
+
+	p, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+	p.SetFeeds([]configfile.Feed{{URL: "https://example.com/feed"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := p.processFeeds(ctx, []string{"user@example.com"})
+	if len(errs) == 0 {
+		t.Fatalf("expected an error")
+	}
+	for _, err := range errs {
+		var fe *FeedError
+		if !errors.As(err, &fe) || !fe.Retryable {
+			t.Fatalf("error wasn't a retryable FeedError: %v", err)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("error wasn't a cancellation: %v", err)
+		}
+	}
+}
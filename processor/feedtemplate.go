@@ -0,0 +1,193 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/processor/emailer"
+)
+
+// loadFeedTemplates reads and compiles every template named by an
+// "email-body-template-file" option, before any feeds are processed.
+//
+// Unlike pattern files a missing, or broken, template is fatal: we'd
+// rather refuse to run than send emails in a layout the user didn't ask
+// for.  The error identifies the feed which referenced the template.
+func (p *Processor) loadFeedTemplates(entries []configfile.Feed) error {
+
+	p.feedTemplates = make(map[string]*template.Template)
+
+	for _, entry := range entries {
+		for _, opt := range entry.Options {
+			if opt.Name != "email-body-template-file" {
+				continue
+			}
+
+			path := patternFilePath(opt.Value)
+			if _, ok := p.feedTemplates[path]; ok {
+				continue
+			}
+
+			tmpl, err := loadTemplateFile(path)
+			if err != nil {
+				return &FeedError{
+					FeedURL: entry.URL,
+					Phase:   PhaseTemplate,
+					Cause:   fmt.Errorf("email-body-template-file %s: %w", path, err),
+				}
+			}
+
+			p.logger.Debug("loaded email-body-template-file",
+				slog.String("feed", entry.URL),
+				slog.String("path", path))
+
+			p.feedTemplates[path] = tmpl
+		}
+	}
+
+	return nil
+}
+
+// loadTemplateFile reads the given email template, and validates it.
+func loadTemplateFile(path string) (*template.Template, error) {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return emailer.ParseTemplate(string(content))
+}
+
+// feedTemplate returns the template to use for emails from the given
+// feed, which is the one loaded for its "email-body-template-file" option
+// if it has one, or the global template otherwise.
+func (p *Processor) feedTemplate(entry configfile.Feed) *template.Template {
+
+	for _, opt := range entry.Options {
+		if opt.Name == "email-body-template-file" {
+			if tmpl, ok := p.feedTemplates[patternFilePath(opt.Value)]; ok {
+				return tmpl
+			}
+		}
+	}
+
+	return p.template
+}
+
+// subjectTemplate returns the compiled "subject-template" option of the
+// given feed, or nil if it doesn't have one.
+//
+// Templates are compiled the first time they're used.  One which fails to
+// compile is reported once, and the feed's emails keep the subject from
+// their email template.
+func (p *Processor) subjectTemplate(entry configfile.Feed) *template.Template {
+
+	p.shared.Lock()
+	defer p.shared.Unlock()
+
+	for _, opt := range entry.Options {
+		if opt.Name != "subject-template" {
+			continue
+		}
+
+		if tmpl, ok := p.subjectTemplates[opt.Value]; ok {
+			return tmpl
+		}
+
+		if p.subjectTemplates == nil {
+			p.subjectTemplates = make(map[string]*template.Template)
+		}
+
+		tmpl, err := emailer.ParseSubjectTemplate(opt.Value)
+		if err != nil {
+			p.logger.Warn("failed to parse subject-template, using the default subject",
+				slog.String("feed", entry.URL),
+				slog.String("subject-template", opt.Value),
+				slog.String("error", err.Error()))
+			tmpl = nil
+		}
+
+		p.subjectTemplates[opt.Value] = tmpl
+		return tmpl
+	}
+
+	return nil
+}
+
+// isTemplateFile returns true if the value of a "body-template" option is
+// the path to a file, rather than the template itself.
+func isTemplateFile(value string) bool {
+	return strings.HasPrefix(value, "/") || strings.HasPrefix(value, "./")
+}
+
+// bodyTemplate returns the compiled "body-template" option of the given
+// feed, or nil if it doesn't have one.
+//
+// The option is either a template, or the path to a file containing one,
+// relative paths being beneath our state directory.  Templates are
+// compiled the first time they're used, files being re-read by each run so
+// that they may be edited.  One which can't be read, or compiled, is
+// reported once, and the feed's emails contain the item's content.
+func (p *Processor) bodyTemplate(entry configfile.Feed) *template.Template {
+
+	p.shared.Lock()
+	defer p.shared.Unlock()
+
+	for _, opt := range entry.Options {
+		if opt.Name != "body-template" {
+			continue
+		}
+
+		if tmpl, ok := p.bodyTemplates[opt.Value]; ok {
+			return tmpl
+		}
+
+		if p.bodyTemplates == nil {
+			p.bodyTemplates = make(map[string]*template.Template)
+		}
+
+		text := opt.Value
+		var err error
+		if isTemplateFile(opt.Value) {
+			var content []byte
+			content, err = os.ReadFile(patternFilePath(opt.Value))
+			text = string(content)
+		}
+
+		var tmpl *template.Template
+		if err == nil {
+			tmpl, err = emailer.ParseBodyTemplate(text)
+		}
+		if err != nil {
+			p.logger.Warn("failed to load body-template, using the item's content",
+				slog.String("feed", entry.URL),
+				slog.String("body-template", opt.Value),
+				slog.String("error", err.Error()))
+			tmpl = nil
+		}
+
+		p.bodyTemplates[opt.Value] = tmpl
+		return tmpl
+	}
+
+	return nil
+}
+
+// forgetBodyTemplateFiles discards the body templates which were read from
+// files, so that they're read again by the next run.
+func (p *Processor) forgetBodyTemplateFiles() {
+
+	p.shared.Lock()
+	defer p.shared.Unlock()
+
+	for value := range p.bodyTemplates {
+		if isTemplateFile(value) {
+			delete(p.bodyTemplates, value)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/withstate"
+)
+
+// FilterResult describes the decision one of our filters made about an
+// item, so that the reason it was, or wasn't, skipped can be shown.
+type FilterResult struct {
+
+	// Skipped is true if the item was excluded by the filter.
+	Skipped bool
+
+	// Filter is the name of the filter, one of the Filter-constants.
+	Filter string
+
+	// Reason describes why the item was skipped, naming the option and
+	// what it matched, such as "exclude-title: Spam matched 'Spam!'".
+	// It is empty unless the item was skipped.
+	Reason string
+
+	// MatchedOption is the name of the option which caused the item to
+	// be skipped, such as "exclude-title".
+	MatchedOption string
+}
+
+// newFilterResult returns the result of the given filter, which skipped
+// the item if the reason isn't empty.
+//
+// Our reasons always begin with the name of the option responsible.
+func newFilterResult(filter string, reason string) FilterResult {
+
+	result := FilterResult{Filter: filter, Reason: reason, Skipped: reason != ""}
+	if result.Skipped {
+		result.MatchedOption, _, _ = strings.Cut(reason, ":")
+		result.MatchedOption = strings.TrimSpace(result.MatchedOption)
+	}
+	return result
+}
+
+// filterCheck is one of the filters applied by filterReason.
+type filterCheck struct {
+
+	// filter is the name of the filter, one of the Filter-constants.
+	filter string
+
+	// reason returns why the item should be skipped, or the empty
+	// string if it shouldn't be.
+	reason func() string
+}
+
+// filterChecks returns our filters, in the order in which they're applied
+// to the given item.
+func (p *Processor) filterChecks(logger *slog.Logger, entry configfile.Feed, item withstate.FeedItem, content string) []filterCheck {
+
+	return []filterCheck{
+		// check for link filtering, before the content
+		{FilterPattern, func() string { return p.linkReason(logger, entry, item.Link) }},
+
+		// check for regular expressions
+		{FilterPattern, func() string { return p.skipReason(logger, entry, item.Title, content) }},
+
+		// check for age (exclude-older, and exclude-newer)
+		{FilterAge, func() string { return p.olderReason(logger, entry, item.Published) }},
+		{FilterNewer, func() string { return p.newerReason(logger, entry, item.Published) }},
+
+		// check for category filtering
+		{FilterCategory, func() string { return p.categoryReason(logger, entry, item.Categories) }},
+
+		// check for enclosures, such as the audio of podcast episodes
+		{FilterEnclosure, func() string { return p.enclosureReason(logger, entry, item.Enclosures) }},
+
+		// check for author filtering
+		{FilterAuthor, func() string { return p.authorReason(logger, entry, itemAuthors(item.Item)) }},
+
+		// check for publisher filtering, in aggregated feeds
+		{FilterPublisher, func() string {
+			sourceTitle, sourceURL := item.Source()
+			return p.publisherReason(logger, entry, sourceTitle, sourceURL)
+		}},
+
+		// check for minimum lengths, both must pass if both are set.
+		{FilterWords, func() string { return p.minWordsReason(logger, entry, content) }},
+		{FilterSentences, func() string { return p.minSentencesReason(logger, entry, content) }},
+		{FilterWords, func() string { return p.maxWordsReason(logger, entry, content) }},
+
+		// check for items without content, last of all so that the
+		// patterns have already had their say.
+		{FilterNoContent, func() string { return p.noContentReason(logger, entry, content) }},
+	}
+}
+
+// logFilterResult logs the decision our filters made about the given item,
+// at debug level, or at info level if we're explaining our decisions.
+func (p *Processor) logFilterResult(logger *slog.Logger, item withstate.FeedItem, result FilterResult) {
+
+	level := slog.LevelDebug
+	if p.explain {
+		level = slog.LevelInfo
+	}
+
+	logger.Log(context.Background(), level, "filter decision",
+		slog.String("item-title", item.Title),
+		slog.Bool("skipped", result.Skipped),
+		slog.String("filter", result.Filter),
+		slog.String("option", result.MatchedOption),
+		slog.String("reason", result.Reason))
+}
+
+// shouldSkipWithReason is shouldSkip, returning which option, if any, was
+// responsible for the decision.
+func (p *Processor) shouldSkipWithReason(logger *slog.Logger, config configfile.Feed, title string, content string) FilterResult {
+	return newFilterResult(FilterPattern, p.skipReason(logger, config, title, content))
+}
+
+// shouldSkipOlderWithReason is shouldSkipOlder, returning which option, if
+// any, was responsible for the decision.
+func (p *Processor) shouldSkipOlderWithReason(logger *slog.Logger, config configfile.Feed, published string) FilterResult {
+	return newFilterResult(FilterAge, p.olderReason(logger, config, published))
+}
+
+// shouldSkipCategoryWithReason is shouldSkipCategory, returning which
+// option, if any, was responsible for the decision.
+func (p *Processor) shouldSkipCategoryWithReason(logger *slog.Logger, config configfile.Feed, categories []string) FilterResult {
+	return newFilterResult(FilterCategory, p.categoryReason(logger, config, categories))
+}
+
+// ExplainFilter runs every filter configured for the given feed against a
+// single item, unlike TestFilter which stops at the first that skips it,
+// and returns the decision of each in the order they're applied.
+//
+// No state is consulted, so this doesn't take into account whether the
+// item has been seen before.
+func (p *Processor) ExplainFilter(feed configfile.Feed, item withstate.FeedItem) []FilterResult {
+
+	content := transformContent(p.logger, feed, itemContent(item))
+
+	var results []FilterResult
+	for _, check := range p.filterChecks(p.logger, feed, item, content) {
+		results = append(results, newFilterResult(check.filter, check.reason()))
+	}
+	return results
+}
+
+// SetExplain causes the decisions of our filters to be logged at info
+// level, rather than debug level, so that they can be seen without all of
+// our other debugging output.
+func (p *Processor) SetExplain(explain bool) {
+	p.explain = explain
+}
@@ -0,0 +1,51 @@
+package processor
+
+import (
+	"log/slog"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// checkFingerprint compares the fingerprint of the feed's options with
+// the one we recorded on our previous run, and records the new one.
+//
+// If the options have changed the items we've seen were filtered by
+// different settings, which we warn about.  When resetOnConfigChange is
+// set we also forget them, so the feed is treated as new.
+//
+// A dry-run records nothing, so there's nothing to do.
+func (p *Processor) checkFingerprint(entry configfile.Feed) error {
+
+	if p.dryRun != nil {
+		return nil
+	}
+
+	fingerprint := entry.Fingerprint()
+
+	previous, err := p.store.Fingerprint(entry.URL)
+	if err != nil {
+		return err
+	}
+	if previous == fingerprint {
+		return nil
+	}
+
+	// A feed we've not recorded a fingerprint for before has
+	// nothing to compare against.
+	if previous != "" {
+		p.logger.Warn("feed options changed, some historical filtering may differ",
+			slog.String("feed", entry.URL))
+
+		if p.resetOnConfigChange {
+			p.logger.Info("clearing the state of feed, as its options changed",
+				slog.String("feed", entry.URL))
+
+			err = p.store.DeleteFeed(entry.URL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return p.store.SetFingerprint(entry.URL, fingerprint)
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"encoding/base64"
+	"log/slog"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/httpfetch"
+)
+
+// defaultMaxInlineImages is the number of images of each item which the
+// "inline-images" option embeds, unless "max-inline-images" is set.
+const defaultMaxInlineImages = 10
+
+// defaultMaxImageSize is the largest image, in bytes, which the
+// "inline-images" option embeds, unless "max-image-size" is set.
+const defaultMaxImageSize = 1024 * 1024
+
+// inlineImages replaces the src of the images in the given HTML content
+// with data: URIs of their content, if the "inline-images" option of the
+// feed is enabled, so that they're shown by mail clients which block
+// remote images.
+//
+// Images which can't be fetched, or which are too large, are left as
+// remote URLs, as are those beyond "max-inline-images".
+func inlineImages(logger *slog.Logger, entry configfile.Feed, helper *httpfetch.HTTPFetch, content string) string {
+
+	if !enabledOption(entry, "inline-images") {
+		return content
+	}
+
+	limit := minimumOption(logger, entry, "max-inline-images")
+	if limit == 0 {
+		limit = defaultMaxInlineImages
+	}
+	size := minimumOption(logger, entry, "max-image-size")
+	if size == 0 {
+		size = defaultMaxImageSize
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+
+	inlined := 0
+	doc.Find("img[src]").Each(func(i int, img *goquery.Selection) {
+
+		src, _ := img.Attr("src")
+		if inlined >= limit || !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+			return
+		}
+
+		data, mediaType, err := helper.FetchImage(src, int64(size))
+		if err != nil {
+			logger.Warn("failed to inline image, leaving it remote",
+				slog.String("src", src),
+				slog.String("error", err.Error()))
+			return
+		}
+
+		img.SetAttr("src", "data:"+mediaType+";base64,"+base64.StdEncoding.EncodeToString(data))
+		inlined++
+	})
+
+	if inlined == 0 {
+		return content
+	}
+
+	out, err := doc.Html()
+	if err != nil {
+		return content
+	}
+
+	logger.Debug("inlined images",
+		slog.Int("images", inlined))
+
+	return out
+}
@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"log/slog"
+
+	"github.com/skx/rss2email/imap"
+	"github.com/skx/rss2email/withstate"
+)
+
+// seenChecker tests whether the emails we'd generate for feed items have
+// already been read, via the "imap-seen-check" option.
+//
+// The connection to the IMAP server is made the first time it is needed,
+// and if that fails we don't try again for the rest of the feed.
+type seenChecker struct {
+
+	// url is the IMAP URL of the mailbox we're searching.
+	url string
+
+	// client is our connection, if we have one.
+	client *imap.Client
+
+	// failed is set if we couldn't connect.
+	failed bool
+
+	// logger is used to report problems.
+	logger *slog.Logger
+}
+
+// seen returns true if the email for the given item is present in the
+// mailbox, and has been read.
+//
+// Errors are logged, and we assume the item has not been read.
+func (s *seenChecker) seen(item withstate.FeedItem) bool {
+
+	if s.failed {
+		return false
+	}
+
+	if s.client == nil {
+		client, err := imap.Dial(s.url)
+		if err != nil {
+			s.logger.Warn("failed to connect to IMAP server, not checking for read items",
+				slog.String("error", err.Error()))
+			s.failed = true
+			return false
+		}
+		s.client = client
+	}
+
+	read, err := s.client.Seen(item.MessageID())
+	if err != nil {
+		s.logger.Warn("failed to search IMAP mailbox, not checking for read items",
+			slog.String("error", err.Error()))
+		s.close()
+		s.failed = true
+		return false
+	}
+
+	return read
+}
+
+// close logs out of the IMAP server, if we connected.
+func (s *seenChecker) close() {
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
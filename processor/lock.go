@@ -0,0 +1,52 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by New if another process is using our state, and
+// it didn't finish within the LockTimeout.
+var ErrLocked = errors.New("state is locked")
+
+// errLockUnsupported is returned by lockFile on platforms where we can't
+// lock files.
+var errLockUnsupported = errors.New("file locking is not supported on this platform")
+
+// defaultLockTimeout is how long New waits for another process to release
+// the lock on our state, unless the configuration says otherwise.
+const defaultLockTimeout = 5 * time.Second
+
+// acquireLock takes an exclusive lock on the file at the given path,
+// creating it if necessary, waiting for up to the timeout if another
+// process holds it.
+//
+// The lock is held until the returned file is closed, which happens when
+// our process exits, however that happens.
+func acquireLock(path string, timeout time.Duration) (*os.File, error) {
+
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := lockFile(fh)
+		if err != nil {
+			fh.Close()
+			return nil, err
+		}
+		if locked {
+			return fh, nil
+		}
+
+		if time.Now().After(deadline) {
+			fh.Close()
+			return nil, fmt.Errorf("%w: %s is held by another rss2email process, wait for it to finish or stop it", ErrLocked, path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
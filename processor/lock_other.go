@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package processor
+
+import (
+	"os"
+)
+
+// lockFile always fails, as we don't know how to lock files here.
+func lockFile(fh *os.File) (bool, error) {
+	return false, errLockUnsupported
+}
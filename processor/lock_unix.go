@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package processor
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile tries to take an exclusive lock on the file, without waiting,
+// and returns true if it did.
+func lockFile(fh *os.File) (bool, error) {
+
+	err := syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
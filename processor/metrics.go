@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"time"
+)
+
+// MetricsRecorder receives the statistics of each run, so that they can be
+// exported for monitoring, for example by the metrics package.
+//
+// This is an interface so that we don't depend upon any particular
+// monitoring system.
+type MetricsRecorder interface {
+
+	// RecordRun is called once each run of ProcessFeeds has finished,
+	// with the statistics of every feed it processed, as returned by
+	// Statistics, and the time the run took.
+	RecordRun(stats map[string]FeedStatistics, duration time.Duration)
+}
+
+// SetMetrics causes the statistics of each run to be given to the
+// specified recorder, as well as being available via Statistics.
+//
+// The recorder isn't closed by Close, so the same one may be used by a
+// series of processors, as the daemon does.
+func (p *Processor) SetMetrics(m MetricsRecorder) {
+	p.metrics = m
+}
+
+// recordMetrics gives the statistics of the run which began at the given
+// time to our metrics recorder, if we have one.
+func (p *Processor) recordMetrics(start time.Time) {
+	if p.metrics != nil {
+		p.metrics.RecordRun(p.Statistics(), p.clock().Sub(start))
+	}
+}
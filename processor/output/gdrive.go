@@ -0,0 +1,310 @@
+package output
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+)
+
+// ErrRetry is wrapped by the errors of outputs whose failed deliveries
+// should be retried on the next run, so the item must not be recorded
+// as having been seen.
+var ErrRetry = errors.New("delivery will be retried")
+
+// gdriveUploadURL is the endpoint we create documents with.
+const gdriveUploadURL = "https://www.googleapis.com/upload/drive/v3/files?uploadType=multipart"
+
+// gdriveScope is the permission we request, which only allows access to
+// the files we create.
+const gdriveScope = "https://www.googleapis.com/auth/drive.file"
+
+// serviceAccount contains the parts of a Google service account key file
+// which we need.
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// GDrive is an output which creates a Google Doc, in a Drive folder, for
+// each item.  It authenticates as a service account, which must have been
+// given access to the folder.
+type GDrive struct {
+
+	// folder is the ID of the Drive folder the documents are created in.
+	folder string
+
+	// account is the service account we authenticate as.
+	account serviceAccount
+
+	// key is the private key of the service account.
+	key *rsa.PrivateKey
+
+	// delay is the minimum time between uploads, to stay within the
+	// Drive API quotas.
+	delay time.Duration
+
+	// last is the time of our most recent upload.
+	last time.Time
+
+	// token is our current access token, and expires when it stops
+	// being valid.
+	token   string
+	expires time.Time
+
+	// uploadURL is the endpoint documents are created with, which is
+	// replaced in our tests.
+	uploadURL string
+
+	// client makes our HTTP requests.
+	client *http.Client
+
+	// logger is used to report our progress.
+	logger *slog.Logger
+}
+
+// NewGDrive creates a Google Drive output, which creates documents in
+// the given folder, using the service account key in credentialsFile.
+func NewGDrive(folder string, credentialsFile string, delay time.Duration, logger *slog.Logger) (*GDrive, error) {
+
+	if credentialsFile == "" {
+		return nil, errors.New("the gdrive output needs a gdrive-credentials-file in config.yaml")
+	}
+
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", credentialsFile, err)
+	}
+
+	var account serviceAccount
+	err = json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", credentialsFile, err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("%s is not a service account key file", credentialsFile)
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no private key", credentialsFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key in %s: %w", credentialsFile, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key in %s is not an RSA key", credentialsFile)
+	}
+
+	return &GDrive{
+		folder:    folder,
+		account:   account,
+		key:       key,
+		delay:     delay,
+		uploadURL: gdriveUploadURL,
+		client:    &http.Client{Timeout: time.Minute},
+		logger:    logger,
+	}, nil
+}
+
+// Deliver is part of the Output interface.
+//
+// The document is named after the item's title, and contains its full
+// content, which Drive converts from HTML.  Failures wrap ErrRetry, so
+// that the item is delivered again on the next run.
+func (g *GDrive) Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+
+	// Don't exceed the API quotas.
+	if wait := g.delay - time.Since(g.last); !g.last.IsZero() && wait > 0 {
+		time.Sleep(wait)
+	}
+	g.last = time.Now()
+
+	content, err := item.HTMLContent()
+	if err != nil {
+		content = item.RawContent()
+	}
+
+	err = g.upload(item.Title, content)
+	if err != nil {
+		g.logger.Warn("failed to upload item to Google Drive",
+			slog.String("title", item.Title),
+			slog.String("feed", feedURL),
+			slog.String("error", err.Error()))
+		return fmt.Errorf("%w: %s", ErrRetry, err)
+	}
+
+	g.logger.Debug("uploaded item to Google Drive",
+		slog.String("title", item.Title),
+		slog.String("folder", g.folder))
+
+	return nil
+}
+
+// Close is part of the Output interface.
+func (g *GDrive) Close() error {
+	return nil
+}
+
+// upload creates a document with the given name, and HTML content.
+func (g *GDrive) upload(name string, content string) error {
+
+	token, err := g.accessToken()
+	if err != nil {
+		return err
+	}
+
+	metadata, err := json.Marshal(map[string]any{
+		"name":     name,
+		"mimeType": "application/vnd.google-apps.document",
+		"parents":  []string{g.folder},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The request is a multipart/related body, of the metadata and
+	// then the content.
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for _, part := range []struct{ kind, data string }{
+		{"application/json; charset=UTF-8", string(metadata)},
+		{"text/html; charset=UTF-8", content},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.kind}})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, part.data)
+		if err != nil {
+			return err
+		}
+	}
+	err = mw.Close()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, g.uploadURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("creating document failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// accessToken returns a valid access token, fetching a new one if we
+// don't have one, or it is about to expire.
+func (g *GDrive) accessToken() (string, error) {
+
+	if g.token != "" && time.Until(g.expires) > time.Minute {
+		return g.token, nil
+	}
+
+	assertion, err := g.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := g.client.PostForm(g.account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("authentication failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse authentication response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("authentication response contained no access token")
+	}
+
+	g.token = result.AccessToken
+	g.expires = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+
+	return g.token, nil
+}
+
+// assertion returns the signed JWT which we exchange for an access token.
+func (g *GDrive) assertion(now time.Time) (string, error) {
+
+	encode := func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(data), nil
+	}
+
+	header, err := encode(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := encode(map[string]any{
+		"iss":   g.account.ClientEmail,
+		"scope": gdriveScope,
+		"aud":   g.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + claims
+	sum := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,139 @@
+package output
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+)
+
+// TestGDrive tests creating documents in Google Drive, against a fake
+// server.
+func TestGDrive(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode key: %s", err)
+	}
+
+	var mutex sync.Mutex
+	tokens := 0
+	fail := false
+	var names, contents []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch r.URL.Path {
+		case "/token":
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" ||
+				strings.Count(r.FormValue("assertion"), ".") != 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tokens++
+			w.Write([]byte(`{"access_token":"secret","expires_in":3600}`))
+		case "/upload":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if fail {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			mr := multipart.NewReader(r.Body, params["boundary"])
+
+			part, _ := mr.NextPart()
+			var meta struct {
+				Name     string   `json:"name"`
+				MimeType string   `json:"mimeType"`
+				Parents  []string `json:"parents"`
+			}
+			json.NewDecoder(part).Decode(&meta)
+			if meta.MimeType != "application/vnd.google-apps.document" || len(meta.Parents) != 1 || meta.Parents[0] != "folder" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			part, _ = mr.NextPart()
+			content, _ := io.ReadAll(part)
+
+			names = append(names, meta.Name)
+			contents = append(contents, string(content))
+			w.Write([]byte(`{"id":"doc"}`))
+		}
+	}))
+	defer ts.Close()
+
+	creds := filepath.Join(t.TempDir(), "creds.json")
+	data, _ := json.Marshal(map[string]string{
+		"client_email": "rss2email@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    ts.URL + "/token",
+	})
+	err = os.WriteFile(creds, data, 0600)
+	if err != nil {
+		t.Fatalf("failed to write credentials: %s", err)
+	}
+
+	o, err := New("gdrive:folder", Options{GDriveCredentialsFile: creds})
+	if err != nil {
+		t.Fatalf("failed to create output: %s", err)
+	}
+	defer o.Close()
+	o.(*GDrive).uploadURL = ts.URL + "/upload"
+
+	for _, title := range []string{"One", "Two"} {
+		item := withstate.FeedItem{Item: &gofeed.Item{Title: title, Content: "<p>" + title + "</p>"}}
+		err = o.Deliver("https://example.com/feed", nil, item)
+		if err != nil {
+			t.Fatalf("failed to deliver: %s", err)
+		}
+	}
+
+	mutex.Lock()
+	if tokens != 1 || strings.Join(names, ",") != "One,Two" || !strings.Contains(contents[1], "<p>Two</p>") {
+		t.Fatalf("unexpected uploads %d %v %v", tokens, names, contents)
+	}
+	fail = true
+	mutex.Unlock()
+
+	// Failures are to be retried.
+	err = o.Deliver("https://example.com/feed", nil, withstate.FeedItem{Item: &gofeed.Item{Title: "Three"}})
+	if !errors.Is(err, ErrRetry) {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+
+	// We need credentials.
+	_, err = New("gdrive:folder", Options{})
+	if err == nil {
+		t.Fatalf("expected an error without credentials")
+	}
+	_, err = New("gdrive:folder", Options{GDriveCredentialsFile: filepath.Join(t.TempDir(), "missing.json")})
+	if err == nil {
+		t.Fatalf("expected an error with missing credentials")
+	}
+}
@@ -0,0 +1,248 @@
+package output
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blankLines matches runs of blank lines, which we collapse.
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// whitespace matches runs of whitespace, which we collapse as a browser
+// would.
+var whitespace = regexp.MustCompile(`\s+`)
+
+// mdEscaper escapes the characters which have a special meaning in
+// Markdown, when they appear in ordinary text.
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`<`, `\<`,
+	`>`, `\>`,
+)
+
+// htmlToMarkdown converts the given HTML into Markdown.
+//
+// This handles the common elements found in feed content: headings,
+// paragraphs, emphasis, links, images, lists, quotes, and code.  Anything
+// else is reduced to its text.
+func htmlToMarkdown(content string) string {
+
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+
+	c := &mdConverter{}
+	c.walk(doc)
+
+	out := blankLines.ReplaceAllString(c.buf.String(), "\n\n")
+	return strings.TrimSpace(out) + "\n"
+}
+
+// mdConverter holds the state of a conversion.
+type mdConverter struct {
+
+	// buf holds the output.
+	buf strings.Builder
+
+	// lists holds the counter for each list we're within, which is
+	// negative for unordered lists.
+	lists []int
+
+	// quote is the number of blockquotes we're within.
+	quote int
+
+	// pre is true if we're within a <pre> element.
+	pre bool
+
+	// fresh is true if we're at the start of a line, or just after
+	// a list-marker, where leading whitespace should be dropped.
+	fresh bool
+}
+
+// write adds text to our output, prefixing new lines within quotes.
+func (c *mdConverter) write(s string) {
+	if c.quote > 0 {
+		s = strings.ReplaceAll(s, "\n", "\n"+strings.Repeat("> ", c.quote))
+	}
+	c.buf.WriteString(s)
+	c.fresh = false
+}
+
+// mark writes something which starts a line, such as a list-marker.
+func (c *mdConverter) mark(s string) {
+	c.write(s)
+	c.fresh = true
+}
+
+// block ensures the output is at the start of a new paragraph.
+func (c *mdConverter) block() {
+	c.mark("\n\n")
+}
+
+// children converts the children of the given node.
+func (c *mdConverter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+// text returns the plain text content of the given node.
+func text(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(text(child))
+	}
+	return sb.String()
+}
+
+// attr returns the value of the named attribute of the given node.
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// walk converts the given node, and its children.
+func (c *mdConverter) walk(n *html.Node) {
+
+	switch n.Type {
+	case html.TextNode:
+		if c.pre {
+			c.write(n.Data)
+			return
+		}
+		txt := whitespace.ReplaceAllString(n.Data, " ")
+		if c.fresh {
+			txt = strings.TrimLeft(txt, " ")
+			if txt == "" {
+				return
+			}
+		}
+		c.write(mdEscaper.Replace(txt))
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+
+	switch n.Data {
+	case "script", "style", "head":
+		return
+
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.block()
+		c.write(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		c.write(strings.TrimSpace(whitespace.ReplaceAllString(text(n), " ")))
+		c.block()
+
+	case "p", "div", "section", "article", "figure", "table", "tr":
+		c.block()
+		c.children(n)
+		c.block()
+
+	case "br":
+		c.mark("  \n")
+
+	case "hr":
+		c.block()
+		c.write("---")
+		c.block()
+
+	case "strong", "b":
+		c.write("**")
+		c.children(n)
+		c.write("**")
+
+	case "em", "i":
+		c.write("_")
+		c.children(n)
+		c.write("_")
+
+	case "code":
+		if c.pre {
+			c.children(n)
+			return
+		}
+		c.write("`" + text(n) + "`")
+
+	case "pre":
+		c.block()
+		c.write("```\n")
+		c.pre = true
+		c.children(n)
+		c.pre = false
+		c.write("\n```")
+		c.block()
+
+	case "a":
+		href := attr(n, "href")
+		if href == "" {
+			c.children(n)
+			return
+		}
+		c.write("[")
+		c.children(n)
+		c.write("](" + href + ")")
+
+	case "img":
+		src := attr(n, "src")
+		if src != "" {
+			c.write("![" + mdEscaper.Replace(attr(n, "alt")) + "](" + src + ")")
+		}
+
+	case "blockquote":
+		c.block()
+		c.quote++
+		c.mark("> ")
+		c.children(n)
+		c.quote--
+		c.block()
+
+	case "ul", "ol":
+		counter := -1
+		if n.Data == "ol" {
+			counter = 1
+		}
+		c.lists = append(c.lists, counter)
+		if len(c.lists) == 1 {
+			c.block()
+		}
+		c.children(n)
+		c.lists = c.lists[:len(c.lists)-1]
+		if len(c.lists) == 0 {
+			c.block()
+		}
+
+	case "li":
+		depth := len(c.lists)
+		marker := "- "
+		if depth > 0 && c.lists[depth-1] > 0 {
+			marker = strconv.Itoa(c.lists[depth-1]) + ". "
+			c.lists[depth-1]++
+		}
+		if depth > 1 {
+			marker = strings.Repeat("  ", depth-1) + marker
+		}
+		c.mark("\n" + marker)
+		c.children(n)
+
+	default:
+		c.children(n)
+	}
+}
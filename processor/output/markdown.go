@@ -0,0 +1,152 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+	"gopkg.in/yaml.v3"
+)
+
+// Markdown is an output which writes each item to a Markdown file, with
+// YAML front matter, suitable for static site generators such as Hugo
+// and Jekyll.
+type Markdown struct {
+
+	// dir is the directory the files are written to.
+	dir string
+
+	// logger is used to report items which are skipped.
+	logger *slog.Logger
+}
+
+// frontMatter is the metadata written at the top of each file.
+type frontMatter struct {
+	Title      string   `yaml:"title"`
+	Date       string   `yaml:"date"`
+	Author     string   `yaml:"author,omitempty"`
+	Categories []string `yaml:"categories,omitempty"`
+	SourceURL  string   `yaml:"source_url,omitempty"`
+	FeedURL    string   `yaml:"feed_url"`
+}
+
+// NewMarkdown creates a Markdown output, which writes to the given
+// directory.  The directory is created if it doesn't exist.
+func NewMarkdown(dir string, logger *slog.Logger) (*Markdown, error) {
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	return &Markdown{dir: dir, logger: logger}, nil
+}
+
+// Deliver is part of the Output interface.
+//
+// Files are named after the date and title of the item, and an existing
+// file is never overwritten: the item is skipped with a warning instead.
+func (m *Markdown) Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+
+	date := time.Now()
+	if item.PublishedParsed != nil {
+		date = *item.PublishedParsed
+	} else if item.UpdatedParsed != nil {
+		date = *item.UpdatedParsed
+	}
+
+	meta := frontMatter{
+		Title:      item.Title,
+		Date:       date.Format(time.RFC3339),
+		Categories: item.Categories,
+		SourceURL:  item.Link,
+		FeedURL:    feedURL,
+	}
+	if item.Author != nil {
+		meta.Author = item.Author.Name
+		if meta.Author == "" {
+			meta.Author = item.Author.Email
+		}
+	}
+
+	content, err := item.HTMLContent()
+	if err != nil {
+		content = item.RawContent()
+	}
+
+	header, err := yaml.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(header)
+	buf.WriteString("---\n\n")
+	buf.WriteString(htmlToMarkdown(content))
+
+	name := date.Format("2006-01-02") + "-" + slugify(item.Title) + ".md"
+	path := filepath.Join(m.dir, name)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		m.logger.Warn("not overwriting existing markdown file",
+			slog.String("path", path),
+			slog.String("title", item.Title))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(buf.Bytes())
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Close is part of the Output interface.
+func (m *Markdown) Close() error {
+	return nil
+}
+
+// slugify converts a title into something suitable for use in a filename,
+// lowercase letters and digits separated by hyphens.
+func slugify(title string) string {
+
+	var sb strings.Builder
+
+	hyphen := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && sb.Len() > 0 {
+				sb.WriteRune('-')
+			}
+			sb.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+
+		if sb.Len() >= 60 {
+			break
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "untitled"
+	}
+	return sb.String()
+}
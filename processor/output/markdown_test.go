@@ -0,0 +1,119 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+	"gopkg.in/yaml.v3"
+)
+
+// TestMarkdown tests writing items as Markdown files.
+func TestMarkdown(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "content", "posts")
+
+	o, err := New("markdown:"+dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to create output: %s", err)
+	}
+	defer o.Close()
+
+	published := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	item := withstate.FeedItem{Item: &gofeed.Item{
+		Title:           "Hello, World: Part 2!",
+		Link:            "https://example.com/hello",
+		Content:         `<p>Some <b>bold</b> text, and <a href="https://example.com/">a link</a>.</p><ul><li>One</li><li>Two</li></ul>`,
+		Author:          &gofeed.Person{Name: "Steve"},
+		Categories:      []string{"go", "news"},
+		PublishedParsed: &published,
+	}}
+
+	err = o.Deliver("https://example.com/feed", nil, item)
+	if err != nil {
+		t.Fatalf("failed to deliver: %s", err)
+	}
+
+	path := filepath.Join(dir, "2024-03-09-hello-world-part-2.md")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read output: %s", err)
+	}
+
+	parts := strings.SplitN(string(data), "---\n", 3)
+	if len(parts) != 3 || parts[0] != "" {
+		t.Fatalf("missing front matter in %s", data)
+	}
+
+	var meta frontMatter
+	err = yaml.Unmarshal([]byte(parts[1]), &meta)
+	if err != nil {
+		t.Fatalf("invalid front matter: %s", err)
+	}
+	if meta.Title != item.Title || meta.Author != "Steve" || len(meta.Categories) != 2 ||
+		meta.SourceURL != item.Link || meta.FeedURL != "https://example.com/feed" ||
+		meta.Date != "2024-03-09T12:00:00Z" {
+		t.Fatalf("unexpected front matter %v", meta)
+	}
+
+	for _, str := range []string{"Some **bold** text", "[a link](https://example.com/)", "- One\n- Two"} {
+		if !strings.Contains(parts[2], str) {
+			t.Fatalf("expected %q in body %q", str, parts[2])
+		}
+	}
+
+	// Delivering again doesn't overwrite the file.
+	item.Content = "<p>Changed</p>"
+	err = o.Deliver("https://example.com/feed", nil, item)
+	if err != nil {
+		t.Fatalf("failed to deliver: %s", err)
+	}
+	again, _ := os.ReadFile(path)
+	if string(again) != string(data) {
+		t.Fatalf("existing file was overwritten")
+	}
+}
+
+// TestHTMLToMarkdown tests converting HTML content.
+func TestHTMLToMarkdown(t *testing.T) {
+
+	tests := map[string]string{
+		"<h2>Title</h2><p>Text</p>":                      "## Title\n\nText\n",
+		"<p>A <em>b</em> <code>c()</code></p>":           "A _b_ `c()`\n",
+		"<ol><li>One</li><li>Two</li></ol>":              "1. One\n2. Two\n",
+		"<blockquote>Quoted</blockquote>":                "> Quoted\n",
+		"<pre><code>x := 1\ny := 2</code></pre>":         "```\nx := 1\ny := 2\n```\n",
+		`<p><img src="a.png" alt="A cat"></p>`:           "![A cat](a.png)\n",
+		"<p>1 * 2 = [2]</p><script>alert(1)</script>":    "1 \\* 2 = \\[2\\]\n",
+		"<p>Line one<br>Line two</p><hr><p>After</p>":    "Line one  \nLine two\n\n---\n\nAfter\n",
+		"<ul><li>Outer<ul><li>Inner</li></ul></li></ul>": "- Outer\n  - Inner\n",
+	}
+
+	for in, expected := range tests {
+		if got := htmlToMarkdown(in); got != expected {
+			t.Fatalf("htmlToMarkdown(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
+
+// TestSlugify tests generating filenames from titles.
+func TestSlugify(t *testing.T) {
+
+	tests := map[string]string{
+		"Hello, World!":         "hello-world",
+		"  Go 1.23 released  ":  "go-1-23-released",
+		"Ünïcode títle":         "ünïcode-títle",
+		"!!!":                   "untitled",
+		strings.Repeat("a", 99): strings.Repeat("a", 60),
+	}
+
+	for in, expected := range tests {
+		if got := slugify(in); got != expected {
+			t.Fatalf("slugify(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
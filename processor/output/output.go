@@ -0,0 +1,78 @@
+// Package output contains the alternatives to email delivery.
+//
+// When an output is configured new feed items are written to it, rather
+// than being emailed, which allows the history of the feeds to be stored
+// and queried locally.
+package output
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+)
+
+// Output is the interface which must be implemented by anything that can
+// receive new feed items.
+type Output interface {
+
+	// Deliver stores the given item, which was found in the feed
+	// with the specified URL.
+	Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error
+
+	// Close releases any resources held by the output.
+	Close() error
+}
+
+// Options contains settings which are specific to some outputs.
+type Options struct {
+
+	// SQLiteFTS causes the SQLite output to maintain full-text
+	// search tables.
+	SQLiteFTS bool
+
+	// GDriveCredentialsFile is the path to the service account key
+	// the Google Drive output authenticates with.
+	GDriveCredentialsFile string
+
+	// GDriveDelay is the minimum time between uploads to Google
+	// Drive, to stay within its API quotas.
+	GDriveDelay time.Duration
+
+	// Logger is used by outputs to report problems which aren't
+	// errors, if it is nil nothing is logged.
+	Logger *slog.Logger
+}
+
+// New creates an output from the given specification, which has a
+// prefix identifying the type of output followed by its destination.
+//
+// For example "sqlite:/path/to/feeds.db", "markdown:/path/to/dir", or
+// "gdrive:FOLDER_ID".
+func New(spec string, opts Options) (Output, error) {
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	kind, dest, found := strings.Cut(spec, ":")
+	if !found || dest == "" {
+		return nil, fmt.Errorf("invalid output '%s', expected type:destination", spec)
+	}
+
+	switch kind {
+	case "sqlite":
+		return NewSQLite(dest, opts.SQLiteFTS)
+	case "markdown":
+		return NewMarkdown(dest, logger)
+	case "gdrive":
+		return NewGDrive(dest, opts.GDriveCredentialsFile, opts.GDriveDelay, logger)
+	default:
+		return nil, fmt.Errorf("unknown output type '%s'", kind)
+	}
+}
@@ -0,0 +1,185 @@
+package output
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+
+	// Pure-Go SQLite driver, so we don't need cgo.
+	_ "modernc.org/sqlite"
+)
+
+// schema describes our tables, which are created if they don't exist.
+//
+// Times are stored as RFC3339 strings, in UTC.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS feeds (
+		id           INTEGER PRIMARY KEY,
+		url          TEXT NOT NULL UNIQUE,
+		name         TEXT,
+		last_fetched TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS items (
+		id           INTEGER PRIMARY KEY,
+		feed_id      INTEGER NOT NULL REFERENCES feeds(id) ON DELETE CASCADE,
+		guid         TEXT NOT NULL,
+		title        TEXT,
+		link         TEXT,
+		author       TEXT,
+		content      TEXT,
+		published_at TEXT,
+		delivered_at TEXT,
+		UNIQUE (feed_id, guid)
+	)`,
+	`CREATE TABLE IF NOT EXISTS categories (
+		id      INTEGER PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		name    TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS categories_item ON categories(item_id)`,
+}
+
+// ftsSchema describes the full-text search table, which indexes the
+// items table via triggers.
+var ftsSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS items_fts USING fts5(
+		title, content, content='items', content_rowid='id'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS items_fts_insert AFTER INSERT ON items BEGIN
+		INSERT INTO items_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS items_fts_delete AFTER DELETE ON items BEGIN
+		INSERT INTO items_fts(items_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS items_fts_update AFTER UPDATE ON items BEGIN
+		INSERT INTO items_fts(items_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+		INSERT INTO items_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+	END`,
+}
+
+// SQLite is an output which stores items in a SQLite database.
+type SQLite struct {
+
+	// db is our database handle.
+	db *sql.DB
+}
+
+// NewSQLite opens the database at the given path, creating it and our
+// schema if necessary.
+//
+// If fts is true then the full-text search table is created too.
+func NewSQLite(path string, fts bool) (*SQLite, error) {
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only supports a single writer.
+	db.SetMaxOpenConns(1)
+
+	statements := append([]string{"PRAGMA foreign_keys = ON"}, schema...)
+	if fts {
+		statements = append(statements, ftsSchema...)
+	}
+
+	for _, stmt := range statements {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create schema in %s: %w", path, err)
+		}
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// Deliver is part of the Output interface.
+//
+// Items are identified by their GUID, falling back to their link, and an
+// item which has been stored previously is updated in place.
+func (s *SQLite) Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	guid := item.GUID
+	if guid == "" {
+		guid = item.Link
+	}
+
+	author := ""
+	if item.Author != nil {
+		author = item.Author.Name
+		if author == "" {
+			author = item.Author.Email
+		}
+	}
+
+	var published sql.NullString
+	if item.PublishedParsed != nil {
+		published.String = item.PublishedParsed.UTC().Format(time.RFC3339)
+		published.Valid = true
+	}
+
+	content, err := item.HTMLContent()
+	if err != nil {
+		content = item.RawContent()
+	}
+
+	name := ""
+	if feed != nil {
+		name = feed.Title
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var feedID int64
+	err = tx.QueryRow(`INSERT INTO feeds (url, name, last_fetched) VALUES (?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET name = excluded.name, last_fetched = excluded.last_fetched
+		RETURNING id`, feedURL, name, now).Scan(&feedID)
+	if err != nil {
+		return fmt.Errorf("failed to store feed %s: %w", feedURL, err)
+	}
+
+	var itemID int64
+	err = tx.QueryRow(`INSERT INTO items (feed_id, guid, title, link, author, content, published_at, delivered_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (feed_id, guid) DO UPDATE SET
+			title = excluded.title,
+			link = excluded.link,
+			author = excluded.author,
+			content = excluded.content,
+			published_at = excluded.published_at,
+			delivered_at = excluded.delivered_at
+		RETURNING id`,
+		feedID, guid, item.Title, item.Link, author, content, published, now).Scan(&itemID)
+	if err != nil {
+		return fmt.Errorf("failed to store item %s: %w", guid, err)
+	}
+
+	// Replace the categories, which might have changed.
+	_, err = tx.Exec(`DELETE FROM categories WHERE item_id = ?`, itemID)
+	if err != nil {
+		return err
+	}
+	for _, category := range item.Categories {
+		_, err = tx.Exec(`INSERT INTO categories (item_id, name) VALUES (?, ?)`, itemID, category)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close is part of the Output interface.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,114 @@
+package output
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/withstate"
+)
+
+// TestSQLite tests storing, and updating, an item.
+func TestSQLite(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "feeds.db")
+
+	s, err := NewSQLite(path, true)
+	if err != nil {
+		t.Fatalf("failed to create database: %s", err)
+	}
+	defer s.Close()
+
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	feed := &gofeed.Feed{Title: "Example Feed"}
+	item := withstate.FeedItem{Item: &gofeed.Item{
+		GUID:            "guid-1",
+		Title:           "First title",
+		Link:            "https://example.com/1",
+		Content:         "<p>Some kittens</p>",
+		Author:          &gofeed.Person{Name: "Steve"},
+		Categories:      []string{"cats", "pets"},
+		PublishedParsed: &published,
+	}}
+
+	err = s.Deliver("https://example.com/feed", feed, item)
+	if err != nil {
+		t.Fatalf("failed to deliver: %s", err)
+	}
+
+	// Deliver it again, with changes.
+	item.Title = "Updated title"
+	item.Categories = []string{"cats"}
+
+	err = s.Deliver("https://example.com/feed", feed, item)
+	if err != nil {
+		t.Fatalf("failed to deliver: %s", err)
+	}
+
+	var count int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&count)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one item, got %d (%v)", count, err)
+	}
+
+	var title, author, pub, name string
+	err = s.db.QueryRow(`SELECT items.title, items.author, items.published_at, feeds.name
+		FROM items JOIN feeds ON items.feed_id = feeds.id`).Scan(&title, &author, &pub, &name)
+	if err != nil {
+		t.Fatalf("failed to query item: %s", err)
+	}
+	if title != "Updated title" || author != "Steve" || name != "Example Feed" {
+		t.Fatalf("unexpected item: %s %s %s", title, author, name)
+	}
+	if pub != "2024-01-02T03:04:05Z" {
+		t.Fatalf("unexpected publish date: %s", pub)
+	}
+
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM categories`).Scan(&count)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one category, got %d (%v)", count, err)
+	}
+
+	// The full-text search should find the updated item.
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM items_fts WHERE items_fts MATCH 'updated'`).Scan(&count)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one search result, got %d (%v)", count, err)
+	}
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM items_fts WHERE items_fts MATCH 'first'`).Scan(&count)
+	if err != nil || count != 0 {
+		t.Fatalf("expected no search results, got %d (%v)", count, err)
+	}
+}
+
+// TestSQLiteReopen ensures that an existing database can be reopened.
+func TestSQLiteReopen(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "feeds.db")
+
+	for i := 0; i < 2; i++ {
+		s, err := NewSQLite(path, false)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err)
+		}
+		s.Close()
+	}
+}
+
+// TestNew tests parsing output specifications.
+func TestNew(t *testing.T) {
+
+	for _, spec := range []string{"", "sqlite", "sqlite:", "unknown:/tmp/foo"} {
+		_, err := New(spec, Options{})
+		if err == nil {
+			t.Fatalf("expected error for '%s'", spec)
+		}
+	}
+
+	o, err := New("sqlite:"+filepath.Join(t.TempDir(), "feeds.db"), Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	o.Close()
+}
@@ -0,0 +1,154 @@
+package processor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/state"
+)
+
+// patternFilePath returns the path to a pattern file, as specified by a
+// per-feed option.
+//
+// Relative paths are taken to be relative to our state directory, in the
+// same way as templates.
+func patternFilePath(value string) string {
+	value = strings.TrimSpace(value)
+	if filepath.IsAbs(value) {
+		return value
+	}
+	return filepath.Join(state.Directory(), value)
+}
+
+// loadPatternFile reads the regular expressions from the given file, one
+// per line.
+//
+// Blank lines, and lines beginning with "#", are ignored.  Patterns which
+// fail to compile are reported and skipped, so that a single typo doesn't
+// disable the whole list.
+func loadPatternFile(logger *slog.Logger, path string) ([]*regexp.Regexp, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []*regexp.Regexp
+
+	line := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line++
+
+		txt := strings.TrimSpace(scanner.Text())
+		if txt == "" || strings.HasPrefix(txt, "#") {
+			continue
+		}
+
+		re, err := regexp.Compile(txt)
+		if err != nil {
+			logger.Warn("ignoring invalid regular expression in pattern file",
+				slog.String("path", path),
+				slog.Int("line", line),
+				slog.String("error", err.Error()))
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+
+	return patterns, scanner.Err()
+}
+
+// patternListOptions are the per-feed options whose values name a file of
+// regular expressions.
+var patternListOptions = map[string]bool{
+	"exclude-author-list-file": true,
+	"exclude-title-list-file":  true,
+	"include-author-list-file": true,
+}
+
+// loadPatternLists reads every file named by an option which refers to a
+// list of patterns, such as "exclude-title-list-file", so that they're
+// ready for use when the feeds are processed.
+//
+// Errors are returned for files which could not be read, but the feeds
+// using them are still processed.
+func (p *Processor) loadPatternLists(entries []configfile.Feed) []error {
+
+	var errs []error
+
+	p.patternLists = make(map[string][]*regexp.Regexp)
+
+	for _, entry := range entries {
+		for _, opt := range entry.Options {
+			if !patternListOptions[opt.Name] {
+				continue
+			}
+
+			path := patternFilePath(opt.Value)
+			if _, ok := p.patternLists[path]; ok {
+				continue
+			}
+
+			patterns, err := loadPatternFile(p.logger, path)
+			if err != nil {
+				p.logger.Error("failed to load pattern file",
+					slog.String("option", opt.Name),
+					slog.String("path", path),
+					slog.String("error", err.Error()))
+
+				errs = append(errs, &FeedError{
+					FeedURL: entry.URL,
+					Phase:   PhaseFilter,
+					Cause:   fmt.Errorf("failed to load %s: %w", opt.Name, err),
+				})
+			} else {
+				p.logger.Debug("loaded pattern file",
+					slog.String("option", opt.Name),
+					slog.String("path", path),
+					slog.Int("patterns", len(patterns)))
+			}
+
+			p.patternLists[path] = patterns
+		}
+	}
+
+	return errs
+}
+
+// patternList returns the patterns from the file named by the given
+// option, loading them if they've not already been loaded.
+func (p *Processor) patternList(logger *slog.Logger, opt configfile.Option) []*regexp.Regexp {
+
+	path := patternFilePath(opt.Value)
+
+	p.shared.Lock()
+	defer p.shared.Unlock()
+
+	patterns, ok := p.patternLists[path]
+	if ok {
+		return patterns
+	}
+
+	patterns, err := loadPatternFile(logger, path)
+	if err != nil {
+		logger.Error("failed to load pattern file",
+			slog.String("option", opt.Name),
+			slog.String("path", path),
+			slog.String("error", err.Error()))
+	}
+
+	if p.patternLists == nil {
+		p.patternLists = make(map[string][]*regexp.Regexp)
+	}
+	p.patternLists[path] = patterns
+
+	return patterns
+}
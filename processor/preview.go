@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/k3a/html2text"
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/withstate"
+)
+
+// Preview returns the email which would be sent to the given recipient
+// for an item from the feed, rendered with the same template, tag, and
+// content-transformations as a real delivery, without sending it or
+// updating any state.
+func (p *Processor) Preview(entry configfile.Feed, feed *gofeed.Feed, xp *gofeed.Item, recipient string) ([]byte, error) {
+
+	err := p.loadFeedTemplates([]configfile.Feed{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	applyFeedName(entry, feed)
+
+	item := withstate.FeedItem{Item: xp, FeedURL: entry.URL}
+	if feed != nil {
+		item.FeedName = feed.Title
+	}
+	for _, opt := range entry.Options {
+		if strings.ToLower(opt.Name) == "tag" {
+			item.Tag = opt.Value
+		}
+	}
+
+	content := transformContent(p.logger, entry, itemContent(item))
+
+	helper := p.newEmailer(entry, feed, item, p.logger)
+
+	return helper.Render(recipient, html2text.HTML2Text(content), content)
+}
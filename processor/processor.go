@@ -9,23 +9,34 @@
 package processor
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/mail"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/k3a/html2text"
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/config"
 	"github.com/skx/rss2email/configfile"
 	"github.com/skx/rss2email/httpfetch"
 	"github.com/skx/rss2email/processor/emailer"
+	"github.com/skx/rss2email/processor/output"
 	"github.com/skx/rss2email/state"
 	"github.com/skx/rss2email/withstate"
-	"go.etcd.io/bbolt"
+	"golang.org/x/time/rate"
 )
 
 // Processor stores our state
@@ -34,50 +45,438 @@ type Processor struct {
 	// send controls whether we send emails, or just pretend to.
 	send bool
 
-	// database holds a handle to the BoltDB database we use to
-	// store feed-entry state within.
-	dbHandle *bbolt.DB
+	// dryRun receives the emails we'd send, instead of them being
+	// sent, if non-nil.  Nothing is recorded in our state.
+	dryRun *emailer.Writer
+
+	// emails receives our emails, instead of them being sent, if
+	// non-nil.  Unlike a dry-run the items are recorded as seen.
+	emails *emailer.Writer
+
+	// store holds the state of each feed, which is usually a BoltDB
+	// database, or a SQLite one if we were given a StateDB.
+	store state.Store
+
+	// lock is the file we hold a lock on while our state is in use,
+	// if any.  lockErr is the reason we don't have one, if locking
+	// isn't supported, which we warn about.
+	lock    *os.File
+	lockErr error
+
+	// tempState is the path to our database, if it is a temporary
+	// one which should be removed when we're closed.
+	tempState string
+
+	// smtp holds the SMTP settings to use, if they were given to us,
+	// rather than those in the application configuration.
+	smtp *config.SMTPConfig
 
 	// logger stores the logging dbHandle.
 	logger *slog.Logger
 
+	// explain causes the decisions of our filters to be logged at
+	// info level, rather than debug level.
+	explain bool
+
 	// version stores the version of our application.
 	version string
 
 	// defaultFrom stores the default from address for emails.
 	defaultFrom string
+
+	// backfill holds the number of items we send from a feed the
+	// first time we see it, if non-zero.
+	backfill int
+
+	// feeds holds the list of feeds to process, if it has been set
+	// explicitly.  When nil the configuration file is parsed at the
+	// start of each run instead.
+	feeds []configfile.Feed
+
+	// output receives new items instead of them being emailed, if
+	// it is non-nil.
+	output output.Output
+
+	// template is the email template to use, if it has been set
+	// explicitly.
+	template *template.Template
+
+	// limiter restricts the rate at which items are delivered.
+	limiter *rate.Limiter
+
+	// patternLists holds the compiled contents of each file named by
+	// an option such as "exclude-title-list-file", keyed by path.
+	patternLists map[string][]*regexp.Regexp
+
+	// feedTemplates holds the compiled email templates named by an
+	// "email-body-template-file" option, keyed by path.
+	feedTemplates map[string]*template.Template
+
+	// subjectTemplates caches the compiled "subject-template" options,
+	// keyed by their value.  Those which failed to compile are nil.
+	subjectTemplates map[string]*template.Template
+
+	// bodyTemplates caches the compiled "body-template" options, keyed
+	// by their value.  Those which failed to load are nil.
+	bodyTemplates map[string]*template.Template
+
+	// maxEmails is the number of items one run may deliver, zero means
+	// there is no limit, and dispatched counts those this run has.
+	maxEmails  int
+	dispatched int
+
+	// proxy is the default proxy feeds are fetched through.
+	proxy string
+
+	// webhookURL is the default URL new items are POSTed to, and
+	// webhookSecret the key they're signed with, if any.
+	webhookURL    string
+	webhookSecret string
+
+	// stats holds the metrics of each feed from the most recent run,
+	// keyed by feed URL.
+	stats map[string]*FeedStatistics
+
+	// metrics receives the statistics of each run, if non-nil.
+	metrics MetricsRecorder
+
+	// statusPath is the file we record the status of each complete
+	// run in, if any.
+	statusPath string
+
+	// circuitThreshold is the number of consecutive failures after
+	// which a feed isn't fetched, until circuitReset has passed since
+	// the last of them.  Zero disables this.
+	circuitThreshold int
+	circuitReset     time.Duration
+
+	// sender delivers our emails, such as into a Maildir, instead of
+	// them being sent via SMTP or sendmail, if non-nil.
+	sender emailer.Sender
+
+	// pool holds the SMTP connections we reuse, if pooling is enabled.
+	pool *emailer.Pool
+
+	// clock returns the current time, it is time.Now except when
+	// replaced by SetClock in our tests.
+	clock func() time.Time
+
+	// recipients are the addresses ProcessOnce sends new items to.
+	recipients []string
+
+	// templateVars are the values available to email templates as
+	// ".Vars".
+	templateVars map[string]any
+
+	// resetOnConfigChange causes the state of feeds whose options
+	// have changed to be cleared.
+	resetOnConfigChange bool
+
+	// running is held while ProcessFeeds runs, so that ReloadFeeds
+	// doesn't replace the feeds part-way through a run.
+	running sync.Mutex
+
+	// concurrency is the number of feeds which are processed at once.
+	concurrency int
+
+	// shared guards the values our workers share while processing
+	// feeds: stats, dispatched, patternLists, subjectTemplates, and
+	// bodyTemplates.
+	shared sync.Mutex
+
+	// delivering is held while an item is written to our output, as
+	// outputs needn't support concurrent deliveries.
+	delivering sync.Mutex
 }
 
-// New creates a new Processor object.
+// New creates a new Processor object, with the given settings.
 //
-// This might return an error if we fail to open the database we use
-// for maintaining state.
-func New() (*Processor, error) {
+// This might return an error if the settings are invalid, or if we fail
+// to open the database we use for maintaining state.
+func New(cfg ProcessorConfig) (*Processor, error) {
 
-	// Ensure we have a state-directory.
-	dir := state.Directory()
-	errM := os.MkdirAll(dir, 0755)
-	if errM != nil {
-		return nil, errM
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup the Maildir, or mbox, we deliver to, if any.
+	var sender emailer.Sender
+	if cfg.Maildir != "" {
+		sender, err = emailer.NewMaildir(cfg.Maildir)
+	} else if cfg.Mbox != "" {
+		sender, err = emailer.NewMbox(cfg.Mbox)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a temporary state database, if we weren't given a path.
+	path := cfg.StatePath
+	if cfg.StateDB != "" {
+		path = cfg.StateDB
+	}
+	temporary := ""
+	if path == "" {
+		tmp, err := os.CreateTemp("", "rss2email-state-*.db")
+		if err != nil {
+			return nil, err
+		}
+		tmp.Close()
+
+		path = tmp.Name()
+		temporary = path
+	}
+
+	// Ensure we have a state-directory, unless our state is only
+	// held in memory.
+	if path != ":memory:" {
+		errM := os.MkdirAll(filepath.Dir(path), 0755)
+		if errM != nil {
+			return nil, errM
+		}
+	}
+
+	// Make sure no other process is using the same state, as we'd
+	// both see, and send, the same new items.  Temporary state
+	// can't be shared.
+	var lock *os.File
+	var lockErr error
+	if temporary == "" && path != ":memory:" {
+		timeout := cfg.LockTimeout
+		if timeout == 0 {
+			timeout = defaultLockTimeout
+		}
+
+		lock, lockErr = acquireLock(path+".lock", timeout)
+		if lockErr != nil && !errors.Is(lockErr, errLockUnsupported) {
+			return nil, lockErr
+		}
 	}
 
 	// Now create the database, if missing, or open it if it exists.
-	db, err := bbolt.Open(filepath.Join(dir, "state.db"), 0666, nil)
+	var store state.Store
+	if cfg.StateDB != "" {
+		store, err = state.OpenSQLite(path)
+	} else {
+		store, err = state.OpenBolt(path)
+	}
 	if err != nil {
+		if temporary != "" {
+			os.Remove(temporary)
+		}
+		if lock != nil {
+			lock.Close()
+		}
 		return nil, err
 	}
 
-	return &Processor{send: true, dbHandle: db}, nil
+	// Setup the rate limit, if any.
+	limit := rate.Inf
+	if cfg.RateLimit > 0 {
+		limit = rate.Limit(cfg.RateLimit)
+	}
+	burst := cfg.RateBurst
+	if burst == 0 {
+		burst = 1
+	}
+
+	circuitReset := cfg.CircuitBreakerReset
+	if circuitReset == 0 {
+		circuitReset = defaultCircuitBreakerReset
+	}
+
+	// Setup the SMTP connection pool, which holds a single connection
+	// unless we're told otherwise.  Connections are only made once an
+	// email is sent.
+	poolSize := cfg.SMTPPoolSize
+	if poolSize == 0 {
+		poolSize = 1
+	}
+	pool := emailer.NewPool(poolSize, cfg.SMTPIdleTimeout)
+
+	return &Processor{
+		pool:        pool,
+		sender:      sender,
+		send:        cfg.Send,
+		limiter:     rate.NewLimiter(limit, burst),
+		store:       store,
+		lock:        lock,
+		lockErr:     lockErr,
+		tempState:   temporary,
+		defaultFrom: cfg.DefaultFrom,
+		smtp:        cfg.SMTP,
+		backfill:    cfg.Backfill,
+		version:     cfg.Version,
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		clock:       time.Now,
+		recipients:  cfg.Recipients,
+
+		templateVars:        cfg.TemplateVariables,
+		resetOnConfigChange: cfg.ResetOnConfigChange,
+		maxEmails:           cfg.MaxEmailsPerRun,
+		proxy:               cfg.Proxy,
+		concurrency:         cfg.Concurrency,
+		statusPath:          cfg.StatusPath,
+		webhookURL:          cfg.WebhookURL,
+		webhookSecret:       cfg.WebhookSecret,
+		circuitThreshold:    cfg.CircuitBreakerThreshold,
+		circuitReset:        circuitReset,
+	}, nil
 }
 
 // Close should be called to cleanup our internal database-handle.
 func (p *Processor) Close() {
-	p.dbHandle.Close()
+	p.store.Close()
+
+	if p.lock != nil {
+		p.lock.Close()
+	}
+
+	if p.pool != nil {
+		p.pool.Close()
+	}
+
+	if p.tempState != "" {
+		os.Remove(p.tempState)
+	}
+}
+
+// ProcessOnce processes all the feeds a single time, sending new items to
+// the recipients given in our ProcessorConfig, and returns an error which
+// summarizes any failures.
+//
+// The statistics of any previous run are discarded, and the state is
+// flushed to disk before we return, so ProcessOnce may be called again
+// on the same Processor, as a cron-job would, and the next run will see
+// the items this one recorded.
+//
+// If the context is cancelled we stop before the next feed.  In that case
+// the state of the feeds we didn't reach is left alone.
+//
+// Runs never overlap, but the Set-methods are not synchronized, so a
+// Processor should not be used from several goroutines at once without
+// external synchronization.
+func (p *Processor) ProcessOnce(ctx context.Context) error {
+
+	errs := p.processFeeds(ctx, p.recipients)
+
+	err := p.store.Sync()
+	if err != nil {
+		errs = append(errs, &FeedError{Phase: PhaseState, Cause: fmt.Errorf("failed to flush state: %w", err)})
+	}
+
+	return errors.Join(errs...)
 }
 
 // ProcessFeeds is the main workhorse here, we process each feed and send
 // emails appropriately.
 func (p *Processor) ProcessFeeds(recipients []string) []error {
+	return p.processFeeds(context.Background(), recipients)
+}
+
+// ProcessResult summarizes the processing of a single feed by ProcessFeed.
+type ProcessResult struct {
+
+	// ItemsTotal is the number of items in the remote feed.
+	ItemsTotal int
+
+	// ItemsSent is the number of items delivered successfully.
+	ItemsSent int
+
+	// ItemsSkipped is the number of new items which were excluded by
+	// the filtering options of the feed.
+	ItemsSkipped int
+
+	// Errors are the problems we found processing the feed, which
+	// will be *FeedErrors if they're specific to it.
+	Errors []error
+}
+
+// ProcessFeed processes the given feed alone, delivering its new items to
+// the recipients of its "notify" option, or those of our configuration,
+// and returns a summary of what happened.
+//
+// It is the same processing ProcessFeeds applies to each of its feeds,
+// so callers can schedule feeds as they wish.  The feed's statistics
+// replace any it had from a previous run, but unlike ProcessFeeds the
+// state of other feeds is never pruned.
+//
+// The returned error joins the Errors of the result.  If the context is
+// cancelled we stop before the next item, and the rest wait for a later
+// run.  Calls never overlap, with each other or with ProcessFeeds.
+func (p *Processor) ProcessFeed(ctx context.Context, entry configfile.Feed) (ProcessResult, error) {
+
+	var result ProcessResult
+
+	if ctx.Err() != nil {
+		result.Errors = []error{ctx.Err()}
+		return result, ctx.Err()
+	}
+
+	// Prevent our feeds being replaced until we're done.
+	p.running.Lock()
+	defer p.running.Unlock()
+
+	// Discard the statistics of any previous run of this feed.
+	p.shared.Lock()
+	delete(p.stats, entry.URL)
+	p.shared.Unlock()
+
+	// Load the templates, and lists of patterns, the feed uses.
+	p.forgetBodyTemplateFiles()
+	err := p.loadFeedTemplates([]configfile.Feed{entry})
+	if err != nil {
+		result.Errors = []error{err}
+		return result, err
+	}
+	result.Errors = p.loadPatternLists([]configfile.Feed{entry})
+
+	err = p.checkFingerprint(entry)
+	if err != nil {
+		result.Errors = append(result.Errors, &FeedError{
+			FeedURL: entry.URL,
+			Phase:   PhaseState,
+			Cause:   fmt.Errorf("error recording fingerprint: %s", err),
+		})
+		return result, errors.Join(result.Errors...)
+	}
+
+	err = p.processFeed(ctx, entry, p.feedRecipients(entry, p.recipients))
+	if err != nil {
+		result.Errors = append(result.Errors, err)
+	}
+	if ctx.Err() != nil {
+		result.Errors = append(result.Errors, ctx.Err())
+	}
+
+	stats := p.feedStatistics(entry.URL)
+	result.ItemsTotal = stats.ItemsFetched
+	result.ItemsSent = stats.ItemsSent
+	for _, n := range stats.ItemsSkippedByFilter {
+		result.ItemsSkipped += n
+	}
+
+	return result, errors.Join(result.Errors...)
+}
+
+// feedRecipients returns the addresses the new items of the given feed
+// are sent to, which are those of its "notify" option, if it has one,
+// otherwise the given defaults.
+func (p *Processor) feedRecipients(entry configfile.Feed, recipients []string) []string {
+
+	feedRecipients := recipients
+	for _, opt := range entry.Options {
+		if opt.Name == "notify" || opt.Name == "to" {
+			feedRecipients = recipientsOption(p.logger, opt, recipients)
+		}
+	}
+	return feedRecipients
+}
+
+// processFeeds implements ProcessFeeds, and ProcessOnce, stopping early
+// if the context is cancelled.
+func (p *Processor) processFeeds(ctx context.Context, recipients []string) []error {
 
 	//
 	// If we receive errors we'll store them here,
@@ -85,18 +484,55 @@ func (p *Processor) ProcessFeeds(recipients []string) []error {
 	//
 	var errors []error
 
-	// Get the configuration-file
-	conf := configfile.New()
+	// Prevent our feeds being replaced until we're done.
+	p.running.Lock()
+	defer p.running.Unlock()
+
+	// Use the feeds we were given, if any.
+	entries := p.feeds
+	var err error
+
+	// Otherwise parse the configuration-file
+	if entries == nil {
+		conf := configfile.New()
+
+		entries, err = conf.Parse()
+		if err != nil {
+			p.logger.Error("failed to parse configuration file",
+				slog.String("configfile", conf.Path()),
+				slog.String("error", err.Error()))
+			return errors
+		}
+	}
+
+	// Without a lock another process might send the same items.
+	if p.lockErr != nil {
+		p.logger.Warn("state is not locked, concurrent runs may send duplicate emails",
+			slog.String("error", p.lockErr.Error()))
+	}
+
+	// Discard the statistics of any previous run.
+	p.shared.Lock()
+	p.stats = make(map[string]*FeedStatistics)
+	p.dispatched = 0
+	p.shared.Unlock()
+
+	// Report the statistics of this run, however it ends.
+	defer p.recordMetrics(p.clock())
 
-	// Now do the parsing
-	entries, err := conf.Parse()
+	// Load any per-feed templates, failing to do so is fatal.  Body
+	// templates in files are read again, in case they've changed.
+	p.forgetBodyTemplateFiles()
+	err = p.loadFeedTemplates(entries)
 	if err != nil {
-		p.logger.Error("failed to parse configuration file",
-			slog.String("configfile", conf.Path()),
+		p.logger.Error("failed to load email template",
 			slog.String("error", err.Error()))
-		return errors
+		return append(errors, err)
 	}
 
+	// Load any lists of patterns the feeds use.
+	errors = append(errors, p.loadPatternLists(entries)...)
+
 	// Keep track of the previous hostname from which we fetched a feed
 	prev := ""
 
@@ -107,48 +543,60 @@ func (p *Processor) ProcessFeeds(recipients []string) []error {
 	p.logger.Debug("about to process feeds",
 		slog.Int("feed_count", len(entries)))
 
+	// Have we been cancelled part-way through?
+	cancelled := false
+
+	// The feeds are processed by a pool of workers, reporting their
+	// errors by the position of the feed, so that they're returned in
+	// the same order however many workers we have.
+	results := make([]error, len(entries))
+	jobs := make(chan feedJob)
+	var wg sync.WaitGroup
+	for i := 0; i < max(p.concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results[job.index] = p.runFeed(ctx, job)
+			}
+		}()
+	}
+
+	// An error with our state stops us from starting any more feeds.
+	var stateErr error
+
 	// For each feed contained in the configuration file
-	for _, entry := range entries {
+	for i, entry := range entries {
+
+		if ctx.Err() != nil {
+			cancelled = true
+			break
+		}
 
 		p.logger.Debug("starting to process feed",
 			slog.String("feed", entry.URL))
 
-		// Create a bucket to hold the entry-state here,
-		// if we've not done so previously.
-		//
-		// We do this because we store the "seen" vs "unseen"
-		// state in BoltDB database.
-		//
-		// BoltDB has a concept of "Buckets", which contain
-		// key=value entries.
-		//
-		// Since we process feeds it seems logical to create
-		// a bucket for each Feed URL, and then store the
-		// URLs we've seen with a random value.
-		//
-		err = p.dbHandle.Update(func(tx *bbolt.Tx) error {
-			_, err2 := tx.CreateBucketIfNotExists([]byte(entry.URL))
-			if err2 != nil {
-				return fmt.Errorf("create bucket failed: %s", err)
-			}
-			return nil
-		})
+		// Record the URL of the feed in our list,
+		// which is used for reaping obsolete feeds
+		feeds = append(feeds, entry.URL)
 
-		// If we have a DB-error then we return, this shouldn't happen.
+		// Have the options of the feed changed since we last
+		// processed it?
+		err = p.checkFingerprint(entry)
 		if err != nil {
 
-			p.logger.Error("error creating bucket",
+			p.logger.Error("error recording fingerprint of feed",
 				slog.String("feed", entry.URL),
 				slog.String("error", err.Error()))
 
-			errors = append(errors, fmt.Errorf("error creating bucket for %s: %s", entry.URL, err))
-			return (errors)
+			stateErr = &FeedError{
+				FeedURL: entry.URL,
+				Phase:   PhaseState,
+				Cause:   fmt.Errorf("error recording fingerprint: %s", err),
+			}
+			break
 		}
 
-		// Record the URL of the feed in our list,
-		// which is used for reaping obsolete feeds
-		feeds = append(feeds, entry.URL)
-
 		// Should we sleep before getting this feed?
 		sleep := 0
 
@@ -156,7 +604,7 @@ func (p *Processor) ProcessFeeds(recipients []string) []error {
 		//
 		// But there might be a per-feed set of recipients which
 		// we'll prefer if available.
-		feedRecipients := recipients
+		feedRecipients := p.feedRecipients(entry, recipients)
 
 		// parse the hostname form the URL
 		//
@@ -185,18 +633,6 @@ func (p *Processor) ProcessFeeds(recipients []string) []error {
 		// Now look at each per-feed option, if any are set.
 		for _, opt := range entry.Options {
 
-			// Is it a set of recipients?
-			if opt.Name == "notify" {
-
-				// Save the values
-				feedRecipients = strings.Split(opt.Value, ",")
-
-				// But trim leading/trailing space
-				for i := range feedRecipients {
-					feedRecipients[i] = strings.TrimSpace(feedRecipients[i])
-				}
-			}
-
 			// Sleep setting?
 			if opt.Name == "sleep" {
 
@@ -217,49 +653,128 @@ func (p *Processor) ProcessFeeds(recipients []string) []error {
 			}
 		}
 
-		// If we're supposed to sleep, do so
-		if sleep != 0 {
+		// Hand the feed to the next free worker.
+		jobs <- feedJob{index: i, entry: entry, recipients: feedRecipients, sleep: sleep}
 
-			p.logger.Debug("sleeping",
-				slog.Int("sleep", sleep))
+		// Now update with our current host.
+		prev = host
+	}
 
-			time.Sleep(time.Duration(sleep) * time.Second)
-		}
+	// Wait for the workers to finish the feeds they've started.
+	close(jobs)
+	wg.Wait()
 
-		// Process this specific entry.
-		err = p.processFeed(entry, feedRecipients)
+	for _, err := range results {
 		if err != nil {
-			errors = append(errors, fmt.Errorf("error processing %s - %s", entry.URL, err))
+			errors = append(errors, err)
 		}
-
-		// Now update with our current host.
-		prev = host
+	}
+	if stateErr != nil {
+		return append(errors, stateErr)
 	}
 
-	// Reap feeds which are obsolete.
-	err = p.pruneUnknownFeeds(feeds)
-	if err != nil {
+	// Reap feeds which are obsolete, unless we might not have reached
+	// them all in which case we can't tell.
+	if cancelled || ctx.Err() != nil {
+		p.logger.Warn("processing cancelled",
+			slog.String("error", ctx.Err().Error()))
 
-		p.logger.Warn("failed to prune unknown feeds",
-			slog.String("error", err.Error()))
+		errors = append(errors, &FeedError{Phase: PhaseFetch, Cause: ctx.Err(), Retryable: true})
+	} else {
+		err = p.pruneUnknownFeeds(feeds)
+		if err != nil {
+
+			p.logger.Warn("failed to prune unknown feeds",
+				slog.String("error", err.Error()))
+
+			errors = append(errors, &FeedError{Phase: PhaseState, Cause: err})
+		}
 
-		errors = append(errors, err)
+		// Record that we reached every feed, for health-checks.
+		err = p.writeStatus()
+		if err != nil {
+			p.logger.Warn("failed to write status file",
+				slog.String("path", p.statusPath),
+				slog.String("error", err.Error()))
+		}
 	}
 
 	// We're about to process the feeds.
 	p.logger.Debug("all feeds processed",
 		slog.Int("feed_count", len(entries)))
 
+	p.logStatistics()
+
+	// Let the user know if there's more to come.
+	deferred := 0
+	for _, s := range p.stats {
+		deferred += s.ItemsDeferred
+	}
+	if deferred > 0 && p.maxEmails > 0 && p.dispatched >= p.maxEmails {
+		p.logger.Info("reached max-emails-per-run, remaining items will be sent on a later run",
+			slog.Int("max-emails-per-run", p.maxEmails),
+			slog.Int("deferred", deferred))
+	}
+
 	// All feeds were processed, return any errors we found along the way
 	return errors
 }
 
+// feedJob is a feed which is ready to be processed by one of the workers
+// started by processFeeds.
+type feedJob struct {
+
+	// index is the position of the feed in our list.
+	index int
+
+	// entry is the feed, and its options.
+	entry configfile.Feed
+
+	// recipients are the addresses the feed's new items are sent to.
+	recipients []string
+
+	// sleep is the number of seconds to wait before fetching the feed.
+	sleep int
+}
+
+// runFeed processes the feed of the given job, after sleeping if it
+// should, and returns any error.
+//
+// If the context is cancelled while we're sleeping the feed is skipped,
+// and processFeeds reports the cancellation.
+func (p *Processor) runFeed(ctx context.Context, job feedJob) error {
+
+	// If we're supposed to sleep, do so
+	if job.sleep != 0 {
+
+		p.logger.Debug("sleeping",
+			slog.String("feed", job.entry.URL),
+			slog.Int("sleep", job.sleep))
+
+		select {
+		case <-time.After(time.Duration(job.sleep) * time.Second):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	// Process this specific entry.
+	//
+	// Any error here will already be a FeedError.
+	return p.processFeed(ctx, job.entry, job.recipients)
+}
+
 // processFeed takes a configuration entry as input, fetches the appropriate
 // remote contents, and then processes each feed item found within it.
 //
 // Feed items which are new/unread will generate an email, unless they are
 // specifically excluded by the per-feed options.
-func (p *Processor) processFeed(entry configfile.Feed, recipients []string) error {
+//
+// If the context is cancelled the remaining items are left for a later
+// run.
+//
+// Any error returned will be a *FeedError.
+func (p *Processor) processFeed(ctx context.Context, entry configfile.Feed, recipients []string) error {
 
 	// Create a local logger with some dedicated information
 	logger := p.logger.With(
@@ -269,16 +784,61 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 	// Is there a tag set for this feed?
 	tag := ""
 
+	// Are we checking a mailbox for items which were already read?
+	var checker *seenChecker
+
+	// Should the links of new items be checked before we send them?
+	verifyLinks := false
+
+	// Are the emails for this feed sent together, once we're done?
+	var batch *emailer.Batch
+
+	// Are the new items of this feed combined into a single email?
+	var pending *digest
+
 	// Look at each per-feed option to determine that
 	for _, opt := range entry.Options {
 		if strings.ToLower(opt.Name) == "tag" {
 			tag = opt.Value
 		}
+		if opt.Name == "imap-seen-check" {
+			checker = &seenChecker{url: opt.Value, logger: logger}
+		}
+		if opt.Name == "verify-item-link" {
+			val := strings.ToLower(opt.Value)
+			verifyLinks = val == "yes" || val == "true"
+		}
+		if opt.Name == "bulk-send-mode" {
+			val := strings.ToLower(opt.Value)
+			if (val == "yes" || val == "true") && p.dryRun == nil {
+				batch = emailer.NewBatch(logger)
+			}
+		}
+		if opt.Name == "digest" {
+			val := strings.ToLower(opt.Value)
+			if val == "yes" || val == "true" {
+				pending = &digest{}
+			}
+		}
+	}
+
+	// Record our metrics as we go.
+	stats := p.feedStatistics(entry.URL)
+
+	// Feeds which keep failing aren't tried for a while.
+	if p.circuitOpen(logger, entry.URL) {
+		stats.CircuitOpen = true
+		return nil
 	}
 
 	// Fetch the feed for the input URL
+	start := p.clock()
 	helper := httpfetch.New(entry, logger, p.version)
+	helper.SetDefaultProxy(p.proxy)
+	helper.SetReadOnly(p.dryRun != nil)
 	feed, err := helper.Fetch()
+	stats.FetchDurationMs = p.since(start)
+	p.recordFetch(logger, entry.URL, err)
 	if err != nil {
 
 		if err == httpfetch.ErrUnchanged {
@@ -288,9 +848,23 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 
 		logger.Error("failed to fetch feed",
 			slog.String("error", err.Error()))
-		return err
+
+		// Content which was retrieved but couldn't be parsed
+		// isn't going to get better by itself, but a network
+		// failure might.
+		if errors.Is(err, httpfetch.ErrParse) {
+			stats.FetchError = &FeedError{FeedURL: entry.URL, Phase: PhaseParse, Cause: err}
+		} else {
+			stats.FetchError = &FeedError{FeedURL: entry.URL, Phase: PhaseFetch, Cause: err, Retryable: true}
+		}
+		return stats.FetchError
 	}
 
+	stats.ItemsFetched = len(feed.Items)
+
+	// The user might prefer their own name for the feed.
+	applyFeedName(entry, feed)
+
 	// Show how many entries we've found in the feed.
 	logger.Debug("feed retrieved", slog.Int("entries", len(feed.Items)))
 
@@ -312,6 +886,16 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 	// Keep track of all the items in the feed.
 	items := []string{}
 
+	// Remove the tracking parameters from the links of the items,
+	// if we've been asked to, before anything looks at them.  The
+	// link is then what we record, and what we send.
+	stripUTM := enabledOption(entry, "strip-utm")
+	if stripUTM {
+		for _, xp := range feed.Items {
+			xp.Link = stripTracking(xp.Link)
+		}
+	}
+
 	//
 	// Issue #111 reported an example feed which
 	// contained duplicate URLs
@@ -341,15 +925,67 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 		seenDupes[str.Link]++
 	}
 
-	// For each entry in the feed ..
-	for _, xp := range feed.Items {
-
-		// If the feed contains duplicate entries
-		// then we try to uniquify them.
-		if dupes {
+	// If the feed contains duplicate entries
+	// then we try to uniquify them.
+	if dupes {
+		for _, xp := range feed.Items {
 			xp.Link += "#"
 			xp.Link += xp.GUID
 		}
+	}
+
+	// Items without a GUID are identified by their link, which
+	// should be the canonical one once it has been stripped.
+	if stripUTM {
+		for _, xp := range feed.Items {
+			if xp.GUID == "" {
+				xp.GUID = xp.Link
+			}
+		}
+	}
+
+	// How do we decide if items have been seen before?
+	mode := dedupMode(entry)
+
+	// If we're backfilling a brand new feed then work out which
+	// items we'll actually send.
+	//
+	// A nil map means we're not backfilling.
+	var backfill map[int]bool
+	if p.sending() && p.backfill > 0 && p.feedIsNew(entry.URL) {
+		backfill = p.backfillItems(entry, feed.Items, tag)
+
+		logger.Debug("backfilling new feed",
+			slog.Int("backfill", p.backfill),
+			slog.Int("selected", len(backfill)))
+	}
+
+	// Ensure we logout of any IMAP server we connected to.
+	if checker != nil {
+		defer checker.close()
+	}
+
+	// If we'll only process some of the new items then we process
+	// the oldest first, so that the newer items wait for a later run,
+	// otherwise we use the order of the feed.
+	maxItems := minimumOption(logger, entry, "max-items")
+	order := make([]int, len(feed.Items))
+	for i := range order {
+		order[i] = i
+	}
+	if maxItems > 0 {
+		oldestFirst(feed.Items, order)
+	}
+
+	// The number of new items we've processed, for max-items.
+	processed := 0
+
+	// Should items we've seen be sent again if they're updated?
+	resendUpdated := enabledOption(entry, "resend-updated")
+
+	// For each entry in the feed ..
+	for _, i := range order {
+		xp := feed.Items[i]
 
 		// Wrap the feed-item in a class of our own,
 		// so that we can get access to the content easily.
@@ -359,29 +995,73 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 		//
 		// We have some legacy code for determining "new" vs "seen",
 		// but that will go away in the future.
-		item := withstate.FeedItem{Item: xp}
+		item := withstate.FeedItem{Item: xp, FeedURL: entry.URL, FeedName: feed.Title}
 
 		// Set the tag for the item, if present.
 		if tag != "" {
 			item.Tag = tag
 		}
 
+		// The keys under which we record this item, which depend
+		// upon the deduplication options of the feed.
+		keys := stateKeys(mode, item)
+
 		// Keep track of the fact that we saw this feed-item.
 		//
 		// This is used for pruning the BoltDB state file.
-		items = append(items, item.Link)
+		items = append(items, keys...)
+
+		// If we've been cancelled the remaining items wait for a
+		// later run, they're neither sent nor recorded.
+		if ctx.Err() != nil {
+			continue
+		}
+
+		// Items outside the positions the user is interested in
+		// are ignored entirely, before they're even checked to see
+		// if they're new, so they'll be considered again if they
+		// move within them.
+		if p.shouldSkipByItemPosition(logger, entry, i, len(feed.Items)) {
+			stats.ItemsSkippedByFilter[FilterPosition]++
+			continue
+		}
 
 		// Assume this feed-entry is new, and we've not seen it
 		// in the past.
 		isNew := true
 
-		// Is this link already in the BoltDB?
+		// Should we avoid recording the item, so that it is
+		// delivered again next time?
+		retry := false
+
+		// Is this item already in the BoltDB?
 		//
 		// If so it's not new.
-		if p.seenItem(entry.URL, item.Link) {
+		if p.seenItemKeys(entry.URL, mode, item, keys) {
 			isNew = false
 		}
 
+		// An item we've seen is new again if it has been updated
+		// since we last saw it, and we've been asked to resend
+		// such items.  If we didn't record when it was updated
+		// it isn't, as we can't tell.
+		stamp := ""
+		previous := ""
+		updated := false
+		if resendUpdated {
+			stamp = updatedStamp(item)
+		}
+		if stamp != "" {
+			previous = p.recordedUpdated(entry.URL, keys[0])
+		}
+		if !isNew && previous != "" && previous != stamp {
+			logger.Debug("entry has been updated",
+				slog.String("title", item.Title),
+				slog.String("updated", stamp))
+			isNew = true
+			updated = true
+		}
+
 		// If this entry is new then we must notify, unless
 		// the entry is excluded for some reason.
 		if isNew {
@@ -395,47 +1075,154 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 				slog.String("link", item.Link))
 
 			// If we're supposed to send email then do that.
-			if p.send {
+			if p.sending() {
 
 				// Get the content of the feed-item.
 				//
 				// This has to be done ahead of sending email,
 				// as we can use this to skip entries via
 				// regular expression on the title/body contents.
-				content := ""
-				content, err = item.HTMLContent()
-				if err != nil {
-					content = item.RawContent()
-				}
+				content := transformContent(logger, entry, itemContent(item))
 
 				// Should we skip this entry?
 				//
 				// Skipping here means that we don't send an email,
 				// however we do mark it as read - so it will only
 				// be processed once.
+				filter := p.skipFilter(logger, entry, item, content)
+
+				// If we're backfilling then only the selected
+				// items are sent.
+				if filter == "" && backfill != nil && !backfill[i] {
+					logger.Debug("excluding entry due to backfill",
+						slog.String("item-title", item.Title))
+					filter = FilterBackfill
+				}
 
-				// check for regular expressions
-				skip := p.shouldSkip(logger, entry, item.Title, content)
+				// If the user has already read the email for
+				// this item, perhaps because our local state
+				// was lost, we'll not send it again.
+				if filter == "" && checker != nil && checker.seen(item) {
+					logger.Debug("excluding entry which has been read in IMAP mailbox",
+						slog.String("item-title", item.Title))
+					filter = FilterIMAP
+				}
 
-				// check for age (exclude-older)
-				skip = skip || p.shouldSkipOlder(logger, entry, item.Published)
+				// Links which are broken would make for a
+				// useless email, so we don't send them.  This
+				// is done last, as it costs a HTTP-request.
+				if filter == "" && verifyLinks && item.Link != "" {
+					err := helper.CheckLink(item.Link)
+					if err != nil {
+						logger.Warn("excluding entry with a broken link",
+							slog.String("item-title", item.Title),
+							slog.String("link", item.Link),
+							slog.String("error", err.Error()))
+						filter = FilterLink
+					}
+				}
 
-				// check for category filtering
-				skip = skip || p.shouldSkipCategory(logger, entry, item.Categories)
+				skip := filter != ""
+				if skip {
+					stats.ItemsSkippedByFilter[filter]++
+				}
 
+				// Items which are too new are skipped for
+				// now, but not recorded, so that they're
+				// delivered once they're old enough.
+				if filter == FilterNewer {
+					retry = true
+				}
+
+				// Once we've processed as many of this feed's
+				// items as we're allowed the remaining items
+				// wait for a later run.
+				if !skip && maxItems > 0 && processed >= maxItems {
+					logger.Debug("deferring entry due to max-items",
+						slog.String("item-title", item.Title),
+						slog.Int("max-items", maxItems))
+					stats.ItemsDeferred++
+					skip = true
+					retry = true
+				}
+
+				// Once we've sent as many emails as we're
+				// allowed the remaining items wait for a
+				// later run.  A digest is a single email,
+				// however many items it contains.
+				if !skip && !p.dispatch(pending == nil || pending.Len() == 0) {
+					logger.Debug("deferring entry due to max-emails-per-run",
+						slog.String("item-title", item.Title))
+					stats.ItemsDeferred++
+					skip = true
+					retry = true
+				}
+
+				if !skip {
+					processed++
+				}
+
+				// Updated items say so, once the filters
+				// have seen their real title.
+				if !skip && updated {
+					item.Title = updatedPrefix + item.Title
+				}
+
+				// Long content is truncated, and images are
+				// only fetched for the items we deliver, once
+				// the filters have passed them.
 				if !skip {
+					content = limitContent(logger, entry, item.Link, content)
+					content = inlineImages(logger, entry, helper, content)
+				}
+
+				// Time the delivery, however it happens.
+				start = p.clock()
+
+				// Items in a digest are sent together, once
+				// we've found them all.  Are we writing to an
+				// output, rather than sending email?
+				if !skip && pending != nil {
+					pending.add(item, content)
+				} else if !skip && p.output != nil {
+					p.waitForDelivery(logger)
+
+					err = p.deliverOutput(entry.URL, feed, item)
+					if err != nil {
+						sendErrors++
+						logger.Error("failed to write item to output, continuing with remaining items",
+							slog.String("title", item.Title),
+							slog.String("error", err.Error()))
+
+						// Some outputs want the item to be
+						// delivered again next time.
+						retry = errors.Is(err, output.ErrRetry)
+					} else {
+						sentCount++
+					}
+				} else if !skip {
 					// Throttle between sends when processing multiple
 					// new items, to avoid triggering provider rate limits.
-					if sentCount > 0 {
+					//
+					// Items which are batched aren't sent yet, so
+					// there's nothing to wait for, nor is there if
+					// emails are written locally.
+					if sentCount > 0 && batch == nil && !p.deliversLocally() {
 						time.Sleep(sendThrottleDelay)
 					}
 
 					// Convert the content to text.
 					text := html2text.HTML2Text(content)
 
+					p.waitForDelivery(logger)
+
 					// Send the mail
-					helper := emailer.New(feed, item, entry.Options, logger, p.defaultFrom)
-					err = helper.Sendmail(recipients, text, content)
+					helper := p.newEmailer(entry, feed, item, logger)
+					if batch != nil {
+						err = helper.Queue(batch, recipients, text, content)
+					} else {
+						err = helper.Sendmail(recipients, text, content)
+					}
 					if err != nil {
 
 						sendErrors++
@@ -452,6 +1239,24 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 						sentCount++
 					}
 				}
+
+				// Tell the webhook about the item, if there is
+				// one, however it was delivered.  Items which
+				// will be delivered again wait until then, so
+				// that the webhook only hears about them once.
+				if !skip && !retry {
+					err = p.postWebhook(logger, entry, item, content)
+					if err != nil {
+						stats.WebhookErrors++
+						logger.Error("failed to call webhook, continuing with remaining items",
+							slog.String("title", item.Title),
+							slog.String("error", err.Error()))
+					}
+				}
+
+				if !skip {
+					stats.DeliverDurationMs += p.since(start)
+				}
 			}
 		} else {
 
@@ -466,14 +1271,67 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 		// should not cause the item to be retried forever, potentially
 		// flooding the recipient on every subsequent poll cycle. One
 		// missed email is better than infinite duplicates.
-		err = p.recordItem(entry.URL, item.Link)
+		//
+		// The exception is outputs which ask for a retry, as
+		// nobody is flooded by those.  Nor is anything recorded
+		// by a dry-run.
+		if retry || p.dryRun != nil {
+			continue
+		}
+		for _, key := range keys {
+			err = p.recordItem(entry.URL, key)
+			if err != nil {
+				logger.Error("failed to mark item as processed",
+					slog.String("error", err.Error()))
+				return &FeedError{FeedURL: entry.URL, Phase: PhaseState, Cause: err, ItemGUID: item.GUID}
+			}
+		}
+		if stamp != "" && stamp != previous {
+			err = p.recordUpdated(entry.URL, keys[0], stamp)
+			if err != nil {
+				return &FeedError{FeedURL: entry.URL, Phase: PhaseState, Cause: err, ItemGUID: item.GUID}
+			}
+		}
+	}
+
+	// Send the digest of the new items, if there are any.
+	if pending != nil && pending.Len() > 0 {
+		start = p.clock()
+
+		err = p.deliverDigest(logger, entry, feed, tag, pending, recipients)
+		if err != nil {
+			sendErrors += pending.Len()
+			logger.Error("failed to send digest",
+				slog.Int("items", pending.Len()),
+				slog.String("recipients", strings.Join(recipients, ",")),
+				slog.String("error", err.Error()))
+		} else {
+			sentCount += pending.Len()
+		}
+
+		stats.DeliverDurationMs += p.since(start)
+	}
+
+	// Send any emails we batched up, over a single connection.
+	if batch != nil && batch.Len() > 0 {
+		start = p.clock()
+
+		failed, err := batch.Send()
 		if err != nil {
-			logger.Error("failed to mark item as processed",
+			sendErrors += failed
+			sentCount -= failed
+			logger.Error("failed to send batched emails",
+				slog.Int("failed", failed),
+				slog.String("recipients", strings.Join(recipients, ",")),
 				slog.String("error", err.Error()))
-			return err
 		}
+
+		stats.DeliverDurationMs += p.since(start)
 	}
 
+	stats.ItemsSent = sentCount
+	stats.ItemsAlreadySeen = seen
+
 	logger.Debug("feed processed",
 		slog.Int("seen_count", seen),
 		slog.Int("unseen_count", unseen),
@@ -487,37 +1345,246 @@ func (p *Processor) processFeed(entry configfile.Feed, recipients []string) erro
 		logger.Error("failed to prune bolddb",
 			slog.String("error", err.Error()))
 
-		return fmt.Errorf("error pruning boltdb for %s: %s", entry.URL, err)
+		return &FeedError{
+			FeedURL: entry.URL,
+			Phase:   PhaseState,
+			Cause:   fmt.Errorf("error pruning boltdb: %s", err),
+		}
 	}
 
 	// If there were send failures, return a summary error so the caller
 	// knows this feed had problems — but all items are still marked as
 	// seen to prevent retry storms.
 	if sendErrors > 0 {
-		return fmt.Errorf("feed %s: %d/%d emails failed to send", entry.URL, sendErrors, sentCount+sendErrors)
+		stats.DeliverError = &FeedError{
+			FeedURL: entry.URL,
+			Phase:   PhaseDeliver,
+			Cause:   fmt.Errorf("%d/%d emails failed to send", sendErrors, sentCount+sendErrors),
+		}
+		return stats.DeliverError
 	}
 
 	return nil
 }
 
-// seenItem returns true if we've seen this item.
+// dispatch returns true if another email may be sent by this run, which
+// is counted if count is set, or false if we've reached our limit.
+func (p *Processor) dispatch(count bool) bool {
+
+	if p.maxEmails == 0 {
+		return true
+	}
+
+	p.shared.Lock()
+	defer p.shared.Unlock()
+
+	if count {
+		if p.dispatched >= p.maxEmails {
+			return false
+		}
+		p.dispatched++
+	}
+
+	return true
+}
+
+// newEmailer returns an emailer for the given feed item, configured with
+// our templates and delivery settings.
+func (p *Processor) newEmailer(entry configfile.Feed, feed *gofeed.Feed, item withstate.FeedItem, logger *slog.Logger) *emailer.Emailer {
+
+	helper := emailer.New(feed, item, entry.Options, logger, p.defaultFrom)
+	if tmpl := p.feedTemplate(entry); tmpl != nil {
+		helper.SetTemplate(tmpl)
+	}
+	helper.SetVariables(p.templateVars)
+	if tmpl := p.subjectTemplate(entry); tmpl != nil {
+		helper.SetSubjectTemplate(tmpl)
+	}
+	if tmpl := p.bodyTemplate(entry); tmpl != nil {
+		helper.SetBodyTemplate(tmpl)
+	}
+	if p.smtp != nil {
+		helper.SetSMTP(*p.smtp)
+	}
+	if p.pool != nil {
+		helper.SetPool(p.pool)
+	}
+	if p.sender != nil {
+		helper.SetSender(p.sender)
+	}
+	if p.emails != nil {
+		helper.SetSender(p.emails.For(entry.URL))
+	}
+	if p.dryRun != nil {
+		helper.SetSender(p.dryRun.For(entry.URL))
+	}
+
+	return helper
+}
+
+// FilterItems returns the items from the given feed which would be sent,
+// according to the per-feed filtering options of the given entry.
 //
-// It does this by checking the BoltDB in which we record state.
-func (p *Processor) seenItem(feed string, entry string) bool {
-	val := ""
+// No state is consulted or updated, so items which have been seen
+// previously are included.
+func (p *Processor) FilterItems(entry configfile.Feed, items []*gofeed.Item) []*gofeed.Item {
+
+	var pass []*gofeed.Item
+
+	for _, xp := range items {
+		item := withstate.FeedItem{Item: xp}
+		content := transformContent(p.logger, entry, itemContent(item))
+		if !p.skipItem(p.logger, entry, item, content) {
+			pass = append(pass, xp)
+		}
+	}
+
+	return pass
+}
 
-	err := p.dbHandle.View(func(tx *bbolt.Tx) error {
+// itemContent returns the HTML content of the given feed item, falling
+// back to the raw content if that cannot be processed.
+func itemContent(item withstate.FeedItem) string {
+	content, err := item.HTMLContent()
+	if err != nil {
+		content = item.RawContent()
+	}
+	return content
+}
+
+// skipItem returns true if the given feed item should be skipped, due to
+// any of the filtering options set for the feed.
+func (p *Processor) skipItem(logger *slog.Logger, entry configfile.Feed, item withstate.FeedItem, content string) bool {
+	return p.skipFilter(logger, entry, item, content) != ""
+}
+
+// skipFilter returns the name of the first filter which causes the given
+// feed item to be skipped, one of the Filter-constants, or the empty
+// string if it should not be skipped.
+func (p *Processor) skipFilter(logger *slog.Logger, entry configfile.Feed, item withstate.FeedItem, content string) string {
+	filter, _ := p.filterReason(logger, entry, item, content)
+	return filter
+}
 
-		// Select the feed-bucket
-		b := tx.Bucket([]byte(feed))
+// filterReason runs each of our filters in turn, and returns the name of
+// the first which causes the given feed item to be skipped along with a
+// description of why.
+func (p *Processor) filterReason(logger *slog.Logger, entry configfile.Feed, item withstate.FeedItem, content string) (string, string) {
 
-		// Get the entry with key of the feed URL.
-		v := b.Get([]byte(entry))
-		if v != nil {
-			val = string(v)
+	for _, check := range p.filterChecks(logger, entry, item, content) {
+		if reason := check.reason(); reason != "" {
+			p.logFilterResult(logger, item, newFilterResult(check.filter, reason))
+			return check.filter, reason
 		}
-		return nil
-	})
+	}
+
+	p.logFilterResult(logger, item, FilterResult{})
+	return "", ""
+}
+
+// TestFilter runs the filters configured for the given feed against a
+// single item, and reports whether it would be skipped.
+//
+// The reason names the option which caused the item to be skipped, and
+// what it matched, for example:
+//
+//	exclude-category: (?i)sports matched 'Sports'
+//
+// No state is consulted, so this doesn't take into account whether the
+// item has been seen before.
+func (p *Processor) TestFilter(feed configfile.Feed, item withstate.FeedItem) (bool, string) {
+
+	content := transformContent(p.logger, feed, itemContent(item))
+
+	filter, reason := p.filterReason(p.logger, feed, item, content)
+	return filter != "", reason
+}
+
+// backfillItems returns the indexes of the feed items which should be
+// sent when backfilling a new feed.
+//
+// These are the oldest items which aren't excluded by the per-feed
+// filters, limited to the backfill count.
+func (p *Processor) backfillItems(entry configfile.Feed, items []*gofeed.Item, tag string) map[int]bool {
+
+	// We don't want the filters logging twice for each item.
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Find the items which pass the filters.
+	candidates := []int{}
+	for i, xp := range items {
+		item := withstate.FeedItem{Item: xp, Tag: tag}
+		if !p.skipItem(quiet, entry, item, transformContent(quiet, entry, itemContent(item))) {
+			candidates = append(candidates, i)
+		}
+	}
+
+	return backfillSelect(items, candidates, p.backfill)
+}
+
+// backfillSelect returns the n oldest of the candidate items.
+func backfillSelect(items []*gofeed.Item, candidates []int, n int) map[int]bool {
+
+	oldestFirst(items, candidates)
+
+	selected := make(map[int]bool)
+	for _, i := range candidates {
+		if len(selected) >= n {
+			break
+		}
+		selected[i] = true
+	}
+	return selected
+}
+
+// oldestFirst sorts the given indexes of the feed items so that the oldest
+// item comes first.
+//
+// If every item has a publication date we use that to find the oldest,
+// otherwise we assume the feed is in the usual newest-first order and
+// reverse it.
+func oldestFirst(items []*gofeed.Item, indexes []int) {
+
+	dated := true
+	for _, i := range indexes {
+		if items[i].PublishedParsed == nil {
+			dated = false
+			break
+		}
+	}
+
+	if dated {
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return items[indexes[a]].PublishedParsed.Before(*items[indexes[b]].PublishedParsed)
+		})
+	} else {
+		for a, b := 0, len(indexes)-1; a < b; a, b = a+1, b-1 {
+			indexes[a], indexes[b] = indexes[b], indexes[a]
+		}
+	}
+}
+
+// feedIsNew returns true if we have no record of any items in the given
+// feed, which means it has not been processed previously.
+func (p *Processor) feedIsNew(feed string) bool {
+
+	items, err := p.store.Items(feed)
+	if err != nil {
+		p.logger.Error("error checking state of feed",
+			slog.String("feed", feed),
+			slog.String("error", err.Error()))
+		return false
+	}
+
+	return len(items) == 0
+}
+
+// seenItem returns true if we've seen this item.
+//
+// It does this by checking the store in which we record state.
+func (p *Processor) seenItem(feed string, entry string) bool {
+
+	seen, err := p.store.Seen(feed, entry)
 	if err != nil {
 		p.logger.Error("error checking state of item",
 			slog.String("feed", feed),
@@ -525,24 +1592,15 @@ func (p *Processor) seenItem(feed string, entry string) bool {
 			slog.String("error", err.Error()))
 	}
 
-	return val != ""
+	return seen
 }
 
 // recordItem marks an URL as having been seen.
 //
-// It does this by updating the BoltDB in which we record state.
+// It does this by updating the store in which we record state.
 func (p *Processor) recordItem(feed string, entry string) error {
 
-	err := p.dbHandle.Update(func(tx *bbolt.Tx) error {
-
-		// Select the feed-bucket
-		b := tx.Bucket([]byte(feed))
-
-		// Set a value "seen" to the key of the feed item link
-		err := b.Put([]byte(entry), []byte("seen"))
-		return err
-	})
-
+	err := p.store.Record(feed, entry)
 	if err != nil {
 		p.logger.Error("error recording state of item",
 			slog.String("feed", feed),
@@ -562,6 +1620,11 @@ func (p *Processor) recordItem(feed string, entry string) error {
 // fetched at all.
 func (p *Processor) pruneFeed(feed string, items []string) error {
 
+	// A dry-run leaves our state alone.
+	if p.dryRun != nil {
+		return nil
+	}
+
 	// A list of items to remove
 	toRemove := []string{}
 
@@ -571,35 +1634,10 @@ func (p *Processor) pruneFeed(feed string, items []string) error {
 		seen[str] = true
 	}
 
-	// Select the bucket, which we know will exist,
-	// and see if we should remove any of the keys
-	// that are present.
+	// See if we should remove any of the keys that are present.
 	//
 	// (i.e. Remove the ones that are not in the map above)
-	err := p.dbHandle.View(func(tx *bbolt.Tx) error {
-
-		// Select the bucket, which we know must exist
-		b := tx.Bucket([]byte(feed))
-
-		// Get a cursor to the key=value entries in the bucket
-		c := b.Cursor()
-
-		// Iterate over the key/value pairs.
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-
-			// Convert the key to a string
-			key := string(k)
-
-			// Is this in our list of seen entries?
-			_, ok := seen[key]
-			if !ok {
-				// If not remove the key/value pair
-				toRemove = append(toRemove, key)
-			}
-		}
-		return nil
-	})
-
+	known, err := p.store.Items(feed)
 	if err != nil {
 
 		p.logger.Error("error getting all bucket keys",
@@ -607,25 +1645,29 @@ func (p *Processor) pruneFeed(feed string, items []string) error {
 		return err
 	}
 
-	// Remove each entry that we were supposed to remove.
-	for _, ent := range toRemove {
+	for _, key := range known {
 
-		err := p.dbHandle.Update(func(tx *bbolt.Tx) error {
+		// Is this in our list of seen entries?
+		_, ok := seen[key]
+		if !ok {
+			// If not remove the key/value pair
+			toRemove = append(toRemove, key)
+		}
+	}
 
-			// Select the bucket
-			b := tx.Bucket([]byte(feed))
+	if len(toRemove) == 0 {
+		return nil
+	}
 
-			// Delete the key=value pair.
-			return b.Delete([]byte(ent))
-		})
-		if err != nil {
+	// Remove each entry that we were supposed to remove, together.
+	err = p.store.Remove(feed, toRemove...)
+	if err != nil {
 
-			p.logger.Error("error deleting key from bucket",
-				slog.String("entry", ent),
-				slog.String("error", err.Error()))
+		p.logger.Error("error deleting keys from bucket",
+			slog.Int("entries", len(toRemove)),
+			slog.String("error", err.Error()))
 
-			return fmt.Errorf("failed to remove %s - %s", ent, err)
-		}
+		return fmt.Errorf("failed to remove %d entries - %s", len(toRemove), err)
 	}
 
 	return nil
@@ -634,82 +1676,53 @@ func (p *Processor) pruneFeed(feed string, items []string) error {
 // pruneUnknownFeeds removes feeds from our database which are no longer
 // contained within our configuration file.
 //
-// To recap BoltDB has a notion of buckets, which are used to store key=value
-// pairs.  We create a bucket for every feed which is present in our
-// configuration value, then use the URL of feed-items as the keys.
-//
-// Here we remove buckets which are obsolete.
+// Our store records the items of every feed we've processed, along with
+// the fingerprint of its options.  Here we remove those of feeds which
+// are obsolete.
 func (p *Processor) pruneUnknownFeeds(feeds []string) error {
 
+	// A dry-run leaves our state alone.
+	if p.dryRun != nil {
+		return nil
+	}
+
 	// Create a map for lookup
 	seen := make(map[string]bool)
 	for _, str := range feeds {
 		seen[str] = true
 	}
 
-	// Now walk the database and see which buckets should be removed.
+	// Now walk the database and see which feeds should be removed.
 	toRemove := []string{}
 
-	err := p.dbHandle.View(func(tx *bbolt.Tx) error {
-
-		return tx.ForEach(func(bucketName []byte, _ *bbolt.Bucket) error {
-
-			// Does this name exist in our map?
-			_, ok := seen[string(bucketName)]
-
-			// If not then it should be removed.
-			if !ok {
-				toRemove = append(toRemove, string(bucketName))
-			}
-			return nil
-		})
-	})
-
+	known, err := p.store.Feeds()
 	if err != nil {
 		p.logger.Error("error finding orphaned buckets",
 			slog.String("error", err.Error()))
 
 		return err
 	}
-	// For each bucket we need to remove, remove it
-	for _, bucket := range toRemove {
-
-		err := p.dbHandle.Update(func(tx *bbolt.Tx) error {
-
-			// Select the bucket, which we know must exist
-			b := tx.Bucket([]byte(bucket))
-
-			// Get a cursor to the key=value entries in the bucket
-			c := b.Cursor()
 
-			// Iterate over the key/value pairs and delete them.
-			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+	for _, feed := range known {
 
-				err := b.Delete(k)
-				if err != nil {
+		// Does this name exist in our map?
+		_, ok := seen[feed]
 
-					p.logger.Error("error removing key from bucket",
-						slog.String("bucket", bucket),
-						slog.String("key", string(k)),
-						slog.String("error", err.Error()))
-
-					return (fmt.Errorf("failed to delete bucket key %s:%s - %s", bucket, k, err))
-				}
-			}
+		// If not then it should be removed.
+		if !ok {
+			toRemove = append(toRemove, feed)
+		}
+	}
 
-			// Now delete the bucket itself
-			err := tx.DeleteBucket([]byte(bucket))
-			if err != nil {
-				p.logger.Error("error removing bucket",
-					slog.String("bucket", bucket),
-					slog.String("error", err.Error()))
-				return fmt.Errorf("failed to remove bucket %s: %s", bucket, err)
-			}
+	// For each feed we need to remove, remove it
+	for _, feed := range toRemove {
 
-			return nil
-		})
+		err := p.store.DeleteFeed(feed)
 		if err != nil {
-			return fmt.Errorf("error removing bucket %s: %s", bucket, err)
+			p.logger.Error("error removing bucket",
+				slog.String("bucket", feed),
+				slog.String("error", err.Error()))
+			return fmt.Errorf("error removing bucket %s: %s", feed, err)
 		}
 	}
 
@@ -728,35 +1741,50 @@ func (p *Processor) pruneUnknownFeeds(feeds []string) error {
 // Note that if an entry should be skipped it is still marked as
 // having been read, but no email is sent.
 func (p *Processor) shouldSkip(logger *slog.Logger, config configfile.Feed, title string, content string) bool {
+	return p.skipReason(logger, config, title, content) != ""
+}
 
-	// Walk over the options to see if there are any exclude* options
-	// specified.
-	for _, opt := range config.Options {
+// skipReason implements shouldSkip, returning a description of the option
+// which caused the entry to be skipped, or the empty string.
+func (p *Processor) skipReason(logger *slog.Logger, config configfile.Feed, title string, content string) string {
+
+	// Exclude by title?
+	for _, value := range config.GetAllOptions("exclude-title") {
+		match, _ := regexp.MatchString(value, title)
+		if match {
+			logger.Debug("excluding entry due to exclude-title",
+				slog.String("exclude-title", value),
+				slog.String("item-title", title))
+			// Skip/ignore this entry
+			return fmt.Sprintf("exclude-title: %s matched '%s'", value, title)
+		}
+	}
 
-		// Exclude by title?
-		if opt.Name == "exclude-title" {
-			match, _ := regexp.MatchString(opt.Value, title)
-			if match {
-				logger.Debug("excluding entry due to exclude-title",
-					slog.String("exclude-title", opt.Value),
+	// Exclude by title, from a list of patterns?
+	for _, value := range config.GetAllOptions("exclude-title-list-file") {
+		opt := configfile.Option{Name: "exclude-title-list-file", Value: value}
+		for _, re := range p.patternList(logger, opt) {
+			if re.MatchString(title) {
+				logger.Debug("excluding entry due to exclude-title-list-file",
+					slog.String("exclude-title-list-file", value),
+					slog.String("pattern", re.String()),
 					slog.String("item-title", title))
-				// True: skip/ignore this entry
-				return true
+				// Skip/ignore this entry
+				return fmt.Sprintf("exclude-title-list-file: %s pattern %s matched '%s'", value, re, title)
 			}
 		}
+	}
 
-		// Exclude by body/content?
-		if opt.Name == "exclude" {
-
-			match, _ := regexp.MatchString(opt.Value, content)
-			if match {
-				logger.Debug("excluding entry due to exclude",
-					slog.String("exclude", opt.Value),
-					slog.String("item-title", title))
+	// Exclude by body/content?
+	for _, value := range config.GetAllOptions("exclude") {
+		re, err := regexp.Compile(value)
+		if err == nil && re.MatchString(content) {
+			logger.Debug("excluding entry due to exclude",
+				slog.String("exclude", value),
+				slog.String("item-title", title))
 
-				// True: skip/ignore this entry
-				return true
-			}
+			// Skip/ignore this entry
+			return fmt.Sprintf("exclude: %s matched '%s'", value, re.FindString(content))
 		}
 	}
 
@@ -766,183 +1794,775 @@ func (p *Processor) shouldSkip(logger *slog.Logger, config configfile.Feed, titl
 	// There might be more than one include setting and a match against
 	// any will suffice.
 	//
-	include := false
-
-	it := ""
-	i := ""
+	if !config.HasOption("include-title") && !config.HasOption("include") {
+		// Do not skip/ignore this entry
+		return ""
+	}
 
-	for _, opt := range config.Options {
-		if opt.Name == "include-title" {
+	for _, value := range config.GetAllOptions("include-title") {
+		match, _ := regexp.MatchString(value, title)
+		if match {
+			logger.Debug("including entry due to 'include-title'",
+				slog.String("include-title", value),
+				slog.String("item-title", title))
 
-			// Save
-			it = opt.Value
+			// Do not skip/ignore this entry
+			return ""
+		}
+	}
+	for _, value := range config.GetAllOptions("include") {
+		match, _ := regexp.MatchString(value, content)
+		if match {
+			logger.Debug("including entry due to 'include'",
+				slog.String("include", value),
+				slog.String("item-title", title))
+
+			// Do not skip/ignore this entry
+			return ""
+		}
+	}
 
-			// We found (at least one) include option
-			include = true
+	// We had at least one "include" setting, and no match.
+	//
+	// i.e. The entry did not include a string we regarded as mandatory.
+	it := config.GetOption("include-title", "")
+	i := config.GetOption("include", "")
 
-			// OK we've found a `include` setting,
-			// so we MUST skip unless there is a match
-			match, _ := regexp.MatchString(opt.Value, title)
-			if match {
-				logger.Debug("including entry due to 'include-title'",
-					slog.String("include-title", opt.Value),
-					slog.String("item-title", title))
+	logger.Debug("excluding entry due to 'include', or 'include-title'",
+		slog.String("include", i),
+		slog.String("include-title", it),
+		slog.String("item-title", title))
 
-				// False: Do not skip/ignore this entry
-				return false
-			}
-		}
-		if opt.Name == "include" {
+	// Skip/ignore this entry
+	if it != "" {
+		return fmt.Sprintf("include-title: %s did not match '%s'", it, title)
+	}
+	return fmt.Sprintf("include: %s did not match the content", i)
+}
 
-			// Save
-			i = opt.Value
+// shouldSkipOlder returns true if this entry should be skipped due to age.
+//
+// Age is configured with "exclude-older" in days.
+func (p *Processor) shouldSkipOlder(logger *slog.Logger, config configfile.Feed, published string) bool {
+	return p.olderReason(logger, config, published) != ""
+}
 
-			// We found (at least one) include option
-			include = true
+// olderReason implements shouldSkipOlder, returning a description of the
+// option which caused the entry to be skipped, or the empty string.
+func (p *Processor) olderReason(logger *slog.Logger, config configfile.Feed, published string) string {
 
-			// OK we've found a `include` setting,
-			// so we MUST skip unless there is a match
-			match, _ := regexp.MatchString(opt.Value, content)
-			if match {
-				logger.Debug("including entry due to 'include'",
-					slog.String("include", opt.Value),
-					slog.String("item-title", title))
+	// Are there any exclude-age options specified?
+	for _, value := range config.GetAllOptions("exclude-older") {
 
-				// False: Do not skip/ignore this entry
-				return false
-			}
+		pubTime, err := time.Parse(time.RFC1123, published)
+		if err != nil {
+			logger.Warn("failed to parse 'item.published' as date",
+				slog.String("date", published),
+				slog.String("error", err.Error()))
+			return ""
 		}
-	}
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			logger.Warn("failed to parse 'exclude-older' as float",
+				slog.String("exclude-older", value),
+				slog.String("error", err.Error()))
 
-	// If we had at least one "include" setting and we reach here
-	// the we had no match.
-	//
-	// i.e. The entry did not include a string we regarded as mandatory.
-	if include {
-		logger.Debug("excluding entry due to 'include', or 'include-title'",
-			slog.String("include", i),
-			slog.String("include-title", it),
-			slog.String("item-title", title))
+			return ""
+		}
 
-		// True: skip/ignore this entry
-		return true
+		delta := time.Second * time.Duration(f*24*60*60)
+		now := p.clock()
+		if pubTime.Add(delta).Before(now) {
+			logger.Debug("excluding entry due to exclude-older setting",
+				slog.String("exclude-older", value),
+				slog.Float64("days", now.Sub(pubTime).Hours()/24))
+			return fmt.Sprintf("exclude-older: %s matched '%s', which is %.1f days old",
+				value, published, now.Sub(pubTime).Hours()/24)
+		}
 	}
 
-	// False: Do not skip/ignore this entry
-	return false
+	// Do not skip/ignore this entry
+	return ""
 }
 
-// shouldSkipOlder returns true if this entry should be skipped due to age.
+// shouldSkipNewer returns true if this entry should be skipped because
+// it was published too recently.
 //
-// Age is configured with "exclude-older" in days.
-func (p *Processor) shouldSkipOlder(logger *slog.Logger, config configfile.Feed, published string) bool {
+// Age is configured with "exclude-newer" in days.  Such entries aren't
+// recorded as seen, so they're processed once they're old enough.
+func (p *Processor) shouldSkipNewer(logger *slog.Logger, config configfile.Feed, published string) bool {
+	return p.newerReason(logger, config, published) != ""
+}
+
+// newerReason implements shouldSkipNewer, returning a description of the
+// option which caused the entry to be skipped, or the empty string.
+func (p *Processor) newerReason(logger *slog.Logger, config configfile.Feed, published string) string {
 
-	// Walk over the options to see if there are any exclude-age options
-	// specified.
 	for _, opt := range config.Options {
 
-		if opt.Name == "exclude-older" {
+		if opt.Name == "exclude-newer" {
 			pubTime, err := time.Parse(time.RFC1123, published)
 			if err != nil {
 				logger.Warn("failed to parse 'item.published' as date",
 					slog.String("date", published),
 					slog.String("error", err.Error()))
-				return false
+				return ""
 			}
 			f, err := strconv.ParseFloat(opt.Value, 32)
 			if err != nil {
-				logger.Warn("failed to parse 'exclude-older' as float",
-					slog.String("exclude-older", opt.Value),
+				logger.Warn("failed to parse 'exclude-newer' as float",
+					slog.String("exclude-newer", opt.Value),
 					slog.String("error", err.Error()))
 
-				return false
+				return ""
 			}
 
 			delta := time.Second * time.Duration(f*24*60*60)
-			if pubTime.Add(delta).Before(time.Now()) {
-				logger.Debug("excluding entry due to exclude-older setting",
-					slog.String("exclude-older", opt.Value),
-					slog.Float64("days", time.Since(pubTime).Hours()/24))
-				return true
+			now := p.clock()
+			if pubTime.After(now.Add(-delta)) {
+				logger.Debug("excluding entry due to exclude-newer setting",
+					slog.String("exclude-newer", opt.Value),
+					slog.Float64("days", now.Sub(pubTime).Hours()/24))
+				return fmt.Sprintf("exclude-newer: %s matched '%s', which is %.1f days old",
+					opt.Value, published, now.Sub(pubTime).Hours()/24)
 			}
 		}
 	}
 
-	// False: Do not skip/ignore this entry
-	return false
+	// Do not skip/ignore this entry
+	return ""
 }
 
-// shouldSkipCategory returns true if this entry should be skipped based on category.
+// recipientsOption returns the comma-separated recipients given by the
+// "notify", or "to", option.
 //
-// Our configuration file allows a series of per-feed configuration items,
-// and those allow skipping the entry by regular expression matches on
-// the item categories.
-//
-// If `exclude-category` is set and any category matches, the item is skipped.
-// If `include-category` is set and no category matches, the item is skipped.
-func (p *Processor) shouldSkipCategory(logger *slog.Logger, config configfile.Feed, categories []string) bool {
-
-	// Walk over the options to see if there are any exclude-category options
-	// specified.
-	for _, opt := range config.Options {
-		if opt.Name == "exclude-category" {
-			for _, cat := range categories {
-				match, err := regexp.MatchString(opt.Value, cat)
-				if err != nil {
-					logger.Warn("invalid regular expression in exclude-category",
-						slog.String("exclude-category", opt.Value),
-						slog.String("error", err.Error()))
-					continue
-				}
-				if match {
-					logger.Debug("excluding entry due to exclude-category",
-						slog.String("exclude-category", opt.Value),
-						slog.String("matched-category", cat))
-					return true
-				}
-			}
+// The value is parsed as an RFC 5322 address list, so that quoted names
+// may contain commas.  If that fails each comma-separated address is
+// parsed in turn, and those which are malformed are ignored, with a
+// warning.  If none are valid the fallback recipients are returned
+// instead.
+func recipientsOption(logger *slog.Logger, opt configfile.Option, fallback []string) []string {
+
+	var result []string
+
+	if list, err := mail.ParseAddressList(opt.Value); err == nil {
+		for _, parsed := range list {
+			result = append(result, parsed.Address)
 		}
+		return result
 	}
 
-	// If we have an include-category setting then we must skip the entry unless
-	// at least one category matches.
-	//
-	// There might be more than one include-category setting and a match against
-	// any will suffice.
-	includeCategory := false
+	for _, addr := range strings.Split(opt.Value, ",") {
+
+		// Trim leading/trailing space
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			logger.Warn("ignoring invalid recipient address",
+				slog.String(opt.Name, addr),
+				slog.String("error", err.Error()))
+			continue
+		}
+		result = append(result, parsed.Address)
+	}
+
+	if len(result) == 0 {
+		logger.Warn("no valid recipients, using the default recipients",
+			slog.String(opt.Name, opt.Value),
+			slog.String("recipients", strings.Join(fallback, ",")))
+		return fallback
+	}
+
+	return result
+}
+
+// minimumOption returns the value of the given numeric per-feed option,
+// or zero if it is not set or invalid.
+func minimumOption(logger *slog.Logger, config configfile.Feed, name string) int {
 
 	for _, opt := range config.Options {
-		if opt.Name == "include-category" {
-			includeCategory = true
-
-			for _, cat := range categories {
-				match, err := regexp.MatchString(opt.Value, cat)
-				if err != nil {
-					logger.Warn("invalid regular expression in include-category",
-						slog.String("include-category", opt.Value),
-						slog.String("error", err.Error()))
-					continue
-				}
-				if match {
-					logger.Debug("including entry due to 'include-category'",
-						slog.String("include-category", opt.Value),
-						slog.String("matched-category", cat))
-					return false
-				}
+		if opt.Name == name {
+			n, err := strconv.Atoi(strings.TrimSpace(opt.Value))
+			if err != nil || n < 0 {
+				logger.Warn("failed to parse option as a positive number",
+					slog.String(name, opt.Value))
+				return 0
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// applyFeedName replaces the title of the fetched feed with the value of
+// the "feed-name" option, if it has one, so that it is the name shown in
+// the From header, and subject, of the feed's emails.
+func applyFeedName(config configfile.Feed, feed *gofeed.Feed) {
+
+	if feed == nil {
+		return
+	}
+
+	for _, opt := range config.Options {
+		if opt.Name == "feed-name" && strings.TrimSpace(opt.Value) != "" {
+			feed.Title = strings.TrimSpace(opt.Value)
+		}
+	}
+}
+
+// shouldSkipByItemPosition returns true if the item at the given index,
+// in a feed of count items, should be skipped because of its position.
+//
+// "include-top-items" keeps only the first N items of the feed, and
+// "include-bottom-items" only the last N, by the order in which the feed
+// lists them rather than their publication dates.  If both are set an
+// item is kept if either would keep it.
+func (p *Processor) shouldSkipByItemPosition(logger *slog.Logger, config configfile.Feed, index int, count int) bool {
+
+	top := minimumOption(logger, config, "include-top-items")
+	bottom := minimumOption(logger, config, "include-bottom-items")
+
+	// No limits?  Then nothing is skipped.
+	if top == 0 && bottom == 0 {
+		return false
+	}
+
+	if top > 0 && index < top {
+		return false
+	}
+	if bottom > 0 && index >= count-bottom {
+		return false
+	}
+
+	logger.Debug("excluding entry due to its position in the feed",
+		slog.Int("position", index+1),
+		slog.Int("include-top-items", top),
+		slog.Int("include-bottom-items", bottom))
+	return true
+}
+
+// shouldSkipByMinWordCount returns true if this entry should be skipped
+// because it contains fewer words than "include-words-min", or its
+// alias "min-words".
+func (p *Processor) shouldSkipByMinWordCount(logger *slog.Logger, config configfile.Feed, content string) bool {
+	return p.minWordsReason(logger, config, content) != ""
+}
+
+// minWordsReason implements shouldSkipByMinWordCount, returning a description of the
+// option which caused the entry to be skipped, or the empty string.
+func (p *Processor) minWordsReason(logger *slog.Logger, config configfile.Feed, content string) string {
+
+	name := "include-words-min"
+	minimum := minimumOption(logger, config, name)
+	if minimum == 0 {
+		name = "min-words"
+		minimum = minimumOption(logger, config, name)
+	}
+	if minimum == 0 {
+		return ""
+	}
+
+	count := countWords(content)
+	if count < minimum {
+		logger.Debug("excluding entry due to "+name,
+			slog.Int(name, minimum),
+			slog.Int("words", count))
+		return fmt.Sprintf("%s: %d matched content of only %d words", name, minimum, count)
+	}
+	return ""
+}
+
+// shouldSkipByMaxWordCount returns true if this entry should be skipped
+// because it contains more words than "max-words".
+func (p *Processor) shouldSkipByMaxWordCount(logger *slog.Logger, config configfile.Feed, content string) bool {
+	return p.maxWordsReason(logger, config, content) != ""
+}
+
+// maxWordsReason implements shouldSkipByMaxWordCount, returning a description of the
+// option which caused the entry to be skipped, or the empty string.
+func (p *Processor) maxWordsReason(logger *slog.Logger, config configfile.Feed, content string) string {
+
+	maximum := minimumOption(logger, config, "max-words")
+	if maximum == 0 {
+		return ""
+	}
+
+	count := countWords(content)
+	if count > maximum {
+		logger.Debug("excluding entry due to max-words",
+			slog.Int("max-words", maximum),
+			slog.Int("words", count))
+		return fmt.Sprintf("max-words: %d matched content of %d words", maximum, count)
+	}
+	return ""
+}
+
+// shouldSkipByNoContent returns true if this entry should be skipped
+// because "exclude-if-no-content" is set and its content, once the HTML
+// has been removed, is empty or only whitespace.
+func (p *Processor) shouldSkipByNoContent(logger *slog.Logger, config configfile.Feed, content string) bool {
+	return p.noContentReason(logger, config, content) != ""
+}
+
+// noContentReason implements shouldSkipByNoContent, returning a description
+// of the option which caused the entry to be skipped, or the empty string.
+func (p *Processor) noContentReason(logger *slog.Logger, config configfile.Feed, content string) string {
+
+	if !enabledOption(config, "exclude-if-no-content") {
+		return ""
+	}
+
+	if strings.TrimSpace(plainText(content, "")) != "" {
+		return ""
+	}
+
+	logger.Debug("excluding entry due to exclude-if-no-content")
+	return "exclude-if-no-content: matched empty content"
+}
+
+// shouldSkipByMinSentenceCount returns true if this entry should be skipped
+// because it contains fewer sentences than "include-sentences-min".
+func (p *Processor) shouldSkipByMinSentenceCount(logger *slog.Logger, config configfile.Feed, content string) bool {
+	return p.minSentencesReason(logger, config, content) != ""
+}
+
+// minSentencesReason implements shouldSkipByMinSentenceCount, returning a description of the
+// option which caused the entry to be skipped, or the empty string.
+func (p *Processor) minSentencesReason(logger *slog.Logger, config configfile.Feed, content string) string {
+
+	minimum := minimumOption(logger, config, "include-sentences-min")
+	if minimum == 0 {
+		return ""
+	}
+
+	count := countSentences(content)
+	if count < minimum {
+		logger.Debug("excluding entry due to include-sentences-min",
+			slog.Int("include-sentences-min", minimum),
+			slog.Int("sentences", count))
+		return fmt.Sprintf("include-sentences-min: %d matched content of only %d sentences", minimum, count)
+	}
+	return ""
+}
+
+// sentenceEnd matches the end of a sentence.
+var sentenceEnd = regexp.MustCompile(`[.!?]+(\s+|$)`)
+
+// hasWord matches text which contains something other than punctuation.
+var hasWord = regexp.MustCompile(`[\p{L}\p{N}]`)
+
+// plainText returns the text of the given HTML, with any elements
+// matching the ignore selector removed.
+func plainText(content string, ignore string) string {
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+
+	if ignore != "" {
+		doc.Find(ignore).Remove()
+	}
+
+	// Ensure the text of adjacent block elements doesn't
+	// run together.
+	doc.Find("p, div, br, li, blockquote, pre, td, h1, h2, h3, h4, h5, h6").AfterHtml(" ")
+
+	return doc.Text()
+}
+
+// countWords returns the number of words in the given HTML.
+func countWords(content string) int {
+	return len(strings.Fields(plainText(content, "")))
+}
+
+// countSentences returns the number of sentences in the given HTML.
+//
+// This is a simple heuristic, splitting the text on ".", "!", or "?"
+// followed by whitespace or the end of the text.  List items and headings
+// are ignored, as they're rarely sentences.
+func countSentences(content string) int {
+
+	count := 0
+	for _, part := range sentenceEnd.Split(plainText(content, "li, h1, h2, h3, h4, h5, h6"), -1) {
+		if hasWord.MatchString(part) {
+			count++
+		}
+	}
+	return count
+}
+
+// shouldSkipCategory returns true if this entry should be skipped based on category.
+//
+// Our configuration file allows a series of per-feed configuration items,
+// and those allow skipping the entry by regular expression matches on
+// the item categories.
+//
+// If `exclude-category` is set and any category matches, the item is skipped.
+// If `include-category` is set and no category matches, the item is skipped.
+func (p *Processor) shouldSkipCategory(logger *slog.Logger, config configfile.Feed, categories []string) bool {
+	return p.categoryReason(logger, config, categories) != ""
+}
+
+// categoryReason implements shouldSkipCategory, returning a description of
+// the option which caused the entry to be skipped, or the empty string.
+func (p *Processor) categoryReason(logger *slog.Logger, config configfile.Feed, categories []string) string {
+
+	// Are there any exclude-category options specified?
+	for _, value := range config.GetAllOptions("exclude-category") {
+		for _, cat := range categories {
+			match, err := regexp.MatchString(value, cat)
+			if err != nil {
+				logger.Warn("invalid regular expression in exclude-category",
+					slog.String("exclude-category", value),
+					slog.String("error", err.Error()))
+				continue
+			}
+			if match {
+				logger.Debug("excluding entry due to exclude-category",
+					slog.String("exclude-category", value),
+					slog.String("matched-category", cat))
+				return fmt.Sprintf("exclude-category: %s matched '%s'", value, cat)
+			}
+		}
+	}
+
+	// If we have an include-category setting then we must skip the entry unless
+	// at least one category matches.
+	//
+	// There might be more than one include-category setting and a match against
+	// any will suffice.
+	for _, value := range config.GetAllOptions("include-category") {
+		for _, cat := range categories {
+			match, err := regexp.MatchString(value, cat)
+			if err != nil {
+				logger.Warn("invalid regular expression in include-category",
+					slog.String("include-category", value),
+					slog.String("error", err.Error()))
+				continue
+			}
+			if match {
+				logger.Debug("including entry due to 'include-category'",
+					slog.String("include-category", value),
+					slog.String("matched-category", cat))
+				return ""
 			}
 		}
 	}
 
 	// If we had at least one "include-category" setting and we reach here
 	// then we had no match.
-	if includeCategory {
+	if includeCategory := config.GetOption("include-category", ""); includeCategory != "" {
 		logger.Debug("excluding entry due to 'include-category' (no match)",
 			slog.String("categories", strings.Join(categories, ", ")))
-		return true
+		return fmt.Sprintf("include-category: %s did not match '%s'", includeCategory, strings.Join(categories, ", "))
+	}
+
+	// Do not skip/ignore this entry
+	return ""
+}
+
+// shouldSkipEnclosure returns true if this entry should be skipped based on
+// whether it has an enclosure.
+//
+// If `include-has-enclosure` is set, items without an enclosure are
+// skipped, and if `exclude-has-enclosure` is set items with one are.
+// Enclosures without a URL don't count.
+func (p *Processor) shouldSkipEnclosure(logger *slog.Logger, config configfile.Feed, enclosures []*gofeed.Enclosure) bool {
+	return p.enclosureReason(logger, config, enclosures) != ""
+}
+
+// enclosureReason implements shouldSkipEnclosure, returning a description
+// of the option which caused the entry to be skipped, or the empty string.
+func (p *Processor) enclosureReason(logger *slog.Logger, config configfile.Feed, enclosures []*gofeed.Enclosure) string {
+
+	has := false
+	for _, enc := range enclosures {
+		if enc != nil && strings.TrimSpace(enc.URL) != "" {
+			has = true
+			break
+		}
+	}
+
+	if has && enabledOption(config, "exclude-has-enclosure") {
+		logger.Debug("excluding entry due to exclude-has-enclosure")
+		return "exclude-has-enclosure: matched an enclosure"
+	}
+	if !has && enabledOption(config, "include-has-enclosure") {
+		logger.Debug("excluding entry due to include-has-enclosure (no enclosure)")
+		return "include-has-enclosure: did not match an enclosure"
+	}
+
+	return ""
+}
+
+// enabledOption returns true if the named option of the feed is "true", or
+// "yes".
+func enabledOption(config configfile.Feed, name string) bool {
+
+	enabled := false
+	for _, opt := range config.Options {
+		if opt.Name == name {
+			val := strings.ToLower(strings.TrimSpace(opt.Value))
+			enabled = val == "yes" || val == "true"
+		}
+	}
+	return enabled
+}
+
+// itemAuthors returns the names, and email addresses, of the authors of
+// the given feed item.
+func itemAuthors(item *gofeed.Item) []string {
+
+	var authors []string
+
+	people := item.Authors
+	if len(people) == 0 && item.Author != nil {
+		people = []*gofeed.Person{item.Author}
+	}
+
+	for _, person := range people {
+		if person == nil {
+			continue
+		}
+		if person.Name != "" {
+			authors = append(authors, person.Name)
+		}
+		if person.Email != "" {
+			authors = append(authors, person.Email)
+		}
+	}
+
+	return authors
+}
+
+// authorMatches returns the pattern which matched one of the given authors,
+// if any did.
+//
+// The option may be a regular expression, or name a file of them.
+func (p *Processor) authorMatches(logger *slog.Logger, opt configfile.Option, authors []string) string {
+
+	var patterns []*regexp.Regexp
+
+	if strings.HasSuffix(opt.Name, "-list-file") {
+		patterns = p.patternList(logger, opt)
+	} else {
+		re, err := regexp.Compile(opt.Value)
+		if err != nil {
+			logger.Warn("invalid regular expression in "+opt.Name,
+				slog.String(opt.Name, opt.Value),
+				slog.String("error", err.Error()))
+			return ""
+		}
+		patterns = []*regexp.Regexp{re}
+	}
+
+	for _, re := range patterns {
+		for _, author := range authors {
+			if re.MatchString(author) {
+				return re.String()
+			}
+		}
+	}
+
+	return ""
+}
+
+// shouldSkipAuthor returns true if this entry should be skipped based on
+// its authors.
+//
+// If `exclude-author`, or a pattern in an `exclude-author-list-file`,
+// matches any author the item is skipped.  If `include-author`, or
+// `include-author-list-file`, is set and nothing matches the item is
+// skipped.
+func (p *Processor) shouldSkipAuthor(logger *slog.Logger, config configfile.Feed, authors []string) bool {
+	return p.authorReason(logger, config, authors) != ""
+}
+
+// authorReason implements shouldSkipAuthor, returning a description of the
+// option which caused the entry to be skipped, or the empty string.
+func (p *Processor) authorReason(logger *slog.Logger, config configfile.Feed, authors []string) string {
+
+	for _, opt := range config.Options {
+		if opt.Name == "exclude-author" || opt.Name == "exclude-author-list-file" {
+			if match := p.authorMatches(logger, opt, authors); match != "" {
+				logger.Debug("excluding entry due to "+opt.Name,
+					slog.String(opt.Name, opt.Value),
+					slog.String("pattern", match))
+				return fmt.Sprintf("%s: %s matched '%s'", opt.Name, opt.Value, strings.Join(authors, ", "))
+			}
+		}
+	}
+
+	// If we have an include-author setting then we must skip the entry
+	// unless at least one author matches.
+	//
+	// The inline and file-based settings are combined, a match against
+	// any will suffice.
+	includeAuthor := ""
+
+	for _, opt := range config.Options {
+		if opt.Name == "include-author" || opt.Name == "include-author-list-file" {
+			includeAuthor = opt.Name + ": " + opt.Value
+
+			if match := p.authorMatches(logger, opt, authors); match != "" {
+				logger.Debug("including entry due to '"+opt.Name+"'",
+					slog.String(opt.Name, opt.Value),
+					slog.String("pattern", match))
+				return ""
+			}
+		}
+	}
+
+	if includeAuthor != "" {
+		logger.Debug("excluding entry due to 'include-author' (no match)",
+			slog.String("authors", strings.Join(authors, ", ")))
+		return fmt.Sprintf("%s did not match '%s'", includeAuthor, strings.Join(authors, ", "))
+	}
+
+	// Do not skip/ignore this entry
+	return ""
+}
+
+// shouldSkipByPublisher returns true if this entry should be skipped based
+// on its original publisher, given by the <source> element which is found
+// in aggregated feeds.
+//
+// If `exclude-publisher` matches the title or URL of the source the item
+// is skipped.  If `include-publisher` is set and neither matches the item
+// is skipped.  Items without a source are never skipped by either option.
+func (p *Processor) shouldSkipByPublisher(logger *slog.Logger, config configfile.Feed, sourceTitle string, sourceURL string) bool {
+	return p.publisherReason(logger, config, sourceTitle, sourceURL) != ""
+}
+
+// publisherReason implements shouldSkipByPublisher, returning a description
+// of the option which caused the entry to be skipped, or the empty string.
+func (p *Processor) publisherReason(logger *slog.Logger, config configfile.Feed, sourceTitle string, sourceURL string) string {
+
+	// We're conservative, items which don't name a publisher are
+	// left alone.
+	if sourceTitle == "" && sourceURL == "" {
+		return ""
+	}
+
+	publisher := sourceTitle
+	if publisher == "" {
+		publisher = sourceURL
+	}
+
+	// matches returns true if the option matches the publisher.
+	matches := func(opt configfile.Option) bool {
+		re, err := regexp.Compile(opt.Value)
+		if err != nil {
+			logger.Warn("invalid regular expression in "+opt.Name,
+				slog.String(opt.Name, opt.Value),
+				slog.String("error", err.Error()))
+			return false
+		}
+		return (sourceTitle != "" && re.MatchString(sourceTitle)) ||
+			(sourceURL != "" && re.MatchString(sourceURL))
+	}
+
+	for _, opt := range config.Options {
+		if opt.Name == "exclude-publisher" && matches(opt) {
+			logger.Debug("excluding entry due to exclude-publisher",
+				slog.String("exclude-publisher", opt.Value),
+				slog.String("source-title", sourceTitle),
+				slog.String("source-url", sourceURL))
+			return fmt.Sprintf("exclude-publisher: %s matched '%s'", opt.Value, publisher)
+		}
+	}
+
+	// If we have an include-publisher setting then we must skip the
+	// entry unless one matches.
+	includePublisher := ""
+
+	for _, opt := range config.Options {
+		if opt.Name == "include-publisher" {
+			includePublisher = opt.Value
+
+			if matches(opt) {
+				logger.Debug("including entry due to 'include-publisher'",
+					slog.String("include-publisher", opt.Value))
+				return ""
+			}
+		}
+	}
+
+	if includePublisher != "" {
+		logger.Debug("excluding entry due to 'include-publisher' (no match)",
+			slog.String("source-title", sourceTitle),
+			slog.String("source-url", sourceURL))
+		return fmt.Sprintf("include-publisher: %s did not match '%s'", includePublisher, publisher)
+	}
+
+	// Do not skip/ignore this entry
+	return ""
+}
+
+// shouldSkipByLink returns true if this entry should be skipped based on
+// its link.
+//
+// If `exclude-link` matches the link the item is skipped.  If
+// `include-link` is set and none match the item is skipped, including
+// items without a link.
+func (p *Processor) shouldSkipByLink(logger *slog.Logger, config configfile.Feed, link string) bool {
+	return p.linkReason(logger, config, link) != ""
+}
+
+// linkReason implements shouldSkipByLink, returning a description of the
+// option which caused the entry to be skipped, or the empty string.
+func (p *Processor) linkReason(logger *slog.Logger, config configfile.Feed, link string) string {
+
+	// matches returns true if the option matches the link.
+	matches := func(opt configfile.Option) bool {
+		re, err := regexp.Compile(opt.Value)
+		if err != nil {
+			logger.Warn("invalid regular expression in "+opt.Name,
+				slog.String(opt.Name, opt.Value),
+				slog.String("error", err.Error()))
+			return false
+		}
+		return re.MatchString(link)
+	}
+
+	for _, opt := range config.Options {
+		if opt.Name == "exclude-link" && matches(opt) {
+			logger.Debug("excluding entry due to exclude-link",
+				slog.String("exclude-link", opt.Value),
+				slog.String("link", link))
+			return fmt.Sprintf("exclude-link: %s matched '%s'", opt.Value, link)
+		}
+	}
+
+	// If we have an include-link setting then we must skip the entry
+	// unless one matches.
+	includeLink := ""
+
+	for _, opt := range config.Options {
+		if opt.Name == "include-link" {
+			includeLink = opt.Value
+
+			if link != "" && matches(opt) {
+				logger.Debug("including entry due to 'include-link'",
+					slog.String("include-link", opt.Value))
+				return ""
+			}
+		}
 	}
 
-	// False: Do not skip/ignore this entry
-	return false
+	if includeLink != "" {
+		logger.Debug("excluding entry due to 'include-link' (no match)",
+			slog.String("link", link))
+		return fmt.Sprintf("include-link: %s did not match '%s'", includeLink, link)
+	}
+
+	// Do not skip/ignore this entry
+	return ""
 }
 
 // SetSendEmail updates the state of this object, when the send-flag
@@ -951,17 +2571,186 @@ func (p *Processor) SetSendEmail(state bool) {
 	p.send = state
 }
 
-// SetLogger ensures we have a logging-handle
+// SetDryRun causes the emails we'd send to be written to the given writer,
+// one after another, rather than being sent.  Each is preceded by an
+// emailer.FeedHeader naming its feed, and followed by a blank line.
+//
+// This takes precedence over SetSendEmail, new items are found and
+// filtered as if we were sending them, but nothing is recorded in our
+// state, so the next run will find them again.  Items written to an
+// output, set via SetOutput, aren't emails and are still written.
+func (p *Processor) SetDryRun(w io.Writer) {
+	if w == nil {
+		p.dryRun = nil
+		return
+	}
+	p.dryRun = emailer.NewWriter(w)
+}
+
+// SetEmailOutput causes our emails to be written to the given writer, one
+// after another, rather than being sent, as by SetDryRun.
+//
+// Unlike a dry-run the items are recorded as seen, so each email is
+// written once.  A dry-run takes precedence over this.
+func (p *Processor) SetEmailOutput(w io.Writer) {
+	if w == nil {
+		p.emails = nil
+		return
+	}
+	p.emails = emailer.NewWriter(w)
+}
+
+// sending returns true if new items should be delivered, or written by
+// a dry-run.
+func (p *Processor) sending() bool {
+	return p.send || p.dryRun != nil
+}
+
+// deliversLocally returns true if our emails are written locally, such as
+// to a file, rather than sent to a mail server, so there are no rate limits
+// to avoid.
+func (p *Processor) deliversLocally() bool {
+	return p.dryRun != nil || p.emails != nil || p.sender != nil
+}
+
+// SetBackfill sets the number of items to send from a brand new feed,
+// the first time it is processed.
+//
+// The oldest n items will be sent, and the remainder are marked as having
+// been seen without any email being generated.  Zero disables this, so
+// that every item is sent.
+func (p *Processor) SetBackfill(n int) {
+	p.backfill = n
+}
+
+// SetLogger ensures we have a logging-handle.
+//
+// The given logger, with any attributes it already has, is used for
+// everything the processor logs, including the reasons items are
+// filtered.  Until it is called nothing is logged.
 func (p *Processor) SetLogger(logger *slog.Logger) {
 	p.logger = logger
 }
 
+// SetClock replaces the function used to find the current time, which is
+// time.Now by default, so that time-dependent filters can be tested with
+// fixed times.  A nil clock restores the default.
+func (p *Processor) SetClock(clock func() time.Time) {
+	if clock == nil {
+		clock = time.Now
+	}
+	p.clock = clock
+}
+
 // SetVersion ensures we can pass the version of our client to our HTTP-fetcher,
 // which means that the version will end up in our (default) user-agent.
 func (p *Processor) SetVersion(version string) {
 	p.version = version
 }
 
+// SetFeeds replaces the list of feeds which will be processed, rather
+// than having them read from the configuration file on each run.
+//
+// This is used by the daemon, which watches the configuration file
+// for changes.
+func (p *Processor) SetFeeds(feeds []configfile.Feed) {
+	p.feeds = feeds
+}
+
+// ReloadFeeds replaces the list of feeds which will be processed, like
+// SetFeeds, but is safe to call while the feeds are being processed.
+//
+// If ProcessFeeds is running we wait for it to complete before making
+// the switch, so that a run never sees a mixture of old and new feeds.
+func (p *Processor) ReloadFeeds(feeds []configfile.Feed) {
+	p.running.Lock()
+	defer p.running.Unlock()
+
+	p.feeds = feeds
+}
+
+// SetRateLimit restricts the rate at which items are delivered, across
+// all feeds and delivery methods, to r items per second with bursts of
+// up to b items.
+//
+// SetRateLimit(rate.Inf, 0) disables the limit.
+func (p *Processor) SetRateLimit(r rate.Limit, b int) {
+	p.limiter = rate.NewLimiter(r, b)
+}
+
+// waitForDelivery blocks until the rate limit allows the next item to be
+// delivered.
+func (p *Processor) waitForDelivery(logger *slog.Logger) {
+
+	if p.limiter.Limit() == rate.Inf {
+		return
+	}
+
+	r := p.limiter.Reserve()
+	if !r.OK() {
+		// The burst is zero, so nothing could ever be
+		// delivered - treat that as no limit.
+		return
+	}
+
+	delay := r.Delay()
+	if delay > 0 {
+		logger.Debug("rate limiting delivery",
+			slog.Duration("delay", delay))
+		time.Sleep(delay)
+	}
+}
+
+// deliverOutput writes the item to our output, one at a time however
+// many feeds we're processing at once.
+func (p *Processor) deliverOutput(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+	p.delivering.Lock()
+	defer p.delivering.Unlock()
+
+	return p.output.Deliver(feedURL, feed, item)
+}
+
+// SetOutput causes new items to be written to the given output, rather
+// than being emailed.
+func (p *Processor) SetOutput(o output.Output) {
+	p.output = o
+}
+
+// SetTemplateFromFile reads the email template from the given file,
+// which will be used in preference to the default.
+//
+// The template is validated immediately, see SetTemplateString.
+func (p *Processor) SetTemplateFromFile(path string) error {
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	err = p.SetTemplateString(string(content))
+	if err != nil {
+		return fmt.Errorf("invalid template %s: %w", path, err)
+	}
+	return nil
+}
+
+// SetTemplateString sets the email template to use, in preference to
+// the default.
+//
+// The template is parsed and rendered against a sample item, and an
+// error is returned if that fails or produces no output.  Per-feed
+// templates, set via the "template" option, are still honoured.
+func (p *Processor) SetTemplateString(tmpl string) error {
+
+	t, err := emailer.ParseTemplate(tmpl)
+	if err != nil {
+		return err
+	}
+
+	p.template = t
+	return nil
+}
+
 // SetDefaultFrom sets the default from address for emails.
 func (p *Processor) SetDefaultFrom(from string) {
 	p.defaultFrom = from
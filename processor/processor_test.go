@@ -1,12 +1,40 @@
 package processor
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"io"
 	"log/slog"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/config"
 	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/processor/output"
+	"github.com/skx/rss2email/state"
+	"github.com/skx/rss2email/withstate"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -40,7 +68,17 @@ func setupTestHome(t *testing.T) {
 func TestSendEmail(t *testing.T) {
 	setupTestHome(t)
 
-	p, err := New()
+	// The zero configuration doesn't deliver anything.
+	z, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	if z.send {
+		t.Fatalf("unexpected default to sending mail")
+	}
+	z.Close()
+
+	p, err := New(ProcessorConfig{Send: true})
 
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
@@ -48,7 +86,7 @@ func TestSendEmail(t *testing.T) {
 	defer p.Close()
 
 	if !p.send {
-		t.Fatalf("unexpected default to sending mail")
+		t.Fatalf("unexpected send-setting")
 	}
 
 	p.SetSendEmail(false)
@@ -59,10 +97,66 @@ func TestSendEmail(t *testing.T) {
 
 }
 
+// TestProcessorConfig tests the validation of our settings, and the
+// handling of the state database.
+func TestProcessorConfig(t *testing.T) {
+	setupTestHome(t)
+
+	bad := []ProcessorConfig{
+		{Backfill: -1},
+		{DefaultFrom: "not an address"},
+		{SMTP: &config.SMTPConfig{Port: 25}},
+		{SMTP: &config.SMTPConfig{Host: "smtp.example.com"}},
+		{SMTPPoolSize: -1},
+	}
+	for _, cfg := range bad {
+		_, err := New(cfg)
+		if err == nil {
+			t.Fatalf("expected error for %v", cfg)
+		}
+	}
+
+	p, err := New(ProcessorConfig{
+		Send:         true,
+		DefaultFrom:  "Steve <steve@example.com>",
+		SMTP:         &config.SMTPConfig{Host: "smtp.example.com", Port: 587},
+		Backfill:     3,
+		SMTPPoolSize: 2,
+		Version:      "1.2.3",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.defaultFrom != "Steve <steve@example.com>" || p.backfill != 3 || p.version != "1.2.3" || p.smtp == nil || p.pool == nil {
+		t.Fatalf("settings not applied")
+	}
+
+	// The temporary state is removed on close.
+	tmp := p.tempState
+	if tmp == "" {
+		t.Fatalf("expected a temporary state database")
+	}
+	p.Close()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("temporary state wasn't removed")
+	}
+
+	// An explicit path is created, and kept.
+	path := filepath.Join(t.TempDir(), "sub", "state.db")
+	p, err = New(ProcessorConfig{StatePath: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	p.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("state database missing: %s", err)
+	}
+}
+
 func TestVerbose(t *testing.T) {
 	setupTestHome(t)
 
-	p, err := New()
+	p, err := New(ProcessorConfig{})
 
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
@@ -84,7 +178,7 @@ func TestSkipExclude(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
@@ -123,7 +217,7 @@ func TestSkipInclude(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
@@ -163,7 +257,7 @@ func TestSkipIncludeTitle(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
 	}
@@ -194,7 +288,7 @@ func TestSkipIncludeTitle(t *testing.T) {
 
 	// Create the new processor
 	x.Close()
-	x, err = New()
+	x, err = New(ProcessorConfig{})
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
 	}
@@ -227,13 +321,17 @@ func TestSkipOlder(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
 	}
 	defer x.Close()
 
+	// Use a fixed time, so we're not affected by the passage of time.
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	x.SetClock(func() time.Time { return now })
+
 	if x.shouldSkipOlder(logger, feed, "X") {
 		t.Fatalf("failed to skip non correct published-date")
 	}
@@ -242,25 +340,123 @@ func TestSkipOlder(t *testing.T) {
 		t.Fatalf("failed to skip old entry by age")
 	}
 
-	if !x.shouldSkipOlder(logger, feed, time.Now().Add(-time.Hour*24*2).Format(time.RFC1123)) {
+	if !x.shouldSkipOlder(logger, feed, "Fri, 08 Mar 2024 12:00:00 UTC") {
 		t.Fatalf("failed to skip newer entry by age")
 	}
 
-	if x.shouldSkipOlder(logger, feed, time.Now().Add(-time.Hour*12).Format(time.RFC1123)) {
+	if x.shouldSkipOlder(logger, feed, "Sun, 10 Mar 2024 00:00:00 UTC") {
 		t.Fatalf("skipped new entry by age")
 	}
 
+	// Items are judged relative to our clock, not the real time.
+	x.SetClock(func() time.Time { return now.AddDate(0, 0, -3) })
+	if x.shouldSkipOlder(logger, feed, "Fri, 08 Mar 2024 12:00:00 UTC") {
+		t.Fatalf("skipped entry which was new at the time")
+	}
+
 	// With no options we're not going to skip
 	feed = configfile.Feed{
 		URL:     "blah",
 		Options: []configfile.Option{},
 	}
 
-	if x.shouldSkipOlder(logger, feed, time.Now().Add(-time.Hour*24*128).String()) {
+	if x.shouldSkipOlder(logger, feed, now.Add(-time.Hour*24*128).Format(time.RFC1123)) {
 		t.Fatalf("skipped age with no options!")
 	}
 }
 
+// TestSkipNewer ensures that we can hold back recent items.
+func TestSkipNewer(t *testing.T) {
+	setupTestHome(t)
+
+	feed := configfile.Feed{
+		URL: "blah",
+		Options: []configfile.Option{
+			{Name: "exclude-newer", Value: "1"},
+		},
+	}
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	x.SetClock(func() time.Time { return now })
+
+	if x.shouldSkipNewer(logger, feed, "X") {
+		t.Fatalf("skipped entry with an invalid published-date")
+	}
+
+	if !x.shouldSkipNewer(logger, feed, "Sun, 10 Mar 2024 06:00:00 UTC") {
+		t.Fatalf("failed to skip new entry by age")
+	}
+
+	if x.shouldSkipNewer(logger, feed, "Fri, 08 Mar 2024 12:00:00 UTC") {
+		t.Fatalf("skipped old entry by age")
+	}
+
+	// Fractional days are allowed.
+	feed.Options[0].Value = "0.125"
+	if x.shouldSkipNewer(logger, feed, "Sun, 10 Mar 2024 06:00:00 UTC") {
+		t.Fatalf("skipped entry older than three hours")
+	}
+
+	// An invalid value skips nothing.
+	feed.Options[0].Value = "soon"
+	if x.shouldSkipNewer(logger, feed, "Sun, 10 Mar 2024 11:00:00 UTC") {
+		t.Fatalf("skipped entry with an invalid option")
+	}
+}
+
+// TestProcessFeedsNewer tests that items held back by exclude-newer are
+// delivered once they're old enough.
+func TestProcessFeedsNewer(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>One</title><link>https://example.com/1</link><guid>1</guid><pubDate>Sun, 10 Mar 2024 06:00:00 UTC</pubDate></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, tst := range []struct {
+		days int
+		sent int
+	}{{0, 0}, {1, 1}, {2, 1}} {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetClock(func() time.Time { return now.AddDate(0, 0, tst.days) })
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "exclude-newer", Value: "1"},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		p.Close()
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		if len(out.titles) != tst.sent {
+			t.Fatalf("day %d: expected %d items, got %v", tst.days, tst.sent, out.titles)
+		}
+	}
+}
+
 // TestSkipExcludeCategory ensures that we can exclude items by category regexp
 func TestSkipExcludeCategory(t *testing.T) {
 	setupTestHome(t)
@@ -273,7 +469,7 @@ func TestSkipExcludeCategory(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
 	}
@@ -317,7 +513,7 @@ func TestSkipIncludeCategory(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
 	}
@@ -357,7 +553,7 @@ func TestSkipMultipleIncludeCategory(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
 	}
@@ -392,7 +588,7 @@ func TestSkipInvalidCategoryRegex(t *testing.T) {
 	}
 
 	// Create the new processor
-	x, err := New()
+	x, err := New(ProcessorConfig{})
 	if err != nil {
 		t.Fatalf("error creating processor %s", err.Error())
 	}
@@ -417,3 +613,3352 @@ func TestSkipInvalidCategoryRegex(t *testing.T) {
 		t.Fatalf("failed to skip entry when include-category has invalid regex")
 	}
 }
+
+// TestSkipLink tests filtering items by their link.
+func TestSkipLink(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	exclude := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "exclude-link", Value: `(?i)nytimes\.com`},
+	}}
+	include := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "include-link", Value: `^https://blog\.example\.com/`},
+		{Name: "include-link", Value: `example\.org`},
+	}}
+	invalid := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "exclude-link", Value: "[invalid"},
+	}}
+	invalidInclude := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "include-link", Value: "[invalid"},
+	}}
+
+	tests := []struct {
+		feed configfile.Feed
+		link string
+		skip bool
+	}{
+		{exclude, "https://www.NYTimes.com/2024/story.html", true},
+		{exclude, "https://example.com/story.html", false},
+		{exclude, "", false},
+		{include, "https://blog.example.com/post", false},
+		{include, "https://www.example.org/post", false},
+		{include, "https://www.example.com/post", true},
+		{include, "", true},
+		{invalid, "https://example.com/[invalid", false},
+		{invalidInclude, "https://example.com/[invalid", true},
+	}
+
+	for _, tst := range tests {
+		if x.shouldSkipByLink(logger, tst.feed, tst.link) != tst.skip {
+			t.Fatalf("unexpected result for %s", tst.link)
+		}
+	}
+
+	// The link is checked before the other filters.
+	skip, reason := x.TestFilter(exclude, withstate.FeedItem{Item: &gofeed.Item{Link: "https://nytimes.com/a"}})
+	if !skip || reason != "exclude-link: (?i)nytimes\\.com matched 'https://nytimes.com/a'" {
+		t.Fatalf("unexpected result %v %s", skip, reason)
+	}
+}
+
+// TestBackfillSelect ensures we pick the oldest items when backfilling.
+func TestBackfillSelect(t *testing.T) {
+
+	day := func(n int) *time.Time {
+		t := time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	// Newest first, as is usual.
+	items := []*gofeed.Item{
+		{Title: "four", PublishedParsed: day(4)},
+		{Title: "three", PublishedParsed: day(3)},
+		{Title: "one", PublishedParsed: day(1)},
+		{Title: "two", PublishedParsed: day(2)},
+	}
+
+	// Item "three" is filtered out.
+	out := backfillSelect(items, []int{0, 2, 3}, 2)
+	if len(out) != 2 || !out[2] || !out[3] {
+		t.Fatalf("wrong items selected: %v", out)
+	}
+
+	// Asking for more than we have is fine.
+	out = backfillSelect(items, []int{0, 1, 2, 3}, 10)
+	if len(out) != 4 {
+		t.Fatalf("wrong items selected: %v", out)
+	}
+
+	// Without dates we assume the oldest are at the end.
+	items[2].PublishedParsed = nil
+	out = backfillSelect(items, []int{0, 1, 2, 3}, 2)
+	if len(out) != 2 || !out[2] || !out[3] {
+		t.Fatalf("wrong items selected: %v", out)
+	}
+}
+
+// TestFeedIsNew ensures that we can identify feeds we've not processed.
+func TestFeedIsNew(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+	x.SetLogger(logger)
+
+	// A feed with no bucket is new.
+	if !x.feedIsNew("https://example.com/") {
+		t.Fatalf("missing feed should be new")
+	}
+
+	err = x.store.Record("https://example.com/", "https://example.com/0")
+	if err == nil {
+		err = x.store.Remove("https://example.com/", "https://example.com/0")
+	}
+	if err != nil {
+		t.Fatalf("failed to create bucket: %s", err)
+	}
+
+	// As is one with an empty bucket.
+	if !x.feedIsNew("https://example.com/") {
+		t.Fatalf("empty feed should be new")
+	}
+
+	err = x.recordItem("https://example.com/", "https://example.com/1")
+	if err != nil {
+		t.Fatalf("failed to record item: %s", err)
+	}
+	if x.feedIsNew("https://example.com/") {
+		t.Fatalf("feed with state shouldn't be new")
+	}
+}
+
+// recordingOutput is an output which remembers what it received.
+type recordingOutput struct {
+	titles []string
+}
+
+func (r *recordingOutput) Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+	r.titles = append(r.titles, item.Title)
+	return nil
+}
+
+func (r *recordingOutput) Close() error {
+	return nil
+}
+
+// TestProcessFeed tests processing a single feed, and the summary of it
+// which we return.
+func TestProcessFeed(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>One</title><link>https://example.com/1</link></item>
+<item><title>Spam</title><link>https://example.com/2</link></item>
+<item><title>Three</title><link>https://example.com/3</link></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	p, err := New(ProcessorConfig{Send: true})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	rec := &recordingOutput{}
+	p.SetLogger(logger)
+	p.SetOutput(rec)
+
+	feed := configfile.Feed{URL: ts.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "exclude-title", Value: "Spam"},
+	}}
+
+	result, err := p.ProcessFeed(context.Background(), feed)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.ItemsTotal != 3 || result.ItemsSent != 2 || result.ItemsSkipped != 1 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if strings.Join(rec.titles, ",") != "One,Three" {
+		t.Fatalf("unexpected items delivered %v", rec.titles)
+	}
+
+	// The result of the next run is its own, with nothing new.
+	result, err = p.ProcessFeed(context.Background(), feed)
+	if err != nil || result.ItemsTotal != 3 || result.ItemsSent != 0 || result.ItemsSkipped != 0 {
+		t.Fatalf("unexpected result %+v: %v", result, err)
+	}
+
+	// Nothing is done once we're cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = p.ProcessFeed(ctx, feed)
+	if !errors.Is(err, context.Canceled) || result.ItemsTotal != 0 || len(result.Errors) != 1 {
+		t.Fatalf("unexpected result %+v: %v", result, err)
+	}
+}
+
+// TestProcessFeedsOutput ensures new items are written to an output,
+// when one is configured, and only once.
+func TestProcessFeedsOutput(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>One</title><link>https://example.com/1</link><guid>1</guid></item>
+<item><title>Two</title><link>https://example.com/2</link><guid>2</guid></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	for run := 0; run < 2; run++ {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		p.Close()
+
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	}
+
+	if len(out.titles) != 2 || out.titles[0] != "One" || out.titles[1] != "Two" {
+		t.Fatalf("unexpected items delivered: %v", out.titles)
+	}
+}
+
+// retryOutput is an output which fails, asking for a retry, the first
+// time each item is delivered.
+type retryOutput struct {
+	attempts map[string]int
+	titles   []string
+}
+
+func (r *retryOutput) Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+	r.attempts[item.Title]++
+	if r.attempts[item.Title] == 1 {
+		return fmt.Errorf("%w: upload failed", output.ErrRetry)
+	}
+	r.titles = append(r.titles, item.Title)
+	return nil
+}
+
+func (r *retryOutput) Close() error {
+	return nil
+}
+
+// TestProcessFeedsOutputRetry ensures items which an output asks to retry
+// aren't marked as seen.
+func TestProcessFeedsOutputRetry(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>One</title><link>https://example.com/1</link><guid>1</guid></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	// The webhook only hears about the item once it is delivered.
+	var mutex sync.Mutex
+	posts := 0
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		posts++
+	}))
+	defer hook.Close()
+
+	out := &retryOutput{attempts: make(map[string]int)}
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	for run := 0; run < 3; run++ {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path, WebhookURL: hook.URL})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		p.Close()
+
+		if (run == 0) != (len(errs) != 0) {
+			t.Fatalf("run %d: unexpected errors: %v", run, errs)
+		}
+	}
+
+	if out.attempts["One"] != 2 || len(out.titles) != 1 {
+		t.Fatalf("expected one retry, got %v attempts, delivered %v", out.attempts, out.titles)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if posts != 1 {
+		t.Fatalf("expected the webhook to be called once, got %d", posts)
+	}
+}
+
+// TestMaxEmailsPerRun tests that runs stop delivering items once they
+// reach their limit, across feeds, and deliver the rest on later runs.
+func TestMaxEmailsPerRun(t *testing.T) {
+	setupTestHome(t)
+
+	feedServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>`+name+`</title>
+<item><title>`+name+` 1</title><link>https://example.com/`+name+`/1</link><guid>1</guid></item>
+<item><title>`+name+` 2</title><link>https://example.com/`+name+`/2</link><guid>2</guid></item>
+</channel></rss>`)
+		}))
+	}
+	one := feedServer("one")
+	defer one.Close()
+	two := feedServer("two")
+	defer two.Close()
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	var feeds []configfile.Feed
+	for _, ts := range []*httptest.Server{one, two} {
+		feeds = append(feeds, configfile.Feed{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}})
+	}
+
+	for run, expected := range []int{3, 4, 4} {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path, MaxEmailsPerRun: 3})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds(feeds)
+
+		errs := p.ProcessFeeds([]string{})
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		if len(out.titles) != expected {
+			t.Fatalf("run %d: expected %d items, got %v", run, expected, out.titles)
+		}
+		if run == 0 && p.Statistics()[two.URL].ItemsDeferred != 1 {
+			t.Fatalf("expected one item to be deferred, got %v", p.Statistics()[two.URL])
+		}
+		p.Close()
+	}
+
+	// Nothing was delivered twice.
+	if strings.Join(out.titles, ",") != "one 1,one 2,two 1,two 2" {
+		t.Fatalf("unexpected deliveries %v", out.titles)
+	}
+
+	// A negative limit is invalid.
+	_, err := New(ProcessorConfig{MaxEmailsPerRun: -1})
+	if err == nil {
+		t.Fatalf("expected an error with a negative limit")
+	}
+}
+
+// TestMaxItems tests that only max-items new items of a feed are processed
+// by each run, oldest first, and the rest by later runs.
+func TestMaxItems(t *testing.T) {
+	setupTestHome(t)
+
+	// The feed lists its five items newest-first.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>`)
+		for i := 5; i > 0; i-- {
+			fmt.Fprintf(w, `<item><title>Item %d</title><link>https://example.com/%d</link><guid>%d</guid></item>`, i, i, i)
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+	feeds := []configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "max-items", Value: "2"},
+	}}}
+
+	for run, expected := range []string{"Item 1,Item 2", "Item 1,Item 2,Item 3,Item 4"} {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds(feeds)
+
+		errs := p.ProcessFeeds([]string{})
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		if strings.Join(out.titles, ",") != expected {
+			t.Fatalf("run %d: unexpected deliveries %v", run, out.titles)
+		}
+
+		// Only the delivered items are marked as seen, the
+		// others are still pending.
+		keys, err := p.store.Items(ts.URL)
+		if err != nil || len(keys) != 2*(run+1) {
+			t.Fatalf("run %d: expected %d items to be recorded, got %v: %v", run, 2*(run+1), keys, err)
+		}
+		if p.Statistics()[ts.URL].ItemsDeferred != 3-2*run {
+			t.Fatalf("run %d: unexpected statistics %v", run, p.Statistics()[ts.URL])
+		}
+		p.Close()
+	}
+}
+
+// TestJSONFeed tests that the filters treat the items of a JSON Feed just
+// like those of RSS and Atom feeds.
+func TestJSONFeed(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/feed+json")
+		fmt.Fprint(w, `{"version": "https://jsonfeed.org/version/1.1", "title": "JSON", "items": [
+{"id": "1", "url": "https://example.com/1", "title": "News", "content_html": "<p>Something happened</p>", "tags": ["news"]},
+{"id": "2", "url": "https://example.com/2", "title": "Sport", "content_html": "<p>A match</p>", "tags": ["sport"]},
+{"id": "3", "url": "https://example.com/3", "title": "Advert", "content_text": "Buy things", "tags": ["news"]},
+{"id": "4", "url": "https://example.com/4", "title": "Other", "content_html": "<p>Another thing</p>", "authors": [{"name": "Steve"}]}
+]}`)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		opts     []configfile.Option
+		expected string
+	}{
+		{nil, "News,Sport,Advert,Other"},
+		{[]configfile.Option{{Name: "exclude", Value: "(?i)buy"}}, "News,Sport,Other"},
+		{[]configfile.Option{{Name: "include-title", Value: "^(News|Other)$"}}, "News,Other"},
+		{[]configfile.Option{{Name: "exclude-category", Value: "sport"}}, "News,Advert,Other"},
+		{[]configfile.Option{{Name: "include-author", Value: "Steve"}}, "Other"},
+	}
+
+	for _, tst := range tests {
+		setupTestHome(t)
+
+		p, err := New(ProcessorConfig{Send: true})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+
+		out := &recordingOutput{}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: append([]configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}, tst.opts...)}})
+
+		errs := p.ProcessFeeds([]string{})
+		p.Close()
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		if strings.Join(out.titles, ",") != tst.expected {
+			t.Fatalf("%v: unexpected deliveries %v", tst.opts, out.titles)
+		}
+	}
+}
+
+// TestResendUpdated tests that items are sent again, when resend-updated is
+// set, if their updated timestamp changes.
+func TestResendUpdated(t *testing.T) {
+
+	var mutex sync.Mutex
+	updated := "2024-01-01T00:00:00Z"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Test</title><id>urn:test</id>
+<entry><title>Item</title><id>urn:item</id><link href="https://example.com/1"/>
+<published>2024-01-01T00:00:00Z</published><updated>%s</updated><content>Body</content></entry>
+</feed>`, updated)
+	}))
+	defer ts.Close()
+
+	for _, enabled := range []bool{true, false} {
+		setupTestHome(t)
+
+		opts := []configfile.Option{{Name: "retry", Value: "0"}, {Name: "frequency", Value: "0"}}
+		if enabled {
+			opts = append(opts, configfile.Option{Name: "resend-updated", Value: "true"})
+		}
+
+		p, err := New(ProcessorConfig{Send: true})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+
+		buf := &strings.Builder{}
+		p.SetLogger(logger)
+		p.SetEmailOutput(buf)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: opts}})
+
+		// The timestamp changes before the third run, but only the
+		// format of the ones before the fourth and fifth.
+		var subjects []string
+		for _, stamp := range []string{"2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z", "2024-02-01T00:00:00+00:00", "2024-02-01T01:00:00+01:00"} {
+			mutex.Lock()
+			updated = stamp
+			mutex.Unlock()
+
+			buf.Reset()
+			errs := p.ProcessFeeds([]string{"user@example.com"})
+			if len(errs) != 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+
+			for _, line := range strings.Split(buf.String(), "\n") {
+				if strings.HasPrefix(line, "Subject: ") {
+					subjects = append(subjects, strings.TrimSpace(line))
+				}
+			}
+		}
+		p.Close()
+
+		expected := "Subject: [rss2email] Item"
+		if enabled {
+			expected += ",Subject: [rss2email] [UPDATED] Item"
+		}
+		if strings.Join(subjects, ",") != expected {
+			t.Fatalf("resend-updated=%v: unexpected emails %v", enabled, subjects)
+		}
+	}
+}
+
+// TestFilterResult tests that the results of our filters name the option
+// which caused an item to be skipped.
+func TestFilterResult(t *testing.T) {
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	feed := func(name, value string) configfile.Feed {
+		return configfile.Feed{URL: "https://example.com/", Options: []configfile.Option{{Name: name, Value: value}}}
+	}
+	old := time.Now().AddDate(0, 0, -30).Format(time.RFC1123)
+
+	tests := []struct {
+		result FilterResult
+		filter string
+		option string
+	}{
+		{x.shouldSkipWithReason(logger, feed("exclude-title", "Spam"), "Spam", "<p>Body</p>"), FilterPattern, "exclude-title"},
+		{x.shouldSkipWithReason(logger, feed("exclude", "offer"), "Title", "<p>Special offer</p>"), FilterPattern, "exclude"},
+		{x.shouldSkipWithReason(logger, feed("include-title", "Go"), "Rust", "<p>Body</p>"), FilterPattern, "include-title"},
+		{x.shouldSkipWithReason(logger, feed("include", "Go"), "Title", "<p>Rust</p>"), FilterPattern, "include"},
+		{x.shouldSkipOlderWithReason(logger, feed("exclude-older", "7"), old), FilterAge, "exclude-older"},
+		{x.shouldSkipCategoryWithReason(logger, feed("exclude-category", "sport"), []string{"sport"}), FilterCategory, "exclude-category"},
+		{x.shouldSkipCategoryWithReason(logger, feed("include-category", "tech"), []string{"sport"}), FilterCategory, "include-category"},
+	}
+
+	for _, tst := range tests {
+		if !tst.result.Skipped || tst.result.Filter != tst.filter || tst.result.MatchedOption != tst.option ||
+			!strings.HasPrefix(tst.result.Reason, tst.option+":") {
+			t.Fatalf("expected %s to skip by %s, got %+v", tst.filter, tst.option, tst.result)
+		}
+	}
+
+	// Items which pass have no reason.
+	passed := x.shouldSkipCategoryWithReason(logger, feed("exclude-category", "sport"), []string{"tech"})
+	if passed.Skipped || passed.Reason != "" || passed.MatchedOption != "" {
+		t.Fatalf("unexpected result %+v", passed)
+	}
+
+	// Every filter is explained, whether it skips the item or not.
+	item := withstate.FeedItem{Item: &gofeed.Item{Title: "Item", Link: "https://example.com/1",
+		Description: "<p>Two words</p>", Author: &gofeed.Person{Name: "Someone"}}}
+	entry := configfile.Feed{URL: "https://example.com/", Options: []configfile.Option{
+		{Name: "exclude-link", Value: "example"},
+		{Name: "exclude-author", Value: "Someone"},
+		{Name: "max-words", Value: "1"},
+	}}
+	skipped := map[string]string{}
+	results := x.ExplainFilter(entry, item)
+	for _, result := range results {
+		if result.Skipped {
+			skipped[result.MatchedOption] = result.Filter
+		}
+	}
+	if len(results) < 10 || len(skipped) != 3 || skipped["exclude-link"] != FilterPattern ||
+		skipped["exclude-author"] != FilterAuthor || skipped["max-words"] != FilterWords {
+		t.Fatalf("unexpected explanation %+v", results)
+	}
+
+	// When we're explaining our decisions they're logged at info level.
+	buf := &bytes.Buffer{}
+	x.SetLogger(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	x.FilterItems(entry, []*gofeed.Item{item.Item})
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected records %s", buf.String())
+	}
+	x.SetExplain(true)
+	x.FilterItems(entry, []*gofeed.Item{item.Item})
+	if !strings.Contains(buf.String(), `"msg":"filter decision"`) || !strings.Contains(buf.String(), `"option":"exclude-link"`) {
+		t.Fatalf("expected the decision to be logged, got %s", buf.String())
+	}
+}
+
+// TestSetLogger tests that the records of our filters go to the logger
+// we're given, with its attributes.
+func TestSetLogger(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Logs</title><link>https://example.com/</link>
+<item><title>Spam</title><link>https://example.com/1</link></item>
+<item><title>Old</title><link>https://example.com/2</link><pubDate>Mon, 01 Jan 2001 00:00:00 UTC</pubDate></item>
+<item><title>Sport</title><link>https://example.com/3</link><category>sport</category></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	buf := &bytes.Buffer{}
+	custom := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})).With("component", "rss2email")
+
+	feeds := []configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "exclude-title", Value: "Spam"},
+		{Name: "exclude-older", Value: "7"},
+		{Name: "exclude-category", Value: "sport"},
+	}}}
+
+	p, err := New(ProcessorConfig{Send: true})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	// Without a logger of our own the default discards everything.
+	p.SetOutput(&recordingOutput{})
+	p.SetFeeds(feeds)
+	if errs := p.ProcessFeeds(nil); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// Once we have one, the filters log to it.
+	p.SetLogger(custom)
+	for _, item := range []*gofeed.Item{
+		{Title: "Spam"},
+		{Title: "Old", Published: "Mon, 01 Jan 2001 00:00:00 UTC"},
+		{Title: "Sport", Categories: []string{"sport"}},
+	} {
+		if len(p.FilterItems(feeds[0], []*gofeed.Item{item})) != 0 {
+			t.Fatalf("expected %s to be filtered", item.Title)
+		}
+	}
+
+	for _, msg := range []string{
+		"excluding entry due to exclude-title",
+		"excluding entry due to exclude-older setting",
+		"excluding entry due to exclude-category",
+	} {
+		found := false
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("failed to parse log record %q: %s", line, err)
+			}
+			if record["msg"] == msg && record["component"] == "rss2email" {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected a record %q, got %s", msg, buf.String())
+		}
+	}
+}
+
+// TestLimitContent tests that long content is truncated at a word
+// boundary, with a link to the rest of the item.
+func TestLimitContent(t *testing.T) {
+
+	entry := configfile.Feed{Options: []configfile.Option{{Name: "max-content-length", Value: "10"}}}
+	link := "https://example.com/1?a=1&b=2"
+	more := `<p><a href="https://example.com/1?a=1&amp;b=2">Read more →</a></p>`
+
+	tests := map[string]string{
+		"<p>The quick brown fox</p>":             "<p>The quick…</p>" + more,
+		"<p>One <b>two three</b></p><p>four</p>": "<p>One <b>two…</b></p>" + more,
+		"<p>Unbreakable-word</p>":                "<p>…</p>" + more,
+	}
+
+	for input, expected := range tests {
+		got := limitContent(logger, entry, link, input)
+		if !strings.Contains(got, "<body>"+expected+"</body>") {
+			t.Fatalf("limitContent(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+
+	// Content which is short enough, once the markup is removed, is
+	// left alone, as is all content without the option.
+	for _, input := range []string{"<p>Short</p>", "<p>Exactly <b>ten</b></p>", "<p>  Lots\n\n of   it  </p>"} {
+		if got := limitContent(logger, entry, link, input); got != input {
+			t.Fatalf("limitContent(%q) = %q, expected it unchanged", input, got)
+		}
+	}
+	long := "<p>" + strings.Repeat("word ", 100) + "</p>"
+	if got := limitContent(logger, configfile.Feed{}, link, long); got != long {
+		t.Fatalf("content was changed without max-content-length: %q", got)
+	}
+}
+
+// TestInlineImages tests that images are embedded in emails as data: URIs,
+// unless they can't be fetched or are too large.
+func TestInlineImages(t *testing.T) {
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	pixel := &bytes.Buffer{}
+	png.Encode(pixel, img)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pixel.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pixel.Bytes())
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat(pixel.Bytes(), 100))
+		case "/missing.png":
+			http.NotFound(w, r)
+		default:
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Images</title><link>https://example.com/</link>
+<item><title>Item</title><link>https://example.com/1</link>
+<description><![CDATA[<p><img src="%[1]s/pixel.png"><img src="%[1]s/large.png"><img src="%[1]s/missing.png"></p>]]></description></item>
+</channel></rss>`, ts.URL)
+		}
+	}))
+	defer ts.Close()
+
+	for _, enabled := range []bool{true, false} {
+		setupTestHome(t)
+
+		opts := []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "max-image-size", Value: fmt.Sprintf("%d", pixel.Len()*10)},
+		}
+		if enabled {
+			opts = append(opts, configfile.Option{Name: "inline-images", Value: "true"})
+		}
+
+		p, err := New(ProcessorConfig{Send: true})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+
+		buf := &strings.Builder{}
+		p.SetLogger(logger)
+		p.SetEmailOutput(buf)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: opts}})
+
+		errs := p.ProcessFeeds([]string{"user@example.com"})
+		p.Close()
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		body, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(buf.String())))
+		if err != nil {
+			t.Fatalf("failed to decode email: %s", err)
+		}
+
+		// Only the small image is inlined, the others stay remote.
+		uri := `src="data:image/png;base64,` + base64.StdEncoding.EncodeToString(pixel.Bytes()) + `"`
+		if strings.Contains(string(body), uri) != enabled {
+			t.Fatalf("inline-images %t: unexpected email %s", enabled, body)
+		}
+		for _, name := range []string{"large.png", "missing.png"} {
+			if !strings.Contains(string(body), `src="`+ts.URL+"/"+name+`"`) {
+				t.Fatalf("expected %s to remain remote in %s", name, body)
+			}
+		}
+		if strings.Contains(string(body), ts.URL+"/pixel.png") == enabled {
+			t.Fatalf("inline-images %t: unexpected pixel.png in %s", enabled, body)
+		}
+	}
+}
+
+// TestFeedName tests that the feed-name option replaces the title of the
+// feed in emails.
+func TestFeedName(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>XML Title</title><link>https://example.com/</link>
+<item><title>Item</title><link>https://example.com/1</link><description>Body</description></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	for _, name := range []string{"My Custom Name", ""} {
+		setupTestHome(t)
+
+		opts := []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "subject-template", Value: "[{{.FeedTitle}}] {{.ItemTitle}}"},
+		}
+		if name != "" {
+			opts = append(opts, configfile.Option{Name: "feed-name", Value: name})
+		}
+
+		p, err := New(ProcessorConfig{Send: true, DefaultFrom: "sender@example.com"})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+
+		buf := &strings.Builder{}
+		p.SetLogger(logger)
+		p.SetEmailOutput(buf)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: opts}})
+
+		errs := p.ProcessFeeds([]string{"user@example.com"})
+		p.Close()
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		expected := name
+		if expected == "" {
+			expected = "XML Title"
+		}
+		for _, header := range []string{
+			"Subject: [" + expected + "] Item\n",
+			"From: \"" + expected + "\" <sender@example.com>\n",
+		} {
+			if !strings.Contains(strings.ReplaceAll(buf.String(), "\r\n", "\n"), header) {
+				t.Fatalf("expected %q in email %s", header, buf.String())
+			}
+		}
+	}
+}
+
+// TestUserAgent tests the User-Agent we send when fetching feeds.
+func TestUserAgent(t *testing.T) {
+	setupTestHome(t)
+
+	// The server shows the User-Agent as the title of its only item.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>%s</title><link>https://example.com/1</link></item>
+</channel></rss>`, html.EscapeString(r.UserAgent()))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		opts     []configfile.Option
+		expected string
+	}{
+		{nil, "rss2email 1.2.3 (https://github.com/skx/rss2email)"},
+		{[]configfile.Option{{Name: "user-agent", Value: "Mozilla/5.0 <custom>"}}, "Mozilla/5.0 <custom>"},
+	}
+
+	for _, tst := range tests {
+		out := &recordingOutput{}
+
+		p, err := New(ProcessorConfig{Send: true, Version: "1.2.3"})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: append([]configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}, tst.opts...)}})
+
+		errs := p.ProcessFeeds([]string{})
+		p.Close()
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		if len(out.titles) != 1 || out.titles[0] != tst.expected {
+			t.Fatalf("expected User-Agent %q, got %v", tst.expected, out.titles)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	setupTestHome(t)
+	os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".rss2email"), 0755)
+
+	// The server replies to conditional requests with 304, and adds
+	// a new item each time it sends the feed in full.
+	conditional := 0
+	full := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", `"abc"`)
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>Item %d</title><link>https://example.com/%d</link></item>
+</channel></rss>`, full, full)
+	}))
+	defer ts.Close()
+
+	out := &recordingOutput{}
+	for i := 0; i < 2; i++ {
+		p, err := New(ProcessorConfig{Send: true})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		p.Close()
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	}
+
+	if full != 1 || conditional != 1 {
+		t.Fatalf("expected one full and one conditional fetch, got %d and %d", full, conditional)
+	}
+	if len(out.titles) != 1 || out.titles[0] != "Item 1" {
+		t.Fatalf("unexpected items delivered %v", out.titles)
+	}
+}
+
+// TestDryRun tests that a dry-run writes the emails which would be sent,
+// without recording anything in our state.
+func TestDryRun(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title><link>https://example.com/</link>
+<item><title>Item One</title><link>https://example.com/1</link><guid>1</guid><description>The first body</description></item>
+<item><title>Item Two</title><link>https://example.com/2</link><guid>2</guid><description>The second body</description></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	// Each run should write the same emails, as nothing is recorded.
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		p, err := New(ProcessorConfig{})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+
+		buf := &strings.Builder{}
+		p.SetLogger(logger)
+		p.SetDryRun(buf)
+
+		// The dry-run takes precedence.
+		p.SetSendEmail(true)
+
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "bulk-send-mode", Value: "yes"},
+		}}})
+
+		errs := p.ProcessFeeds([]string{"user@example.com"})
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		keys, err := p.store.Items(ts.URL)
+		if err != nil {
+			t.Fatalf("failed to read state: %s", err)
+		}
+		if len(keys) != 0 {
+			t.Fatalf("a dry-run recorded items %v", keys)
+		}
+		p.Close()
+
+		outputs = append(outputs, buf.String())
+	}
+
+	got := outputs[0]
+	for _, expected := range []string{
+		"To: user@example.com",
+		"Subject: [rss2email] Item One",
+		"Subject: [rss2email] Item Two",
+		"The first body",
+		"The second body",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Fatalf("expected %q in the output, got %s", expected, got)
+		}
+	}
+	if strings.Count(got, "\nTo: user@example.com") != 2 {
+		t.Fatalf("expected two emails, got %s", got)
+	}
+	if outputs[1] != got {
+		t.Fatalf("dry-runs differ:\n%s\n%s", got, outputs[1])
+	}
+}
+
+// TestEmailOutput tests writing our emails, rather than sending them.
+func TestEmailOutput(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title><link>https://example.com/</link>
+<item><title>Item One</title><link>https://example.com/1</link><guid>1</guid><description>The first body</description></item>
+<item><title>Item Two</title><link>https://example.com/2</link><guid>2</guid><description>The second body</description></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	p, err := New(ProcessorConfig{Send: true})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	buf := &strings.Builder{}
+	p.SetLogger(logger)
+	p.SetEmailOutput(buf)
+	p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+	}}})
+
+	errs := p.ProcessFeeds([]string{"user@example.com"})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// Each email begins with the header naming its feed.
+	emails := strings.Split(buf.String(), "X-rss2email-feed: "+ts.URL+"\n")
+	if len(emails) != 3 || emails[0] != "" {
+		t.Fatalf("expected two emails, got %s", buf.String())
+	}
+	for i, expected := range []string{"Item One\n", "The first body", "Item Two\n", "The second body"} {
+		if !strings.Contains(emails[i/2+1], expected) {
+			t.Fatalf("expected %q in email %s", expected, emails[i/2+1])
+		}
+	}
+
+	// Unlike a dry-run, the items are recorded.
+	keys, err := p.store.Items(ts.URL)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("expected 2 items to be recorded, got %v: %v", keys, err)
+	}
+}
+
+// TestBodyTemplateFile tests rendering email bodies from a template file,
+// which is read again by each run.
+func TestBodyTemplateFile(t *testing.T) {
+	setupTestHome(t)
+
+	var runs atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := runs.Load()
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title><link>https://example.com/</link>
+<item><title>Item %d</title><link>https://example.com/%d</link><guid>%d</guid><description>The body</description></item>
+</channel></rss>`, n, n, n)
+	}))
+	defer ts.Close()
+
+	err := os.MkdirAll(state.Directory(), 0755)
+	if err != nil {
+		t.Fatalf("failed to create the state directory: %s", err)
+	}
+	path := filepath.Join(state.Directory(), "body.tmpl")
+
+	p, err := New(ProcessorConfig{Send: true})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	buf := &strings.Builder{}
+	p.SetLogger(logger)
+	p.SetEmailOutput(buf)
+	p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "body-template", Value: "./body.tmpl"},
+	}}})
+
+	for i, tmpl := range []string{"<p>First {{.ItemTitle}}</p>", "<p>Second {{.ItemTitle}}</p>"} {
+		err = os.WriteFile(path, []byte(tmpl), 0644)
+		if err != nil {
+			t.Fatalf("failed to write template: %s", err)
+		}
+		runs.Store(int32(i))
+		buf.Reset()
+
+		errs := p.ProcessFeeds([]string{"user@example.com"})
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		expected := fmt.Sprintf("%s Item %d", []string{"First", "Second"}[i], i)
+		if !strings.Contains(buf.String(), expected) || strings.Contains(buf.String(), "The body") {
+			t.Fatalf("expected %q in email %s", expected, buf.String())
+		}
+	}
+
+	// A missing file leaves the item's content in place.
+	os.Remove(path)
+	runs.Store(2)
+	buf.Reset()
+	errs := p.ProcessFeeds([]string{"user@example.com"})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if !strings.Contains(buf.String(), "The body") {
+		t.Fatalf("expected the item's content in email %s", buf.String())
+	}
+}
+
+// TestWebhook tests POSTing new items to a webhook, with a signature.
+func TestWebhook(t *testing.T) {
+	setupTestHome(t)
+
+	bak := webhookRetryDelay
+	webhookRetryDelay = 0
+	defer func() { webhookRetryDelay = bak }()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>First</title><link>https://example.com/1</link><author>steve@example.com (Steve)</author>
+<pubDate>Sun, 10 Mar 2024 12:00:00 GMT</pubDate><description>The first body</description></item>
+<item><title>Second</title><link>https://example.com/2</link><description>The second body</description></item>
+</channel></rss>`)
+	}))
+	defer feed.Close()
+
+	// Record the payloads, rejecting those which aren't signed
+	// correctly, and failing the first attempt at the second item.
+	var mutex sync.Mutex
+	var payloads []WebhookPayload
+	attempts := 0
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" ||
+			!hmac.Equal([]byte(r.Header.Get(WebhookSignatureHeader)), []byte(SignWebhook(body, "secret"))) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if attempts == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payloads = append(payloads, payload)
+	}))
+	defer hook.Close()
+
+	// The per-feed secret replaces the global one.
+	p, err := New(ProcessorConfig{Send: true, WebhookURL: hook.URL, WebhookSecret: "wrong"})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	p.SetLogger(logger)
+	p.SetOutput(&recordingOutput{})
+	p.SetFeeds([]configfile.Feed{{URL: feed.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "sleep", Value: "0"},
+		{Name: "webhook-secret", Value: "secret"},
+	}}})
+
+	errs := p.ProcessFeeds(nil)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// Both items arrived, the second after being retried.
+	if attempts != 3 || len(payloads) != 2 {
+		t.Fatalf("expected 2 payloads after 3 attempts, got %v after %d", payloads, attempts)
+	}
+	expected := WebhookPayload{
+		FeedURL:       feed.URL,
+		ItemTitle:     "First",
+		ItemLink:      "https://example.com/1",
+		ItemAuthor:    "Steve, steve@example.com",
+		ItemPublished: "2024-03-10T12:00:00Z",
+	}
+
+	// The body is the HTML that would be emailed.
+	body := payloads[0].ItemBody
+	payloads[0].ItemBody = ""
+	if payloads[0] != expected || !strings.Contains(body, "The first body") {
+		t.Fatalf("unexpected payload %+v, with body %s", payloads[0], body)
+	}
+	if payloads[1].ItemTitle != "Second" || !strings.Contains(payloads[1].ItemBody, "The second body") || payloads[1].ItemPublished != "" {
+		t.Fatalf("unexpected payload %+v", payloads[1])
+	}
+
+	// A webhook which keeps failing is only tried twice, and the
+	// items are still delivered.
+	attempts = 0
+	out := &recordingOutput{}
+	p.SetOutput(out)
+	p.SetFeeds([]configfile.Feed{{URL: feed.URL + "/other", Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "sleep", Value: "0"},
+	}}})
+	errs = p.ProcessFeeds(nil)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	stats := p.Statistics()[feed.URL+"/other"]
+	if attempts != 4 || stats.WebhookErrors != 2 || stats.ItemsSent != 2 {
+		t.Fatalf("expected 4 attempts and 2 errors, got %d and %+v", attempts, stats)
+	}
+
+	// The webhook must be http:// or https://.
+	_, err = New(ProcessorConfig{WebhookURL: "ftp://example.com/"})
+	if err == nil {
+		t.Fatalf("expected an error with an invalid webhook URL")
+	}
+}
+
+// TestStatus tests that the status of a complete run is recorded.
+func TestStatus(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>First</title><link>https://example.com/1</link></item>
+<item><title>Second</title><link>https://example.com/2</link></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "status.json")
+	p, err := New(ProcessorConfig{Send: true, StatusPath: path})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	options := []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "sleep", Value: "0"},
+	}
+	p.SetLogger(logger)
+	p.SetOutput(&recordingOutput{})
+	p.SetFeeds([]configfile.Feed{
+		{URL: ts.URL + "/feed", Options: options},
+		{URL: ts.URL + "/broken", Options: options},
+	})
+
+	before := time.Now().Add(-time.Second)
+	p.ProcessFeeds(nil)
+
+	status, err := ReadStatus(path)
+	if err != nil {
+		t.Fatalf("failed to read status: %s", err)
+	}
+	if status.LastRun.Before(before) || status.FeedsOK != 1 || status.FeedsError != 1 || status.EmailsSent != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// A cancelled run doesn't update the status.
+	os.Remove(path)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.ProcessOnce(ctx)
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("a cancelled run recorded its status")
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>First</title><link>https://example.com/1</link></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	// The Maildir is created, and receives the email.
+	path := filepath.Join(t.TempDir(), "Maildir")
+	p, err := New(ProcessorConfig{Send: true, Maildir: path})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	p.SetLogger(logger)
+	p.SetFeeds([]configfile.Feed{
+		{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "sleep", Value: "0"},
+		}},
+	})
+
+	errs := p.ProcessFeeds([]string{"user@example.com"})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	files, err := os.ReadDir(filepath.Join(path, "new"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 email, got %d: %v", len(files), err)
+	}
+	data, err := os.ReadFile(filepath.Join(path, "new", files[0].Name()))
+	if err != nil || !strings.Contains(string(data), "To: user@example.com") {
+		t.Fatalf("unexpected email %q: %v", data, err)
+	}
+
+	// We can't deliver to both a Maildir and an mbox.
+	_, err = New(ProcessorConfig{Maildir: path, Mbox: filepath.Join(t.TempDir(), "feeds.mbox")})
+	if err == nil {
+		t.Fatalf("expected an error with a maildir and an mbox")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	setupTestHome(t)
+
+	// Count the fetches, and fail them until we're told otherwise.
+	var mutex sync.Mutex
+	fetches := 0
+	broken := true
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches++
+		if broken {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>First</title><link>https://example.com/1</link></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	p, err := New(ProcessorConfig{Send: true, CircuitBreakerThreshold: 3, CircuitBreakerReset: time.Hour})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	now := time.Now()
+	p.SetClock(func() time.Time { return now })
+	p.SetLogger(logger)
+	p.SetOutput(&recordingOutput{})
+	p.SetFeeds([]configfile.Feed{
+		{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "sleep", Value: "0"},
+		}},
+	})
+
+	// The feed stops being fetched once it has failed three times.
+	for i := 0; i < 5; i++ {
+		p.ProcessFeeds(nil)
+	}
+	if fetches != 3 {
+		t.Fatalf("expected 3 fetches, got %d", fetches)
+	}
+	if !p.Statistics()[ts.URL].CircuitOpen {
+		t.Fatalf("expected the feed to be skipped")
+	}
+
+	// Once the reset period has passed it is tried again, and another
+	// failure means we wait again.
+	now = now.Add(2 * time.Hour)
+	p.ProcessFeeds(nil)
+	p.ProcessFeeds(nil)
+	if fetches != 4 {
+		t.Fatalf("expected 4 fetches, got %d", fetches)
+	}
+
+	// A successful fetch resets the count of failures.
+	now = now.Add(2 * time.Hour)
+	mutex.Lock()
+	broken = false
+	mutex.Unlock()
+	p.ProcessFeeds(nil)
+	count, _, err := p.store.Failures(ts.URL)
+	if err != nil || count != 0 || fetches != 5 {
+		t.Fatalf("expected the failures to be reset after 5 fetches, got %d after %d: %v", count, fetches, err)
+	}
+
+	// Negative settings are invalid.
+	_, err = New(ProcessorConfig{CircuitBreakerThreshold: -1})
+	if err == nil {
+		t.Fatalf("expected an error with a negative threshold")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	setupTestHome(t)
+
+	// Record how many feeds are being fetched at once.
+	var mutex sync.Mutex
+	inflight, busiest := 0, 0
+
+	var feeds []configfile.Feed
+	for i := 0; i < 8; i++ {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			inflight++
+			busiest = max(busiest, inflight)
+			mutex.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			mutex.Lock()
+			inflight--
+			mutex.Unlock()
+
+			// One feed is broken.
+			if i == 3 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Feed %d</title>
+<item><title>Feed %d 1</title><link>https://example.com/%d/1</link></item>
+<item><title>Feed %d 2</title><link>https://example.com/%d/2</link></item>
+</channel></rss>`, i, i, i, i, i)
+		}))
+		defer ts.Close()
+
+		feeds = append(feeds, configfile.Feed{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}})
+	}
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	// The second run sends the items the first deferred.
+	for run, sent := range []int{10, 14} {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path, Concurrency: 4, MaxEmailsPerRun: 10})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds(feeds)
+
+		errs := p.ProcessFeeds([]string{})
+		stats := p.Statistics()
+		p.Close()
+
+		// The broken feed doesn't stop the others.
+		var fe *FeedError
+		if len(errs) != 1 || !errors.As(errs[0], &fe) || fe.FeedURL != feeds[3].URL {
+			t.Fatalf("run %d: expected an error from the broken feed, got %v", run, errs)
+		}
+		if len(stats) != len(feeds) {
+			t.Fatalf("run %d: expected statistics for every feed, got %d", run, len(stats))
+		}
+		if len(out.titles) != sent {
+			t.Fatalf("run %d: expected %d items in total, got %d: %v", run, sent, len(out.titles), out.titles)
+		}
+	}
+
+	// Each item was sent exactly once.
+	seen := make(map[string]bool)
+	for _, title := range out.titles {
+		if seen[title] {
+			t.Fatalf("item sent twice: %s", title)
+		}
+		seen[title] = true
+	}
+
+	if busiest < 2 || busiest > 4 {
+		t.Fatalf("expected between two and four feeds to be fetched at once, got %d", busiest)
+	}
+
+	// Negative values are rejected.
+	_, err := New(ProcessorConfig{Concurrency: -1})
+	if err == nil {
+		t.Fatalf("expected an error with a negative concurrency")
+	}
+}
+
+// itemOutput records the items delivered to it.
+type itemOutput struct {
+	items []withstate.FeedItem
+}
+
+func (o *itemOutput) Deliver(feedURL string, feed *gofeed.Feed, item withstate.FeedItem) error {
+	o.items = append(o.items, item)
+	return nil
+}
+
+func (o *itemOutput) Close() error {
+	return nil
+}
+
+// TestDigest tests that the new items of a feed with the digest option
+// are delivered together, and that nothing is sent without new items.
+func TestDigest(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title><link>https://example.com/</link>
+<item><title>One</title><link>https://example.com/1</link><guid>1</guid><author>steve@example.com</author><pubDate>Sun, 10 Mar 2024 06:00:00 UTC</pubDate><description>The first &lt;b&gt;item&lt;/b&gt;.</description></item>
+<item><title>Two &amp; more</title><link>https://example.com/2</link><guid>2</guid></item>
+<item><title>Spam</title><link>https://example.com/3</link><guid>3</guid></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	out := &itemOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	for run := 0; run < 2; run++ {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "exclude-title", Value: "Spam"},
+			{Name: "digest", Value: "yes"},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if p.Statistics()[ts.URL].ItemsSent != 2-2*run {
+			t.Fatalf("run %d: unexpected statistics %v", run, p.Statistics()[ts.URL])
+		}
+		p.Close()
+	}
+
+	// The second run found nothing new, so sent nothing.
+	if len(out.items) != 1 {
+		t.Fatalf("expected a single digest, got %d", len(out.items))
+	}
+
+	item := out.items[0]
+	if item.Title != "2 new items" || item.FeedName != "Test" {
+		t.Fatalf("unexpected digest %s from %s", item.Title, item.FeedName)
+	}
+	for _, expected := range []string{
+		`<h2><a href="https://example.com/1">One</a></h2>`,
+		"<p>steve@example.com - Sun, 10 Mar 2024 06:00:00 UTC</p>",
+		"<p>The first item.</p>",
+		"<hr>",
+		`<a href="https://example.com/2">Two &amp; more</a>`,
+	} {
+		if !strings.Contains(item.Content, expected) {
+			t.Fatalf("digest doesn't contain %s: %s", expected, item.Content)
+		}
+	}
+	if strings.Contains(item.Content, "Spam") {
+		t.Fatalf("digest contains a filtered item: %s", item.Content)
+	}
+}
+
+// TestDigestEmail tests the email a digest is sent as.
+func TestDigestEmail(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	d := &digest{}
+	d.add(withstate.FeedItem{Item: &gofeed.Item{Title: "One", GUID: "1"}}, "<p>"+strings.Repeat("word ", 100)+"</p>")
+
+	entry := configfile.Feed{URL: "https://example.com/feed"}
+	item, err := d.item(entry, &gofeed.Feed{Title: "Feed"}, "")
+	if err != nil {
+		t.Fatalf("failed to create digest: %s", err)
+	}
+	if item.Title != "1 new item" || !strings.Contains(item.Content, "word…") {
+		t.Fatalf("unexpected digest %s %s", item.Title, item.Content)
+	}
+
+	// The subject counts the items.
+	helper := x.newEmailer(entry, &gofeed.Feed{Title: "Feed"}, item, logger)
+	helper.SetSubjectTemplate(digestSubject)
+	msg, err := helper.Render("user@example.com", "", item.Content)
+	if err != nil {
+		t.Fatalf("failed to render: %s", err)
+	}
+	if !strings.Contains(string(msg), "\nSubject: [Feed] 1 new item") {
+		t.Fatalf("unexpected message %s", msg)
+	}
+}
+
+// TestResetOnConfigChange tests that the state of feeds whose options
+// change is cleared, if we ask for that.
+func TestResetOnConfigChange(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>One</title><link>https://example.com/1</link><guid>1</guid></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	tests := []struct {
+		tag   string
+		reset bool
+		sent  int
+	}{
+		{"one", false, 1},
+
+		// Nothing has changed.
+		{"one", true, 1},
+
+		// The options changed, but we only warn.
+		{"two", false, 1},
+
+		// Now they change and we forget the feed.
+		{"three", true, 2},
+	}
+
+	for _, tst := range tests {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path, ResetOnConfigChange: tst.reset})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "tag", Value: tst.tag},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		p.Close()
+
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if len(out.titles) != tst.sent {
+			t.Fatalf("tag %s: expected %d items sent, got %v", tst.tag, tst.sent, out.titles)
+		}
+	}
+
+	// The fingerprints aren't reported as a feed.
+	store, err := state.OpenBolt(path)
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	defer store.Close()
+
+	feeds, err := store.Feeds()
+	if err != nil || len(feeds) != 1 || feeds[0] != ts.URL {
+		t.Fatalf("unexpected feeds in state %v %v", feeds, err)
+	}
+}
+
+// TestSQLiteState tests recording our state in a SQLite database.
+func TestSQLiteState(t *testing.T) {
+	setupTestHome(t)
+
+	items := `<item><title>One</title><link>https://example.com/1</link></item>
+<item><title>Two</title><link>https://example.com/2</link></item>`
+	feedServer := func(content *string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>`+*content+`</channel></rss>`)
+		}))
+	}
+	one := feedServer(&items)
+	defer one.Close()
+	other := `<item><title>Other</title><link>https://example.com/other</link></item>`
+	two := feedServer(&other)
+	defer two.Close()
+
+	feed := func(url string) configfile.Feed {
+		return configfile.Feed{URL: url, Options: []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}}
+	}
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.sqlite.db")
+	unused := filepath.Join(t.TempDir(), "state.db")
+
+	run := func(feeds ...configfile.Feed) {
+		p, err := New(ProcessorConfig{Send: true, StatePath: unused, StateDB: path})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds(feeds)
+
+		err = p.ProcessOnce(context.Background())
+		p.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// The items are only sent once.
+	run(feed(one.URL), feed(two.URL))
+	run(feed(one.URL), feed(two.URL))
+	if strings.Join(out.titles, ",") != "One,Two,Other" {
+		t.Fatalf("unexpected items delivered: %v", out.titles)
+	}
+
+	// Items which have left the feed, and feeds we no longer
+	// process, are pruned.
+	items = `<item><title>Two</title><link>https://example.com/2</link></item>`
+	run(feed(one.URL))
+
+	store, err := state.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("failed to open state: %s", err)
+	}
+	defer store.Close()
+
+	feeds, err := store.Feeds()
+	if err != nil || len(feeds) != 1 || feeds[0] != one.URL {
+		t.Fatalf("unexpected feeds in state %v %v", feeds, err)
+	}
+	keys, err := store.Items(one.URL)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("unexpected items in state %v %v", keys, err)
+	}
+	fp, err := store.Fingerprint(one.URL)
+	if err != nil || fp != feed(one.URL).Fingerprint() {
+		t.Fatalf("unexpected fingerprint %q %v", fp, err)
+	}
+
+	// The BoltDB database wasn't used.
+	if _, err = os.Stat(unused); !os.IsNotExist(err) {
+		t.Fatalf("expected no BoltDB database, got %v", err)
+	}
+
+	// State may be held in memory.
+	p, err := New(ProcessorConfig{Send: true, StateDB: ":memory:"})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+	p.SetOutput(out)
+	p.SetFeeds([]configfile.Feed{feed(two.URL)})
+	for i := 0; i < 2; i++ {
+		errs := p.ProcessFeeds([]string{})
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	}
+	if len(out.titles) != 4 || out.titles[3] != "Other" {
+		t.Fatalf("unexpected items delivered: %v", out.titles)
+	}
+}
+
+// TestLock tests that only one processor may use the same state at once.
+func TestLock(t *testing.T) {
+	setupTestHome(t)
+
+	for _, cfg := range []ProcessorConfig{
+		{StatePath: filepath.Join(t.TempDir(), "state.db"), LockTimeout: 200 * time.Millisecond},
+		{StateDB: filepath.Join(t.TempDir(), "state.db"), LockTimeout: 200 * time.Millisecond},
+	} {
+
+		// Start two processors at once, the one which gets the lock
+		// holds it for longer than the other will wait.
+		results := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				p, err := New(cfg)
+				if err == nil {
+					time.Sleep(500 * time.Millisecond)
+					p.Close()
+				}
+				results <- err
+			}()
+		}
+
+		var errs []error
+		for i := 0; i < 2; i++ {
+			if err := <-results; err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrLocked) {
+			t.Fatalf("expected one processor to be locked out, got %v", errs)
+		}
+
+		// Once it is closed the state may be used again.
+		p, err := New(cfg)
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.Close()
+	}
+
+	// Temporary state isn't shared, so isn't locked.
+	a, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer a.Close()
+	b, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer b.Close()
+
+	_, err = New(ProcessorConfig{LockTimeout: -time.Second})
+	if err == nil {
+		t.Fatalf("expected an error with a negative lock timeout")
+	}
+}
+
+// TestSetTemplate tests setting the email template.
+func TestSetTemplate(t *testing.T) {
+	setupTestHome(t)
+
+	p, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	err = p.SetTemplateString("Subject: {{.Subject}}\n\n{{.Text}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.template == nil {
+		t.Fatalf("template wasn't set")
+	}
+
+	err = p.SetTemplateString("{{.Subject")
+	if err == nil {
+		t.Fatalf("expected parse error")
+	}
+
+	// From a file
+	path := filepath.Join(t.TempDir(), "email.tmpl")
+	err = os.WriteFile(path, []byte("{{.Bogus}}"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write template: %s", err)
+	}
+	err = p.SetTemplateFromFile(path)
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+
+	err = p.SetTemplateFromFile(filepath.Join(t.TempDir(), "missing.tmpl"))
+	if err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+}
+
+// TestCountSentences tests our sentence-counting heuristic.
+func TestCountSentences(t *testing.T) {
+
+	tests := []struct {
+		content string
+		count   int
+	}{
+		{"", 0},
+		{"One sentence.", 1},
+		{"One sentence", 1},
+		{"<p>One.</p><p>Two!</p><p>Three?</p>", 3},
+		{"Version 1.2 is out. Get it now", 2},
+		{"<h1>A heading.</h1><p>Some text.</p>", 1},
+		{"<ul><li>First.</li><li>Second.</li></ul><p>Only this.</p>", 1},
+		{"What?! Really... Yes.", 3},
+	}
+
+	for _, tst := range tests {
+		out := countSentences(tst.content)
+		if out != tst.count {
+			t.Fatalf("expected %d sentences in %q, got %d", tst.count, tst.content, out)
+		}
+	}
+}
+
+// TestSkipMinLength tests the minimum word and sentence filters.
+func TestSkipMinLength(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	content := "<p>This has four words. And two sentences.</p>"
+
+	tests := []struct {
+		opts []configfile.Option
+		skip bool
+	}{
+		{nil, false},
+		{[]configfile.Option{{Name: "include-sentences-min", Value: "0"}}, false},
+		{[]configfile.Option{{Name: "include-sentences-min", Value: "2"}}, false},
+		{[]configfile.Option{{Name: "include-sentences-min", Value: "3"}}, true},
+		{[]configfile.Option{{Name: "include-sentences-min", Value: "bogus"}}, false},
+		{[]configfile.Option{{Name: "include-words-min", Value: "7"}}, false},
+		{[]configfile.Option{{Name: "include-words-min", Value: "8"}}, true},
+		{[]configfile.Option{{Name: "min-words", Value: "7"}}, false},
+		{[]configfile.Option{{Name: "min-words", Value: "8"}}, true},
+		{[]configfile.Option{{Name: "min-words", Value: "many"}}, false},
+		{[]configfile.Option{{Name: "max-words", Value: "7"}}, false},
+		{[]configfile.Option{{Name: "max-words", Value: "6"}}, true},
+		{[]configfile.Option{{Name: "max-words", Value: "few"}}, false},
+
+		// Both must pass
+		{[]configfile.Option{
+			{Name: "include-words-min", Value: "7"},
+			{Name: "include-sentences-min", Value: "3"},
+		}, true},
+		{[]configfile.Option{
+			{Name: "include-words-min", Value: "8"},
+			{Name: "include-sentences-min", Value: "2"},
+		}, true},
+		{[]configfile.Option{
+			{Name: "include-words-min", Value: "7"},
+			{Name: "include-sentences-min", Value: "2"},
+		}, false},
+	}
+
+	for i, tst := range tests {
+
+		feed := configfile.Feed{URL: "blah", Options: tst.opts}
+		item := withstate.FeedItem{Item: &gofeed.Item{Title: "Title", Content: content}}
+
+		if x.skipItem(logger, feed, item, content) != tst.skip {
+			t.Fatalf("test %d: expected skip=%v", i, tst.skip)
+		}
+	}
+}
+
+// TestSkipNoContent tests the exclude-if-no-content filter.
+func TestSkipNoContent(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	enabled := configfile.Feed{URL: "blah", Options: []configfile.Option{{Name: "exclude-if-no-content", Value: "true"}}}
+	disabled := configfile.Feed{URL: "blah", Options: []configfile.Option{{Name: "exclude-if-no-content", Value: "no"}}}
+
+	tests := []struct {
+		content string
+		skip    bool
+	}{
+		{"", true},
+		{"<p></p>", true},
+		{" \n\t ", true},
+		{"<div> <br/>&nbsp;</div>", true},
+		{"<p>Some text</p>", false},
+		{"Text", false},
+	}
+
+	for _, tst := range tests {
+		if x.shouldSkipByNoContent(logger, enabled, tst.content) != tst.skip {
+			t.Fatalf("%q: expected skip=%v", tst.content, tst.skip)
+		}
+		if x.shouldSkipByNoContent(logger, disabled, tst.content) {
+			t.Fatalf("%q: expected to be kept when disabled", tst.content)
+		}
+	}
+
+	// The other filters are applied first.
+	filter, _ := x.filterReason(logger, configfile.Feed{URL: "blah", Options: append(enabled.Options,
+		configfile.Option{Name: "include-title", Value: "Wanted"})},
+		withstate.FeedItem{Item: &gofeed.Item{Title: "Other"}}, "<p></p>")
+	if filter != FilterPattern {
+		t.Fatalf("expected the include-title filter first, got %q", filter)
+	}
+	filter, _ = x.filterReason(logger, enabled, withstate.FeedItem{Item: &gofeed.Item{Title: "Wanted"}}, "<p></p>")
+	if filter != FilterNoContent {
+		t.Fatalf("expected the exclude-if-no-content filter, got %q", filter)
+	}
+}
+
+// TestSkipEnclosure tests the include-has-enclosure and exclude-has-enclosure
+// filters.
+func TestSkipEnclosure(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	episode := []*gofeed.Enclosure{{URL: "https://example.com/1.mp3", Type: "audio/mpeg"}}
+	empty := []*gofeed.Enclosure{{URL: " "}}
+
+	tests := []struct {
+		opts       []configfile.Option
+		enclosures []*gofeed.Enclosure
+		skip       bool
+	}{
+		// Neither option, or both disabled, skips nothing.
+		{nil, episode, false},
+		{nil, nil, false},
+		{[]configfile.Option{{Name: "include-has-enclosure", Value: "false"}}, nil, false},
+		{[]configfile.Option{{Name: "exclude-has-enclosure", Value: "no"}}, episode, false},
+
+		{[]configfile.Option{{Name: "include-has-enclosure", Value: "true"}}, episode, false},
+		{[]configfile.Option{{Name: "include-has-enclosure", Value: "true"}}, nil, true},
+		{[]configfile.Option{{Name: "include-has-enclosure", Value: "yes"}}, empty, true},
+		{[]configfile.Option{{Name: "exclude-has-enclosure", Value: "true"}}, episode, true},
+		{[]configfile.Option{{Name: "exclude-has-enclosure", Value: "true"}}, nil, false},
+		{[]configfile.Option{{Name: "exclude-has-enclosure", Value: "YES"}}, empty, false},
+	}
+
+	for i, tst := range tests {
+		feed := configfile.Feed{URL: "blah", Options: tst.opts}
+		if x.shouldSkipEnclosure(logger, feed, tst.enclosures) != tst.skip {
+			t.Fatalf("test %d: expected skip=%v", i, tst.skip)
+		}
+
+		item := withstate.FeedItem{Item: &gofeed.Item{Title: "Title", Enclosures: tst.enclosures}}
+		filter, _ := x.filterReason(logger, feed, item, "<p>Content</p>")
+		if (filter == FilterEnclosure) != tst.skip {
+			t.Fatalf("test %d: unexpected filter %q", i, filter)
+		}
+	}
+}
+
+// TestStripTracking tests removing the tracking parameters from links.
+func TestStripTracking(t *testing.T) {
+
+	tests := []struct {
+		link     string
+		expected string
+	}{
+		{"https://example.com/post", "https://example.com/post"},
+		{"https://example.com/post?", "https://example.com/post?"},
+		{"https://example.com/post?utm_source=feedburner&utm_medium=feed&utm_campaign=x", "https://example.com/post"},
+		{"https://example.com/post?id=3&utm_source=feed&page=2", "https://example.com/post?id=3&page=2"},
+		{"https://example.com/post?fbclid=abc", "https://example.com/post"},
+		{"https://example.com/post?gclid=abc&q=a%20b#top", "https://example.com/post?q=a%20b#top"},
+		{"https://example.com/post?UTM_Source=x&utm=kept", "https://example.com/post?utm=kept"},
+		{"https://example.com/post?utm%5Fsource=x", "https://example.com/post"},
+		{"https://example.com/post?z=1&a=2", "https://example.com/post?z=1&a=2"},
+		{"%zz", "%zz"},
+	}
+
+	for _, tst := range tests {
+		out := stripTracking(tst.link)
+		if out != tst.expected {
+			t.Fatalf("%s: expected %s, got %s", tst.link, tst.expected, out)
+		}
+	}
+}
+
+// TestStripUTM tests that the strip-utm option changes the links which are
+// sent and recorded.
+func TestStripUTM(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title><link>https://example.com/</link>
+<item><title>One</title><link>https://example.com/1?utm_source=feed&amp;id=1</link><description>Body</description></item>
+<item><title>Two</title><link>https://example.com/2?utm_source=feed</link><guid>two</guid><description>Body</description></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	p, err := New(ProcessorConfig{Send: true})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	buf := &strings.Builder{}
+	p.SetLogger(logger)
+	p.SetEmailOutput(buf)
+	p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "strip-utm", Value: "true"},
+		{Name: "deduplicate-by-link-or-guid", Value: "true"},
+	}}})
+
+	errs := p.ProcessFeeds([]string{"user@example.com"})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if strings.Contains(buf.String(), "utm_source") {
+		t.Fatalf("tracking parameters were sent: %s", buf.String())
+	}
+
+	// The stripped link is recorded, and used as the GUID of the item
+	// which didn't have one.
+	keys, err := p.store.Items(ts.URL)
+	if err != nil {
+		t.Fatalf("failed to read state: %s", err)
+	}
+	sort.Strings(keys)
+	expected := []string{
+		"guid:https://example.com/1?id=1",
+		"guid:two",
+		"link:https://example.com/1?id=1",
+		"link:https://example.com/2",
+	}
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+// TestSubjectTemplate tests that subject templates are compiled once,
+// and that broken ones are ignored.
+func TestSubjectTemplate(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+	x.SetLogger(logger)
+
+	good := configfile.Feed{URL: "good", Options: []configfile.Option{{Name: "subject-template", Value: "[{{.FeedTitle}}] {{.ItemTitle}}"}}}
+	broken := configfile.Feed{URL: "broken", Options: []configfile.Option{{Name: "subject-template", Value: "{{.ItemTitle"}}}
+
+	tmpl := x.subjectTemplate(good)
+	if tmpl == nil || x.subjectTemplate(good) != tmpl {
+		t.Fatalf("expected the template to be compiled once")
+	}
+	if x.subjectTemplate(broken) != nil || x.subjectTemplate(configfile.Feed{URL: "none"}) != nil {
+		t.Fatalf("expected no template")
+	}
+
+	// The template is used when previewing.
+	msg, err := x.Preview(good, &gofeed.Feed{Title: "Feed"}, &gofeed.Item{Title: "Item"}, "user@example.com")
+	if err != nil {
+		t.Fatalf("failed to preview: %s", err)
+	}
+	if !strings.Contains(string(msg), "\nSubject: [Feed] Item") {
+		t.Fatalf("unexpected message %s", msg)
+	}
+	msg, err = x.Preview(broken, &gofeed.Feed{Title: "Feed"}, &gofeed.Item{Title: "Item"}, "user@example.com")
+	if err != nil {
+		t.Fatalf("failed to preview: %s", err)
+	}
+	if !strings.Contains(string(msg), "Subject: [rss2email] Item") {
+		t.Fatalf("unexpected message %s", msg)
+	}
+}
+
+// TestRecipientsOption tests the per-feed recipients.
+func TestRecipientsOption(t *testing.T) {
+
+	fallback := []string{"global@example.com"}
+
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{"family@example.com", []string{"family@example.com"}},
+		{" one@example.com, Two <two@example.com> ", []string{"one@example.com", "two@example.com"}},
+		{"one@example.com, not an address", []string{"one@example.com"}},
+		{`"Doe, John" <j@example.com>, two@example.com`, []string{"j@example.com", "two@example.com"}},
+		{"not an address", fallback},
+		{"", fallback},
+	}
+
+	for _, tst := range tests {
+		out := recipientsOption(logger, configfile.Option{Name: "to", Value: tst.value}, fallback)
+		if strings.Join(out, ",") != strings.Join(tst.expected, ",") {
+			t.Fatalf("%q: expected %v, got %v", tst.value, tst.expected, out)
+		}
+	}
+}
+
+// TestSkipWordCount tests the word-count filters with empty content, and
+// content which is entirely markup.
+func TestSkipWordCount(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+
+	min := configfile.Feed{URL: "blah", Options: []configfile.Option{{Name: "min-words", Value: "1"}}}
+	max := configfile.Feed{URL: "blah", Options: []configfile.Option{{Name: "max-words", Value: "1"}}}
+
+	for _, content := range []string{"", `<div><img src="x.png"><br/></div>`} {
+		if !x.shouldSkipByMinWordCount(logger, min, content) {
+			t.Fatalf("expected %q to be skipped by min-words", content)
+		}
+		if x.shouldSkipByMaxWordCount(logger, max, content) {
+			t.Fatalf("expected %q to be kept by max-words", content)
+		}
+	}
+
+	// Exactly one word passes both.
+	if x.shouldSkipByMinWordCount(logger, min, "<p><b>one</b></p>") || x.shouldSkipByMaxWordCount(logger, max, "<p><b>one</b></p>") {
+		t.Fatalf("expected a single word to be kept")
+	}
+	if !x.shouldSkipByMaxWordCount(logger, max, "<p>one\u00a0two</p>") {
+		t.Fatalf("expected words split by unicode whitespace to be counted")
+	}
+}
+
+// TestSkipTitleListFile tests excluding titles via a file of patterns.
+func TestSkipTitleListFile(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+	x.SetLogger(logger)
+
+	path := filepath.Join(t.TempDir(), "patterns.txt")
+	err = os.WriteFile(path, []byte(`# Spam
+(?i)sponsored
+
+  casino
+[invalid
+`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write patterns: %s", err)
+	}
+
+	feed := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "exclude-title-list-file", Value: path},
+		{Name: "exclude-title", Value: "cake"},
+	}}
+
+	errs := x.loadPatternLists([]configfile.Feed{feed})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors loading patterns: %v", errs)
+	}
+	if len(x.patternLists[path]) != 2 {
+		t.Fatalf("expected two patterns, got %d", len(x.patternLists[path]))
+	}
+
+	tests := []struct {
+		title string
+		skip  bool
+	}{
+		{"A SPONSORED post", true},
+		{"Online casino", true},
+		{"I like cake", true},
+		{"Nothing to see here", false},
+		{"[invalid", false},
+	}
+
+	for _, tst := range tests {
+		if x.shouldSkip(logger, feed, tst.title, "") != tst.skip {
+			t.Fatalf("unexpected result for %s", tst.title)
+		}
+	}
+
+	// A missing file is reported.
+	feed.Options[0].Value = filepath.Join(t.TempDir(), "missing.txt")
+	errs = x.loadPatternLists([]configfile.Feed{feed})
+	if len(errs) != 1 {
+		t.Fatalf("expected an error for a missing file")
+	}
+}
+
+// TestRateLimit tests that deliveries are limited.
+func TestRateLimit(t *testing.T) {
+	setupTestHome(t)
+
+	p, err := New(ProcessorConfig{RateLimit: 20, RateBurst: 2})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+
+	if p.limiter.Limit() != 20 || p.limiter.Burst() != 2 {
+		t.Fatalf("limiter not configured from settings")
+	}
+
+	// Two are allowed immediately, then we wait 50ms for each.
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		p.waitForDelivery(logger)
+	}
+	if time.Since(start) < 90*time.Millisecond {
+		t.Fatalf("deliveries were not limited")
+	}
+
+	// Disabling the limit means no waiting.
+	p.SetRateLimit(rate.Inf, 0)
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		p.waitForDelivery(logger)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Fatalf("deliveries were limited")
+	}
+
+	// The default is no limit.
+	z, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer z.Close()
+	if z.limiter.Limit() != rate.Inf {
+		t.Fatalf("unexpected default limit")
+	}
+}
+
+// TestFeedTemplates tests that per-feed templates are loaded up front.
+func TestFeedTemplates(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+	x.SetLogger(logger)
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "podcast.tmpl")
+	err = os.WriteFile(good, []byte("Subject: {{.Subject}}\n\n{{.Text}}\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write template: %s", err)
+	}
+	bad := filepath.Join(dir, "broken.tmpl")
+	err = os.WriteFile(bad, []byte("Subject: {{.Subject"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write template: %s", err)
+	}
+
+	podcast := configfile.Feed{URL: "https://example.com/podcast", Options: []configfile.Option{
+		{Name: "email-body-template-file", Value: good},
+	}}
+	plain := configfile.Feed{URL: "https://example.com/plain"}
+
+	err = x.loadFeedTemplates([]configfile.Feed{podcast, plain})
+	if err != nil {
+		t.Fatalf("unexpected error loading templates: %s", err)
+	}
+	if x.feedTemplate(podcast) == nil {
+		t.Fatalf("expected a template for the podcast feed")
+	}
+	if x.feedTemplate(plain) != nil {
+		t.Fatalf("expected no template for the plain feed")
+	}
+
+	// Missing and broken templates are errors, naming the feed.
+	for _, path := range []string{filepath.Join(dir, "missing.tmpl"), bad} {
+		broken := configfile.Feed{URL: "https://example.com/broken", Options: []configfile.Option{
+			{Name: "email-body-template-file", Value: path},
+		}}
+
+		err = x.loadFeedTemplates([]configfile.Feed{podcast, broken})
+		if err == nil {
+			t.Fatalf("expected an error loading %s", path)
+		}
+
+		var fe *FeedError
+		if !errors.As(err, &fe) || fe.FeedURL != broken.URL || fe.Phase != PhaseTemplate {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	// And ProcessFeeds fails before fetching anything.
+	x.SetFeeds([]configfile.Feed{{URL: "http://127.0.0.1:1/feed", Options: []configfile.Option{
+		{Name: "email-body-template-file", Value: bad},
+	}}})
+	errs := x.ProcessFeeds([]string{})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "broken.tmpl") {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+// TestSkipAuthor tests filtering by author, inline and from files.
+func TestSkipAuthor(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+	x.SetLogger(logger)
+
+	dir := t.TempDir()
+	blocked := filepath.Join(dir, "blocked.txt")
+	err = os.WriteFile(blocked, []byte("# Spammers\n(?i)^spam bot$\n@example\\.net$\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write author list: %s", err)
+	}
+	trusted := filepath.Join(dir, "trusted.txt")
+	err = os.WriteFile(trusted, []byte("^Alice\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write author list: %s", err)
+	}
+
+	exclude := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "exclude-author-list-file", Value: blocked},
+		{Name: "exclude-author", Value: "Mallory"},
+	}}
+	include := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "include-author-list-file", Value: trusted},
+		{Name: "include-author", Value: "^Bob$"},
+	}}
+
+	errs := x.loadPatternLists([]configfile.Feed{exclude, include})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors loading patterns: %v", errs)
+	}
+
+	tests := []struct {
+		feed    configfile.Feed
+		authors []string
+		skip    bool
+	}{
+		{exclude, []string{"SPAM Bot"}, true},
+		{exclude, []string{"Eve", "eve@example.net"}, true},
+		{exclude, []string{"Mallory"}, true},
+		{exclude, []string{"Alice"}, false},
+		{exclude, nil, false},
+		{include, []string{"Alice Smith"}, false},
+		{include, []string{"Bob"}, false},
+		{include, []string{"Bobby"}, true},
+		{include, nil, true},
+	}
+
+	for _, tst := range tests {
+		if x.shouldSkipAuthor(logger, tst.feed, tst.authors) != tst.skip {
+			t.Fatalf("unexpected result for %v", tst.authors)
+		}
+	}
+
+	// The authors are found from the item.
+	item := &gofeed.Item{Authors: []*gofeed.Person{{Name: "Eve", Email: "eve@example.net"}}}
+	if len(x.FilterItems(exclude, []*gofeed.Item{item})) != 0 {
+		t.Fatalf("expected the item to be excluded")
+	}
+	item = &gofeed.Item{Author: &gofeed.Person{Name: "Alice"}}
+	if len(x.FilterItems(include, []*gofeed.Item{item})) != 1 {
+		t.Fatalf("expected the item to be included")
+	}
+}
+
+// TestSkipAuthorPatterns tests multiple author patterns, invalid ones,
+// and items without an author.
+func TestSkipAuthorPatterns(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+	x.SetLogger(logger)
+
+	// Multiple include-author options match if any of them does.
+	include := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "include-author", Value: "(?i)^alice"},
+		{Name: "include-author", Value: "Bob"},
+	}}
+	if x.shouldSkipAuthor(logger, include, []string{"ALICE"}) {
+		t.Fatalf("this should be included because of the first pattern")
+	}
+	if x.shouldSkipAuthor(logger, include, []string{"Bob"}) {
+		t.Fatalf("this should be included because of the second pattern")
+	}
+	if !x.shouldSkipAuthor(logger, include, []string{"Carol"}) {
+		t.Fatalf("this should be excluded, no pattern matches")
+	}
+
+	// An item without an author can't match, even a pattern which
+	// matches anything.
+	anything := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "include-author", Value: ".*"},
+	}}
+	if !x.shouldSkipAuthor(logger, anything, nil) {
+		t.Fatalf("an item without an author should be excluded")
+	}
+	if len(x.FilterItems(anything, []*gofeed.Item{{Author: &gofeed.Person{}}})) != 0 {
+		t.Fatalf("an item with an empty author should be excluded")
+	}
+
+	// Invalid patterns never match.
+	invalid := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "exclude-author", Value: "[Mallory"},
+	}}
+	if x.shouldSkipAuthor(logger, invalid, []string{"[Mallory"}) {
+		t.Fatalf("an invalid exclude-author pattern shouldn't exclude anything")
+	}
+	invalid = configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "include-author", Value: "[Alice"},
+	}}
+	if !x.shouldSkipAuthor(logger, invalid, []string{"[Alice"}) {
+		t.Fatalf("an invalid include-author pattern shouldn't include anything")
+	}
+}
+
+// TestSkipPublisher tests filtering aggregated feeds by publisher.
+func TestSkipPublisher(t *testing.T) {
+	setupTestHome(t)
+
+	x, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer x.Close()
+	x.SetLogger(logger)
+
+	exclude := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "exclude-publisher", Value: "(?i)spam"},
+	}}
+	include := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "include-publisher", Value: "^Haskell Weekly$"},
+		{Name: "include-publisher", Value: `example\.org`},
+	}}
+
+	tests := []struct {
+		feed  configfile.Feed
+		title string
+		url   string
+		skip  bool
+	}{
+		{exclude, "SpamCo Blog", "https://example.com/feed", true},
+		{exclude, "Good Blog", "https://spam.example.com/feed", true},
+		{exclude, "Good Blog", "https://example.com/feed", false},
+		{exclude, "", "", false},
+		{include, "Haskell Weekly", "", false},
+		{include, "", "https://example.org/rss", false},
+		{include, "Other Blog", "https://example.com/feed", true},
+		{include, "", "", false},
+	}
+
+	for _, tst := range tests {
+		if x.shouldSkipByPublisher(logger, tst.feed, tst.title, tst.url) != tst.skip {
+			t.Fatalf("unexpected result for %s %s", tst.title, tst.url)
+		}
+	}
+
+	// The publisher is found from the item.
+	item := &gofeed.Item{Title: "Post"}
+	withstate.SetSource(item, "SpamCo Blog", "https://example.com/feed")
+	skip, reason := x.TestFilter(exclude, withstate.FeedItem{Item: item})
+	if !skip || reason != "exclude-publisher: (?i)spam matched 'SpamCo Blog'" {
+		t.Fatalf("unexpected result %v %s", skip, reason)
+	}
+}
+
+// TestNormalizeLink tests the links we use for deduplication.
+func TestNormalizeLink(t *testing.T) {
+
+	tests := map[string]string{
+		"https://example.com/post/":         "https://example.com/post",
+		"https://Example.com/Post#comments": "https://example.com/post",
+		" https://example.com/?id=1 ":       "https://example.com/?id=1",
+		"https://example.com":               "https://example.com",
+	}
+
+	for in, expected := range tests {
+		if got := normalizeLink(in); got != expected {
+			t.Fatalf("normalizeLink(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
+
+// TestDeduplicate tests the deduplicate-by-link options.
+func TestDeduplicate(t *testing.T) {
+	setupTestHome(t)
+
+	items := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>%s</channel></rss>`, items)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		option string
+		first  string
+		second string
+		sent   int
+	}{
+		// By default a changed link is a new item.
+		{"", `<item><title>One</title><link>https://example.com/1</link><guid>a</guid></item>`,
+			`<item><title>One</title><link>https://example.com/1/</link><guid>a</guid></item>`, 2},
+
+		// With deduplicate-by-link changes to the GUID, case, fragment,
+		// and trailing slash are ignored.
+		{"deduplicate-by-link", `<item><title>One</title><link>https://example.com/1</link><guid>a</guid></item>`,
+			`<item><title>One</title><link>https://EXAMPLE.com/1/#top</link><guid>b</guid></item>`, 1},
+
+		// But a new link is still new.
+		{"deduplicate-by-link", `<item><title>One</title><link>https://example.com/1</link><guid>a</guid></item>`,
+			`<item><title>One</title><link>https://example.com/2</link><guid>a</guid></item>`, 2},
+
+		// With deduplicate-by-link-or-guid either will do.
+		{"deduplicate-by-link-or-guid", `<item><title>One</title><link>https://example.com/1</link><guid>a</guid></item>`,
+			`<item><title>One</title><link>https://example.com/2</link><guid>a</guid></item>
+<item><title>Two</title><link>https://example.com/1/</link><guid>b</guid></item>`, 1},
+		{"deduplicate-by-link-or-guid", `<item><title>One</title><link>https://example.com/1</link><guid>a</guid></item>`,
+			`<item><title>Two</title><link>https://example.com/2</link><guid>b</guid></item>`, 2},
+	}
+
+	for _, tst := range tests {
+
+		out := &recordingOutput{}
+		path := filepath.Join(t.TempDir(), "state.db")
+
+		options := []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+		}
+		if tst.option != "" {
+			options = append(options, configfile.Option{Name: tst.option, Value: "true"})
+		}
+
+		for _, body := range []string{tst.first, tst.second} {
+			items = body
+
+			p, err := New(ProcessorConfig{Send: true, StatePath: path})
+			if err != nil {
+				t.Fatalf("error creating processor %s", err.Error())
+			}
+			p.SetLogger(logger)
+			p.SetOutput(out)
+			p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: options}})
+
+			errs := p.ProcessFeeds([]string{})
+			p.Close()
+
+			if len(errs) != 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+		}
+
+		if len(out.titles) != tst.sent {
+			t.Fatalf("%s: expected %d items to be sent, got %v", tst.option, tst.sent, out.titles)
+		}
+	}
+}
+
+// TestVerifyItemLink tests that items with broken links are skipped.
+func TestVerifyItemLink(t *testing.T) {
+	setupTestHome(t)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		case "/moved-missing":
+			http.Redirect(w, r, "/missing", http.StatusFound)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>OK</title><link>%[1]s/ok</link></item>
+<item><title>Gone</title><link>%[1]s/gone</link></item>
+<item><title>Missing</title><link>%[1]s/missing</link></item>
+<item><title>Moved</title><link>%[1]s/moved</link></item>
+<item><title>Moved Missing</title><link>%[1]s/moved-missing</link></item>
+<item><title>No HEAD</title><link>%[1]s/no-head</link></item>
+</channel></rss>`, ts.URL)
+		}
+	}))
+	defer ts.Close()
+
+	for _, verify := range []string{"false", "true"} {
+
+		out := &recordingOutput{}
+		p, err := New(ProcessorConfig{Send: true, StatePath: filepath.Join(t.TempDir(), "state.db")})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "verify-item-link", Value: verify},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		stats := p.Statistics()[ts.URL]
+		p.Close()
+
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		expected := []string{"OK", "Gone", "Missing", "Moved", "Moved Missing", "No HEAD"}
+		skipped := 0
+		if verify == "true" {
+			expected = []string{"OK", "Moved", "No HEAD"}
+			skipped = 3
+		}
+		if strings.Join(out.titles, ",") != strings.Join(expected, ",") {
+			t.Fatalf("verify-item-link:%s expected %v to be sent, got %v", verify, expected, out.titles)
+		}
+		if stats.ItemsSkippedByFilter[FilterLink] != skipped {
+			t.Fatalf("verify-item-link:%s expected %d skipped items, got %v", verify, skipped, stats)
+		}
+	}
+}
+
+// TestItemPosition tests the include-top-items and include-bottom-items
+// options.
+func TestItemPosition(t *testing.T) {
+
+	p, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	tests := []struct {
+		options []configfile.Option
+		kept    string
+	}{
+		{nil, "01234"},
+		{[]configfile.Option{{Name: "include-top-items", Value: "2"}}, "01"},
+		{[]configfile.Option{{Name: "include-bottom-items", Value: "2"}}, "34"},
+		{[]configfile.Option{{Name: "include-top-items", Value: "1"}, {Name: "include-bottom-items", Value: "1"}}, "04"},
+		{[]configfile.Option{{Name: "include-top-items", Value: "10"}}, "01234"},
+		{[]configfile.Option{{Name: "include-top-items", Value: "3"}, {Name: "include-bottom-items", Value: "3"}}, "01234"},
+		{[]configfile.Option{{Name: "include-top-items", Value: "bogus"}}, "01234"},
+	}
+
+	for _, tst := range tests {
+		kept := ""
+		for i := 0; i < 5; i++ {
+			if !p.shouldSkipByItemPosition(logger, configfile.Feed{Options: tst.options}, i, 5) {
+				kept += fmt.Sprint(i)
+			}
+		}
+		if kept != tst.kept {
+			t.Fatalf("%v: expected to keep %s, got %s", tst.options, tst.kept, kept)
+		}
+	}
+
+	// Items which are skipped aren't marked as seen, so they're sent
+	// if they later move to the top of the feed.
+	setupTestHome(t)
+
+	items := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>%s</channel></rss>`, items)
+	}))
+	defer ts.Close()
+
+	one := `<item><title>One</title><link>https://example.com/1</link></item>`
+	two := `<item><title>Two</title><link>https://example.com/2</link></item>`
+
+	out := &recordingOutput{}
+	path := filepath.Join(t.TempDir(), "state.db")
+	for _, body := range []string{one + two, two + one} {
+		items = body
+
+		p, err := New(ProcessorConfig{Send: true, StatePath: path})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(out)
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+			{Name: "retry", Value: "1"},
+			{Name: "delay", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "include-top-items", Value: "1"},
+		}}})
+
+		errs := p.ProcessFeeds([]string{})
+		stats := p.Statistics()[ts.URL]
+		p.Close()
+
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if stats.ItemsSkippedByFilter[FilterPosition] != 1 {
+			t.Fatalf("expected one item skipped by position, got %v", stats)
+		}
+	}
+
+	if strings.Join(out.titles, ",") != "One,Two" {
+		t.Fatalf("unexpected items sent %v", out.titles)
+	}
+}
+
+// TestStatistics tests the per-feed metrics of a run.
+func TestStatistics(t *testing.T) {
+	setupTestHome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>One</title><link>https://example.com/1</link><guid>1</guid></item>
+<item><title>Sponsored</title><link>https://example.com/2</link><guid>2</guid></item>
+<item><title>Three</title><link>https://example.com/3</link><guid>3</guid><category>ads</category></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "state.db")
+	options := []configfile.Option{
+		{Name: "retry", Value: "1"},
+		{Name: "delay", Value: "0"},
+		{Name: "frequency", Value: "0"},
+		{Name: "exclude-title", Value: "Sponsored"},
+		{Name: "exclude-category", Value: "ads"},
+	}
+
+	for run := 0; run < 2; run++ {
+		p, err := New(ProcessorConfig{Send: true, StatePath: path})
+		if err != nil {
+			t.Fatalf("error creating processor %s", err.Error())
+		}
+		p.SetLogger(logger)
+		p.SetOutput(&recordingOutput{})
+		p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: options}})
+
+		errs := p.ProcessFeeds([]string{})
+		stats := p.Statistics()
+		p.Close()
+
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		s, ok := stats[ts.URL]
+		if !ok || len(stats) != 1 {
+			t.Fatalf("expected statistics for one feed, got %v", stats)
+		}
+		if s.ItemsFetched != 3 || s.FetchError != nil || s.DeliverError != nil {
+			t.Fatalf("unexpected statistics %v", s)
+		}
+
+		if run == 0 {
+			if s.ItemsSent != 1 || s.ItemsAlreadySeen != 0 ||
+				s.ItemsSkippedByFilter[FilterPattern] != 1 ||
+				s.ItemsSkippedByFilter[FilterCategory] != 1 {
+				t.Fatalf("unexpected statistics for first run %v", s)
+			}
+		} else {
+			if s.ItemsSent != 0 || s.ItemsAlreadySeen != 3 || len(s.ItemsSkippedByFilter) != 0 {
+				t.Fatalf("unexpected statistics for second run %v", s)
+			}
+		}
+	}
+
+	// A feed which can't be fetched records the error.
+	p, err := New(ProcessorConfig{Send: true})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+	p.SetFeeds([]configfile.Feed{{URL: "http://127.0.0.1:1/feed", Options: options}})
+	p.ProcessFeeds([]string{})
+
+	s := p.Statistics()["http://127.0.0.1:1/feed"]
+	var fe *FeedError
+	if !errors.As(s.FetchError, &fe) || fe.Phase != PhaseFetch {
+		t.Fatalf("expected a fetch error, got %v", s.FetchError)
+	}
+}
+
+// TestTransformContent tests the content-transform option.
+func TestTransformContent(t *testing.T) {
+
+	content := `<p>Hello <img src="a.png" alt="A cat"> <img src="b.png" title="A dog"> <img src="c.png"></p>
+<picture><source srcset="d.webp"><img src="d.png" alt="A &quot;bird&quot;"></picture>`
+
+	tests := []struct {
+		mode     string
+		present  []string
+		excluded []string
+	}{
+		{"", []string{"a.png", "d.webp"}, []string{"[Image"}},
+		{"keep-images", []string{"a.png", "d.webp"}, []string{"[Image"}},
+		{"bogus", []string{"a.png", "d.webp"}, []string{"[Image"}},
+		{"strip-images", []string{"Hello"}, []string{"<img", "<picture", "d.webp", "[Image"}},
+		{"images-to-text", []string{"[Image: A cat]", "[Image: A dog]", "[Image]", "[Image: A &#34;bird&#34;]"},
+			[]string{"<img", "<picture", "d.webp"}},
+	}
+
+	for _, tst := range tests {
+		feed := configfile.Feed{URL: "blah"}
+		if tst.mode != "" {
+			feed.Options = []configfile.Option{{Name: "content-transform", Value: tst.mode}}
+		}
+
+		out := transformContent(logger, feed, content)
+		for _, str := range tst.present {
+			if !strings.Contains(out, str) {
+				t.Fatalf("%s: expected %s in %s", tst.mode, str, out)
+			}
+		}
+		for _, str := range tst.excluded {
+			if strings.Contains(out, str) {
+				t.Fatalf("%s: did not expect %s in %s", tst.mode, str, out)
+			}
+		}
+	}
+
+	// The transformation happens before filtering.
+	p, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+
+	feed := configfile.Feed{URL: "blah", Options: []configfile.Option{
+		{Name: "content-transform", Value: "strip-images"},
+		{Name: "exclude", Value: "<img"},
+	}}
+	item := &gofeed.Item{Content: `<p>Look <img src="x.png"></p>`}
+	if len(p.FilterItems(feed, []*gofeed.Item{item})) != 1 {
+		t.Fatalf("expected the item to pass the filter once images were stripped")
+	}
+}
+
+// TestTestFilter tests we explain why items are skipped.
+func TestTestFilter(t *testing.T) {
+	setupTestHome(t)
+
+	p, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	p.SetClock(func() time.Time { return now })
+	old := now.Add(-time.Hour * 24 * 30).Format(time.RFC1123)
+
+	tests := []struct {
+		options []configfile.Option
+		item    *gofeed.Item
+		reason  string
+	}{
+		{[]configfile.Option{{Name: "exclude-category", Value: "(?i)sports"}},
+			&gofeed.Item{Title: "Match report", Categories: []string{"News", "Sports"}},
+			"exclude-category: (?i)sports matched 'Sports'"},
+		{[]configfile.Option{{Name: "exclude-title", Value: "cake"}},
+			&gofeed.Item{Title: "I like cake"},
+			"exclude-title: cake matched 'I like cake'"},
+		{[]configfile.Option{{Name: "exclude", Value: "spons[a-z]+"}},
+			&gofeed.Item{Title: "Post", Content: "<p>This post is sponsored by us</p>"},
+			"exclude: spons[a-z]+ matched 'sponsored'"},
+		{[]configfile.Option{{Name: "include-title", Value: "^Go"}},
+			&gofeed.Item{Title: "Rust news"},
+			"include-title: ^Go did not match 'Rust news'"},
+		{[]configfile.Option{{Name: "include-category", Value: "tech"}},
+			&gofeed.Item{Title: "Post", Categories: []string{"food"}},
+			"include-category: tech did not match 'food'"},
+		{[]configfile.Option{{Name: "exclude-author", Value: "Mallory"}},
+			&gofeed.Item{Title: "Post", Author: &gofeed.Person{Name: "Mallory"}},
+			"exclude-author: Mallory matched 'Mallory'"},
+		{[]configfile.Option{{Name: "include-words-min", Value: "10"}},
+			&gofeed.Item{Title: "Post", Content: "<p>Too short.</p>"},
+			"include-words-min: 10 matched content of only 2 words"},
+		{[]configfile.Option{{Name: "exclude-older", Value: "7"}},
+			&gofeed.Item{Title: "Post", Published: old},
+			"exclude-older: 7 matched '" + old + "', which is 30.0 days old"},
+		{[]configfile.Option{{Name: "exclude-title", Value: "cake"}},
+			&gofeed.Item{Title: "I like pie"},
+			""},
+	}
+
+	for _, tst := range tests {
+		feed := configfile.Feed{URL: "blah", Options: tst.options}
+
+		skipped, reason := p.TestFilter(feed, withstate.FeedItem{Item: tst.item})
+		if skipped != (tst.reason != "") {
+			t.Fatalf("unexpected skip result for %v", tst.options)
+		}
+		if reason != tst.reason {
+			t.Fatalf("unexpected reason, got %q, expected %q", reason, tst.reason)
+		}
+	}
+}
+
+// TestReloadFeeds tests that feeds aren't replaced during a run.
+func TestReloadFeeds(t *testing.T) {
+	setupTestHome(t)
+
+	p, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+
+	p.SetFeeds([]configfile.Feed{{URL: "https://example.com/old"}})
+
+	// Pretend we're in the middle of a run.
+	p.running.Lock()
+
+	done := make(chan struct{})
+	go func() {
+		p.ReloadFeeds([]configfile.Feed{{URL: "https://example.com/new"}})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("feeds were reloaded during a run")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.running.Unlock()
+	<-done
+
+	if len(p.feeds) != 1 || p.feeds[0].URL != "https://example.com/new" {
+		t.Fatalf("feeds were not reloaded: %v", p.feeds)
+	}
+}
+
+// TestConfigWatcher tests applying configuration changes.
+func TestConfigWatcher(t *testing.T) {
+	setupTestHome(t)
+
+	p, err := New(ProcessorConfig{})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+
+	path := filepath.Join(t.TempDir(), "feeds.txt")
+	err = os.WriteFile(path, []byte("https://example.com/\n - exclude: [broken\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	// An invalid file is reported at once.
+	_, err = NewConfigWatcher(p, path)
+	if err == nil || !strings.Contains(err.Error(), "[broken") {
+		t.Fatalf("expected error with invalid regexp, got %v", err)
+	}
+
+	err = os.WriteFile(path, []byte("https://example.com/\nhttps://example.net/\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	w, err := NewConfigWatcher(p, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(p.feeds) != 2 {
+		t.Fatalf("feeds were not loaded: %v", p.feeds)
+	}
+
+	// An invalid update leaves the feeds alone.
+	broken := configfile.NewWithPath(path)
+	err = os.WriteFile(path, []byte("https://example.org/\n - include-title: (\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+	_, err = broken.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+	if w.apply(broken) == nil {
+		t.Fatalf("expected error with invalid regexp")
+	}
+	if len(p.feeds) != 2 {
+		t.Fatalf("feeds were replaced: %v", p.feeds)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = w.Start(ctx)
+	if err != nil {
+		t.Fatalf("failed to start watching: %s", err)
+	}
+
+	// We can't watch a file which has gone away.
+	os.Remove(path)
+	if w.Start(ctx) == nil {
+		t.Fatalf("expected error watching missing file")
+	}
+}
+
+// TestProcessOnce tests running a single processor repeatedly.
+func TestProcessOnce(t *testing.T) {
+	setupTestHome(t)
+
+	items := `<item><title>One</title><link>https://example.com/1</link></item>`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>`+items+`</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	p, err := New(ProcessorConfig{Send: true, StatePath: filepath.Join(t.TempDir(), "state.db")})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	defer p.Close()
+	p.SetLogger(logger)
+
+	out := &recordingOutput{}
+	p.SetOutput(out)
+	p.SetFeeds([]configfile.Feed{{URL: ts.URL, Options: []configfile.Option{
+		{Name: "retry", Value: "1"},
+		{Name: "delay", Value: "0"},
+		{Name: "frequency", Value: "0"},
+	}}})
+
+	err = p.ProcessOnce(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The second run only sees the new item.
+	items += `<item><title>Two</title><link>https://example.com/2</link></item>`
+	err = p.ProcessOnce(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strings.Join(out.titles, ",") != "One,Two" {
+		t.Fatalf("unexpected items delivered: %v", out.titles)
+	}
+	if p.Statistics()[ts.URL].ItemsAlreadySeen != 1 {
+		t.Fatalf("statistics were not reset: %v", p.Statistics())
+	}
+
+	// A cancelled run processes nothing, and prunes nothing.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.SetFeeds([]configfile.Feed{})
+	err = p.ProcessOnce(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+
+	keys, err := p.store.Items(ts.URL)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("state was modified: %v %v", keys, err)
+	}
+}
+
+// TestSMTPConnectionReuse ensures that a run opens a single SMTP
+// connection, and reuses it for every email, unless told otherwise.
+func TestSMTPConnectionReuse(t *testing.T) {
+	setupTestHome(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer l.Close()
+
+	var dials, messages atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+				data := false
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch {
+					case data && line == ".\r\n":
+						data = false
+						messages.Add(1)
+						fmt.Fprint(conn, "250 accepted\r\n")
+					case data:
+					case strings.HasPrefix(strings.ToUpper(line), "EHLO"):
+						fmt.Fprint(conn, "250-localhost\r\n250 AUTH PLAIN\r\n")
+					case strings.HasPrefix(strings.ToUpper(line), "AUTH"):
+						fmt.Fprint(conn, "235 authenticated\r\n")
+					case strings.HasPrefix(strings.ToUpper(line), "DATA"):
+						data = true
+						fmt.Fprint(conn, "354 go ahead\r\n")
+					case strings.HasPrefix(strings.ToUpper(line), "QUIT"):
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					default:
+						fmt.Fprint(conn, "250 OK\r\n")
+					}
+				}
+			}()
+		}
+	}()
+
+	// Each feed has a single item, so that we don't wait between the
+	// emails of a feed, and they don't wait for each other.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title><item><title>Item</title><link>https://example.com%s</link></item></channel></rss>`, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	p, err := New(ProcessorConfig{
+		Send:        true,
+		DefaultFrom: "sender@example.com",
+		SMTP:        &config.SMTPConfig{Host: host, Port: portNumber, Username: "user", Password: "pass"},
+		Concurrency: 1,
+	})
+	if err != nil {
+		t.Fatalf("error creating processor %s", err.Error())
+	}
+	p.SetLogger(logger)
+
+	var feeds []configfile.Feed
+	for i := 0; i < 5; i++ {
+		feeds = append(feeds, configfile.Feed{URL: fmt.Sprintf("%s/%d", ts.URL, i), Options: []configfile.Option{
+			{Name: "retry", Value: "0"},
+			{Name: "frequency", Value: "0"},
+			{Name: "sleep", Value: "0"},
+		}})
+	}
+	p.SetFeeds(feeds)
+
+	errs := p.ProcessFeeds([]string{"user@example.com"})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// The connection is kept open until we're closed.
+	p.Close()
+
+	if dials.Load() != 1 || messages.Load() != 5 {
+		t.Fatalf("expected five emails over one connection, got %d over %d", messages.Load(), dials.Load())
+	}
+}
@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"html"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/skx/rss2email/configfile"
+	nethtml "golang.org/x/net/html"
+)
+
+// limitContent truncates the given HTML content, if its text is longer
+// than the "max-content-length" option of the feed, and appends a link to
+// the item so that the rest of it can be read.
+//
+// The length is that of the text, once the HTML markup is removed and runs
+// of whitespace are counted as one.  We truncate at a word boundary, and
+// the markup which encloses the remaining text is kept.
+func limitContent(logger *slog.Logger, entry configfile.Feed, link string, content string) string {
+
+	limit := minimumOption(logger, entry, "max-content-length")
+	if limit == 0 {
+		return content
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+
+	body := doc.Find("body")
+	if body.Length() == 0 || !truncateNodes(body.Nodes[0], limit) {
+		return content
+	}
+
+	if link != "" {
+		body.AppendHtml(`<p><a href="` + html.EscapeString(link) + `">Read more →</a></p>`)
+	}
+
+	out, err := doc.Html()
+	if err != nil {
+		return content
+	}
+
+	logger.Debug("truncated content due to max-content-length",
+		slog.Int("max-content-length", limit))
+
+	return out
+}
+
+// truncateNodes removes the text beneath the given node which follows the
+// first limit characters, returning true if anything was removed.
+func truncateNodes(root *nethtml.Node, limit int) bool {
+
+	remaining := limit
+
+	// cut is the text node the limit was reached within.
+	var cut *nethtml.Node
+
+	var walk func(n *nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		for c := n.FirstChild; c != nil && cut == nil; c = c.NextSibling {
+			if c.Type == nethtml.TextNode {
+				words := strings.Fields(c.Data)
+				length := utf8.RuneCountInString(strings.Join(words, " "))
+				if length <= remaining {
+					remaining -= length
+					continue
+				}
+
+				// Keep as many whole words as fit.
+				kept := []string{}
+				used := 0
+				for _, word := range words {
+					size := utf8.RuneCountInString(word)
+					if len(kept) > 0 {
+						size++
+					}
+					if used+size > remaining {
+						break
+					}
+					kept = append(kept, word)
+					used += size
+				}
+
+				prefix := ""
+				if strings.TrimLeft(c.Data, " \t\r\n") != c.Data {
+					prefix = " "
+				}
+				c.Data = prefix + strings.Join(kept, " ") + "…"
+				cut = c
+				return
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+
+	if cut == nil {
+		return false
+	}
+
+	// Remove everything which follows the node we cut, at each level
+	// up to the root.
+	for n := cut; n != root; n = n.Parent {
+		for s := n.NextSibling; s != nil; {
+			next := s.NextSibling
+			n.Parent.RemoveChild(s)
+			s = next
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"log/slog"
+	"time"
+)
+
+// The names of the filters which are counted in
+// FeedStatistics.ItemsSkippedByFilter.
+const (
+	// FilterPattern covers the include, exclude, include-title,
+	// exclude-title, exclude-title-list-file, include-link, and
+	// exclude-link options.
+	FilterPattern = "pattern"
+
+	// FilterAge covers the exclude-older option.
+	FilterAge = "exclude-older"
+
+	// FilterNewer covers the exclude-newer option.
+	FilterNewer = "exclude-newer"
+
+	// FilterCategory covers the include-category and exclude-category
+	// options.
+	FilterCategory = "category"
+
+	// FilterEnclosure covers the include-has-enclosure and
+	// exclude-has-enclosure options.
+	FilterEnclosure = "enclosure"
+
+	// FilterAuthor covers the include-author and exclude-author options,
+	// and their -list-file variants.
+	FilterAuthor = "author"
+
+	// FilterPublisher covers the include-publisher and exclude-publisher
+	// options.
+	FilterPublisher = "publisher"
+
+	// FilterWords covers the include-words-min, min-words, and
+	// max-words options.
+	FilterWords = "include-words-min"
+
+	// FilterSentences covers the include-sentences-min option.
+	FilterSentences = "include-sentences-min"
+
+	// FilterNoContent covers the exclude-if-no-content option.
+	FilterNoContent = "exclude-if-no-content"
+
+	// FilterBackfill is used for items which were not selected when
+	// backfilling a new feed.
+	FilterBackfill = "backfill"
+
+	// FilterIMAP covers the imap-seen-check option.
+	FilterIMAP = "imap-seen-check"
+
+	// FilterPosition covers the include-top-items and
+	// include-bottom-items options.
+	FilterPosition = "position"
+
+	// FilterLink covers the verify-item-link option.
+	FilterLink = "verify-item-link"
+)
+
+// FeedStatistics holds the metrics of a single feed, from the most
+// recent call to ProcessFeeds.
+type FeedStatistics struct {
+
+	// ItemsFetched is the number of items in the remote feed.
+	ItemsFetched int
+
+	// ItemsSent is the number of items delivered successfully.
+	ItemsSent int
+
+	// ItemsSkippedByFilter counts the new items which were not
+	// delivered, keyed by the Filter-constant which excluded them.
+	ItemsSkippedByFilter map[string]int
+
+	// ItemsAlreadySeen is the number of items we'd seen on a previous
+	// run.
+	ItemsAlreadySeen int
+
+	// ItemsDeferred is the number of new items which weren't delivered
+	// because the run reached MaxEmailsPerRun, or the feed's max-items,
+	// they'll be delivered on a later run.
+	ItemsDeferred int
+
+	// FetchDurationMs is the time taken to fetch, and parse, the feed.
+	FetchDurationMs int64
+
+	// DeliverDurationMs is the total time taken to deliver new items.
+	DeliverDurationMs int64
+
+	// CircuitOpen is true if the feed wasn't fetched, because it has
+	// failed too many times in a row recently.
+	CircuitOpen bool
+
+	// WebhookErrors is the number of new items which couldn't be
+	// POSTed to the feed's webhook.
+	WebhookErrors int
+
+	// FetchError is the error fetching the feed, if any.
+	FetchError error
+
+	// DeliverError is the error delivering items, if any.
+	DeliverError error
+}
+
+// Statistics returns the metrics for each feed processed by the most
+// recent call to ProcessFeeds, keyed by feed URL.
+func (p *Processor) Statistics() map[string]FeedStatistics {
+
+	p.shared.Lock()
+	defer p.shared.Unlock()
+
+	stats := make(map[string]FeedStatistics, len(p.stats))
+
+	for url, s := range p.stats {
+		c := *s
+		c.ItemsSkippedByFilter = make(map[string]int, len(s.ItemsSkippedByFilter))
+		for name, count := range s.ItemsSkippedByFilter {
+			c.ItemsSkippedByFilter[name] = count
+		}
+		stats[url] = c
+	}
+
+	return stats
+}
+
+// feedStatistics returns the statistics for the given feed, creating them
+// if necessary.
+//
+// Each feed's statistics are only updated by the worker processing it.
+func (p *Processor) feedStatistics(url string) *FeedStatistics {
+
+	p.shared.Lock()
+	defer p.shared.Unlock()
+
+	if p.stats == nil {
+		p.stats = make(map[string]*FeedStatistics)
+	}
+
+	s, ok := p.stats[url]
+	if !ok {
+		s = &FeedStatistics{ItemsSkippedByFilter: make(map[string]int)}
+		p.stats[url] = s
+	}
+
+	return s
+}
+
+// logStatistics logs the statistics of every feed, at debug level.
+func (p *Processor) logStatistics() {
+
+	for url, s := range p.stats {
+
+		attrs := []any{
+			slog.String("feed", url),
+			slog.Int("items_fetched", s.ItemsFetched),
+			slog.Int("items_sent", s.ItemsSent),
+			slog.Int("items_already_seen", s.ItemsAlreadySeen),
+			slog.Int("items_deferred", s.ItemsDeferred),
+			slog.Int64("fetch_duration_ms", s.FetchDurationMs),
+			slog.Int64("deliver_duration_ms", s.DeliverDurationMs),
+		}
+
+		for name, count := range s.ItemsSkippedByFilter {
+			attrs = append(attrs, slog.Int("skipped_by_"+name, count))
+		}
+		if s.FetchError != nil {
+			attrs = append(attrs, slog.String("fetch_error", s.FetchError.Error()))
+		}
+		if s.DeliverError != nil {
+			attrs = append(attrs, slog.String("deliver_error", s.DeliverError.Error()))
+		}
+
+		p.logger.Debug("feed statistics", attrs...)
+	}
+}
+
+// since returns the number of milliseconds since the given time.
+func (p *Processor) since(start time.Time) int64 {
+	return p.clock().Sub(start).Milliseconds()
+}
@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/skx/rss2email/state"
+)
+
+// RunStatus summarizes a run which processed every feed, it is written to
+// the StatusPath of our ProcessorConfig so that monitoring systems, and
+// the health-check command, can see when we last ran.
+type RunStatus struct {
+
+	// LastRun is the time the run finished.
+	LastRun time.Time `json:"last_run"`
+
+	// FeedsOK is the number of feeds which were fetched, and whose
+	// items were delivered, without error.
+	FeedsOK int `json:"feeds_ok"`
+
+	// FeedsError is the number of feeds which failed.
+	FeedsError int `json:"feeds_error"`
+
+	// EmailsSent is the number of items which were delivered.
+	EmailsSent int `json:"emails_sent"`
+}
+
+// DefaultStatusPath returns the path to the status file beneath our state
+// directory, which is what our commands use.
+func DefaultStatusPath() string {
+	return filepath.Join(state.Directory(), "status.json")
+}
+
+// ReadStatus reads the status of the last run from the given file.
+func ReadStatus(path string) (RunStatus, error) {
+
+	var status RunStatus
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return status, err
+	}
+
+	err = json.Unmarshal(data, &status)
+	return status, err
+}
+
+// writeStatus records the status of the run which has just finished, if
+// we have a status file.
+//
+// The file is replaced atomically, so that it is never seen half-written.
+func (p *Processor) writeStatus() error {
+
+	if p.statusPath == "" || p.dryRun != nil {
+		return nil
+	}
+
+	status := RunStatus{LastRun: p.clock()}
+	for _, s := range p.Statistics() {
+		if s.FetchError != nil || s.DeliverError != nil {
+			status.FeedsError++
+		} else {
+			status.FeedsOK++
+		}
+		status.EmailsSent += s.ItemsSent
+	}
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.statusPath), filepath.Base(p.statusPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p.statusPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
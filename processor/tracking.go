@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"net/url"
+	"strings"
+)
+
+// trackingPrefixes are the prefixes of the names of the query parameters
+// which "strip-utm" removes from links, they're used to track where the
+// reader came from rather than to find the page.
+var trackingPrefixes = []string{"utm_", "fbclid", "gclid"}
+
+// stripTracking returns the given link without any of the tracking
+// parameters in its query string.
+//
+// The remaining parameters keep their order, and encoding, and if none
+// remain the "?" is dropped too.  Links which can't be parsed are
+// returned unchanged.
+func stripTracking(link string) string {
+
+	u, err := url.Parse(link)
+	if err != nil || u.RawQuery == "" {
+		return link
+	}
+
+	var kept []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !isTrackingParameter(name) {
+			kept = append(kept, param)
+		}
+	}
+
+	u.RawQuery = strings.Join(kept, "&")
+	u.ForceQuery = false
+	return u.String()
+}
+
+// isTrackingParameter returns true if the named query parameter is one of
+// those removed by stripTracking.
+func isTrackingParameter(name string) bool {
+
+	name = strings.ToLower(name)
+	for _, prefix := range trackingPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"html"
+	"log/slog"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/skx/rss2email/configfile"
+)
+
+// The values of the "content-transform" option.
+const (
+	// transformKeepImages leaves the content alone, and is the default.
+	transformKeepImages = "keep-images"
+
+	// transformStripImages removes all images from the content.
+	transformStripImages = "strip-images"
+
+	// transformImagesToText replaces images with a textual placeholder.
+	transformImagesToText = "images-to-text"
+)
+
+// transformContent applies the "content-transform" option of the feed to
+// the HTML content of an item.
+//
+// This happens before the content is filtered, so an item which consists
+// of little but images might fall foul of "include-words-min".
+func transformContent(logger *slog.Logger, entry configfile.Feed, content string) string {
+
+	mode := transformKeepImages
+	for _, opt := range entry.Options {
+		if opt.Name == "content-transform" {
+			mode = strings.ToLower(strings.TrimSpace(opt.Value))
+		}
+	}
+
+	switch mode {
+	case transformKeepImages:
+		return content
+	case transformStripImages, transformImagesToText:
+	default:
+		logger.Warn("ignoring unknown content-transform",
+			slog.String("content-transform", mode))
+		return content
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return content
+	}
+
+	// Handle <picture> elements as a whole, so that their <source>
+	// children don't remain.  They're described by the <img> within.
+	doc.Find("picture").Each(func(i int, picture *goquery.Selection) {
+		if mode == transformStripImages {
+			picture.Remove()
+			return
+		}
+		picture.ReplaceWithHtml(imageText(picture.Find("img").First()))
+	})
+
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		if mode == transformStripImages {
+			img.Remove()
+			return
+		}
+		img.ReplaceWithHtml(imageText(img))
+	})
+
+	out, err := doc.Html()
+	if err != nil {
+		return content
+	}
+	return out
+}
+
+// imageText returns the placeholder for the given image, using the alt
+// text, or the title, if either is present.
+func imageText(img *goquery.Selection) string {
+
+	for _, attr := range []string{"alt", "title"} {
+		val, _ := img.Attr(attr)
+		val = strings.TrimSpace(val)
+		if val != "" {
+			return html.EscapeString("[Image: " + val + "]")
+		}
+	}
+
+	return "[Image]"
+}
@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/skx/rss2email/withstate"
+)
+
+// updatedPrefix is added to the title of the items which are sent again,
+// by "resend-updated", because they've been updated.
+const updatedPrefix = "[UPDATED] "
+
+// updatedStamp returns the "updated" timestamp of the given item, in RFC
+// 3339 format if it could be parsed, so that it doesn't change if only its
+// formatting does.  Items which don't have one return the empty string.
+func updatedStamp(item withstate.FeedItem) string {
+
+	if item.UpdatedParsed != nil {
+		return item.UpdatedParsed.UTC().Format(time.RFC3339)
+	}
+	return strings.TrimSpace(item.Updated)
+}
+
+// recordedUpdated returns the "updated" timestamp we recorded for the given
+// item of the feed, or the empty string if there is none, perhaps because
+// it was seen before "resend-updated" was set.
+func (p *Processor) recordedUpdated(feed string, key string) string {
+
+	previous, err := p.store.Updated(feed, key)
+	if err != nil {
+		p.logger.Error("error checking updated timestamp of item",
+			slog.String("feed", feed),
+			slog.String("item", key),
+			slog.String("error", err.Error()))
+	}
+
+	return previous
+}
+
+// recordUpdated records the "updated" timestamp of the given item of the
+// feed, so that we can tell if it is updated again.
+func (p *Processor) recordUpdated(feed string, key string, stamp string) error {
+
+	err := p.store.SetUpdated(feed, key, stamp)
+	if err != nil {
+		p.logger.Error("error recording updated timestamp of item",
+			slog.String("feed", feed),
+			slog.String("item", key),
+			slog.String("error", err.Error()))
+	}
+
+	return err
+}
@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// ConfigWatcher watches a configuration file for changes, and applies
+// each valid update to a running Processor.
+type ConfigWatcher struct {
+
+	// processor receives the updated feeds.
+	processor *Processor
+
+	// config is the configuration file we watch.
+	config *configfile.ConfigFile
+}
+
+// NewConfigWatcher creates a watcher which applies changes to the given
+// configuration file to the processor.
+//
+// The file is parsed immediately, and its feeds given to the processor,
+// so that an invalid file is reported before we start.
+func NewConfigWatcher(processor *Processor, configPath string) (*ConfigWatcher, error) {
+
+	config := configfile.NewWithPath(configPath)
+	config.SetLogger(processor.logger)
+
+	w := &ConfigWatcher{processor: processor, config: config}
+
+	_, err := config.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.apply(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Start begins watching the configuration file, until the context is
+// cancelled.
+//
+// Each time the file changes it is parsed and validated, and if there
+// are no problems the processor's feeds are replaced.  Otherwise the
+// error is logged and the processor keeps its existing feeds.
+func (w *ConfigWatcher) Start(ctx context.Context) error {
+
+	changes, err := w.config.Watch(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for updated := range changes {
+
+			// The file couldn't be parsed, which Watch has
+			// already reported.
+			if updated == nil {
+				continue
+			}
+
+			err := w.apply(updated)
+			if err != nil {
+				w.processor.logger.Error("ignoring invalid configuration file",
+					slog.String("configfile", updated.Path()),
+					slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// apply validates the feeds of the given configuration, and gives them to
+// the processor if they're OK.
+func (w *ConfigWatcher) apply(config *configfile.ConfigFile) error {
+
+	entries := config.Entries()
+
+	var errs []error
+	for _, entry := range entries {
+		errs = append(errs, configfile.ValidateAllRegexOptions(entry)...)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	w.processor.logger.Debug("reloading feeds from configuration file",
+		slog.String("configfile", config.Path()),
+		slog.Int("feed_count", len(entries)))
+
+	w.processor.ReloadFeeds(entries)
+	return nil
+}
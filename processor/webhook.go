@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/withstate"
+)
+
+// WebhookSignatureHeader is the header which holds the signature of the
+// payload POSTed to a webhook, if there is a secret.
+//
+// Its value is "sha256=" followed by the hex-encoded HMAC-SHA256 of the
+// request body, keyed by the secret.
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// webhookRetryDelay is how long we wait before retrying a webhook which
+// failed, it is a variable so that our tests needn't wait.
+var webhookRetryDelay = time.Second
+
+// webhookTimeout is how long we wait for a webhook to respond.
+const webhookTimeout = 30 * time.Second
+
+// WebhookPayload is the JSON document POSTed to a webhook for each new
+// item.
+type WebhookPayload struct {
+
+	// FeedURL is the URL of the feed the item was found in.
+	FeedURL string `json:"feed_url"`
+
+	// ItemTitle is the title of the item.
+	ItemTitle string `json:"item_title"`
+
+	// ItemLink is the link of the item.
+	ItemLink string `json:"item_link"`
+
+	// ItemAuthor holds the names, and email addresses, of the item's
+	// authors, comma-separated.
+	ItemAuthor string `json:"item_author"`
+
+	// ItemPublished is the publication date of the item, in RFC 3339
+	// format if it could be parsed, otherwise as the feed gave it.
+	ItemPublished string `json:"item_published"`
+
+	// ItemBody is the HTML content of the item, as it would be
+	// emailed.
+	ItemBody string `json:"item_body"`
+}
+
+// SignWebhook returns the value of the WebhookSignatureHeader for the given
+// body and secret.
+func SignWebhook(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhook returns the URL, and secret, of the webhook new items of the
+// given feed are POSTed to, if any.
+//
+// The "webhook-url" and "webhook-secret" options of the feed take
+// precedence over those of our configuration.
+func (p *Processor) webhook(entry configfile.Feed) (string, string) {
+
+	url := p.webhookURL
+	secret := p.webhookSecret
+
+	for _, opt := range entry.Options {
+		switch opt.Name {
+		case "webhook-url":
+			url = opt.Value
+		case "webhook-secret":
+			secret = opt.Value
+		}
+	}
+
+	return url, secret
+}
+
+// postWebhook POSTs the given item to the webhook of its feed, if it has
+// one, retrying once if that fails.
+//
+// Nothing is POSTed by a dry-run.
+func (p *Processor) postWebhook(logger *slog.Logger, entry configfile.Feed, item withstate.FeedItem, content string) error {
+
+	url, secret := p.webhook(entry)
+	if url == "" {
+		return nil
+	}
+
+	if p.dryRun != nil {
+		logger.Debug("not calling webhook for a dry-run",
+			slog.String("item-title", item.Title))
+		return nil
+	}
+
+	payload := WebhookPayload{
+		FeedURL:       entry.URL,
+		ItemTitle:     item.Title,
+		ItemLink:      item.Link,
+		ItemAuthor:    strings.Join(itemAuthors(item.Item), ", "),
+		ItemPublished: item.Published,
+		ItemBody:      content,
+	}
+	if item.PublishedParsed != nil {
+		payload.ItemPublished = item.PublishedParsed.Format(time.RFC3339)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = sendWebhook(url, secret, body)
+		if err == nil || attempt == 2 {
+			return err
+		}
+
+		logger.Warn("webhook failed, retrying",
+			slog.String("item-title", item.Title),
+			slog.String("error", err.Error()))
+		time.Sleep(webhookRetryDelay)
+	}
+}
+
+// sendWebhook makes a single POST of the given body to the webhook, which
+// fails unless the response has a 2xx status.
+func sendWebhook(url string, secret string, body []byte) error {
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(body, secret))
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body, so that the connection can be reused.
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+//
+// Remove old entries from our seen-state.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// Structure for our options and state.
+type purgeStateCmd struct {
+
+	// days is the age, in days, beyond which entries are removed.
+	days int
+
+	// orphans causes the state of feeds we no longer process to be
+	// removed too.
+	orphans bool
+
+	// stateDB is the SQLite database holding our state, if any.
+	stateDB string
+
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+}
+
+// Info is part of the subcommand-API.
+func (p *purgeStateCmd) Info() (string, string) {
+	return "purge-state", `Remove old entries from the seen-state.
+
+This sub-command removes the items which were seen more than the given
+number of days ago, from the state of every feed.  Items recorded by
+older releases, which didn't keep the time they were seen, are kept.
+
+Adding '-purge-orphans' also removes every item of the feeds which are
+no longer in the configuration file, whatever their age.
+
+Note that an item which is still present in its feed will be sent again,
+as a new item, the next time the feed is processed.
+
+The state is the BoltDB database in ~/.rss2email/state.db, unless
+'-state-db' names the SQLite database given to 'cron' or 'daemon'.
+
+Examples:
+
+    $ rss2email purge-state -days 365
+    $ rss2email purge-state -days 90 -purge-orphans
+    $ rss2email purge-state -purge-orphans
+`
+}
+
+// Arguments handles our flag-setup.
+func (p *purgeStateCmd) Arguments(f *flag.FlagSet) {
+	f.IntVar(&p.days, "days", 0, "Remove entries which were seen more than this many days ago")
+	f.BoolVar(&p.orphans, "purge-orphans", false, "Remove every entry of feeds which are no longer in the configuration file")
+	f.StringVar(&p.stateDB, "state-db", "", "Purge the given SQLite database, rather than the default BoltDB one")
+	p.config = configfile.New()
+}
+
+// Entry-point.
+func (p *purgeStateCmd) Execute(args []string) int {
+
+	if p.days < 0 || (p.days == 0 && !p.orphans) {
+		fmt.Fprintf(out, "Please specify a positive number of -days, or -purge-orphans.\n")
+		return 1
+	}
+
+	// The feeds we're configured to process, if we're removing the
+	// others.
+	configured := make(map[string]bool)
+	if p.orphans {
+		entries, err := p.config.Parse()
+		if err != nil {
+			logger.Error("failed to parse configuration file",
+				slog.String("configfile", p.config.Path()),
+				slog.String("error", err.Error()))
+			return 1
+		}
+		for _, entry := range entries {
+			configured[entry.URL] = true
+		}
+	}
+
+	store, path, err := openStateStore(p.stateDB, false)
+	if err != nil {
+		logger.Error("failed to open state", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	defer store.Close()
+
+	feeds, err := store.Feeds()
+	if err != nil {
+		logger.Error("failed to read feeds", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -p.days)
+	total := 0
+	purged := 0
+
+	for _, feed := range feeds {
+
+		removed := 0
+
+		if p.orphans && !configured[feed] {
+			items, err := store.Items(feed)
+			if err == nil {
+				err = store.DeleteFeed(feed)
+			}
+			if err != nil {
+				logger.Error("failed to remove feed", slog.String("state", path), slog.String("feed", feed), slog.String("error", err.Error()))
+				return 1
+			}
+			removed = len(items)
+		} else if p.days > 0 {
+			removed, err = store.RemoveBefore(feed, cutoff)
+			if err != nil {
+				logger.Error("failed to remove items", slog.String("state", path), slog.String("feed", feed), slog.String("error", err.Error()))
+				return 1
+			}
+		}
+
+		if removed > 0 {
+			fmt.Fprintf(out, "%s: removed %d entries\n", feed, removed)
+			total += removed
+			purged++
+		}
+	}
+
+	fmt.Fprintf(out, "Removed %d entries from %d feeds\n", total, purged)
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// TestPurgeState tests removing old, and orphaned, state with each backend.
+func TestPurgeState(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	os.MkdirAll(filepath.Join(dir, ".rss2email"), 0755)
+
+	cfg := filepath.Join(dir, "feeds.txt")
+	err := os.WriteFile(cfg, []byte("https://example.com/feed\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %s", err)
+	}
+
+	for _, stateDB := range []string{"", filepath.Join(dir, "state.sqlite.db")} {
+
+		store, _, err := openStateStore(stateDB, true)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		now := time.Now()
+		err = store.RecordTimes("https://example.com/feed", map[string]time.Time{
+			"old":     now.AddDate(0, 0, -100),
+			"recent":  now.AddDate(0, 0, -1),
+			"unknown": {},
+		})
+		if err == nil {
+			err = store.RecordTimes("https://example.net/orphan", map[string]time.Time{
+				"a": now.AddDate(0, 0, -100),
+				"b": now,
+			})
+		}
+		if err != nil {
+			t.Fatalf("failed to record state: %s", err)
+		}
+		store.Close()
+
+		// Only old entries are removed.
+		out = &bytes.Buffer{}
+		p := purgeStateCmd{days: 30, stateDB: stateDB, config: configfile.NewWithPath(cfg)}
+		if p.Execute(nil) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+		output := out.(*bytes.Buffer).String()
+		if !strings.Contains(output, "https://example.com/feed: removed 1 entries\n") ||
+			!strings.Contains(output, "https://example.net/orphan: removed 1 entries\n") ||
+			!strings.Contains(output, "Removed 2 entries from 2 feeds") {
+			t.Fatalf("unexpected output: %s", output)
+		}
+
+		// Orphaned feeds are removed whatever their age.
+		out = &bytes.Buffer{}
+		p = purgeStateCmd{days: 30, orphans: true, stateDB: stateDB, config: configfile.NewWithPath(cfg)}
+		if p.Execute(nil) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+		if !strings.Contains(out.(*bytes.Buffer).String(), "Removed 1 entries from 1 feeds") {
+			t.Fatalf("unexpected output: %s", out)
+		}
+
+		store, _, err = openStateStore(stateDB, false)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		feeds, err := store.Feeds()
+		if err != nil || len(feeds) != 1 || feeds[0] != "https://example.com/feed" {
+			t.Fatalf("unexpected feeds %v %v", feeds, err)
+		}
+		items, err := store.Items("https://example.com/feed")
+		sort.Strings(items)
+		if err != nil || strings.Join(items, ",") != "recent,unknown" {
+			t.Fatalf("unexpected items %v %v", items, err)
+		}
+		store.Close()
+	}
+
+	// Bogus arguments are reported.
+	for _, p := range []purgeStateCmd{
+		{},
+		{days: -1},
+		{days: 1, stateDB: filepath.Join(dir, "missing.db")},
+	} {
+		out = &bytes.Buffer{}
+		if p.Execute(nil) != 1 {
+			t.Fatalf("expected failure with %v", p)
+		}
+	}
+}
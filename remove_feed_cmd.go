@@ -0,0 +1,75 @@
+//
+// Remove a single feed, with its options, from the configuration file.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/subcommands"
+)
+
+// Structure for our options and state.
+type removeFeedCmd struct {
+
+	// We embed the NoFlags option, because we accept no command-line flags.
+	subcommands.NoFlags
+
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+}
+
+// Arguments handles argument-flags we might have.
+//
+// In our case we use this as a hook to setup our configuration-file,
+// which allows testing.
+func (r *removeFeedCmd) Arguments(flags *flag.FlagSet) {
+	r.config = configfile.New()
+}
+
+// Info is part of the subcommand-API
+func (r *removeFeedCmd) Info() (string, string) {
+	return "remove-feed", `Remove a feed, with its options, from our feed-list.
+
+Remove the given URL, and the options beneath it, from the configuration
+file.
+
+Unlike 'delete' the rest of the file, including any comments, is left
+exactly as it is, and the file is replaced atomically.  It is an error
+if the feed isn't present.
+
+To see details of the configuration file, including the location,
+please run:
+
+   $ rss2email help config
+
+Example:
+
+    $ rss2email remove-feed https://blog.steve.fi/index.rss
+`
+}
+
+// Execute is invoked if the user specifies `remove-feed` as the subcommand.
+func (r *removeFeedCmd) Execute(args []string) int {
+
+	if len(args) != 1 {
+		fmt.Fprintf(out, "Usage: rss2email remove-feed URL\n")
+		return 1
+	}
+	url := args[0]
+
+	err := r.config.RemoveFeed(url)
+	if err != nil {
+		logger.Error("failed to remove feed",
+			slog.String("configfile", r.config.Path()),
+			slog.String("feed", url),
+			slog.String("error", err.Error()))
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestRemoveFeedUsage ensures we require a single URL.
+func TestRemoveFeedUsage(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+	out = &bytes.Buffer{}
+
+	rem := removeFeedCmd{}
+	for _, args := range [][]string{{}, {"a", "b"}} {
+		if res := rem.Execute(args); res != 1 {
+			t.Fatalf("expected failure with arguments %v", args)
+		}
+	}
+}
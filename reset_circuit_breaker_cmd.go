@@ -0,0 +1,76 @@
+//
+// Clear the count of consecutive failures of a feed.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Structure for our options and state.
+type resetCircuitBreakerCmd struct {
+
+	// stateDB is the SQLite database holding our state, if any.
+	stateDB string
+}
+
+// Info is part of the subcommand-API.
+func (r *resetCircuitBreakerCmd) Info() (string, string) {
+	return "reset-circuit-breaker", `Clear the count of failures of a feed.
+
+The 'cron' and 'daemon' sub-commands stop fetching a feed which fails,
+by default, five times in a row, until a day has passed since its last
+failure.  This sub-command clears the count of failures of the given
+feed, so that it is fetched again on the next run.
+
+The state is the BoltDB database in ~/.rss2email/state.db, unless
+'-state-db' names the SQLite database given to 'cron' or 'daemon'.
+
+Example:
+
+    $ rss2email reset-circuit-breaker https://example.com/feed.xml
+`
+}
+
+// Arguments handles our flag-setup.
+func (r *resetCircuitBreakerCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&r.stateDB, "state-db", "", "Use the given SQLite database, rather than the default BoltDB one")
+}
+
+// Entry-point.
+func (r *resetCircuitBreakerCmd) Execute(args []string) int {
+
+	if len(args) != 1 {
+		fmt.Fprintf(out, "Usage: rss2email reset-circuit-breaker URL\n")
+		return 1
+	}
+	feed := args[0]
+
+	store, path, err := openStateStore(r.stateDB, false)
+	if err != nil {
+		logger.Error("failed to open state", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	defer store.Close()
+
+	count, last, err := store.Failures(feed)
+	if err == nil && count > 0 {
+		err = store.ResetFailures(feed)
+	}
+	if err != nil {
+		logger.Error("failed to reset failures", slog.String("state", path), slog.String("feed", feed), slog.String("error", err.Error()))
+		return 1
+	}
+
+	if count == 0 {
+		fmt.Fprintf(out, "%s has no recorded failures\n", feed)
+		return 0
+	}
+
+	fmt.Fprintf(out, "%s: cleared %d consecutive failures, the last at %s\n", feed, count, last.Format(time.RFC3339))
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestResetCircuitBreaker tests clearing the failures of a feed, with each
+// backend.
+func TestResetCircuitBreaker(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	os.MkdirAll(filepath.Join(dir, ".rss2email"), 0755)
+
+	feed := "https://example.com/feed"
+
+	for _, stateDB := range []string{"", filepath.Join(dir, "state.sqlite.db")} {
+
+		store, _, err := openStateStore(stateDB, true)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		then := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 3 && err == nil; i++ {
+			_, err = store.RecordFailure(feed, then)
+		}
+		if err != nil {
+			t.Fatalf("failed to record failures: %s", err)
+		}
+		store.Close()
+
+		// A URL is required.
+		out = &bytes.Buffer{}
+		r := resetCircuitBreakerCmd{stateDB: stateDB}
+		if r.Execute(nil) != 1 || !strings.Contains(out.(*bytes.Buffer).String(), "Usage") {
+			t.Fatalf("expected usage: %s", out)
+		}
+
+		out = &bytes.Buffer{}
+		if r.Execute([]string{feed}) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+		expected := "cleared 3 consecutive failures, the last at 2024-03-10T12:00:00Z"
+		if !strings.Contains(out.(*bytes.Buffer).String(), expected) {
+			t.Fatalf("expected '%s', got: %s", expected, out)
+		}
+
+		// Now there's nothing to clear.
+		out = &bytes.Buffer{}
+		if r.Execute([]string{feed}) != 0 || !strings.Contains(out.(*bytes.Buffer).String(), "no recorded failures") {
+			t.Fatalf("unexpected output: %s", out)
+		}
+
+		store, _, err = openStateStore(stateDB, false)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		count, _, err := store.Failures(feed)
+		store.Close()
+		if err != nil || count != 0 {
+			t.Fatalf("expected no failures, got %d: %v", count, err)
+		}
+	}
+}
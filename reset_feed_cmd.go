@@ -0,0 +1,78 @@
+//
+// Forget the items seen in a feed.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+)
+
+// Structure for our options and state.
+type resetFeedCmd struct {
+
+	// stateDB is the SQLite database holding our state, if any.
+	stateDB string
+}
+
+// Info is part of the subcommand-API.
+func (r *resetFeedCmd) Info() (string, string) {
+	return "reset-feed", `Forget the items seen in a feed.
+
+Remove every entry for the given feed from the seen-state, so that the
+next run treats all of its items as new, such as after adding an
+"include" option to it.  The state of every other feed is unchanged.
+
+The options the feed was last processed with, and its count of failures,
+are forgotten too.  To avoid being sent every item again combine the next
+run with '-backfill', as described in the 'cron' sub-command.
+
+The state is the BoltDB database in ~/.rss2email/state.db, unless
+'-state-db' names the SQLite database given to 'cron' or 'daemon'.
+
+Example:
+
+    $ rss2email reset-feed https://example.com/feed.xml
+`
+}
+
+// Arguments handles our flag-setup.
+func (r *resetFeedCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&r.stateDB, "state-db", "", "Use the given SQLite database, rather than the default BoltDB one")
+}
+
+// Entry-point.
+func (r *resetFeedCmd) Execute(args []string) int {
+
+	if len(args) != 1 {
+		fmt.Fprintf(out, "Usage: rss2email reset-feed URL\n")
+		return 1
+	}
+	feed := args[0]
+
+	store, path, err := openStateStore(r.stateDB, false)
+	if err != nil {
+		logger.Error("failed to open state", slog.String("state", path), slog.String("error", err.Error()))
+		return 1
+	}
+	defer store.Close()
+
+	items, err := store.Items(feed)
+	if err == nil && len(items) > 0 {
+		err = store.DeleteFeed(feed)
+	}
+	if err != nil {
+		logger.Error("failed to reset feed", slog.String("state", path), slog.String("feed", feed), slog.String("error", err.Error()))
+		return 1
+	}
+
+	if len(items) == 0 {
+		fmt.Fprintf(out, "%s has no recorded items\n", feed)
+		return 0
+	}
+
+	fmt.Fprintf(out, "%s: removed %d entries\n", feed, len(items))
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestResetFeed tests forgetting the items of one feed, with each backend,
+// leaving those of another feed alone.
+func TestResetFeed(t *testing.T) {
+
+	bak := out
+	defer func() { out = bak }()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	os.MkdirAll(filepath.Join(dir, ".rss2email"), 0755)
+
+	feed := "https://example.com/feed"
+	other := "https://example.org/feed"
+
+	for _, stateDB := range []string{"", filepath.Join(dir, "state.sqlite.db")} {
+
+		store, _, err := openStateStore(stateDB, true)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		err = store.Record(feed, "https://example.com/1", "https://example.com/2", "https://example.com/3")
+		if err == nil {
+			err = store.Record(other, "https://example.org/1", "https://example.org/2")
+		}
+		if err == nil {
+			err = store.SetFingerprint(other, "abc")
+		}
+		if err != nil {
+			t.Fatalf("failed to record items: %s", err)
+		}
+		store.Close()
+
+		// A URL is required.
+		out = &bytes.Buffer{}
+		r := resetFeedCmd{stateDB: stateDB}
+		if r.Execute(nil) != 1 || !strings.Contains(out.(*bytes.Buffer).String(), "Usage") {
+			t.Fatalf("expected usage: %s", out)
+		}
+
+		out = &bytes.Buffer{}
+		if r.Execute([]string{feed}) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+		expected := feed + ": removed 3 entries"
+		if !strings.Contains(out.(*bytes.Buffer).String(), expected) {
+			t.Fatalf("expected '%s', got: %s", expected, out)
+		}
+
+		// Now there's nothing to reset.
+		out = &bytes.Buffer{}
+		if r.Execute([]string{feed}) != 0 || !strings.Contains(out.(*bytes.Buffer).String(), "no recorded items") {
+			t.Fatalf("unexpected output: %s", out)
+		}
+
+		// The other feed is untouched.
+		store, _, err = openStateStore(stateDB, false)
+		if err != nil {
+			t.Fatalf("failed to open state: %s", err)
+		}
+		items, err := store.Items(feed)
+		if err != nil || len(items) != 0 {
+			t.Fatalf("expected no items, got %v: %v", items, err)
+		}
+		items, err = store.Items(other)
+		if err != nil || len(items) != 2 {
+			t.Fatalf("expected the other feed's items, got %v: %v", items, err)
+		}
+		fingerprint, err := store.Fingerprint(other)
+		store.Close()
+		if err != nil || fingerprint != "abc" {
+			t.Fatalf("expected the other feed's fingerprint, got %q: %v", fingerprint, err)
+		}
+	}
+}
@@ -79,7 +79,9 @@ func (s *seenCmd) Execute(args []string) int {
 
 	err = db.View(func(tx *bbolt.Tx) error {
 		err = tx.ForEach(func(bucketName []byte, _ *bbolt.Bucket) error {
-			bucketNames = append(bucketNames, bucketName)
+			if state.IsFeedBucket(string(bucketName)) {
+				bucketNames = append(bucketNames, bucketName)
+			}
 			return nil
 		})
 		return err
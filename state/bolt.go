@@ -0,0 +1,433 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// FingerprintBucket is the BoltDB bucket in which the processor records
+// the fingerprint of each feed's options, keyed by feed URL.
+//
+// It isn't a feed, so it must be skipped by anything which walks the
+// buckets of the database.
+const FingerprintBucket = "rss2email:fingerprints"
+
+// FailureBucket is the BoltDB bucket in which the processor records the
+// number of consecutive times each feed has failed to be fetched, keyed by
+// feed URL.  The value is the count, and the time of the last failure in
+// RFC 3339 format, separated by a space.
+//
+// Like FingerprintBucket it isn't a feed.
+const FailureBucket = "rss2email:failures"
+
+// UpdatedBucket is the BoltDB bucket in which the processor records the
+// "updated" timestamp of items.  It holds a bucket for each feed, named by
+// its URL, in which the timestamps are keyed by item.
+//
+// Like FingerprintBucket it isn't a feed.
+const UpdatedBucket = "rss2email:updated"
+
+// IsFeedBucket returns true if the BoltDB bucket with the given name holds
+// the items of a feed, rather than being one of our own, such as
+// FingerprintBucket.
+func IsFeedBucket(name string) bool {
+	return name != FingerprintBucket && name != FailureBucket && name != UpdatedBucket
+}
+
+// Bolt is a Store which uses a BoltDB database, with one bucket for each
+// feed and the seen items stored as keys within it.
+//
+// This is the format the processor has always used.  The value of each
+// key is the time the item was seen, in RFC 3339 format, or "seen" if it
+// was recorded by an older release.
+type Bolt struct {
+
+	// db is our database handle.
+	db *bbolt.DB
+}
+
+// OpenBolt opens the BoltDB database at the given path, creating it if
+// necessary.
+func OpenBolt(path string) (*Bolt, error) {
+
+	db, err := bbolt.Open(path, 0666, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// Feeds is part of the Store interface.
+func (b *Bolt) Feeds() ([]string, error) {
+
+	var feeds []string
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if IsFeedBucket(string(name)) {
+				feeds = append(feeds, string(name))
+			}
+			return nil
+		})
+	})
+
+	return feeds, err
+}
+
+// Items is part of the Store interface.
+func (b *Bolt) Items(feed string) ([]string, error) {
+
+	var items []string
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(feed))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, _ []byte) error {
+			items = append(items, string(k))
+			return nil
+		})
+	})
+
+	return items, err
+}
+
+// Seen is part of the Store interface.
+func (b *Bolt) Seen(feed string, item string) (bool, error) {
+
+	seen := false
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(feed))
+		if bucket != nil {
+			seen = bucket.Get([]byte(item)) != nil
+		}
+		return nil
+	})
+
+	return seen, err
+}
+
+// Record is part of the Store interface.
+func (b *Bolt) Record(feed string, items ...string) error {
+
+	now := time.Now()
+
+	times := make(map[string]time.Time, len(items))
+	for _, item := range items {
+		times[item] = now
+	}
+
+	return b.RecordTimes(feed, times)
+}
+
+// SeenTimes is part of the Store interface.
+func (b *Bolt) SeenTimes(feed string) (map[string]time.Time, error) {
+
+	times := make(map[string]time.Time)
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(feed))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			// Older values don't parse, leaving a zero time.
+			seen, _ := time.Parse(time.RFC3339, string(v))
+			times[string(k)] = seen
+			return nil
+		})
+	})
+
+	return times, err
+}
+
+// RecordTimes is part of the Store interface.
+func (b *Bolt) RecordTimes(feed string, items map[string]time.Time) error {
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(feed))
+		if err != nil {
+			return err
+		}
+
+		for item, seen := range items {
+			if bucket.Get([]byte(item)) != nil {
+				continue
+			}
+
+			value := "seen"
+			if !seen.IsZero() {
+				value = seen.UTC().Format(time.RFC3339)
+			}
+
+			err = bucket.Put([]byte(item), []byte(value))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Remove is part of the Store interface.
+func (b *Bolt) Remove(feed string, items ...string) error {
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(feed))
+		if bucket == nil {
+			return nil
+		}
+
+		for _, item := range items {
+			err := bucket.Delete([]byte(item))
+			if err != nil {
+				return err
+			}
+		}
+		return forgetUpdated(tx, feed, items...)
+	})
+}
+
+// RemoveBefore is part of the Store interface.
+func (b *Bolt) RemoveBefore(feed string, before time.Time) (int, error) {
+
+	removed := 0
+
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(feed))
+		if bucket == nil {
+			return nil
+		}
+
+		// Keys can't be deleted while we iterate over them.
+		var old [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			seen, err := time.Parse(time.RFC3339, string(v))
+			if err == nil && seen.Before(before) {
+				old = append(old, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		items := make([]string, 0, len(old))
+		for _, k := range old {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+			items = append(items, string(k))
+		}
+		removed = len(old)
+		return forgetUpdated(tx, feed, items...)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// DeleteFeed is part of the Store interface.
+func (b *Bolt) DeleteFeed(feed string) error {
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.DeleteBucket([]byte(feed))
+		if err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+
+		for _, name := range []string{FingerprintBucket, FailureBucket} {
+			if bucket := tx.Bucket([]byte(name)); bucket != nil {
+				err = bucket.Delete([]byte(feed))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if updated := tx.Bucket([]byte(UpdatedBucket)); updated != nil {
+			err = updated.DeleteBucket([]byte(feed))
+			if err != nil && err != bbolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Updated is part of the Store interface.
+func (b *Bolt) Updated(feed string, item string) (string, error) {
+
+	value := ""
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if updated := tx.Bucket([]byte(UpdatedBucket)); updated != nil {
+			if bucket := updated.Bucket([]byte(feed)); bucket != nil {
+				value = string(bucket.Get([]byte(item)))
+			}
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+// SetUpdated is part of the Store interface.
+func (b *Bolt) SetUpdated(feed string, item string, value string) error {
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		updated, err := tx.CreateBucketIfNotExists([]byte(UpdatedBucket))
+		if err != nil {
+			return err
+		}
+		bucket, err := updated.CreateBucketIfNotExists([]byte(feed))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(item), []byte(value))
+	})
+}
+
+// forgetUpdated removes the "updated" timestamps of the given items of the
+// feed, within a transaction which is removing the items themselves.
+func forgetUpdated(tx *bbolt.Tx, feed string, items ...string) error {
+
+	updated := tx.Bucket([]byte(UpdatedBucket))
+	if updated == nil {
+		return nil
+	}
+	bucket := updated.Bucket([]byte(feed))
+	if bucket == nil {
+		return nil
+	}
+
+	for _, item := range items {
+		err := bucket.Delete([]byte(item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fingerprint is part of the Store interface.
+func (b *Bolt) Fingerprint(feed string) (string, error) {
+
+	fingerprint := ""
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if fp := tx.Bucket([]byte(FingerprintBucket)); fp != nil {
+			fingerprint = string(fp.Get([]byte(feed)))
+		}
+		return nil
+	})
+
+	return fingerprint, err
+}
+
+// SetFingerprint is part of the Store interface.
+func (b *Bolt) SetFingerprint(feed string, fingerprint string) error {
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		fp, err := tx.CreateBucketIfNotExists([]byte(FingerprintBucket))
+		if err != nil {
+			return err
+		}
+		return fp.Put([]byte(feed), []byte(fingerprint))
+	})
+}
+
+// Failures is part of the Store interface.
+func (b *Bolt) Failures(feed string) (int, time.Time, error) {
+
+	count := 0
+	var last time.Time
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if bucket := tx.Bucket([]byte(FailureBucket)); bucket != nil {
+			var err error
+			count, last, err = parseFailures(bucket.Get([]byte(feed)))
+			return err
+		}
+		return nil
+	})
+
+	return count, last, err
+}
+
+// RecordFailure is part of the Store interface.
+func (b *Bolt) RecordFailure(feed string, at time.Time) (int, error) {
+
+	count := 0
+
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(FailureBucket))
+		if err != nil {
+			return err
+		}
+
+		count, _, err = parseFailures(bucket.Get([]byte(feed)))
+		if err != nil {
+			return err
+		}
+		count++
+
+		return bucket.Put([]byte(feed), []byte(fmt.Sprintf("%d %s", count, at.UTC().Format(time.RFC3339))))
+	})
+
+	return count, err
+}
+
+// ResetFailures is part of the Store interface.
+func (b *Bolt) ResetFailures(feed string) error {
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if bucket := tx.Bucket([]byte(FailureBucket)); bucket != nil {
+			return bucket.Delete([]byte(feed))
+		}
+		return nil
+	})
+}
+
+// parseFailures parses a value from FailureBucket, a missing value meaning
+// there have been no failures.
+func parseFailures(value []byte) (int, time.Time, error) {
+
+	if value == nil {
+		return 0, time.Time{}, nil
+	}
+
+	count, at, _ := strings.Cut(string(value), " ")
+
+	var n int
+	_, err := fmt.Sscanf(count, "%d", &n)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid failure count '%s': %w", value, err)
+	}
+
+	last, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid failure time '%s': %w", value, err)
+	}
+
+	return n, last, nil
+}
+
+// Sync is part of the Store interface.
+func (b *Bolt) Sync() error {
+	return b.db.Sync()
+}
+
+// Close is part of the Store interface.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
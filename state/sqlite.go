@@ -0,0 +1,333 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	// Register the pure-Go SQLite driver.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables which hold our state, if they are
+// missing.
+//
+// The primary key means that recording an item twice is harmless.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS seen_items (
+	feed_url TEXT NOT NULL,
+	guid     TEXT NOT NULL,
+	seen_at  INTEGER NOT NULL,
+	PRIMARY KEY (feed_url, guid)
+);
+CREATE TABLE IF NOT EXISTS feed_fingerprints (
+	feed_url    TEXT NOT NULL PRIMARY KEY,
+	fingerprint TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS feed_failures (
+	feed_url             TEXT NOT NULL PRIMARY KEY,
+	consecutive_failures INTEGER NOT NULL,
+	last_failure_at      INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS item_updates (
+	feed_url TEXT NOT NULL,
+	guid     TEXT NOT NULL,
+	updated  TEXT NOT NULL,
+	PRIMARY KEY (feed_url, guid)
+)`
+
+// SQLite is a Store which uses a SQLite database.
+type SQLite struct {
+
+	// db is our database handle.
+	db *sql.DB
+}
+
+// OpenSQLite opens the SQLite database at the given path, creating it and
+// our schema if necessary.
+//
+// The path ":memory:" gives a database which is held in memory, and lost
+// when it is closed.
+func OpenSQLite(path string) (*SQLite, error) {
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only supports a single writer.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema in %s: %w", path, err)
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// Feeds is part of the Store interface.
+func (s *SQLite) Feeds() ([]string, error) {
+	return s.strings("SELECT DISTINCT feed_url FROM seen_items ORDER BY feed_url")
+}
+
+// Items is part of the Store interface.
+func (s *SQLite) Items(feed string) ([]string, error) {
+	return s.strings("SELECT guid FROM seen_items WHERE feed_url = ? ORDER BY guid", feed)
+}
+
+// strings runs a query which returns a single column, and returns the
+// values found.
+func (s *SQLite) strings(query string, args ...any) ([]string, error) {
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// Seen is part of the Store interface.
+func (s *SQLite) Seen(feed string, item string) (bool, error) {
+
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM seen_items WHERE feed_url = ? AND guid = ?", feed, item).Scan(&count)
+
+	return count > 0, err
+}
+
+// Record is part of the Store interface.
+func (s *SQLite) Record(feed string, items ...string) error {
+
+	now := time.Now()
+
+	times := make(map[string]time.Time, len(items))
+	for _, item := range items {
+		times[item] = now
+	}
+
+	return s.RecordTimes(feed, times)
+}
+
+// SeenTimes is part of the Store interface.
+func (s *SQLite) SeenTimes(feed string) (map[string]time.Time, error) {
+
+	rows, err := s.db.Query("SELECT guid, seen_at FROM seen_items WHERE feed_url = ?", feed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[string]time.Time)
+	for rows.Next() {
+		var guid string
+		var seen int64
+		err = rows.Scan(&guid, &seen)
+		if err != nil {
+			return nil, err
+		}
+
+		times[guid] = time.Time{}
+		if seen != 0 {
+			times[guid] = time.Unix(seen, 0).UTC()
+		}
+	}
+
+	return times, rows.Err()
+}
+
+// RecordTimes is part of the Store interface.
+//
+// Items with a zero time are recorded with a seen_at of zero.
+func (s *SQLite) RecordTimes(feed string, items map[string]time.Time) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for item, seen := range items {
+		var when int64
+		if !seen.IsZero() {
+			when = seen.Unix()
+		}
+
+		_, err = tx.Exec("INSERT OR IGNORE INTO seen_items (feed_url, guid, seen_at) VALUES (?, ?, ?)", feed, item, when)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Remove is part of the Store interface.
+func (s *SQLite) Remove(feed string, items ...string) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		for _, query := range []string{
+			"DELETE FROM seen_items WHERE feed_url = ? AND guid = ?",
+			"DELETE FROM item_updates WHERE feed_url = ? AND guid = ?",
+		} {
+			_, err = tx.Exec(query, feed, item)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveBefore is part of the Store interface.
+func (s *SQLite) RemoveBefore(feed string, before time.Time) (int, error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM item_updates WHERE feed_url = ? AND guid IN
+(SELECT guid FROM seen_items WHERE feed_url = ? AND seen_at > 0 AND seen_at < ?)`, feed, feed, before.Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	res, err := tx.Exec("DELETE FROM seen_items WHERE feed_url = ? AND seen_at > 0 AND seen_at < ?", feed, before.Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return int(removed), tx.Commit()
+}
+
+// DeleteFeed is part of the Store interface.
+func (s *SQLite) DeleteFeed(feed string) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM seen_items WHERE feed_url = ?",
+		"DELETE FROM feed_fingerprints WHERE feed_url = ?",
+		"DELETE FROM feed_failures WHERE feed_url = ?",
+		"DELETE FROM item_updates WHERE feed_url = ?",
+	} {
+		_, err = tx.Exec(query, feed)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Fingerprint is part of the Store interface.
+func (s *SQLite) Fingerprint(feed string) (string, error) {
+
+	values, err := s.strings("SELECT fingerprint FROM feed_fingerprints WHERE feed_url = ?", feed)
+	if err != nil || len(values) == 0 {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// SetFingerprint is part of the Store interface.
+func (s *SQLite) SetFingerprint(feed string, fingerprint string) error {
+
+	_, err := s.db.Exec("INSERT OR REPLACE INTO feed_fingerprints (feed_url, fingerprint) VALUES (?, ?)", feed, fingerprint)
+	return err
+}
+
+// Failures is part of the Store interface.
+func (s *SQLite) Failures(feed string) (int, time.Time, error) {
+
+	var count int
+	var last int64
+	err := s.db.QueryRow("SELECT consecutive_failures, last_failure_at FROM feed_failures WHERE feed_url = ?", feed).Scan(&count, &last)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, time.Unix(last, 0).UTC(), nil
+}
+
+// RecordFailure is part of the Store interface.
+func (s *SQLite) RecordFailure(feed string, at time.Time) (int, error) {
+
+	var count int
+	err := s.db.QueryRow(`INSERT INTO feed_failures (feed_url, consecutive_failures, last_failure_at) VALUES (?, 1, ?)
+ON CONFLICT (feed_url) DO UPDATE SET consecutive_failures = consecutive_failures + 1, last_failure_at = excluded.last_failure_at
+RETURNING consecutive_failures`, feed, at.Unix()).Scan(&count)
+
+	return count, err
+}
+
+// ResetFailures is part of the Store interface.
+func (s *SQLite) ResetFailures(feed string) error {
+
+	_, err := s.db.Exec("DELETE FROM feed_failures WHERE feed_url = ?", feed)
+	return err
+}
+
+// Updated is part of the Store interface.
+func (s *SQLite) Updated(feed string, item string) (string, error) {
+
+	values, err := s.strings("SELECT updated FROM item_updates WHERE feed_url = ? AND guid = ?", feed, item)
+	if err != nil || len(values) == 0 {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// SetUpdated is part of the Store interface.
+func (s *SQLite) SetUpdated(feed string, item string, updated string) error {
+
+	_, err := s.db.Exec("INSERT OR REPLACE INTO item_updates (feed_url, guid, updated) VALUES (?, ?, ?)", feed, item, updated)
+	return err
+}
+
+// Sync is part of the Store interface.
+//
+// Each change is committed as it is made, so there is nothing to do.
+func (s *SQLite) Sync() error {
+	return nil
+}
+
+// Close is part of the Store interface.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -4,6 +4,9 @@
 // 1. The location of the configuration-file.
 //
 // 2. The location of the BoltDB database.
+//
+// It also contains the Store interface, which is implemented by each of
+// the backends our seen-state can be kept in.
 package state
 
 import (
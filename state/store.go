@@ -0,0 +1,140 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store is the interface implemented by each of our state backends, which
+// record the items we've seen in each feed.
+//
+// The processor keeps all of its state in a Store, so the backends are
+// interchangeable.
+type Store interface {
+
+	// Feeds returns the URLs of the feeds which have state recorded.
+	Feeds() ([]string, error)
+
+	// Items returns the keys of the items recorded as seen for the
+	// given feed.
+	Items(feed string) ([]string, error)
+
+	// Seen returns true if the given item is recorded as seen for
+	// the feed.
+	Seen(feed string, item string) (bool, error)
+
+	// Record marks the given items as seen for the feed.
+	//
+	// The items are recorded atomically, either all of them are
+	// stored or none are, and recording an item which is already
+	// present is not an error.
+	Record(feed string, items ...string) error
+
+	// SeenTimes returns the time each item of the feed was recorded
+	// as seen, keyed by item.  The time is zero for items recorded by
+	// releases which didn't keep it.
+	SeenTimes(feed string) (map[string]time.Time, error)
+
+	// RecordTimes marks the given items as seen for the feed, at the
+	// given times, atomically.  Items which are already present keep
+	// the time they were first recorded.
+	RecordTimes(feed string, items map[string]time.Time) error
+
+	// Remove forgets the given items of the feed, atomically.
+	// Removing an item which isn't present is not an error.
+	Remove(feed string, items ...string) error
+
+	// RemoveBefore forgets the items of the feed which were seen
+	// before the given time, atomically, and returns how many there
+	// were.  Items whose time isn't known are kept.
+	RemoveBefore(feed string, before time.Time) (int, error)
+
+	// DeleteFeed forgets every item of the feed, along with its
+	// fingerprint and failures.
+	DeleteFeed(feed string) error
+
+	// Fingerprint returns the fingerprint of the feed's options, as
+	// recorded by SetFingerprint, or the empty string if there is
+	// none.
+	Fingerprint(feed string) (string, error)
+
+	// SetFingerprint records the fingerprint of the feed's options.
+	SetFingerprint(feed string, fingerprint string) error
+
+	// Failures returns the number of consecutive times the feed has
+	// failed to be fetched, and the time of the most recent failure,
+	// as recorded by RecordFailure.  The count is zero, and the time
+	// zero, if it hasn't failed since it was last fetched.
+	Failures(feed string) (int, time.Time, error)
+
+	// RecordFailure adds one to the count of consecutive failures of
+	// the feed, which failed at the given time, and returns the new
+	// count.
+	RecordFailure(feed string, at time.Time) (int, error)
+
+	// ResetFailures clears the count of consecutive failures of the
+	// feed.  Resetting a feed which hasn't failed is not an error.
+	ResetFailures(feed string) error
+
+	// Updated returns the "updated" timestamp of the given item of the
+	// feed, as recorded by SetUpdated, or the empty string if there
+	// is none.
+	Updated(feed string, item string) (string, error)
+
+	// SetUpdated records the "updated" timestamp of the given item of
+	// the feed, replacing any previous value.  It is forgotten when
+	// the item is removed.
+	SetUpdated(feed string, item string, updated string) error
+
+	// Sync flushes any changes to disk.
+	Sync() error
+
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// DefaultPath returns the path to the BoltDB database which holds our
+// state, by default.
+func DefaultPath() string {
+	return filepath.Join(Directory(), "state.db")
+}
+
+// Open opens the store described by the given specification, which has
+// a prefix identifying the backend followed by its path.
+//
+// For example "bolt:/path/to/state.db", or "sqlite:/path/to/state.db".
+// The "file" prefix is accepted as an alias for "bolt", as that is the
+// single file we've always used.
+func Open(spec string) (Store, error) {
+
+	kind, path, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "bolt":
+		return OpenBolt(path)
+	case "sqlite":
+		return OpenSQLite(path)
+	default:
+		return nil, fmt.Errorf("unknown state backend '%s'", kind)
+	}
+}
+
+// ParseSpec splits a store specification into its backend and path,
+// resolving any alias.
+func ParseSpec(spec string) (string, string, error) {
+
+	kind, path, found := strings.Cut(spec, ":")
+	if !found || path == "" {
+		return "", "", fmt.Errorf("invalid state '%s', expected backend:path", spec)
+	}
+
+	if kind == "file" {
+		kind = "bolt"
+	}
+	return kind, path, nil
+}
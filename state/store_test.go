@@ -0,0 +1,404 @@
+package state
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// TestParseSpec tests parsing store specifications.
+func TestParseSpec(t *testing.T) {
+
+	kind, path, err := ParseSpec("file:./state.db")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if kind != "bolt" || path != "./state.db" {
+		t.Fatalf("unexpected result %s %s", kind, path)
+	}
+
+	for _, spec := range []string{"", "bolt", "sqlite:"} {
+		_, _, err = ParseSpec(spec)
+		if err == nil {
+			t.Fatalf("expected error parsing '%s'", spec)
+		}
+	}
+
+	_, err = Open("unknown:/tmp/foo")
+	if err == nil {
+		t.Fatalf("expected error with unknown backend")
+	}
+}
+
+// TestStores tests each of our backends behaves the same way.
+func TestStores(t *testing.T) {
+
+	for _, kind := range []string{"bolt", "sqlite"} {
+
+		spec := kind + ":" + filepath.Join(t.TempDir(), "state.db")
+		s, err := Open(spec)
+		if err != nil {
+			t.Fatalf("%s: failed to open: %s", kind, err)
+		}
+
+		// Recording the same item twice is fine.
+		for i := 0; i < 2; i++ {
+			err = s.Record("https://example.com/", "a", "b")
+			if err != nil {
+				t.Fatalf("%s: failed to record: %s", kind, err)
+			}
+		}
+		err = s.Record("https://example.net/", "c")
+		if err != nil {
+			t.Fatalf("%s: failed to record: %s", kind, err)
+		}
+
+		feeds, err := s.Feeds()
+		if err != nil {
+			t.Fatalf("%s: failed to find feeds: %s", kind, err)
+		}
+		if len(feeds) != 2 {
+			t.Fatalf("%s: unexpected feeds %v", kind, feeds)
+		}
+
+		items, err := s.Items("https://example.com/")
+		if err != nil {
+			t.Fatalf("%s: failed to find items: %s", kind, err)
+		}
+		sort.Strings(items)
+		if len(items) != 2 || items[0] != "a" || items[1] != "b" {
+			t.Fatalf("%s: unexpected items %v", kind, items)
+		}
+
+		items, err = s.Items("https://missing.example.com/")
+		if err != nil || len(items) != 0 {
+			t.Fatalf("%s: unexpected items for missing feed %v %v", kind, items, err)
+		}
+
+		err = s.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to close: %s", kind, err)
+		}
+	}
+}
+
+// TestStoreOperations tests the operations the processor uses, with each
+// backend, including an in-memory SQLite database.
+func TestStoreOperations(t *testing.T) {
+
+	stores := map[string]func() (Store, error){
+		"bolt":   func() (Store, error) { return OpenBolt(filepath.Join(t.TempDir(), "state.db")) },
+		"sqlite": func() (Store, error) { return OpenSQLite(filepath.Join(t.TempDir(), "state.db")) },
+		"memory": func() (Store, error) { return OpenSQLite(":memory:") },
+	}
+
+	for kind, open := range stores {
+
+		s, err := open()
+		if err != nil {
+			t.Fatalf("%s: failed to open: %s", kind, err)
+		}
+
+		feed := "https://example.com/"
+
+		// Nothing is seen, and removing nothing is fine.
+		seen, err := s.Seen(feed, "a")
+		if err != nil || seen {
+			t.Fatalf("%s: unexpected state of missing item %v %v", kind, seen, err)
+		}
+		err = s.Remove(feed, "a")
+		if err != nil {
+			t.Fatalf("%s: failed to remove missing item: %s", kind, err)
+		}
+
+		err = s.Record(feed, "a", "b", "c")
+		if err != nil {
+			t.Fatalf("%s: failed to record: %s", kind, err)
+		}
+		err = s.Remove(feed, "a", "c")
+		if err != nil {
+			t.Fatalf("%s: failed to remove: %s", kind, err)
+		}
+		for item, expected := range map[string]bool{"a": false, "b": true, "c": false} {
+			seen, err = s.Seen(feed, item)
+			if err != nil || seen != expected {
+				t.Fatalf("%s: expected %s seen to be %v, got %v %v", kind, item, expected, seen, err)
+			}
+		}
+
+		// Fingerprints
+		fp, err := s.Fingerprint(feed)
+		if err != nil || fp != "" {
+			t.Fatalf("%s: unexpected fingerprint %q %v", kind, fp, err)
+		}
+		for _, value := range []string{"one", "two"} {
+			err = s.SetFingerprint(feed, value)
+			if err != nil {
+				t.Fatalf("%s: failed to set fingerprint: %s", kind, err)
+			}
+			fp, err = s.Fingerprint(feed)
+			if err != nil || fp != value {
+				t.Fatalf("%s: expected fingerprint %q, got %q %v", kind, value, fp, err)
+			}
+		}
+
+		// Fingerprints aren't feeds.
+		feeds, err := s.Feeds()
+		if err != nil || len(feeds) != 1 || feeds[0] != feed {
+			t.Fatalf("%s: unexpected feeds %v %v", kind, feeds, err)
+		}
+
+		// Deleting a feed forgets everything.
+		err = s.DeleteFeed(feed)
+		if err != nil {
+			t.Fatalf("%s: failed to delete feed: %s", kind, err)
+		}
+		items, err := s.Items(feed)
+		if err != nil || len(items) != 0 {
+			t.Fatalf("%s: unexpected items after delete %v %v", kind, items, err)
+		}
+		fp, err = s.Fingerprint(feed)
+		if err != nil || fp != "" {
+			t.Fatalf("%s: unexpected fingerprint after delete %q %v", kind, fp, err)
+		}
+		err = s.DeleteFeed(feed)
+		if err != nil {
+			t.Fatalf("%s: failed to delete missing feed: %s", kind, err)
+		}
+
+		err = s.Sync()
+		if err != nil {
+			t.Fatalf("%s: failed to sync: %s", kind, err)
+		}
+		err = s.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to close: %s", kind, err)
+		}
+	}
+}
+
+// TestSeenTimes tests recording, and reading, the times items were seen.
+func TestSeenTimes(t *testing.T) {
+
+	for _, kind := range []string{"bolt", "sqlite"} {
+
+		s, err := Open(kind + ":" + filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatalf("%s: failed to open: %s", kind, err)
+		}
+
+		feed := "https://example.com/"
+		then := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+		err = s.RecordTimes(feed, map[string]time.Time{"a": then, "b": {}})
+		if err != nil {
+			t.Fatalf("%s: failed to record: %s", kind, err)
+		}
+
+		// Existing items keep their time.
+		err = s.RecordTimes(feed, map[string]time.Time{"a": then.Add(time.Hour)})
+		if err == nil {
+			err = s.Record(feed, "a", "c")
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to record: %s", kind, err)
+		}
+
+		times, err := s.SeenTimes(feed)
+		if err != nil {
+			t.Fatalf("%s: failed to read times: %s", kind, err)
+		}
+		if len(times) != 3 || !times["a"].Equal(then) || !times["b"].IsZero() || time.Since(times["c"]) > time.Minute {
+			t.Fatalf("%s: unexpected times %v", kind, times)
+		}
+
+		times, err = s.SeenTimes("https://missing.example.com/")
+		if err != nil || len(times) != 0 {
+			t.Fatalf("%s: unexpected times for missing feed %v %v", kind, times, err)
+		}
+
+		s.Close()
+	}
+}
+
+// TestRemoveBefore tests forgetting old items.
+func TestRemoveBefore(t *testing.T) {
+
+	for _, kind := range []string{"bolt", "sqlite"} {
+
+		s, err := Open(kind + ":" + filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatalf("%s: failed to open: %s", kind, err)
+		}
+
+		feed := "https://example.com/"
+		now := time.Now()
+
+		err = s.RecordTimes(feed, map[string]time.Time{
+			"old":     now.AddDate(0, 0, -40),
+			"older":   now.AddDate(-1, 0, 0),
+			"recent":  now.AddDate(0, 0, -2),
+			"unknown": {},
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to record: %s", kind, err)
+		}
+
+		removed, err := s.RemoveBefore(feed, now.AddDate(0, 0, -30))
+		if err != nil || removed != 2 {
+			t.Fatalf("%s: expected two items removed, got %d %v", kind, removed, err)
+		}
+
+		items, err := s.Items(feed)
+		sort.Strings(items)
+		if err != nil || len(items) != 2 || items[0] != "recent" || items[1] != "unknown" {
+			t.Fatalf("%s: unexpected items %v %v", kind, items, err)
+		}
+
+		removed, err = s.RemoveBefore("https://missing.example.com/", now)
+		if err != nil || removed != 0 {
+			t.Fatalf("%s: unexpected removal from missing feed %d %v", kind, removed, err)
+		}
+
+		s.Close()
+	}
+}
+
+// TestFailures tests recording the consecutive failures of a feed.
+func TestFailures(t *testing.T) {
+
+	for _, kind := range []string{"bolt", "sqlite"} {
+
+		s, err := Open(kind + ":" + filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatalf("%s: failed to open: %s", kind, err)
+		}
+
+		feed := "https://example.com/"
+		at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		count, last, err := s.Failures(feed)
+		if err != nil || count != 0 || !last.IsZero() {
+			t.Fatalf("%s: unexpected failures %d %s %v", kind, count, last, err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			count, err = s.RecordFailure(feed, at.Add(time.Duration(i)*time.Hour))
+			if err != nil || count != i {
+				t.Fatalf("%s: expected count %d, got %d %v", kind, i, count, err)
+			}
+		}
+
+		count, last, err = s.Failures(feed)
+		if err != nil || count != 3 || !last.Equal(at.Add(3*time.Hour)) {
+			t.Fatalf("%s: unexpected failures %d %s %v", kind, count, last, err)
+		}
+
+		// The failures aren't a feed.
+		feeds, err := s.Feeds()
+		if err != nil || len(feeds) != 0 {
+			t.Fatalf("%s: unexpected feeds %v %v", kind, feeds, err)
+		}
+
+		err = s.ResetFailures(feed)
+		if err != nil {
+			t.Fatalf("%s: failed to reset: %s", kind, err)
+		}
+		count, _, err = s.Failures(feed)
+		if err != nil || count != 0 {
+			t.Fatalf("%s: failures weren't reset %d %v", kind, count, err)
+		}
+
+		// Deleting a feed forgets its failures too.
+		_, err = s.RecordFailure(feed, at)
+		if err != nil {
+			t.Fatalf("%s: failed to record failure: %s", kind, err)
+		}
+		err = s.DeleteFeed(feed)
+		if err != nil {
+			t.Fatalf("%s: failed to delete: %s", kind, err)
+		}
+		count, _, err = s.Failures(feed)
+		if err != nil || count != 0 {
+			t.Fatalf("%s: failures weren't deleted %d %v", kind, count, err)
+		}
+
+		s.Close()
+	}
+}
+
+// TestUpdated tests recording the "updated" timestamps of items.
+func TestUpdated(t *testing.T) {
+
+	for _, kind := range []string{"bolt", "sqlite"} {
+
+		s, err := Open(kind + ":" + filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatalf("%s: failed to open: %s", kind, err)
+		}
+
+		feed := "https://example.com/"
+		now := time.Now()
+
+		value, err := s.Updated(feed, "a")
+		if err != nil || value != "" {
+			t.Fatalf("%s: unexpected timestamp %q %v", kind, value, err)
+		}
+
+		err = s.RecordTimes(feed, map[string]time.Time{"a": now, "b": now, "old": now.AddDate(-1, 0, 0)})
+		if err != nil {
+			t.Fatalf("%s: failed to record: %s", kind, err)
+		}
+		for _, item := range []string{"a", "b", "old"} {
+			for _, stamp := range []string{"first", "second"} {
+				err = s.SetUpdated(feed, item, item+" "+stamp)
+				if err != nil {
+					t.Fatalf("%s: failed to set timestamp: %s", kind, err)
+				}
+			}
+		}
+
+		value, err = s.Updated(feed, "a")
+		if err != nil || value != "a second" {
+			t.Fatalf("%s: unexpected timestamp %q %v", kind, value, err)
+		}
+		value, err = s.Updated("https://example.net/", "a")
+		if err != nil || value != "" {
+			t.Fatalf("%s: unexpected timestamp for another feed %q %v", kind, value, err)
+		}
+
+		// The timestamps aren't a feed.
+		feeds, err := s.Feeds()
+		if err != nil || len(feeds) != 1 {
+			t.Fatalf("%s: unexpected feeds %v %v", kind, feeds, err)
+		}
+
+		// Removing items forgets their timestamps.
+		err = s.Remove(feed, "a")
+		if err != nil {
+			t.Fatalf("%s: failed to remove: %s", kind, err)
+		}
+		_, err = s.RemoveBefore(feed, now.AddDate(0, -1, 0))
+		if err != nil {
+			t.Fatalf("%s: failed to remove: %s", kind, err)
+		}
+		for item, expected := range map[string]string{"a": "", "b": "b second", "old": ""} {
+			value, err = s.Updated(feed, item)
+			if err != nil || value != expected {
+				t.Fatalf("%s: unexpected timestamp of %s %q %v", kind, item, value, err)
+			}
+		}
+
+		err = s.DeleteFeed(feed)
+		if err != nil {
+			t.Fatalf("%s: failed to delete: %s", kind, err)
+		}
+		value, err = s.Updated(feed, "b")
+		if err != nil || value != "" {
+			t.Fatalf("%s: timestamp wasn't deleted %q %v", kind, value, err)
+		}
+
+		s.Close()
+	}
+}
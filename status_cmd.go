@@ -64,8 +64,13 @@ func (s *statusCmd) Execute(args []string) int {
 	} else if cfg.HasSMTP() {
 		fmt.Printf("\nSMTP:\n")
 		fmt.Printf("  Host:      %s:%d\n", cfg.SMTP.Host, cfg.SMTP.Port)
+		fmt.Printf("  SMTPS:     %t\n", cfg.SMTP.SMTPS)
 		fmt.Printf("  Username:  %s\n", cfg.SMTP.Username)
-		fmt.Printf("  Password:  %s\n", strings.Repeat("*", len(cfg.SMTP.Password)))
+		if cfg.SMTP.UsesOAuth2() {
+			fmt.Printf("  Auth:      oauth2 (%s)\n", cfg.SMTP.OAuth2TokenURL)
+		} else {
+			fmt.Printf("  Password:  %s\n", strings.Repeat("*", len(cfg.SMTP.Password)))
+		}
 		if cfg.From != "" {
 			fmt.Printf("  From:      %s\n", cfg.From)
 		}
@@ -107,6 +112,9 @@ func (s *statusCmd) Execute(args []string) int {
 
 	err = db.View(func(tx *bbolt.Tx) error {
 		return tx.ForEach(func(bucketName []byte, b *bbolt.Bucket) error {
+			if !state.IsFeedBucket(string(bucketName)) {
+				return nil
+			}
 			count := 0
 			c := b.Cursor()
 			for k, _ := c.First(); k != nil; k, _ = c.Next() {
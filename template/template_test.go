@@ -8,7 +8,7 @@ func TestTemplate(t *testing.T) {
 
 	// content and expected length
 	content := EmailTemplate()
-	length := 2857
+	length := 2959
 
 	if len(content) != length {
 		t.Fatalf("unexpected template size %d != %d", length, len(content))
@@ -8,11 +8,11 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
-	"net/smtp"
 	"strings"
 	"time"
 
 	"github.com/skx/rss2email/config"
+	"github.com/skx/rss2email/processor/emailer"
 )
 
 // Structure for our options and state.
@@ -102,13 +102,16 @@ func (t *testCmd) Execute(args []string) int {
 
 	if t.verbose {
 		fmt.Printf("Connecting to %s...\n", smtpAddr)
+		fmt.Printf("  SMTPS:    %t\n", cfg.SMTP.SMTPS)
 		fmt.Printf("  Username: %s\n", cfg.SMTP.Username)
+		if cfg.SMTP.UsesOAuth2() {
+			fmt.Printf("  Auth:     oauth2 (%s)\n", cfg.SMTP.OAuth2TokenURL)
+		}
 		fmt.Printf("  From:     %s\n", from)
 		fmt.Printf("  To:       %s\n", addr)
 	}
 
-	auth := smtp.PlainAuth("", cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Host)
-	err = smtp.SendMail(smtpAddr, auth, from, []string{addr}, []byte(msg))
+	err = emailer.SendSMTP(cfg.SMTP, from, []string{addr}, []byte(msg))
 	if err != nil {
 		logger.Error("failed to send test email",
 			slog.String("to", addr),
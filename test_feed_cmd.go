@@ -0,0 +1,166 @@
+//
+// Fetch a single feed, and show what would happen to its items.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/skx/rss2email/configfile"
+	"github.com/skx/rss2email/httpfetch"
+	"github.com/skx/rss2email/processor"
+	"github.com/skx/rss2email/withstate"
+)
+
+// Structure for our options and state.
+type testFeedCmd struct {
+
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+
+	// explain shows the decision of every filter for each item.
+	explain bool
+}
+
+// Info is part of the subcommand-API.
+func (t *testFeedCmd) Info() (string, string) {
+	return "test-feed", `Fetch a feed, and show which of its items would be sent.
+
+This sub-command fetches the given feed immediately, regardless of its
+frequency, and shows a table of every item it contains, with its title,
+date, and GUID, along with whether it would be sent or skipped by the
+filters configured for the feed.
+
+If the feed isn't present in your configuration file it is fetched with
+the default settings, and no items are skipped.
+
+The items we've seen previously aren't considered, so this shows what
+would happen to items which are new.  No state is updated, and no emails
+are sent.
+
+With -explain the decision of every filter is shown for each item, and
+the decisions are logged at info level, which helps to find out why a
+filter doesn't behave as expected.
+
+Example:
+
+    $ rss2email test-feed https://blog.example.com/feed.xml
+    $ rss2email test-feed -explain https://blog.example.com/feed.xml
+`
+}
+
+// Arguments handles our flag-setup.
+//
+// In our case we use this as a hook to setup our configuration-file,
+// which allows testing.
+func (t *testFeedCmd) Arguments(f *flag.FlagSet) {
+	t.config = configfile.New()
+	f.BoolVar(&t.explain, "explain", false, "Show the decision of every filter for each item.")
+}
+
+// Entry-point.
+func (t *testFeedCmd) Execute(args []string) int {
+
+	if len(args) != 1 {
+		fmt.Fprintf(out, "Usage: rss2email test-feed <url>\n")
+		return 1
+	}
+	feedURL := args[0]
+
+	// Use the settings of the feed, if it is one we're watching.
+	entry := configfile.Feed{URL: feedURL}
+	entries, err := t.config.Parse()
+	if err != nil {
+		fmt.Fprintf(out, "Error parsing config: %s\n", err.Error())
+		return 1
+	}
+	found := false
+	for _, e := range entries {
+		if e.URL == feedURL {
+			entry = e
+			found = true
+		}
+	}
+
+	helper := httpfetch.New(entry, logger, version)
+	helper.SetBypassCache(true)
+	helper.SetReadOnly(true)
+	feed, err := helper.Fetch()
+	if err != nil {
+		logger.Error("failed to fetch feed", slog.String("feed", feedURL), slog.String("error", err.Error()))
+		return 1
+	}
+
+	// The processor applies the filters, with a temporary state.
+	proc, err := processor.New(processor.ProcessorConfig{})
+	if err != nil {
+		logger.Error("failed to create feed processor", slog.String("error", err.Error()))
+		return 1
+	}
+	defer proc.Close()
+	proc.SetLogger(logger)
+	proc.SetExplain(t.explain)
+
+	if found {
+		fmt.Fprintf(out, "%s - %d items, filtered by %d options\n\n", feed.Title, len(feed.Items), len(entry.Options))
+	} else {
+		fmt.Fprintf(out, "%s - %d items, not in the configuration file so nothing is filtered\n\n", feed.Title, len(feed.Items))
+	}
+
+	skipped := 0
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "TITLE\tDATE\tGUID\tRESULT\n")
+	for _, xp := range feed.Items {
+		result := "send"
+		skip, reason := proc.TestFilter(entry, withstate.FeedItem{Item: xp, FeedURL: entry.URL, FeedName: feed.Title})
+		if skip {
+			result = "skip: " + reason
+			skipped++
+		}
+
+		date := xp.Published
+		if xp.PublishedParsed != nil {
+			date = xp.PublishedParsed.Format(time.DateTime)
+		}
+
+		// Tabs and newlines would break our table.
+		title := strings.Join(strings.Fields(xp.Title), " ")
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", title, date, xp.GUID, result)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%d/%d items would be sent\n", len(feed.Items)-skipped, len(feed.Items))
+
+	if t.explain {
+		t.explainItems(proc, entry, feed.Title, feed.Items)
+	}
+	return 0
+}
+
+// explainItems shows a table of the decisions of every filter, for each of
+// the given items.
+func (t *testFeedCmd) explainItems(proc *processor.Processor, entry configfile.Feed, title string, items []*gofeed.Item) {
+
+	for _, xp := range items {
+		fmt.Fprintf(out, "\n%s\n", strings.Join(strings.Fields(xp.Title), " "))
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "  FILTER\tRESULT\tOPTION\tREASON\n")
+		for _, result := range proc.ExplainFilter(entry, withstate.FeedItem{Item: xp, FeedURL: entry.URL, FeedName: title}) {
+			decision := "pass"
+			if result.Skipped {
+				decision = "skip"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", result.Filter, decision, result.MatchedOption, result.Reason)
+		}
+		w.Flush()
+	}
+}
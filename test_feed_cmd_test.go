@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+func TestTestFeed(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	defer func() { out = bak }()
+
+	t.Setenv("HOME", t.TempDir())
+
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("ETag", `"abc"`)
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<rss version="2.0">
+<channel>
+<title>Test Feed</title>
+<item><title>Cake recipe</title><link>https://example.com/1</link><guid>guid-1</guid><pubDate>Mon, 02 Jan 2006 15:04:05 +0000</pubDate></item>
+<item><title>Bread recipe</title><link>https://example.com/2</link><guid>guid-2</guid></item>
+</channel></rss>`)
+	}))
+	defer ts.Close()
+
+	tmpfile, err := os.CreateTemp("", "example")
+	if err != nil {
+		t.Fatalf("Error creating temporary file")
+	}
+	defer os.Remove(tmpfile.Name())
+
+	err = os.WriteFile(tmpfile.Name(), []byte(ts.URL+"\n - exclude-title: (?i)cake\n - frequency: 60\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	// The feed is fetched each time, despite its frequency.
+	for i := 0; i < 2; i++ {
+		out = &bytes.Buffer{}
+		c := testFeedCmd{config: configfile.NewWithPath(tmpfile.Name())}
+		if c.Execute([]string{ts.URL}) != 0 {
+			t.Fatalf("unexpected failure: %s", out)
+		}
+
+		output := out.(*bytes.Buffer).String()
+		for _, expected := range []string{
+			"Test Feed - 2 items, filtered by 2 options",
+			"guid-1",
+			"2006-01-02 15:04:05",
+			"skip: exclude-title: (?i)cake matched 'Cake recipe'",
+			"1/2 items would be sent",
+		} {
+			if !strings.Contains(output, expected) {
+				t.Fatalf("expected %q in the output, got %s", expected, output)
+			}
+		}
+	}
+	if fetches != 2 {
+		t.Fatalf("expected two fetches, got %d", fetches)
+	}
+
+	// Every filter's decision can be shown.
+	out = &bytes.Buffer{}
+	explain := testFeedCmd{config: configfile.NewWithPath(tmpfile.Name()), explain: true}
+	if explain.Execute([]string{ts.URL}) != 0 {
+		t.Fatalf("unexpected failure: %s", out)
+	}
+	explained := out.(*bytes.Buffer).String()
+	for _, expected := range []string{
+		"\nCake recipe\n  FILTER",
+		"  pattern                skip    exclude-title  exclude-title: (?i)cake matched 'Cake recipe'",
+		"  category               pass",
+	} {
+		if !strings.Contains(explained, expected) {
+			t.Fatalf("expected %q in the output, got %s", expected, explained)
+		}
+	}
+
+	// Feeds which aren't in the configuration file aren't filtered.
+	err = os.WriteFile(tmpfile.Name(), []byte("https://example.com/other\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	out = &bytes.Buffer{}
+	c := testFeedCmd{config: configfile.NewWithPath(tmpfile.Name())}
+	if c.Execute([]string{ts.URL}) != 0 {
+		t.Fatalf("unexpected failure: %s", out)
+	}
+	output := out.(*bytes.Buffer).String()
+	if !strings.Contains(output, "not in the configuration file") || !strings.Contains(output, "2/2 items would be sent") {
+		t.Fatalf("unexpected output %s", output)
+	}
+
+	// A URL is required.
+	out = &bytes.Buffer{}
+	if c.Execute(nil) != 1 {
+		t.Fatalf("expected failure without a URL")
+	}
+}
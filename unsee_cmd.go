@@ -74,7 +74,9 @@ func (u *unseeCmd) Execute(args []string) int {
 	// Record each bucket
 	err = db.View(func(tx *bbolt.Tx) error {
 		return tx.ForEach(func(bucketName []byte, _ *bbolt.Bucket) error {
-			bucketNames = append(bucketNames, string(bucketName))
+			if state.IsFeedBucket(string(bucketName)) {
+				bucketNames = append(bucketNames, string(bucketName))
+			}
 			return nil
 		})
 	})
@@ -15,6 +15,10 @@ func TestUsage(t *testing.T) {
 	add.Info()
 	add.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
 
+	addf := addFeedCmd{}
+	addf.Info()
+	addf.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
 	cron := cronCmd{}
 	cron.Info()
 	cron.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
@@ -23,6 +27,10 @@ func TestUsage(t *testing.T) {
 	config.Info()
 	config.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
 
+	count := countCmd{}
+	count.Info()
+	count.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
 	daemon := daemonCmd{}
 	daemon.Info()
 	daemon.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
@@ -35,10 +43,26 @@ func TestUsage(t *testing.T) {
 	export.Info()
 	export.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
 
+	exs := exportStateCmd{}
+	exs.Info()
+	exs.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	gen := generateConfigCmd{}
+	gen.Info()
+	gen.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	hc := healthCheckCmd{}
+	hc.Info()
+	hc.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
 	imprt := importCmd{}
 	imprt.Info()
 	imprt.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
 
+	ims := importStateCmd{}
+	ims.Info()
+	ims.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
 	list := listCmd{}
 	list.Info()
 	list.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
@@ -47,14 +71,50 @@ func TestUsage(t *testing.T) {
 	ldt.Info()
 	ldt.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
 
+	lst := listStateCmd{}
+	lst.Info()
+	lst.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	mig := migrateStateCmd{}
+	mig.Info()
+	mig.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	prev := previewBrowserCmd{}
+	prev.Info()
+	prev.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	purg := purgeStateCmd{}
+	purg.Info()
+	purg.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	remf := removeFeedCmd{}
+	remf.Info()
+	remf.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	rcb := resetCircuitBreakerCmd{}
+	rcb.Info()
+	rcb.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
+	rfeed := resetFeedCmd{}
+	rfeed.Info()
+	rfeed.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
 	seen := seenCmd{}
 	seen.Info()
 	seen.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
 
+	tfeed := testFeedCmd{}
+	tfeed.Info()
+	tfeed.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
 	unse := unseeCmd{}
 	unse.Info()
 	unse.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
 
+	val := validateCmd{}
+	val.Info()
+	val.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
+
 	vers := versionCmd{}
 	vers.Info()
 	vers.Arguments(flag.NewFlagSet("test", flag.ContinueOnError))
@@ -114,6 +174,13 @@ func TestBrokenConfig(t *testing.T) {
 		t.Fatalf("expected error with config file")
 	}
 
+	v := validateCmd{}
+	v.config = configfile.NewWithPath(tmpfile.Name())
+	res = v.Execute([]string{})
+	if res != 1 {
+		t.Fatalf("expected error with config file")
+	}
+
 	// TODO : error-match
 
 	os.Remove(tmpfile.Name())
@@ -0,0 +1,168 @@
+//
+// Validate our configuration file.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"text/tabwriter"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+// Structure for our options and state.
+type validateCmd struct {
+
+	// Configuration file, used for testing
+	config *configfile.ConfigFile
+
+	// Should we output JSON?
+	json bool
+
+	// Should we output every problem as a single JSON object?
+	report bool
+
+	// Should we only check regular expressions?
+	regex bool
+}
+
+// Info is part of the subcommand-API
+func (v *validateCmd) Info() (string, string) {
+	return "validate", `Validate the configuration file.
+
+This command parses the configuration file, and reports any feeds whose
+URL cannot be parsed, and any per-feed options which are not recognized.  Unknown options are ignored when
+feeds are processed, so this is a useful way of catching typos.
+
+The values of options which are regular expressions, such as
+"exclude-title", are also compiled and any errors reported.  Use
+'-regex' to check only those.
+
+To see details of the configuration file, including the location and
+the supported options, please run:
+
+   $ rss2email help config
+
+With '-json' the unknown options are output as a JSON array, and the
+other problems are logged.  Use '-json-report' instead to output every
+problem as a JSON object, with the keys "invalid_urls", "unknown_options"
+and "invalid_regexes".
+
+The exit code is non-zero if problems were found.
+
+Example:
+
+    $ rss2email validate
+    $ rss2email validate -json
+    $ rss2email validate -json-report
+    $ rss2email validate -regex
+`
+}
+
+// Arguments handles argument-flags we might have.
+//
+// In our case we use this as a hook to setup our configuration-file,
+// which allows testing.
+func (v *validateCmd) Arguments(flags *flag.FlagSet) {
+	v.config = configfile.New()
+
+	flags.BoolVar(&v.json, "json", false, "Output the unknown options as JSON")
+	flags.BoolVar(&v.report, "json-report", false, "Output all of the problems found as a JSON object")
+	flags.BoolVar(&v.regex, "regex", false, "Only check the options which are regular expressions")
+}
+
+// Execute is invoked if the user specifies `validate` as the subcommand.
+func (v *validateCmd) Execute(args []string) int {
+
+	entries, err := v.config.Parse()
+	if err != nil {
+		logger.Error("failed to parse configuration file",
+			slog.String("configfile", v.config.Path()),
+			slog.String("error", err.Error()))
+		return 1
+	}
+
+	unknown := []configfile.UnknownOption{}
+	if !v.regex {
+		unknown = append(unknown, v.config.ListUnknownOptions()...)
+	}
+
+	// Unknown options were found above, including those of the
+	// defaults, so only the other problems are taken from each feed.
+	urls := []error{}
+	invalid := []error{}
+	for _, entry := range entries {
+		for _, e := range entry.Validate() {
+			var urlErr *configfile.URLError
+			var regexErr *configfile.RegexError
+
+			switch {
+			case errors.As(e, &urlErr):
+				if !v.regex {
+					urls = append(urls, e)
+				}
+			case errors.As(e, &regexErr):
+				invalid = append(invalid, e)
+			}
+		}
+	}
+
+	switch {
+	case v.report:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(struct {
+			URLs    []error                    `json:"invalid_urls"`
+			Unknown []configfile.UnknownOption `json:"unknown_options"`
+			Invalid []error                    `json:"invalid_regexes"`
+		}{urls, unknown, invalid})
+		if err != nil {
+			logger.Error("failed to encode JSON", slog.String("error", err.Error()))
+			return 1
+		}
+	case v.json:
+		// The array of unknown options is kept as it always was,
+		// for the benefit of existing scripts, so the other
+		// problems are logged.
+		for _, e := range append(urls, invalid...) {
+			logger.Warn("invalid configuration", slog.String("error", e.Error()))
+		}
+
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(unknown)
+		if err != nil {
+			logger.Error("failed to encode JSON", slog.String("error", err.Error()))
+			return 1
+		}
+	default:
+		for _, e := range urls {
+			fmt.Fprintf(out, "%s\n", e.Error())
+		}
+		if len(unknown) > 0 {
+			w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "FEED\tOPTION\tVALUE\n")
+			for _, u := range unknown {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", u.FeedURL, u.OptionName, u.OptionValue)
+			}
+			w.Flush()
+		}
+		for _, e := range invalid {
+			fmt.Fprintf(out, "%s\n", e.Error())
+		}
+	}
+
+	if len(urls) > 0 || len(unknown) > 0 || len(invalid) > 0 {
+		return 1
+	}
+
+	if !v.json && !v.report {
+		fmt.Fprintf(out, "%s is valid\n", v.config.Path())
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/skx/rss2email/configfile"
+)
+
+func TestValidate(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	defer func() { out = bak }()
+
+	tests := []struct {
+		content string
+		result  int
+		output  string
+	}{
+		{`https://example.org/
+ - tag: foo
+ - exclude-title: (?i)cake
+`, 0, "is valid"},
+		{`https://example.org/
+ - tga: foo
+https://example.net/
+ - retry: 3
+ - exlcude: bar
+`, 1, "https://example.net/  exlcude  bar"},
+		{`https://example.org/
+ - exclude-title: (cake
+`, 1, "invalid regular expression for exclude-title '(cake'"},
+		{`https://example.org/
+ - include: [a-z]+
+https://example.net/
+ - exclude: (?i)ok
+ - include-title: [cake
+`, 1, "https://example.net/: invalid regular expression for include-title '[cake'"},
+		{`http://[::1/
+ - tag: foo
+`, 1, "http://[::1/: invalid URL"},
+	}
+
+	for _, tst := range tests {
+
+		out = &bytes.Buffer{}
+
+		tmpfile, err := os.CreateTemp("", "example")
+		if err != nil {
+			t.Fatalf("Error creating temporary file")
+		}
+		defer os.Remove(tmpfile.Name())
+
+		err = os.WriteFile(tmpfile.Name(), []byte(tst.content), 0644)
+		if err != nil {
+			t.Fatalf("Error writing to config file")
+		}
+
+		v := validateCmd{config: configfile.NewWithPath(tmpfile.Name())}
+		res := v.Execute([]string{})
+		if res != tst.result {
+			t.Fatalf("expected result %d, got %d", tst.result, res)
+		}
+
+		output := out.(*bytes.Buffer).String()
+		if !strings.Contains(output, tst.output) {
+			t.Fatalf("failed to find expected output, got %s", output)
+		}
+
+		// Now as JSON, which is an array of the unknown options.
+		out = &bytes.Buffer{}
+		v = validateCmd{config: configfile.NewWithPath(tmpfile.Name()), json: true}
+		res = v.Execute([]string{})
+		if res != tst.result {
+			t.Fatalf("expected result %d, got %d", tst.result, res)
+		}
+
+		var unknown []configfile.UnknownOption
+		err = json.Unmarshal(out.(*bytes.Buffer).Bytes(), &unknown)
+		if err != nil {
+			t.Fatalf("failed to parse JSON output: %s", err)
+		}
+		if (len(unknown) > 0) != strings.Contains(tst.content, "tga") {
+			t.Fatalf("unexpected JSON output %v", unknown)
+		}
+
+		// And as a JSON report of every problem.
+		out = &bytes.Buffer{}
+		v = validateCmd{config: configfile.NewWithPath(tmpfile.Name()), report: true}
+		res = v.Execute([]string{})
+		if res != tst.result {
+			t.Fatalf("expected result %d, got %d", tst.result, res)
+		}
+
+		var result struct {
+			URLs    []map[string]string        `json:"invalid_urls"`
+			Unknown []configfile.UnknownOption `json:"unknown_options"`
+			Invalid []map[string]string        `json:"invalid_regexes"`
+		}
+		err = json.Unmarshal(out.(*bytes.Buffer).Bytes(), &result)
+		if err != nil {
+			t.Fatalf("failed to parse JSON output: %s", err)
+		}
+		if (len(result.URLs)+len(result.Unknown)+len(result.Invalid) == 0) != (tst.result == 0) {
+			t.Fatalf("unexpected JSON output %v", result)
+		}
+	}
+}
+
+// TestValidateRegex tests that only regular expressions are checked with
+// the -regex flag.
+func TestValidateRegex(t *testing.T) {
+
+	// Replace the STDIO handle
+	bak := out
+	defer func() { out = bak }()
+
+	tmpfile, err := os.CreateTemp("", "example")
+	if err != nil {
+		t.Fatalf("Error creating temporary file")
+	}
+	defer os.Remove(tmpfile.Name())
+
+	err = os.WriteFile(tmpfile.Name(), []byte(`https://example.org/
+ - colour: blue
+ - include: [a-z]+
+`), 0644)
+	if err != nil {
+		t.Fatalf("Error writing to config file")
+	}
+
+	out = &bytes.Buffer{}
+	v := validateCmd{config: configfile.NewWithPath(tmpfile.Name()), regex: true}
+	res := v.Execute([]string{})
+	if res != 0 {
+		t.Fatalf("expected success, got %d: %s", res, out.(*bytes.Buffer).String())
+	}
+
+	// Without the flag the unknown option is reported.
+	out = &bytes.Buffer{}
+	v = validateCmd{config: configfile.NewWithPath(tmpfile.Name())}
+	res = v.Execute([]string{})
+	if res != 1 {
+		t.Fatalf("expected failure, got %d", res)
+	}
+}
@@ -6,6 +6,8 @@
 package withstate
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"strings"
@@ -25,6 +27,12 @@ type FeedItem struct {
 	// Tag is a field that can be set for this feed item,
 	// inside our configuration file.
 	Tag string
+
+	// FeedURL is the URL of the feed the item was found in, if known.
+	FeedURL string
+
+	// FeedName is the title of the feed the item was found in, if known.
+	FeedName string
 }
 
 // RawContent provides content or fallback to description
@@ -43,6 +51,21 @@ func (item *FeedItem) RawContent() string {
 	return content
 }
 
+// MessageID returns the value to use for the Message-ID header of emails
+// generated for this item.
+//
+// This is derived from the GUID, falling back to the link, so that it is
+// the same every time we see the item.
+func (item *FeedItem) MessageID() string {
+	id := item.Item.GUID
+	if id == "" {
+		id = item.Item.Link
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return "<" + hex.EncodeToString(sum[:16]) + "@rss2email.localhost>"
+}
+
 // HTMLContent provides processed HTML
 func (item *FeedItem) HTMLContent() (string, error) {
 	rawContent := item.RawContent()
@@ -0,0 +1,138 @@
+package withstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// ItemJSON is the JSON representation of a FeedItem, as produced by
+// ToJSON.
+//
+// This schema is part of our public API, used by outputs which need to
+// serialize items.  Fields may be added to it, but existing fields will
+// not be renamed or removed.
+type ItemJSON struct {
+
+	// GUID is the unique identifier of the item.
+	GUID string `json:"guid,omitempty"`
+
+	// Title is the title of the item.
+	Title string `json:"title"`
+
+	// Link is the URL of the item.
+	Link string `json:"link,omitempty"`
+
+	// Content is the HTML content of the item, with relative links
+	// made absolute.
+	Content string `json:"content,omitempty"`
+
+	// Author is the name of the author, or their email address if
+	// they have no name.
+	Author string `json:"author,omitempty"`
+
+	// PublishedAt is the time the item was published, or updated if
+	// it has no publication date, in UTC.
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+
+	// Categories are the categories of the item.
+	Categories []string `json:"categories,omitempty"`
+
+	// Enclosures are the media files attached to the item.
+	Enclosures []EnclosureJSON `json:"enclosures,omitempty"`
+
+	// FeedURL is the URL of the feed the item was found in.
+	FeedURL string `json:"feed_url,omitempty"`
+
+	// FeedName is the title of the feed the item was found in.
+	FeedName string `json:"feed_name,omitempty"`
+}
+
+// EnclosureJSON is the JSON representation of an enclosure, within
+// ItemJSON.
+type EnclosureJSON struct {
+
+	// URL is the location of the enclosure.
+	URL string `json:"url"`
+
+	// Type is the MIME type of the enclosure.
+	Type string `json:"type,omitempty"`
+
+	// Length is the size of the enclosure in bytes, as given by the
+	// feed.
+	Length string `json:"length,omitempty"`
+}
+
+// JSON returns the representation of this item which is serialized by
+// ToJSON.
+func (item *FeedItem) JSON() ItemJSON {
+
+	out := ItemJSON{
+		GUID:       item.GUID,
+		Title:      item.Title,
+		Link:       item.Link,
+		Categories: item.Categories,
+		FeedURL:    item.FeedURL,
+		FeedName:   item.FeedName,
+	}
+
+	if item.RawContent() != "" {
+		content, err := item.HTMLContent()
+		if err != nil {
+			content = item.RawContent()
+		}
+		out.Content = content
+	}
+
+	if item.Author != nil {
+		out.Author = item.Author.Name
+		if out.Author == "" {
+			out.Author = item.Author.Email
+		}
+	}
+
+	date := item.PublishedParsed
+	if date == nil {
+		date = item.UpdatedParsed
+	}
+	if date != nil {
+		utc := date.UTC()
+		out.PublishedAt = &utc
+	}
+
+	for _, enc := range item.Enclosures {
+		if enc == nil {
+			continue
+		}
+		out.Enclosures = append(out.Enclosures, EnclosureJSON{URL: enc.URL, Type: enc.Type, Length: enc.Length})
+	}
+
+	return out
+}
+
+// ToJSON serializes this item to JSON, using the ItemJSON schema.
+//
+// Unlike json.Marshal the HTML characters in the content aren't escaped,
+// to keep the output readable.
+func (item *FeedItem) ToJSON() ([]byte, error) {
+	return item.ToJSONIndent("", "")
+}
+
+// ToJSONIndent is like ToJSON, but indents the output as json.MarshalIndent
+// does.
+func (item *FeedItem) ToJSONIndent(prefix string, indent string) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent(prefix, indent)
+
+	err := enc.Encode(item.JSON())
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the newline the encoder adds.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,73 @@
+package withstate
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// TestToJSON tests the serialization of items.
+func TestToJSON(t *testing.T) {
+
+	published := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+
+	item := FeedItem{
+		Item: &gofeed.Item{
+			GUID:            "guid-1",
+			Title:           "Hello",
+			Link:            "https://example.com/hello",
+			Content:         `<p>Hi</p>`,
+			Author:          &gofeed.Person{Email: "alice@example.com"},
+			PublishedParsed: &published,
+			Categories:      []string{"news"},
+			Enclosures:      []*gofeed.Enclosure{{URL: "https://example.com/a.mp3", Type: "audio/mpeg", Length: "123"}},
+		},
+		FeedURL:  "https://example.com/feed",
+		FeedName: "Example",
+	}
+
+	out, err := item.ToJSON()
+	if err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+
+	var got map[string]any
+	err = json.Unmarshal(out, &got)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %s", out, err)
+	}
+
+	expected := map[string]string{
+		"guid":         "guid-1",
+		"title":        "Hello",
+		"link":         "https://example.com/hello",
+		"author":       "alice@example.com",
+		"published_at": "2024-03-10T11:00:00Z",
+		"feed_url":     "https://example.com/feed",
+		"feed_name":    "Example",
+	}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Fatalf("unexpected %s: %v", key, got[key])
+		}
+	}
+	if !strings.Contains(got["content"].(string), "<p>Hi</p>") {
+		t.Fatalf("unexpected content: %v", got["content"])
+	}
+	if !strings.Contains(string(out), `"enclosures":[{"url":"https://example.com/a.mp3","type":"audio/mpeg","length":"123"}]`) {
+		t.Fatalf("unexpected enclosures: %s", out)
+	}
+
+	// Empty fields are omitted, except the title.
+	empty := FeedItem{Item: &gofeed.Item{}}
+	out, err = empty.ToJSONIndent("", "  ")
+	if err != nil {
+		t.Fatalf("failed to serialize: %s", err)
+	}
+	if string(out) != "{\n  \"title\": \"\"\n}" {
+		t.Fatalf("unexpected JSON: %s", out)
+	}
+}
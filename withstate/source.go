@@ -0,0 +1,35 @@
+package withstate
+
+import (
+	"github.com/mmcdole/gofeed"
+)
+
+// The keys within gofeed.Item.Custom where we store the details of the
+// original publisher of an item, which gofeed doesn't otherwise keep.
+const (
+	sourceTitleKey = "rss2email-source-title"
+	sourceURLKey   = "rss2email-source-url"
+)
+
+// SetSource records the title and URL of the original publisher of the
+// given item, as found in its <source> element.
+func SetSource(item *gofeed.Item, title string, url string) {
+	if title == "" && url == "" {
+		return
+	}
+	if item.Custom == nil {
+		item.Custom = make(map[string]string)
+	}
+	item.Custom[sourceTitleKey] = title
+	item.Custom[sourceURLKey] = url
+}
+
+// Source returns the title and URL of the original publisher of this item,
+// which are present in aggregated feeds.  Both are empty if the item has
+// no <source> element.
+func (item *FeedItem) Source() (string, string) {
+	if item.Item == nil {
+		return "", ""
+	}
+	return item.Custom[sourceTitleKey], item.Custom[sourceURLKey]
+}